@@ -0,0 +1,32 @@
+-- NPHIES Poll Queue Schema
+-- Outbound messages (ClaimResponse, CoverageEligibilityResponse, Communication)
+-- waiting to be retrieved by providers through poll-request messages
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS poll_messages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    event_id VARCHAR(255) NOT NULL,
+    provider_id VARCHAR(255) NOT NULL,
+    message_type VARCHAR(100) NOT NULL, -- ClaimResponse, CoverageEligibilityResponse, Communication
+    resource_id VARCHAR(255),
+    payload JSONB NOT NULL,
+    source_topic VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'queued', -- queued, delivered, acknowledged, expired
+    delivery_count INTEGER NOT NULL DEFAULT 0,
+    lease_expires_at TIMESTAMP WITH TIME ZONE,
+    last_delivered_at TIMESTAMP WITH TIME ZONE,
+    acknowledged_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Kafka redeliveries must not enqueue the same message twice
+CREATE UNIQUE INDEX IF NOT EXISTS idx_poll_messages_event_id ON poll_messages(event_id);
+CREATE INDEX IF NOT EXISTS idx_poll_messages_provider_status ON poll_messages(provider_id, status, created_at);
+CREATE INDEX IF NOT EXISTS idx_poll_messages_expires_at ON poll_messages(expires_at);
+
+GRANT ALL PRIVILEGES ON poll_messages TO nphies;
+
+CREATE TRIGGER update_poll_messages_updated_at BEFORE UPDATE ON poll_messages
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
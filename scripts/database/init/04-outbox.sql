@@ -0,0 +1,53 @@
+-- Transactional Outbox Schema
+-- Audit and domain events are written here in the same transaction as the
+-- state change and relayed to Kafka by a background worker
+
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id UUID PRIMARY KEY, -- also the event ID consumers use for deduplication
+    sequence BIGSERIAL NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    aggregate_type VARCHAR(100) NOT NULL,
+    aggregate_id VARCHAR(255) NOT NULL, -- Kafka message key; events for one aggregate are relayed in order
+    event_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, published, failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events(sequence) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_events_aggregate ON outbox_events(topic, aggregate_id, sequence) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_events_published_at ON outbox_events(published_at);
+
+GRANT ALL PRIVILEGES ON outbox_events TO nphies;
+GRANT ALL PRIVILEGES ON SEQUENCE outbox_events_sequence_seq TO nphies;
+
+\c eligibility;
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id UUID PRIMARY KEY,
+    sequence BIGSERIAL NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    aggregate_type VARCHAR(100) NOT NULL,
+    aggregate_id VARCHAR(255) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events(sequence) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_events_aggregate ON outbox_events(topic, aggregate_id, sequence) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_events_published_at ON outbox_events(published_at);
+
+GRANT ALL PRIVILEGES ON outbox_events TO nphies;
+GRANT ALL PRIVILEGES ON SEQUENCE outbox_events_sequence_seq TO nphies;
@@ -0,0 +1,11 @@
+-- Audit Trail Consumer Schema
+-- audit.trail.v1 events are persisted into audit_logs; Kafka redeliveries and
+-- outbox retries are absorbed by the unique event ID
+\c nphies;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_logs_event_id ON audit_logs(event_id);
+
+-- Keyset pagination walks (timestamp, id) in descending order
+CREATE INDEX IF NOT EXISTS idx_audit_logs_timestamp_id ON audit_logs(timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_service_timestamp ON audit_logs(service, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_resource ON audit_logs(resource);
@@ -0,0 +1,36 @@
+-- Tamper-Evident Audit Chain Schema
+-- Each audit entry carries the hash of the previous entry of the same service;
+-- signed checkpoints pin the chain so that a rewritten chain is detectable
+\c nphies;
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS chain_seq BIGINT;
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS entry_hash VARCHAR(64);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_logs_service_chain_seq ON audit_logs(service, chain_seq);
+
+-- Current tail of each service's chain; locked while appending
+CREATE TABLE IF NOT EXISTS audit_chain_heads (
+    service VARCHAR(50) PRIMARY KEY,
+    last_seq BIGINT NOT NULL DEFAULT 0,
+    last_hash VARCHAR(64) NOT NULL DEFAULT '0000000000000000000000000000000000000000000000000000000000000000',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    service VARCHAR(50) NOT NULL,
+    chain_seq BIGINT NOT NULL,
+    entry_hash VARCHAR(64) NOT NULL,
+    signature TEXT NOT NULL, -- base64 Ed25519 signature
+    key_id VARCHAR(32) NOT NULL,
+    anchor_tx_id VARCHAR(255), -- wallet-service blockchain transaction, when anchored
+    anchor_hash VARCHAR(64),
+    anchored_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_checkpoints_service_seq ON audit_checkpoints(service, chain_seq);
+
+GRANT ALL PRIVILEGES ON audit_chain_heads TO nphies;
+GRANT ALL PRIVILEGES ON audit_checkpoints TO nphies;
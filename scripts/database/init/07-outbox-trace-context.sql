@@ -0,0 +1,11 @@
+-- Outbox Trace Context
+-- W3C trace context of the request that wrote an event, so that consumers
+-- continue the same distributed trace once the relay publishes it
+
+\c nphies;
+
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS trace_context JSONB;
+
+\c eligibility;
+
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS trace_context JSONB;
@@ -0,0 +1,9 @@
+-- Request IDs
+-- Correlation ID of the request that caused an audit event, so that support
+-- can find every audited action behind a provider complaint
+
+\c nphies;
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS request_id VARCHAR(128);
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_request_id ON audit_logs(request_id) WHERE request_id IS NOT NULL;
@@ -0,0 +1,32 @@
+-- API Keys
+-- Static keys for provider and payer systems that cannot use OAuth. Only a
+-- hash of each key is stored; the prefix identifies the key for lookup.
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    organization_id UUID NOT NULL REFERENCES organizations(id),
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(32) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL, -- hex HMAC-SHA256 of the full key
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    quota_per_minute INTEGER NOT NULL DEFAULT 0, -- 0 uses the gateway default
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, revoked
+    expires_at TIMESTAMP WITH TIME ZONE,
+    rotated_from UUID REFERENCES api_keys(id),
+    created_by VARCHAR(255),
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by VARCHAR(255),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    last_used_ip INET,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys(prefix);
+CREATE INDEX IF NOT EXISTS idx_api_keys_organization ON api_keys(organization_id, status);
+
+GRANT ALL PRIVILEGES ON api_keys TO nphies;
+
+CREATE TRIGGER update_api_keys_updated_at BEFORE UPDATE ON api_keys
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
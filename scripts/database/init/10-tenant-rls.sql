@@ -0,0 +1,33 @@
+-- Tenant Row-Level Security
+-- Coverage belongs to the payer in payer_id. The eligibility service filters
+-- every query by the caller's tenant; with TENANT_RLS_ENABLED it also sets
+-- app.tenant_id in its write transactions, and these policies then keep a
+-- transaction scoped to one payer from touching coverage of another.
+-- Sessions that do not set app.tenant_id are not restricted.
+\c eligibility;
+
+ALTER TABLE coverage ENABLE ROW LEVEL SECURITY;
+-- Apply the policies to the table owner as well, which the service connects as
+ALTER TABLE coverage FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS coverage_tenant_isolation ON coverage;
+CREATE POLICY coverage_tenant_isolation ON coverage
+    USING (
+        COALESCE(current_setting('app.tenant_id', true), '') = ''
+        OR payer_id = current_setting('app.tenant_id', true)
+    )
+    WITH CHECK (
+        COALESCE(current_setting('app.tenant_id', true), '') = ''
+        OR payer_id = current_setting('app.tenant_id', true)
+    );
+
+-- Benefit utilization follows the payer of its coverage
+ALTER TABLE benefit_utilization ENABLE ROW LEVEL SECURITY;
+ALTER TABLE benefit_utilization FORCE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS benefit_utilization_tenant_isolation ON benefit_utilization;
+CREATE POLICY benefit_utilization_tenant_isolation ON benefit_utilization
+    USING (
+        COALESCE(current_setting('app.tenant_id', true), '') = ''
+        OR EXISTS (SELECT 1 FROM coverage WHERE coverage.id = benefit_utilization.coverage_id)
+    );
@@ -0,0 +1,47 @@
+-- Access Policies
+-- Declarative RBAC/ABAC policies evaluated by the API gateway when
+-- POLICY_SOURCE=db. Policies are reloaded periodically; a matching deny
+-- overrides every allow and requests no policy allows are denied.
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS access_policies (
+    id VARCHAR(100) PRIMARY KEY,
+    description TEXT,
+    effect VARCHAR(10) NOT NULL CHECK (effect IN ('allow', 'deny')),
+    roles TEXT[] NOT NULL,     -- e.g. {payer_adjuster}, or {*}
+    actions TEXT[] NOT NULL,   -- read, write, delete, or *
+    resources TEXT[] NOT NULL, -- e.g. {fhir.Claim,claims}; fhir.* matches every FHIR type
+    conditions JSONB NOT NULL DEFAULT '{}', -- same_tenant, same_organization, organization_types, auth_methods
+    priority INTEGER NOT NULL DEFAULT 100, -- evaluation order, lowest first
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_policies_enabled ON access_policies(enabled, priority);
+
+-- The gateway's built-in policies, as a starting point
+INSERT INTO access_policies (id, description, effect, roles, actions, resources, conditions, priority) VALUES
+('deny-api-clients-admin', 'API keys never reach administrative endpoints', 'deny',
+ '{api_client}', '{*}', '{admin.*}', '{}', 10),
+('admin-full-access', 'Administrators may do everything', 'allow',
+ '{admin}', '{*}', '{*}', '{}', 20),
+('auditor-read-audit', 'Auditors read the audit trail, statistics and policies', 'allow',
+ '{auditor}', '{read}', '{admin.audit,admin.stats,admin.policies}', '{}', 30),
+('provider-clerk-submit', 'Provider clerks register patients and submit claims and prior authorizations for their organization', 'allow',
+ '{provider_clerk}', '{read,write}', '{fhir.Patient,fhir.Claim,fhir.CoverageEligibilityRequest,eligibility,claims,poll}', '{"same_organization": true}', 40),
+('provider-clerk-read', 'Provider clerks read coverage, claim responses and code systems', 'allow',
+ '{provider_clerk}', '{read}', '{fhir.Coverage,fhir.ClaimResponse,terminology}', '{"same_organization": true}', 50),
+('payer-adjuster-adjudicate', 'Payer adjusters manage coverage and adjudicate claims of their payer', 'allow',
+ '{payer_adjuster}', '{read,write}', '{fhir.Coverage,fhir.Claim,fhir.ClaimResponse,eligibility,claims,poll}', '{"same_tenant": true}', 60),
+('payer-adjuster-read', 'Payer adjusters read patients, prior authorizations and code systems', 'allow',
+ '{payer_adjuster}', '{read}', '{fhir.Patient,fhir.CoverageEligibilityRequest,terminology}', '{"same_tenant": true}', 70),
+('clients-api-access', 'Token holders and API clients use the FHIR and service routes within their tenant', 'allow',
+ '{user,api_client}', '{read,write,delete}', '{fhir.*,eligibility,claims,poll,terminology}', '{"same_tenant": true}', 80)
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TRIGGER update_access_policies_updated_at BEFORE UPDATE ON access_policies
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+GRANT ALL PRIVILEGES ON access_policies TO nphies;
@@ -0,0 +1,52 @@
+-- Break-glass Emergency Access
+-- Sessions opened by clinicians who need to read data outside their usual
+-- scope in an emergency. Each session holds the reason and justification
+-- given, counts the uses of its elevated token and waits for review.
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS break_glass_sessions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL, -- the holder's role when the session was opened
+    home_tenant_id VARCHAR(64), -- tenant of the holder's regular token
+    tenant_id VARCHAR(64) NOT NULL, -- tenant the session grants access to
+    organization_id VARCHAR(255),
+    patient_id VARCHAR(255) NOT NULL, -- the only patient the session may read
+    reason_code VARCHAR(50) NOT NULL,
+    justification TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active, revoked
+    client_ip INET,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    use_count INTEGER NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by VARCHAR(255),
+    review_status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (review_status IN ('pending', 'approved', 'flagged')),
+    reviewed_by VARCHAR(255),
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    review_notes TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The review queue reads pending sessions oldest first
+CREATE INDEX IF NOT EXISTS idx_break_glass_sessions_review ON break_glass_sessions(review_status, created_at);
+CREATE INDEX IF NOT EXISTS idx_break_glass_sessions_user ON break_glass_sessions(user_id, created_at);
+
+GRANT ALL PRIVILEGES ON break_glass_sessions TO nphies;
+
+CREATE TRIGGER update_break_glass_sessions_updated_at BEFORE UPDATE ON break_glass_sessions
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Policies for POLICY_SOURCE=db, matching the gateway's built-in ones
+INSERT INTO access_policies (id, description, effect, roles, actions, resources, conditions, priority) VALUES
+('break-glass-request', 'Clinical and claims staff may open a break-glass session in an emergency', 'allow',
+ '{provider_clerk,payer_adjuster}', '{write}', '{break-glass}', '{"auth_methods": ["jwt"]}', 90),
+('break-glass-read', 'Break-glass sessions read the session patient''s record, coverage and eligibility outside the holder''s usual scope', 'allow',
+ '{break_glass}', '{read}', '{fhir.Patient,fhir.Coverage,eligibility}', '{}', 95)
+ON CONFLICT (id) DO NOTHING;
+
+UPDATE access_policies
+SET resources = array_append(resources, 'admin.break-glass'),
+    description = 'Auditors read the audit trail, statistics, policies and break-glass sessions'
+WHERE id = 'auditor-read-audit' AND NOT ('admin.break-glass' = ANY(resources));
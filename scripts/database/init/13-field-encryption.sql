@@ -0,0 +1,34 @@
+-- Field-level Encryption
+-- With FIELD_ENCRYPTION_ENABLED the eligibility service encrypts member PHI
+-- into the *_encrypted columns and clears the plaintext ones. Values are
+-- envelope-encrypted and record the key version in encryption_key_id, which
+-- the background re-encryption job uses to move rows onto a rotated key.
+-- identifier_index is a blind index (HMAC) of the national ID so that members
+-- can still be looked up by it.
+\c eligibility;
+
+ALTER TABLE members ADD COLUMN IF NOT EXISTS identifier_encrypted TEXT;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS identifier_index VARCHAR(64);
+ALTER TABLE members ADD COLUMN IF NOT EXISTS name_encrypted TEXT;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS contact_info_encrypted TEXT;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS address_encrypted TEXT;
+ALTER TABLE members ADD COLUMN IF NOT EXISTS encryption_key_id VARCHAR(64);
+
+-- Encrypted rows keep no plaintext
+ALTER TABLE members ALTER COLUMN identifier DROP NOT NULL;
+ALTER TABLE members ALTER COLUMN name DROP NOT NULL;
+
+-- Coverage refers to members by row ID rather than by national ID, which
+-- would otherwise stay in coverage in plaintext and no longer stays in
+-- members.identifier once it is encrypted
+ALTER TABLE coverage ADD COLUMN IF NOT EXISTS member_ref UUID REFERENCES members(id);
+UPDATE coverage SET member_ref = members.id
+FROM members
+WHERE coverage.member_ref IS NULL AND members.identifier = coverage.member_id;
+ALTER TABLE coverage ALTER COLUMN member_ref SET NOT NULL;
+ALTER TABLE coverage DROP COLUMN IF EXISTS member_id;
+
+CREATE INDEX IF NOT EXISTS idx_coverage_member_ref ON coverage(member_ref, effective_date);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_members_identifier_index ON members(identifier_index);
+CREATE INDEX IF NOT EXISTS idx_members_encryption_key_id ON members(encryption_key_id);
@@ -0,0 +1,75 @@
+-- FHIR Subscriptions
+-- Topic-based REST-hook subscriptions of provider organizations. The gateway
+-- matches claim and prior authorization response events against them and
+-- queues a notification per match, which is delivered to the endpoint as a
+-- signed notification bundle and retried with backoff until it succeeds or
+-- runs out of attempts. Handshakes and heartbeats are queued the same way.
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    organization_id VARCHAR(255) NOT NULL, -- events addressed to this provider are delivered
+    tenant_id VARCHAR(64),
+    topic VARCHAR(255) NOT NULL,
+    resource_type VARCHAR(50) NOT NULL,
+    filters JSONB NOT NULL DEFAULT '[]', -- [{"parameter": ..., "value": ...}]
+    endpoint TEXT NOT NULL,
+    secret VARCHAR(100) NOT NULL, -- HMAC key of the notification signatures
+    content VARCHAR(20) NOT NULL DEFAULT 'id-only' CHECK (content IN ('empty', 'id-only', 'full-resource')),
+    heartbeat_period INTEGER NOT NULL DEFAULT 0, -- seconds; 0 for none
+    timeout INTEGER NOT NULL DEFAULT 10, -- seconds per delivery attempt
+    status VARCHAR(20) NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'active', 'error', 'off')),
+    reason TEXT,
+    end_at TIMESTAMP WITH TIME ZONE,
+    events_since_start BIGINT NOT NULL DEFAULT 0,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    last_error_at TIMESTAMP WITH TIME ZONE,
+    last_delivered_at TIMESTAMP WITH TIME ZONE,
+    last_notified_at TIMESTAMP WITH TIME ZONE, -- last event or heartbeat queued
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Events are matched by resource type and provider
+CREATE INDEX IF NOT EXISTS idx_subscriptions_match ON subscriptions(resource_type, organization_id) WHERE status <> 'off';
+CREATE INDEX IF NOT EXISTS idx_subscriptions_organization ON subscriptions(organization_id, created_at);
+
+CREATE TABLE IF NOT EXISTS subscription_notifications (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    type VARCHAR(30) NOT NULL CHECK (type IN ('handshake', 'heartbeat', 'event-notification')),
+    event_id VARCHAR(255), -- Kafka event; NULL for handshakes and heartbeats
+    event_number BIGINT,
+    focus VARCHAR(255), -- e.g. ClaimResponse/123
+    resource JSONB,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_notifications_due ON subscription_notifications(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_subscription_notifications_errors ON subscription_notifications(subscription_id, updated_at) WHERE last_error IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_subscription_notifications_finished ON subscription_notifications(updated_at) WHERE status <> 'pending';
+
+GRANT ALL PRIVILEGES ON subscriptions TO nphies;
+GRANT ALL PRIVILEGES ON subscription_notifications TO nphies;
+
+CREATE TRIGGER update_subscriptions_updated_at BEFORE UPDATE ON subscriptions
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_subscription_notifications_updated_at BEFORE UPDATE ON subscription_notifications
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Policy for POLICY_SOURCE=db, matching the gateway's built-in one
+INSERT INTO access_policies (id, description, effect, roles, actions, resources, conditions, priority) VALUES
+('provider-clerk-subscriptions', 'Provider clerks manage the subscriptions of their organization', 'allow',
+ '{provider_clerk}', '{read,write,delete}', '{fhir.Subscription}', '{"same_organization": true}', 55)
+ON CONFLICT (id) DO NOTHING;
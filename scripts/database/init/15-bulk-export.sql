@@ -0,0 +1,60 @@
+-- FHIR Bulk Data $export
+-- Export jobs kicked off by payers and regulators. A runner in the gateway
+-- claims each job under a lease, writes one NDJSON file per resource type to
+-- the file store and records the files for the job's manifest. Finished jobs
+-- and their files are removed once they expire.
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS bulk_export_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    level VARCHAR(20) NOT NULL CHECK (level IN ('system', 'patient', 'group')),
+    group_id VARCHAR(255), -- coverage group number, for group exports
+    types TEXT[] NOT NULL,
+    since TIMESTAMP WITH TIME ZONE,
+    request_url TEXT NOT NULL,
+    tenant_id VARCHAR(64), -- payer the export is scoped to; NULL for all payers
+    requested_by VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'accepted'
+        CHECK (status IN ('accepted', 'in-progress', 'completed', 'failed', 'cancelled')),
+    progress TEXT,
+    error TEXT,
+    output JSONB NOT NULL DEFAULT '[]', -- [{"type": ..., "file": ..., "count": ...}]
+    attempts INTEGER NOT NULL DEFAULT 0,
+    lease_expires_at TIMESTAMP WITH TIME ZONE,
+    transaction_time TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The runner claims the oldest waiting job or one whose lease expired
+CREATE INDEX IF NOT EXISTS idx_bulk_export_jobs_queue ON bulk_export_jobs(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_bulk_export_jobs_requester ON bulk_export_jobs(requested_by, status);
+CREATE INDEX IF NOT EXISTS idx_bulk_export_jobs_expires ON bulk_export_jobs(expires_at) WHERE expires_at IS NOT NULL;
+
+GRANT ALL PRIVILEGES ON bulk_export_jobs TO nphies;
+
+CREATE TRIGGER update_bulk_export_jobs_updated_at BEFORE UPDATE ON bulk_export_jobs
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Exports of responses from the poll queue filter by type and time
+CREATE INDEX IF NOT EXISTS idx_poll_messages_export ON poll_messages(message_type, created_at);
+
+-- Policies for POLICY_SOURCE=db, matching the gateway's built-in ones
+INSERT INTO access_policies (id, description, effect, roles, actions, resources, conditions, priority) VALUES
+('payer-adjuster-bulk-export', 'Payer adjusters export the data of their payer in bulk', 'allow',
+ '{payer_adjuster}', '{read,delete}', '{bulk-export}', '{"same_tenant": true}', 72),
+('api-client-bulk-export', 'Payer and regulator systems export data in bulk, payers only their own', 'allow',
+ '{api_client}', '{read,delete}', '{bulk-export}', '{"same_tenant": true, "organization_types": ["payer", "regulator"]}', 74),
+('regulator-bulk-export', 'Regulators export claims and coverage of all payers in bulk', 'allow',
+ '{regulator}', '{read,delete}', '{bulk-export}', '{}', 76)
+ON CONFLICT (id) DO NOTHING;
+
+-- The eligibility service pages members and coverage for exports by group
+-- and by last update
+\c eligibility;
+
+CREATE INDEX IF NOT EXISTS idx_coverage_group_number ON coverage(group_number);
+CREATE INDEX IF NOT EXISTS idx_coverage_updated_at ON coverage(updated_at);
+CREATE INDEX IF NOT EXISTS idx_members_updated_at ON members(updated_at);
@@ -0,0 +1,52 @@
+-- Bulk NDJSON Import
+-- Payers upload NDJSON of members and coverage to the eligibility service.
+-- The upload is staged line by line; a background runner applies it in
+-- batches, each committed together with the job's cursor, so an import picks
+-- up after the last committed batch when a runner stops. Lines that fail
+-- validation are recorded with their line number and skipped.
+\c eligibility;
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id VARCHAR(64), -- payer the import is scoped to; NULL for unscoped callers
+    status VARCHAR(20) NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'running', 'completed', 'failed')),
+    total_lines INTEGER NOT NULL DEFAULT 0,
+    cursor_line INTEGER NOT NULL DEFAULT 0, -- last line applied
+    created_count INTEGER NOT NULL DEFAULT 0,
+    updated_count INTEGER NOT NULL DEFAULT 0,
+    error_count INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    lease_expires_at TIMESTAMP WITH TIME ZONE,
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Uploaded lines, removed once the import finished
+CREATE TABLE IF NOT EXISTS import_lines (
+    job_id UUID NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    PRIMARY KEY (job_id, line)
+);
+
+CREATE TABLE IF NOT EXISTS import_errors (
+    job_id UUID NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL,
+    message TEXT NOT NULL,
+    PRIMARY KEY (job_id, line)
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_queue ON import_jobs(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_completed_at ON import_jobs(completed_at) WHERE completed_at IS NOT NULL;
+
+-- Imported coverage is matched to existing records by policy
+CREATE INDEX IF NOT EXISTS idx_coverage_member_payer_policy ON coverage(member_ref, payer_id, policy_number);
+
+GRANT ALL PRIVILEGES ON import_jobs, import_lines, import_errors TO nphies;
+
+CREATE TRIGGER update_import_jobs_updated_at BEFORE UPDATE ON import_jobs
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
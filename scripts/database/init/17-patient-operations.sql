@@ -0,0 +1,19 @@
+-- FHIR Patient $everything and $match
+-- $everything reads a patient's responses from the poll queue by the patient
+-- or subject of the payload. $match reads candidates by national ID and by
+-- birth date from the eligibility service.
+
+\c nphies;
+
+CREATE INDEX IF NOT EXISTS idx_poll_messages_patient_identifier
+    ON poll_messages((payload->'patient'->'identifier'->>'value'));
+CREATE INDEX IF NOT EXISTS idx_poll_messages_subject_identifier
+    ON poll_messages((payload->'subject'->'identifier'->>'value'));
+CREATE INDEX IF NOT EXISTS idx_poll_messages_patient_reference
+    ON poll_messages((payload->'patient'->>'reference'));
+CREATE INDEX IF NOT EXISTS idx_poll_messages_subject_reference
+    ON poll_messages((payload->'subject'->>'reference'));
+
+\c eligibility;
+
+CREATE INDEX IF NOT EXISTS idx_members_birth_date ON members(birth_date);
@@ -0,0 +1,18 @@
+-- Arabic-aware member name search
+-- name_keys holds the phonetic keys of the parts of a member's name, shared
+-- by its Arabic and Latin spellings. With field encryption enabled they are
+-- stored as blind indexes (HMAC), as the name itself is encrypted. The
+-- eligibility service fills the keys of existing members at startup; set
+-- them to NULL to rebuild them, e.g. after enabling field encryption.
+
+\c eligibility;
+
+ALTER TABLE members ADD COLUMN IF NOT EXISTS name_keys TEXT[];
+
+-- Searches ask for members whose keys contain every searched key
+CREATE INDEX IF NOT EXISTS idx_members_name_keys ON members USING GIN (name_keys);
+
+-- Keys are rebuilt whenever the way they are computed changes, as when tha,
+-- dhal and za came to share the classes of their Latin spellings and
+-- one-consonant keys were dropped
+UPDATE members SET name_keys = NULL WHERE name_keys IS NOT NULL;
@@ -0,0 +1,39 @@
+-- Claim Attachments
+-- Documents providers attach to claims, such as imaging reports, are
+-- uploaded to the gateway, which streams them into the object store and
+-- records their metadata here. Claims reference them by URL instead of
+-- carrying them inline. Content types are sniffed from the contents and
+-- uploads are scanned for malware when a scanner is configured; rejected
+-- uploads are not recorded.
+\c nphies;
+
+CREATE TABLE IF NOT EXISTS attachments (
+    id UUID PRIMARY KEY,
+    object_key TEXT NOT NULL UNIQUE, -- name of the contents in the object store
+    tenant_id VARCHAR(64) NOT NULL,
+    organization_id VARCHAR(255) NOT NULL, -- organization of the uploader
+    uploaded_by VARCHAR(255) NOT NULL,
+    title TEXT,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL CHECK (size > 0),
+    sha256 CHAR(64) NOT NULL, -- hex
+    scan_status VARCHAR(20) NOT NULL CHECK (scan_status IN ('clean', 'not-scanned')),
+    scanner VARCHAR(50),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_organization ON attachments(organization_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_attachments_tenant ON attachments(tenant_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_attachments_sha256 ON attachments(sha256);
+
+GRANT ALL PRIVILEGES ON attachments TO nphies;
+
+-- Policies for POLICY_SOURCE=db, matching the gateway's built-in ones:
+-- providers upload and read the attachments of their organization, payers
+-- read those of their claims
+UPDATE access_policies SET resources = array_append(resources, 'attachment')
+WHERE id IN ('provider-clerk-submit', 'payer-adjuster-read', 'clients-api-access')
+  AND NOT ('attachment' = ANY(resources));
+
+UPDATE access_policies SET description = 'Payer adjusters read patients, prior authorizations, claim attachments and code systems'
+WHERE id = 'payer-adjuster-read';
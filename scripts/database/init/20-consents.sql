@@ -0,0 +1,31 @@
+-- Member Consents
+-- Consents members grant through the wallet service and their revocations.
+-- The gateway asks the wallet service for a consent decision before
+-- disclosing member data to third parties, so every replica must decide from
+-- these records. A revocation applies to the member's consent only and is
+-- kept on the row, so it survives restarts.
+SELECT 'CREATE DATABASE wallet_db OWNER nphies'
+WHERE NOT EXISTS (SELECT FROM pg_database WHERE datname = 'wallet_db')\gexec
+
+\c wallet_db;
+
+CREATE TABLE IF NOT EXISTS consents (
+    id VARCHAR(64) PRIMARY KEY,
+    member_id VARCHAR(255) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    scope VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('ACTIVE', 'REVOKED')),
+    granted_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    purpose TEXT NOT NULL DEFAULT '',
+    purposes TEXT[] NOT NULL DEFAULT '{}', -- purpose of use codes; empty allows any
+    data_types TEXT[] NOT NULL DEFAULT '{}',
+    recipients TEXT[] NOT NULL DEFAULT '{}',
+    blockchain_hash VARCHAR(255),
+    CHECK ((status = 'REVOKED') = (revoked_at IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_consents_member ON consents(member_id, granted_at);
+
+GRANT ALL PRIVILEGES ON consents TO nphies;
@@ -0,0 +1,63 @@
+#!/bin/bash
+
+# NPHIES Platform - Development TLS certificates
+#
+# Generates a local CA, a server certificate for every service and client
+# certificates for the gateway and a sample provider, for trying out TLS and
+# mTLS locally. Never use these certificates outside development.
+#
+# Usage: scripts/generate-dev-certs.sh [output-dir]
+#
+# Then, for example:
+#   ENABLE_MTLS=true TLS_CA_CERTS=certs/dev/ca.crt \
+#   TLS_CERT_PATH=certs/dev/api-gateway.crt TLS_KEY_PATH=certs/dev/api-gateway.key \
+#   MTLS_CLIENT_MAP="CN:provider-dev=PRV001" ./bin/api-gateway
+#
+#   curl --cacert certs/dev/ca.crt --cert certs/dev/provider-dev.crt \
+#     --key certs/dev/provider-dev.key https://localhost:8080/api/v1/...
+
+set -euo pipefail
+
+OUT_DIR="${1:-certs/dev}"
+DAYS=365
+SERVICES="api-gateway eligibility-service terminology-service wallet-service analytics-service automation-service"
+
+mkdir -p "$OUT_DIR"
+cd "$OUT_DIR"
+
+echo "🔐 Generating development certificates in $OUT_DIR"
+
+# Certificate authority
+if [ ! -f ca.key ]; then
+    openssl req -x509 -newkey rsa:4096 -nodes -sha256 -days $((DAYS * 5)) \
+        -subj "/O=NPHIES Development/CN=NPHIES Dev CA" \
+        -keyout ca.key -out ca.crt 2>/dev/null
+    echo "   ✅ CA"
+fi
+
+# issue NAME SUBJECT SAN EXTENDED_KEY_USAGE
+issue() {
+    local name=$1 subject=$2 san=$3 usage=$4
+
+    openssl req -newkey rsa:2048 -nodes -sha256 \
+        -subj "$subject" -keyout "$name.key" -out "$name.csr" 2>/dev/null
+    openssl x509 -req -sha256 -days $DAYS -in "$name.csr" \
+        -CA ca.crt -CAkey ca.key -CAcreateserial -out "$name.crt" \
+        -extfile <(printf "subjectAltName=%s\nextendedKeyUsage=%s\n" "$san" "$usage") 2>/dev/null
+    rm -f "$name.csr"
+    echo "   ✅ $name"
+}
+
+# Server certificates, valid for the compose service name and localhost.
+# Services are also clients of each other, so both usages are included.
+for service in $SERVICES; do
+    issue "$service" "/O=NPHIES Development/CN=$service" \
+        "DNS:$service,DNS:localhost,IP:127.0.0.1" "serverAuth,clientAuth"
+done
+
+# Client certificate for a sample provider
+issue provider-dev "/O=Development Clinic/CN=provider-dev" \
+    "URI:spiffe://nphies/provider/PRV001" "clientAuth"
+
+chmod 600 *.key
+echo "✨ Done"
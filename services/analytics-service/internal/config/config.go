@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -12,12 +13,23 @@ type Config struct {
 	ML        MLConfig        `json:"ml"`
 	Privacy   PrivacyConfig   `json:"privacy"`
 	IoT       IoTConfig       `json:"iot"`
+	Tracing   TracingConfig   `json:"tracing"`
 	Logging   LoggingConfig   `json:"logging"`
 }
 
 type ServerConfig struct {
-	Port string `json:"port"`
-	Mode string `json:"mode"`
+	Port string    `json:"port"`
+	Mode string    `json:"mode"`
+	TLS  TLSConfig `json:"tls"`
+}
+
+type TLSConfig struct {
+	Enabled        bool     `json:"enabled"` // serve HTTPS; implied by MTLS
+	MTLS           bool     `json:"mtls"`    // require verified client certificates
+	CertFile       string   `json:"cert_file"`
+	KeyFile        string   `json:"key_file"`
+	CAFiles        []string `json:"ca_files"`        // CA bundles that issue client certificates
+	ReloadInterval int      `json:"reload_interval"` // seconds between certificate file checks
 }
 
 type DatabaseConfig struct {
@@ -57,12 +69,35 @@ type IoTConfig struct {
 	MQTTPassword string `json:"mqtt_password"`
 }
 
+type TracingConfig struct {
+	Enabled     bool    `json:"enabled"`
+	Exporter    string  `json:"exporter"` // otlp, stdout or none
+	Endpoint    string  `json:"endpoint"`
+	Insecure    bool    `json:"insecure"`
+	SampleRatio float64 `json:"sample_ratio"`
+	Environment string  `json:"environment"`
+}
+
 type LoggingConfig struct {
-	Level  string `json:"level"`
-	Format string `json:"format"`
+	Level     string          `json:"level"`
+	Format    string          `json:"format"`
+	Redaction RedactionConfig `json:"redaction"`
+}
+
+type RedactionConfig struct {
+	Enabled       bool     `json:"enabled"`
+	Mode          string   `json:"mode"` // mask or tokenize
+	AllowlistMode bool     `json:"allowlist_mode"`
+	Fields        []string `json:"fields"`
+	Allowlist     []string `json:"allowlist"`
+	TokenKey      string   `json:"-"`
 }
 
 func Load() (*Config, error) {
+	sampleRatio, _ := strconv.ParseFloat(getEnv("OTEL_TRACES_SAMPLER_ARG", "1.0"), 64)
+	environment := getEnv("ENVIRONMENT", "development")
+	mtlsEnabled := getEnv("ENABLE_MTLS", "false") == "true"
+	reloadInterval, _ := strconv.Atoi(getEnv("TLS_RELOAD_INTERVAL", "30"))
 	batchSize, _ := strconv.Atoi(getEnv("ML_BATCH_SIZE", "100"))
 	scoreThreshold, _ := strconv.ParseFloat(getEnv("ML_SCORE_THRESHOLD", "0.8"), 64)
 	epsilon, _ := strconv.ParseFloat(getEnv("PRIVACY_EPSILON", "1.0"), 64)
@@ -73,6 +108,14 @@ func Load() (*Config, error) {
 		Server: ServerConfig{
 			Port: getEnv("PORT", "8094"),
 			Mode: getEnv("GIN_MODE", "debug"),
+			TLS: TLSConfig{
+				Enabled:        getEnv("TLS_ENABLED", strconv.FormatBool(mtlsEnabled)) == "true",
+				MTLS:           mtlsEnabled,
+				CertFile:       getEnv("TLS_CERT_PATH", ""),
+				KeyFile:        getEnv("TLS_KEY_PATH", ""),
+				CAFiles:        getEnvList("TLS_CA_CERTS"),
+				ReloadInterval: reloadInterval,
+			},
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -106,9 +149,27 @@ func Load() (*Config, error) {
 			MQTTUsername: getEnv("IOT_MQTT_USERNAME", ""),
 			MQTTPassword: getEnv("IOT_MQTT_PASSWORD", ""),
 		},
+		Tracing: TracingConfig{
+			Enabled:     getEnv("TRACING_ENABLED", "true") == "true",
+			Exporter:    getEnv("OTEL_TRACES_EXPORTER", "otlp"),
+			Endpoint:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
+			Insecure:    getEnv("OTEL_EXPORTER_OTLP_INSECURE", "true") == "true",
+			SampleRatio: sampleRatio,
+			Environment: environment,
+		},
 		Logging: LoggingConfig{
 			Level:  getEnv("LOG_LEVEL", "info"),
 			Format: getEnv("LOG_FORMAT", "json"),
+			// Redaction is on outside development; production logs only
+			// allowlisted fields as-is
+			Redaction: RedactionConfig{
+				Enabled:       getEnv("LOG_REDACTION_ENABLED", strconv.FormatBool(environment != "development")) == "true",
+				Mode:          getEnv("LOG_REDACTION_MODE", "mask"),
+				AllowlistMode: getEnv("LOG_REDACTION_ALLOWLIST_MODE", strconv.FormatBool(environment == "production")) == "true",
+				Fields:        getEnvList("LOG_REDACTION_FIELDS"),
+				Allowlist:     getEnvList("LOG_REDACTION_ALLOWLIST"),
+				TokenKey:      getEnv("LOG_REDACTION_TOKEN_KEY", ""),
+			},
 		},
 	}, nil
 }
@@ -118,4 +179,14 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
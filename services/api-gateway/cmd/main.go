@@ -10,8 +10,14 @@ import (
 	"time"
 
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/config"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/consent"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/handlers"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/middleware"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/mtls"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/policy"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/redact"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -37,6 +43,17 @@ import (
 // @scope.write Grants write access
 // @scope.admin Grants admin access
 
+// logAllowlist are the log fields of the service that are not PHI, logged
+// as-is in allowlist mode in addition to those of every service
+var logAllowlist = []string{
+	"origin", "topic", "partition", "offset", "purged", "event_id", "event_type", "duplicate",
+	"checkpoint_id", "provider_id", "hash", "system", "code", "subject", "serial", "policy", "reason",
+	"key_prefix", "api_key_id", "tenant_id", "requested_tenant_id", "role", "action", "resource",
+	"session_id", "reason_code", "recipient", "data_type", "purpose", "permit", "rows", "created",
+	"subscription_id", "notification_id", "attempts", "status_code", "heartbeats", "ended",
+	"job_id", "level", "files",
+}
+
 func main() {
 	// Load configuration
 	cfg, err := config.Load()
@@ -48,6 +65,20 @@ func main() {
 	logger := logrus.New()
 	logger.SetLevel(logrus.DebugLevel)
 	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(requestid.LogHook{})
+
+	// Redaction runs last so that it also covers fields added by other hooks
+	if err := redact.Install(logger, redact.Config{
+		Enabled:          cfg.Redaction.Enabled,
+		Mode:             cfg.Redaction.Mode,
+		AllowlistMode:    cfg.Redaction.AllowlistMode,
+		Fields:           cfg.Redaction.Fields,
+		ServiceAllowlist: logAllowlist,
+		Allowlist:        cfg.Redaction.Allowlist,
+		TokenKey:         cfg.Redaction.TokenKey,
+	}); err != nil {
+		logger.Fatalf("Failed to configure log redaction: %v", err)
+	}
 
 	// Set Gin mode
 	if cfg.Environment == "production" {
@@ -55,6 +86,21 @@ func main() {
 		logger.SetLevel(logrus.InfoLevel)
 	}
 
+	// Initialize tracing before anything that creates spans
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Enabled:        cfg.Monitoring.TracingEnabled,
+		ServiceName:    "api-gateway",
+		ServiceVersion: "1.0.0",
+		Environment:    cfg.Environment,
+		Exporter:       cfg.Monitoring.TraceExporter,
+		Endpoint:       cfg.Monitoring.OTLPEndpoint,
+		Insecure:       cfg.Monitoring.OTLPInsecure,
+		SampleRatio:    cfg.Monitoring.TraceSampleRatio,
+	})
+	if err != nil {
+		logger.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	// Initialize handlers
 	h, err := handlers.NewHandler(cfg, logger)
 	if err != nil {
@@ -62,8 +108,19 @@ func main() {
 	}
 	defer h.Close()
 
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	h.StartWorkers(workerCtx)
+
+	// Registered client certificates
+	clients, err := mtls.ParseClientMap(cfg.Security.ClientMap)
+	if err != nil {
+		logger.Fatalf("Failed to parse client certificate mapping: %v", err)
+	}
+
 	// Setup router
-	router := setupRouter(cfg, h, logger)
+	router := setupRouter(cfg, h, logger, clients)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -74,10 +131,31 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Serve TLS, verifying client certificates with mTLS. Certificates are
+	// reloaded when their files change.
+	if cfg.Security.TLSEnabled || cfg.Security.EnableMTLS {
+		if cfg.Security.EnableMTLS && len(cfg.Security.TrustedCACerts) == 0 {
+			logger.Fatal("ENABLE_MTLS requires TLS_CA_CERTS")
+		}
+		serverTLS, err := mtls.NewStore(cfg.Security.TLSCertPath, cfg.Security.TLSKeyPath, cfg.Security.TrustedCACerts, logger)
+		if err != nil {
+			logger.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+		go serverTLS.Watch(workerCtx, time.Duration(cfg.Security.ReloadInterval)*time.Second)
+		srv.TLSConfig = serverTLS.ServerConfig(cfg.Security.EnableMTLS)
+	}
+
 	// Start server in a goroutine
 	go func() {
-		logger.Infof("Starting NPHIES API Gateway on port %s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			logger.Infof("Starting NPHIES API Gateway on port %s with TLS (mTLS: %t)", cfg.Port, cfg.Security.EnableMTLS)
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			logger.Infof("Starting NPHIES API Gateway on port %s", cfg.Port)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -88,6 +166,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down server...")
+	stopWorkers()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -97,19 +176,29 @@ func main() {
 		logger.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Errorf("Failed to flush traces: %v", err)
+	}
+
 	logger.Info("Server exited")
 }
 
-func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger) *gin.Engine {
+func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger, clients map[string]string) *gin.Engine {
 	router := gin.New()
 
 	// Middleware
+	router.Use(tracing.Middleware("api-gateway"))
+	router.Use(requestid.Middleware())
 	router.Use(middleware.LoggerMiddleware(logger))
 	router.Use(middleware.RecoveryMiddleware(logger))
-	router.Use(middleware.CORSMiddleware())
+	if cfg.Security.EnableMTLS {
+		router.Use(middleware.ClientCertMiddleware(clients, logger))
+	}
+	router.Use(middleware.CORSMiddleware(logger, corsPolicies(cfg)...))
 	router.Use(middleware.SecurityHeadersMiddleware())
 	router.Use(middleware.RateLimitMiddleware(cfg.RateLimit))
-	router.Use(middleware.MetricsMiddleware())
+	metrics := h.Metrics()
+	router.Use(middleware.MetricsMiddleware(metrics.RequestsTotal, metrics.RequestDuration, metrics.ActiveRequests))
 
 	// Health checks
 	router.GET("/health", h.HealthCheck)
@@ -118,82 +207,145 @@ func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger)
 
 	// API routes
 	v1 := router.Group("/api/v1")
+	apiKeys, quota := h.APIKeys()
+	v1.Use(middleware.APIKeyMiddleware(apiKeys, quota, logger))
+	tenantScope := middleware.TenantMiddleware(cfg.Tenancy.Required, h.AuditCrossTenantAccess, logger)
+	authz := middleware.NewAuthorizer(h.Policies(), h.AuditAccessDenied, logger)
+	breakGlass := middleware.BreakGlassMiddleware(h.RecordBreakGlassUse, logger)
+	consentGuard := middleware.NewConsentGuard(h.Consents(), cfg.Consent.ExemptRoles, cfg.Consent.DefaultPurpose, h.AuditConsentDenied, logger)
 	{
 		// Authentication
 		auth := v1.Group("/auth")
 		{
 			auth.POST("/token", h.GetToken)
 			auth.POST("/refresh", h.RefreshToken)
+
+			// Emergency access outside the caller's usual scope
+			if cfg.BreakGlass.Enabled {
+				auth.POST("/break-glass", middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass,
+					authz.Require(policy.ResourceBreakGlass), h.RequestBreakGlass)
+			}
 		}
 
 		// FHIR Resources - protected endpoints
 		fhirGroup := v1.Group("/fhir")
-		fhirGroup.Use(middleware.AuthMiddleware(cfg.JWT.Secret))
+		fhirGroup.Use(middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass, tenantScope)
 		{
 			// Patient endpoints
-			patients := fhirGroup.Group("/Patient")
+			patients := fhirGroup.Group("/Patient", authz.Require(policy.ResourcePatient))
 			{
-				patients.GET("", h.SearchPatients)
+				patients.GET("", consentGuard.Require(consent.DataTypeDemographics, middleware.QueryMember("_id", "identifier")), h.SearchPatients)
 				patients.POST("", h.CreatePatient)
-				patients.GET("/:id", h.GetPatient)
+				patients.GET("/:id", consentGuard.Require(consent.DataTypeDemographics, middleware.PathMember("id")), h.GetPatient)
 				patients.PUT("/:id", h.UpdatePatient)
 				patients.DELETE("/:id", h.DeletePatient)
+				patients.GET("/:id/$everything",
+					consentGuard.Require(consent.DataTypeDemographics, middleware.PathMember("id")),
+					consentGuard.Require(consent.DataTypeCoverage, middleware.PathMember("id")),
+					consentGuard.Require(consent.DataTypeClaims, middleware.PathMember("id")),
+					h.PatientEverything)
 			}
 
-			// Coverage endpoints
-			coverage := fhirGroup.Group("/Coverage")
+			// $match only reads patients; consent is checked per candidate
+			fhirGroup.POST("/Patient/$match", authz.RequireAction(policy.ResourcePatient, policy.ActionRead), consentGuard.Defer(consent.DataTypeDemographics), h.MatchPatients)
+
+			// Coverage endpoints. Reads by ID only learn the member from the
+			// resource, so their handlers check consent.
+			coverage := fhirGroup.Group("/Coverage", authz.Require(policy.ResourceCoverage))
 			{
-				coverage.GET("", h.SearchCoverage)
+				coverage.GET("", consentGuard.Require(consent.DataTypeCoverage, middleware.QueryMember("beneficiary", "patient")), h.SearchCoverage)
 				coverage.POST("", h.CreateCoverage)
-				coverage.GET("/:id", h.GetCoverage)
+				coverage.GET("/:id", consentGuard.Defer(consent.DataTypeCoverage), h.GetCoverage)
 				coverage.PUT("/:id", h.UpdateCoverage)
 				coverage.DELETE("/:id", h.DeleteCoverage)
 			}
 
 			// Claim endpoints
-			claims := fhirGroup.Group("/Claim")
+			claims := fhirGroup.Group("/Claim", authz.Require(policy.ResourceClaim))
 			{
-				claims.GET("", h.SearchClaims)
+				claims.GET("", consentGuard.Require(consent.DataTypeClaims, middleware.QueryMember("patient")), h.SearchClaims)
 				claims.POST("", h.CreateClaim)
-				claims.GET("/:id", h.GetClaim)
+				claims.GET("/:id", consentGuard.Defer(consent.DataTypeClaims), h.GetClaim)
 				claims.PUT("/:id", h.UpdateClaim)
 				claims.DELETE("/:id", h.DeleteClaim)
 			}
 
 			// ClaimResponse endpoints
-			claimResponses := fhirGroup.Group("/ClaimResponse")
+			claimResponses := fhirGroup.Group("/ClaimResponse", authz.Require(policy.ResourceClaimResponse))
 			{
-				claimResponses.GET("", h.SearchClaimResponses)
-				claimResponses.GET("/:id", h.GetClaimResponse)
+				claimResponses.GET("", consentGuard.Require(consent.DataTypeClaims, middleware.QueryMember("patient")), h.SearchClaimResponses)
+				claimResponses.GET("/:id", consentGuard.Defer(consent.DataTypeClaims), h.GetClaimResponse)
 			}
 
 			// Prior Authorization endpoints
-			priorAuth := fhirGroup.Group("/CoverageEligibilityRequest")
+			priorAuth := fhirGroup.Group("/CoverageEligibilityRequest", authz.Require(policy.ResourcePriorAuth))
 			{
 				priorAuth.GET("", h.SearchPriorAuthorizations)
 				priorAuth.POST("", h.CreatePriorAuthorization)
 				priorAuth.GET("/:id", h.GetPriorAuthorization)
 				priorAuth.PUT("/:id", h.UpdatePriorAuthorization)
 			}
+
+			// Subscription endpoints
+			if cfg.Subscriptions.Enabled {
+				subscriptions := fhirGroup.Group("/Subscription", authz.Require(policy.ResourceSubscription))
+				{
+					subscriptions.GET("", h.ListSubscriptions)
+					subscriptions.POST("", h.CreateSubscription)
+					subscriptions.GET("/:id", h.GetSubscription)
+					subscriptions.GET("/:id/$status", h.GetSubscriptionStatus)
+					subscriptions.DELETE("/:id", h.DeleteSubscription)
+				}
+			}
+
+			// Bulk Data export endpoints
+			if cfg.BulkExport.Enabled {
+				bulkExport := authz.Require(policy.ResourceBulkExport)
+				fhirGroup.GET("/$export", bulkExport, h.ExportSystem)
+				fhirGroup.GET("/Patient/$export", bulkExport, h.ExportPatients)
+				fhirGroup.GET("/Group/:id/$export", bulkExport, h.ExportGroup)
+				fhirGroup.GET("/bulk/status/:id", bulkExport, h.GetExportStatus)
+				fhirGroup.DELETE("/bulk/status/:id", bulkExport, h.CancelExport)
+				fhirGroup.GET("/bulk/files/:id/:file", bulkExport,
+					middleware.TransferDeadline(time.Duration(cfg.BulkExport.FileTimeoutSeconds)*time.Second), h.GetExportFile)
+			}
+		}
+
+		// Poll endpoints for asynchronous responses
+		pollGroup := v1.Group("/poll").Use(middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass, tenantScope, authz.Require(policy.ResourcePoll))
+		{
+			pollGroup.POST("", h.PollMessages)
+			pollGroup.POST("/ack", h.AcknowledgePollMessages)
 		}
 
 		// Eligibility Service Proxy
-		eligibility := v1.Group("/eligibility").Use(middleware.AuthMiddleware(cfg.JWT.Secret))
+		eligibility := v1.Group("/eligibility").Use(middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass, tenantScope, authz.Require(policy.ResourceEligibility))
 		{
 			eligibility.POST("/check", h.CheckEligibility)
-			eligibility.GET("/member/:id/coverage", h.GetMemberCoverage)
+			eligibility.GET("/member/:id/coverage", consentGuard.Require(consent.DataTypeCoverage, middleware.PathMember("id")), h.GetMemberCoverage)
 		}
 
 		// Claims Service Proxy
-		claimsProxy := v1.Group("/claims").Use(middleware.AuthMiddleware(cfg.JWT.Secret))
+		claimsProxy := v1.Group("/claims").Use(middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass, tenantScope, authz.Require(policy.ResourceClaimsProxy))
 		{
 			claimsProxy.POST("/submit", h.SubmitClaim)
 			claimsProxy.GET("/:id/status", h.GetClaimStatus)
 			claimsProxy.POST("/:id/reprocess", h.ReprocessClaim)
 		}
 
+		// Claim attachments
+		if cfg.Attachments.Enabled {
+			attachments := v1.Group("/attachments").Use(middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass, tenantScope, authz.Require(policy.ResourceAttachment))
+			{
+				transfer := middleware.TransferDeadline(time.Duration(cfg.Attachments.TransferTimeoutSeconds) * time.Second)
+				attachments.POST("", transfer, h.UploadAttachment)
+				attachments.GET("/:id", h.GetAttachment)
+				attachments.GET("/:id/content", transfer, h.GetAttachmentContent)
+			}
+		}
+
 		// Terminology Service Proxy
-		terminology := v1.Group("/terminology").Use(middleware.AuthMiddleware(cfg.JWT.Secret))
+		terminology := v1.Group("/terminology").Use(middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass, authz.Require(policy.ResourceTerminology))
 		{
 			terminology.GET("/codesystems", h.GetCodeSystems)
 			terminology.GET("/codesystems/:system/codes/:code", h.LookupCode)
@@ -201,11 +353,32 @@ func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger)
 		}
 
 		// Administrative endpoints
-		admin := v1.Group("/admin").Use(middleware.AuthMiddleware(cfg.JWT.Secret), middleware.AdminMiddleware())
+		admin := v1.Group("/admin").Use(middleware.AuthMiddleware(cfg.JWT.Secret), breakGlass)
 		{
-			admin.GET("/stats", h.GetSystemStats)
-			admin.GET("/audit", h.GetAuditLogs)
-			admin.POST("/cache/clear", h.ClearCache)
+			admin.GET("/stats", authz.Require(policy.ResourceAdminStats), h.GetSystemStats)
+			admin.GET("/audit", authz.Require(policy.ResourceAdminAudit), h.GetAuditLogs)
+			admin.GET("/audit/verify", authz.Require(policy.ResourceAdminAudit), h.VerifyAuditLogs)
+			admin.POST("/cache/clear", authz.Require(policy.ResourceAdminCache), h.ClearCache)
+
+			// API keys for provider and payer integrations
+			apiKeyAccess := authz.Require(policy.ResourceAdminAPIKeys)
+			admin.POST("/api-keys", apiKeyAccess, h.CreateAPIKey)
+			admin.GET("/api-keys", apiKeyAccess, h.ListAPIKeys)
+			admin.GET("/api-keys/:id", apiKeyAccess, h.GetAPIKey)
+			admin.PATCH("/api-keys/:id", apiKeyAccess, h.UpdateAPIKey)
+			admin.DELETE("/api-keys/:id", apiKeyAccess, h.RevokeAPIKey)
+			admin.POST("/api-keys/:id/rotate", apiKeyAccess, h.RotateAPIKey)
+
+			// Access policies; evaluation is a dry run and only reads them
+			admin.GET("/policies", authz.Require(policy.ResourceAdminPolicies), h.ListPolicies)
+			admin.POST("/policies/evaluate", authz.RequireAction(policy.ResourceAdminPolicies, policy.ActionRead), h.EvaluatePolicy)
+
+			// Break-glass review queue
+			breakGlassAccess := authz.Require(policy.ResourceAdminBreakGlass)
+			admin.GET("/break-glass", breakGlassAccess, h.ListBreakGlassSessions)
+			admin.GET("/break-glass/:id", breakGlassAccess, h.GetBreakGlassSession)
+			admin.POST("/break-glass/:id/review", breakGlassAccess, h.ReviewBreakGlassSession)
+			admin.DELETE("/break-glass/:id", breakGlassAccess, h.RevokeBreakGlassSession)
 		}
 	}
 
@@ -214,4 +387,40 @@ func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger)
 	// router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	return router
-}
\ No newline at end of file
+}
+
+// corsPolicies returns the CORS policies of the route groups: administrative
+// endpoints only accept their own origins, everything else the public portal
+// origins. Health and metrics endpoints are not meant for browsers.
+func corsPolicies(cfg *config.Config) []middleware.CORSPolicy {
+	methods := []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	// Bulk export status is in Content-Location and X-Progress, attachment
+	// downloads carry their hash and file name
+	exposed := []string{
+		"X-Request-ID", "Location", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Retry-After",
+		"Content-Location", "X-Progress", "ETag", "Content-Disposition",
+	}
+
+	return []middleware.CORSPolicy{
+		{
+			Name:             "admin",
+			PathPrefix:       "/api/v1/admin",
+			AllowedOrigins:   cfg.CORS.AdminAllowedOrigins,
+			AllowedMethods:   methods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   exposed,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           cfg.CORS.MaxAge,
+		},
+		{
+			Name:             "public",
+			PathPrefix:       "/api/",
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   methods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   exposed,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           cfg.CORS.MaxAge,
+		},
+	}
+}
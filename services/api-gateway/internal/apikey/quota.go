@@ -0,0 +1,66 @@
+package apikey
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/cache"
+)
+
+// QuotaResult is the outcome of counting a request against a key's quota
+type QuotaResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until the current window ends
+}
+
+// Quota enforces per-key request quotas in fixed one-minute windows. Counters
+// live in Redis, so the quota is shared by all gateway replicas.
+type Quota struct {
+	cache        *cache.Manager
+	defaultLimit int
+}
+
+// NewQuota creates a quota enforcer. Keys without a quota of their own get
+// defaultLimit requests per minute; 0 means unlimited. cache must not share
+// its prefix with cached data, whose keys the invalidation API deletes.
+func NewQuota(cache *cache.Manager, defaultLimit int) *Quota {
+	return &Quota{
+		cache:        cache,
+		defaultLimit: defaultLimit,
+	}
+}
+
+// Allow counts a request against the key's quota
+func (q *Quota) Allow(ctx context.Context, key *Key) (QuotaResult, error) {
+	limit := key.QuotaPerMinute
+	if limit <= 0 {
+		limit = q.defaultLimit
+	}
+	if limit <= 0 {
+		return QuotaResult{Allowed: true}, nil
+	}
+
+	now := time.Now()
+	window := now.Truncate(time.Minute)
+	retryAfter := window.Add(time.Minute).Sub(now)
+
+	count, err := q.cache.IncrementCounterWithTTL(ctx,
+		cache.Key("apikey-quota", key.ID, strconv.FormatInt(window.Unix(), 10)), 2*time.Minute)
+	if err != nil {
+		return QuotaResult{Allowed: true, Limit: limit, Remaining: limit}, err
+	}
+
+	remaining := limit - int(count)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return QuotaResult{
+		Allowed:    int(count) <= limit,
+		Limit:      limit,
+		Remaining:  remaining,
+		RetryAfter: retryAfter,
+	}, nil
+}
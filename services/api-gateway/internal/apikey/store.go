@@ -0,0 +1,438 @@
+// Package apikey issues and authenticates static API keys for provider and
+// payer systems that cannot use OAuth. Keys are bound to an organization and
+// only an HMAC of each key is stored; the key itself is shown once, when it is
+// issued or rotated.
+package apikey
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Scopes
+const (
+	// ScopeRead allows GET and HEAD requests
+	ScopeRead = "read"
+	// ScopeWrite allows every other method
+	ScopeWrite = "write"
+)
+
+// Key statuses
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+)
+
+// keyPrefix starts every key, so that leaked keys are easy to recognize
+const keyPrefix = "nphies_"
+
+// prefixLength is the length of the lookup prefix, keyPrefix and 12 hex digits
+const prefixLength = len(keyPrefix) + 12
+
+var (
+	// ErrInvalidKey is returned for malformed, unknown or mismatching keys
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrKeyRevoked is returned for revoked keys
+	ErrKeyRevoked = errors.New("API key revoked")
+	// ErrKeyExpired is returned for expired keys, including rotated keys past
+	// their grace period
+	ErrKeyExpired = errors.New("API key expired")
+	// ErrOrganizationInactive is returned when the key's organization is not active
+	ErrOrganizationInactive = errors.New("organization is not active")
+	// ErrOrganizationNotFound is returned when issuing a key for an unknown
+	// or inactive organization
+	ErrOrganizationNotFound = errors.New("organization not found")
+	// ErrNotFound is returned for unknown key IDs
+	ErrNotFound = errors.New("API key not found")
+)
+
+// Key is an issued API key. The secret part is never stored.
+type Key struct {
+	ID                     string
+	OrganizationID         string
+	OrganizationIdentifier string
+	OrganizationType       string // payer, provider or regulator
+	Name                   string
+	Prefix                 string
+	Scopes                 []string
+	QuotaPerMinute         int // 0 uses the default quota
+	Status                 string
+	ExpiresAt              *time.Time
+	RotatedFrom            string
+	CreatedBy              string
+	CreatedAt              time.Time
+	RevokedAt              *time.Time
+	LastUsedAt             *time.Time
+	LastUsedIP             string
+}
+
+// HasScope reports whether the key was granted scope
+func (k *Key) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewKey describes a key to issue
+type NewKey struct {
+	OrganizationID string
+	Name           string
+	Scopes         []string
+	QuotaPerMinute int
+	ExpiresAt      *time.Time
+	CreatedBy      string
+}
+
+// Filter selects keys. Zero values are ignored.
+type Filter struct {
+	OrganizationID string
+	Status         string
+}
+
+// ValidScope reports whether scope can be granted to a key
+func ValidScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeWrite
+}
+
+// Store persists API keys
+type Store struct {
+	db     *sql.DB
+	logger *logrus.Logger
+	pepper []byte
+
+	mu    sync.Mutex
+	usage map[string]usage // pending last-used updates by key ID
+}
+
+// NewStore creates a new API key store. The pepper keys the stored hashes, so
+// that a leaked table cannot be checked against guessed keys without it.
+func NewStore(db *sql.DB, logger *logrus.Logger, pepper string) *Store {
+	return &Store{
+		db:     db,
+		logger: logger,
+		pepper: []byte(pepper),
+		usage:  make(map[string]usage),
+	}
+}
+
+// Create issues a key for an active organization and returns it together
+// with the full key, which cannot be recovered later
+func (s *Store) Create(ctx context.Context, newKey NewKey) (*Key, string, error) {
+	return s.create(ctx, s.db, newKey, "")
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *Store) create(ctx context.Context, q queryer, newKey NewKey, rotatedFrom string) (*Key, string, error) {
+	prefix, fullKey, err := generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `
+		INSERT INTO api_keys (
+			organization_id, name, prefix, key_hash, scopes, quota_per_minute,
+			expires_at, rotated_from, created_by
+		)
+		SELECT o.id, $2, $3, $4, $5, $6, $7, $8, $9
+		FROM organizations o
+		WHERE o.id = $1 AND o.status = 'active'
+		RETURNING id
+	`
+
+	var id string
+	err = q.QueryRowContext(ctx, query,
+		newKey.OrganizationID,
+		newKey.Name,
+		prefix,
+		s.hash(fullKey),
+		pq.Array(newKey.Scopes),
+		newKey.QuotaPerMinute,
+		newKey.ExpiresAt,
+		nullString(rotatedFrom),
+		nullString(newKey.CreatedBy),
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := s.get(ctx, q, "k.id = $1", id)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, fullKey, nil
+}
+
+// Get returns a key by ID
+func (s *Store) Get(ctx context.Context, id string) (*Key, error) {
+	return s.get(ctx, s.db, "k.id = $1", id)
+}
+
+// List returns the keys matching filter, newest first
+func (s *Store) List(ctx context.Context, filter Filter) ([]Key, error) {
+	query := `SELECT ` + keyColumns + ` FROM api_keys k
+		JOIN organizations o ON o.id = k.organization_id
+		WHERE ($1 = '' OR k.organization_id::text = $1)
+		  AND ($2 = '' OR k.status = $2)
+		ORDER BY k.created_at DESC
+		LIMIT 1000`
+
+	rows, err := s.db.QueryContext(ctx, query, filter.OrganizationID, filter.Status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []Key{}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Rotate issues a replacement for an active key with the same organization,
+// scopes and quota. The old key keeps working for the grace period, so that
+// clients can switch without downtime.
+func (s *Store) Rotate(ctx context.Context, id string, grace time.Duration, actor string) (*Key, string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	old, err := s.get(ctx, tx, "k.id = $1 FOR UPDATE OF k", id)
+	if err != nil {
+		return nil, "", err
+	}
+	if old.Status != StatusActive {
+		return nil, "", ErrKeyRevoked
+	}
+	if old.ExpiresAt != nil && !old.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrKeyExpired
+	}
+
+	key, fullKey, err := s.create(ctx, tx, NewKey{
+		OrganizationID: old.OrganizationID,
+		Name:           old.Name,
+		Scopes:         old.Scopes,
+		QuotaPerMinute: old.QuotaPerMinute,
+		ExpiresAt:      old.ExpiresAt,
+		CreatedBy:      actor,
+	}, old.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, 'infinity'), $2)
+		WHERE id = $1
+	`, old.ID, time.Now().Add(grace)); err != nil {
+		return nil, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	return key, fullKey, nil
+}
+
+// Update changes the scopes and quota of a key. Nil values are left unchanged.
+func (s *Store) Update(ctx context.Context, id string, scopes []string, quotaPerMinute *int) (*Key, error) {
+	var scopeArg interface{}
+	if scopes != nil {
+		scopeArg = pq.Array(scopes)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE api_keys SET
+			scopes = COALESCE($2, scopes),
+			quota_per_minute = COALESCE($3, quota_per_minute)
+		WHERE id = $1
+	`, id, scopeArg, quotaPerMinute)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrNotFound
+	}
+	return s.Get(ctx, id)
+}
+
+// Revoke disables a key immediately. Revoking a revoked key is a no-op.
+func (s *Store) Revoke(ctx context.Context, id, actor string) (*Key, error) {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE api_keys SET status = $2, revoked_at = NOW(), revoked_by = $3
+		WHERE id = $1 AND status <> $2
+	`, id, StatusRevoked, nullString(actor)); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Authenticate resolves a presented key. Unknown and mismatching keys both
+// yield ErrInvalidKey, so that responses do not reveal which prefixes exist.
+// Successful uses are recorded for last-used tracking.
+func (s *Store) Authenticate(ctx context.Context, presented, clientIP string) (*Key, error) {
+	prefix, ok := parse(presented)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+
+	var keyHash, orgStatus string
+	key, err := s.get(ctx, s.db, "k.prefix = $1", prefix, &keyHash, &orgStatus)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal([]byte(keyHash), []byte(s.hash(presented))) {
+		return nil, ErrInvalidKey
+	}
+	if key.Status != StatusActive {
+		return nil, ErrKeyRevoked
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return nil, ErrKeyExpired
+	}
+	if orgStatus != "active" {
+		return nil, ErrOrganizationInactive
+	}
+
+	s.recordUse(key.ID, clientIP)
+	return key, nil
+}
+
+// Prefix returns the public part of a presented key for logging, or "" if it
+// is malformed
+func Prefix(presented string) string {
+	prefix, _ := parse(presented)
+	return prefix
+}
+
+// keyColumns are the columns read by scanKey, in order
+const keyColumns = `k.id, k.organization_id, o.identifier, o.type, k.name, k.prefix, k.scopes,
+	k.quota_per_minute, k.status, k.expires_at, k.rotated_from, k.created_by, k.created_at,
+	k.revoked_at, k.last_used_at, host(k.last_used_ip)`
+
+// get returns the key matching condition, reading the key hash and
+// organization status into extra when given
+func (s *Store) get(ctx context.Context, q queryer, condition string, arg interface{}, extra ...interface{}) (*Key, error) {
+	columns := keyColumns
+	if len(extra) > 0 {
+		columns += `, k.key_hash, o.status`
+	}
+	query := `SELECT ` + columns + ` FROM api_keys k
+		JOIN organizations o ON o.id = k.organization_id
+		WHERE ` + condition
+
+	key, err := scanKey(q.QueryRowContext(ctx, query, arg), extra...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanKey reads keyColumns followed by any extra destinations
+func scanKey(row scanner, extra ...interface{}) (*Key, error) {
+	var key Key
+	var orgType, rotatedFrom, createdBy, lastUsedIP sql.NullString
+	var expiresAt, revokedAt, lastUsedAt sql.NullTime
+
+	dest := []interface{}{
+		&key.ID,
+		&key.OrganizationID,
+		&key.OrganizationIdentifier,
+		&orgType,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.QuotaPerMinute,
+		&key.Status,
+		&expiresAt,
+		&rotatedFrom,
+		&createdBy,
+		&key.CreatedAt,
+		&revokedAt,
+		&lastUsedAt,
+		&lastUsedIP,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	key.OrganizationType = orgType.String
+	key.RotatedFrom = rotatedFrom.String
+	key.CreatedBy = createdBy.String
+	key.LastUsedIP = lastUsedIP.String
+	key.ExpiresAt = nullTime(expiresAt)
+	key.RevokedAt = nullTime(revokedAt)
+	key.LastUsedAt = nullTime(lastUsedAt)
+	return &key, nil
+}
+
+// generate returns a new key as its lookup prefix and the full key,
+// "nphies_<12 hex>_<64 hex>"
+func generate() (prefix, key string, err error) {
+	random := make([]byte, 6+32)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", err
+	}
+	prefix = keyPrefix + hex.EncodeToString(random[:6])
+	return prefix, prefix + "_" + hex.EncodeToString(random[6:]), nil
+}
+
+// parse returns the lookup prefix of a presented key
+func parse(presented string) (string, bool) {
+	if len(presented) <= prefixLength+1 || !strings.HasPrefix(presented, keyPrefix) || presented[prefixLength] != '_' {
+		return "", false
+	}
+	return presented[:prefixLength], true
+}
+
+// hash returns the stored form of a key
+func (s *Store) hash(key string) string {
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
@@ -0,0 +1,72 @@
+package apikey
+
+import (
+	"context"
+	"time"
+)
+
+// usage is the most recent use of a key not yet written to the database
+type usage struct {
+	at       time.Time
+	clientIP string
+}
+
+// recordUse notes a successful authentication. Uses are written in batches by
+// RunUsageFlusher rather than on every request.
+func (s *Store) recordUse(id, clientIP string) {
+	s.mu.Lock()
+	s.usage[id] = usage{at: time.Now(), clientIP: clientIP}
+	s.mu.Unlock()
+}
+
+// FlushUsage writes pending last-used updates. Updates never move last_used_at
+// backwards, so replicas flushing out of order are harmless.
+func (s *Store) FlushUsage(ctx context.Context) error {
+	s.mu.Lock()
+	pending := s.usage
+	s.usage = make(map[string]usage)
+	s.mu.Unlock()
+
+	for id, use := range pending {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE api_keys SET last_used_at = $2, last_used_ip = $3
+			WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)
+		`, id, use.at, nullString(use.clientIP))
+		if err != nil {
+			// Keep the remaining updates for the next flush
+			s.mu.Lock()
+			for id, use := range pending {
+				if newer, ok := s.usage[id]; !ok || newer.at.Before(use.at) {
+					s.usage[id] = use
+				}
+			}
+			s.mu.Unlock()
+			return err
+		}
+		delete(pending, id)
+	}
+	return nil
+}
+
+// RunUsageFlusher periodically flushes last-used updates until the context is
+// cancelled, then flushes once more
+func (s *Store) RunUsageFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := s.FlushUsage(flushCtx); err != nil {
+				s.logger.WithError(err).Error("Failed to flush API key usage")
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := s.FlushUsage(ctx); err != nil {
+				s.logger.WithError(err).Error("Failed to flush API key usage")
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package attachment stores the documents providers attach to claims, such
+// as imaging reports and discharge summaries, so that claims reference them
+// by URL instead of carrying them base64-encoded inline. Uploads are streamed
+// into an object store while their SHA-256 hash is computed, checked against
+// the size limit and the accepted content types, sniffed from the content
+// rather than trusted from the client, scanned for malware and then recorded
+// with their metadata.
+package attachment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Scan statuses
+const (
+	ScanClean      = "clean"
+	ScanNotScanned = "not-scanned" // no scanner is configured
+)
+
+// ErrNotFound is returned for unknown attachments
+var ErrNotFound = errors.New("attachment not found")
+
+// Attachment is an uploaded document
+type Attachment struct {
+	ID             string
+	ObjectKey      string // name of the contents in the object store
+	TenantID       string
+	OrganizationID string // organization of the uploader
+	UploadedBy     string
+	Title          string
+	ContentType    string // sniffed from the contents
+	Size           int64
+	SHA256         string // hex
+	ScanStatus     string
+	Scanner        string
+	CreatedAt      time.Time
+}
+
+// Store persists attachment metadata
+type Store struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+// NewStore creates a new attachment metadata store
+func NewStore(db *sql.DB, logger *logrus.Logger) *Store {
+	return &Store{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create records an attachment whose contents are stored
+func (s *Store) Create(ctx context.Context, a *Attachment) error {
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO attachments (id, object_key, tenant_id, organization_id, uploaded_by, title,
+			content_type, size, sha256, scan_status, scanner)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING created_at
+	`,
+		a.ID,
+		a.ObjectKey,
+		nullString(a.TenantID),
+		nullString(a.OrganizationID),
+		a.UploadedBy,
+		nullString(a.Title),
+		a.ContentType,
+		a.Size,
+		a.SHA256,
+		a.ScanStatus,
+		nullString(a.Scanner),
+	).Scan(&a.CreatedAt)
+}
+
+// Get returns an attachment's metadata
+func (s *Store) Get(ctx context.Context, id string) (*Attachment, error) {
+	var a Attachment
+	var tenantID, organizationID, title, scanner sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, object_key, tenant_id, organization_id, uploaded_by, title,
+			content_type, size, sha256, scan_status, scanner, created_at
+		FROM attachments
+		WHERE id::text = $1
+	`, id).Scan(
+		&a.ID,
+		&a.ObjectKey,
+		&tenantID,
+		&organizationID,
+		&a.UploadedBy,
+		&title,
+		&a.ContentType,
+		&a.Size,
+		&a.SHA256,
+		&a.ScanStatus,
+		&scanner,
+		&a.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.TenantID = tenantID.String
+	a.OrganizationID = organizationID.String
+	a.Title = title.String
+	a.Scanner = scanner.String
+	return &a, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
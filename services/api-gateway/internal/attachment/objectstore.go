@@ -0,0 +1,109 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey is returned for object keys that are not relative paths
+// inside the store
+var ErrInvalidKey = errors.New("invalid object key")
+
+// ObjectStore holds attachment contents. Keys are slash-separated relative
+// paths such as "ab/<attachment ID>".
+type ObjectStore interface {
+	// Put stores the contents read from body under key. The object only
+	// becomes visible once body is read to its end; when reading or writing
+	// fails nothing is stored.
+	Put(ctx context.Context, key string, body io.Reader) error
+	// Get returns a reader for an object
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object. Deleting a key that does not exist is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalObjectStore keeps objects in a directory of the local filesystem.
+// With more than one gateway replica the directory must be shared.
+type LocalObjectStore struct {
+	root string
+}
+
+// NewLocalObjectStore creates a store rooted at dir, creating it if needed
+func NewLocalObjectStore(dir string) (*LocalObjectStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalObjectStore{root: dir}, nil
+}
+
+// Put implements ObjectStore. The object is written under a temporary name
+// and renamed into place once complete.
+func (s *LocalObjectStore) Put(ctx context.Context, key string, body io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".partial-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, body)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// Get implements ObjectStore
+func (s *LocalObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete implements ObjectStore
+func (s *LocalObjectStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// path maps a key to a path below the root, refusing keys that would escape
+// it
+func (s *LocalObjectStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.HasPrefix(part, ".partial-") {
+			return "", ErrInvalidKey
+		}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,114 @@
+package attachment
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scanner checks uploads for malware before they are accepted
+type Scanner interface {
+	// Name identifies the scanner in attachment metadata
+	Name() string
+	// Scan reads the contents and returns the name of the malware found in
+	// them, or "" when they are clean. An error means the contents could not
+	// be scanned.
+	Scan(ctx context.Context, body io.Reader) (string, error)
+}
+
+// clamdChunkSize is the size of the chunks streamed to clamd
+const clamdChunkSize = 64 << 10
+
+// ClamdScanner scans with a ClamAV daemon, streaming the contents with its
+// INSTREAM command. The daemon's StreamMaxLength must be at least the
+// attachment size limit.
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd listening on address,
+// either host:port or the path of a Unix socket
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	return &ClamdScanner{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// Name implements Scanner
+func (s *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan implements Scanner
+func (s *ClamdScanner) Scan(ctx context.Context, body io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return "", fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Chunks are prefixed with their length; an empty chunk ends the stream
+	writeErr := func() error {
+		if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+			return err
+		}
+		chunk := make([]byte, 4+clamdChunkSize)
+		for {
+			n, err := io.ReadFull(body, chunk[4:])
+			if n > 0 {
+				binary.BigEndian.PutUint32(chunk[:4], uint32(n))
+				if _, err := conn.Write(chunk[:4+n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err := conn.Write(make([]byte, 4))
+		return err
+	}()
+
+	// clamd answers before closing the connection when the stream exceeds
+	// its limit, so the reply is read even when writing failed
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		if writeErr != nil {
+			return "", fmt.Errorf("stream to clamd: %w", writeErr)
+		}
+		return "", fmt.Errorf("read clamd reply: %w", err)
+	}
+	reply = strings.TrimSuffix(reply, "\x00")
+
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND"), nil
+	case reply == "stream: OK" && writeErr == nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,228 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// sniffLength is the number of leading bytes content types are sniffed from
+const sniffLength = 512
+
+var (
+	// ErrEmpty is returned for uploads without content
+	ErrEmpty = errors.New("attachment is empty")
+	// ErrTooLarge is returned for uploads above the size limit
+	ErrTooLarge = errors.New("attachment exceeds the size limit")
+	// ErrScanFailed is returned when the scanner could not check an upload
+	ErrScanFailed = errors.New("attachment could not be scanned")
+)
+
+// TypeError rejects contents of a type that is not accepted, or that does
+// not match the type the client declared
+type TypeError struct {
+	ContentType string // sniffed from the contents
+	Declared    string
+}
+
+func (e *TypeError) Error() string {
+	if e.Declared != "" {
+		return fmt.Sprintf("content is %s but was declared as %s", e.ContentType, e.Declared)
+	}
+	return fmt.Sprintf("content type %s is not accepted", e.ContentType)
+}
+
+// MalwareError rejects an upload the scanner found malware in
+type MalwareError struct {
+	Signature string
+}
+
+func (e *MalwareError) Error() string {
+	return "malware found: " + e.Signature
+}
+
+// Sniff returns the media type of contents from their first bytes. DICOM and
+// TIFF, which net/http does not recognize, are detected by their magic
+// numbers. Unrecognized contents are application/octet-stream.
+func Sniff(head []byte) string {
+	switch {
+	case len(head) >= 132 && string(head[128:132]) == "DICM":
+		return "application/dicom"
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "image/tiff"
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// Options configure the checks of uploads
+type Options struct {
+	MaxSize      int64    // bytes
+	AllowedTypes []string // media types
+}
+
+// NewUpload describes an upload
+type NewUpload struct {
+	TenantID       string
+	OrganizationID string
+	UploadedBy     string
+	Title          string
+	ContentType    string // declared by the client; may be empty
+}
+
+// Service uploads attachments and reads them back
+type Service struct {
+	store   *Store
+	objects ObjectStore
+	scanner Scanner // nil when uploads are not scanned
+	logger  *logrus.Logger
+	options Options
+}
+
+// NewService creates an attachment service. With a nil scanner uploads are
+// accepted unscanned.
+func NewService(store *Store, objects ObjectStore, scanner Scanner, logger *logrus.Logger, options Options) *Service {
+	return &Service{
+		store:   store,
+		objects: objects,
+		scanner: scanner,
+		logger:  logger,
+		options: options,
+	}
+}
+
+// Upload streams body into the object store and records the attachment. The
+// contents are rejected, and nothing is kept, when they are empty, exceed the
+// size limit, are of a type that is not accepted or differs from the
+// declared one, or contain malware.
+func (s *Service) Upload(ctx context.Context, body io.Reader, upload NewUpload) (*Attachment, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return nil, ErrEmpty
+	}
+
+	contentType := Sniff(head)
+	if !s.allowed(contentType) {
+		return nil, &TypeError{ContentType: contentType}
+	}
+	if upload.ContentType != "" {
+		declared, _, err := mime.ParseMediaType(upload.ContentType)
+		if err != nil || (declared != contentType && declared != "application/octet-stream") {
+			return nil, &TypeError{ContentType: contentType, Declared: upload.ContentType}
+		}
+	}
+
+	// Objects are spread over directories by the first byte of their ID
+	id := uuid.New().String()
+	key := id[:2] + "/" + id
+	hash := sha256.New()
+	limited := &sizeLimiter{reader: io.MultiReader(bytes.NewReader(head), body), max: s.options.MaxSize}
+	if err := s.objects.Put(ctx, key, io.TeeReader(limited, hash)); err != nil {
+		if errors.Is(err, ErrTooLarge) {
+			return nil, ErrTooLarge
+		}
+		return nil, fmt.Errorf("store attachment: %w", err)
+	}
+
+	a := &Attachment{
+		ID:             id,
+		ObjectKey:      key,
+		TenantID:       upload.TenantID,
+		OrganizationID: upload.OrganizationID,
+		UploadedBy:     upload.UploadedBy,
+		Title:          upload.Title,
+		ContentType:    contentType,
+		Size:           limited.size,
+		SHA256:         hex.EncodeToString(hash.Sum(nil)),
+		ScanStatus:     ScanNotScanned,
+	}
+	if s.scanner != nil {
+		signature, err := s.scan(ctx, key)
+		if err != nil || signature != "" {
+			s.discard(key)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+			}
+			return nil, &MalwareError{Signature: signature}
+		}
+		a.ScanStatus = ScanClean
+		a.Scanner = s.scanner.Name()
+	}
+
+	if err := s.store.Create(ctx, a); err != nil {
+		s.discard(key)
+		return nil, fmt.Errorf("record attachment: %w", err)
+	}
+	return a, nil
+}
+
+// Get returns an attachment's metadata
+func (s *Service) Get(ctx context.Context, id string) (*Attachment, error) {
+	return s.store.Get(ctx, id)
+}
+
+// Open returns a reader for an attachment's contents
+func (s *Service) Open(ctx context.Context, a *Attachment) (io.ReadCloser, error) {
+	return s.objects.Get(ctx, a.ObjectKey)
+}
+
+// scan reads a stored object back through the scanner
+func (s *Service) scan(ctx context.Context, key string) (string, error) {
+	object, err := s.objects.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+	return s.scanner.Scan(ctx, object)
+}
+
+// discard deletes the contents of a rejected upload. The request may have
+// been cancelled, so its context is not used.
+func (s *Service) discard(key string) {
+	if err := s.objects.Delete(context.Background(), key); err != nil {
+		s.logger.Errorf("Failed to delete rejected attachment %s: %v", key, err)
+	}
+}
+
+func (s *Service) allowed(contentType string) bool {
+	for _, allowed := range s.options.AllowedTypes {
+		if strings.EqualFold(allowed, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeLimiter counts the bytes read and fails once there are more than max
+type sizeLimiter struct {
+	reader io.Reader
+	max    int64
+	size   int64
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.size += int64(n)
+	if l.size > l.max {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Anchor is the result of anchoring a checkpoint on a blockchain
+type Anchor struct {
+	TransactionID string
+	Hash          string
+}
+
+// Anchorer records checkpoints on an external ledger
+type Anchorer interface {
+	Anchor(ctx context.Context, checkpointID string, message []byte) (*Anchor, error)
+}
+
+// WalletAnchorer anchors checkpoints through the wallet-service blockchain API
+type WalletAnchorer struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewWalletAnchorer creates an anchorer for the wallet-service at baseURL,
+// calling it through client
+func NewWalletAnchorer(baseURL string, client *http.Client) *WalletAnchorer {
+	return &WalletAnchorer{
+		baseURL: baseURL,
+		client:  client,
+	}
+}
+
+// Anchor submits the checkpoint message to POST /api/v1/blockchain/anchor
+func (w *WalletAnchorer) Anchor(ctx context.Context, checkpointID string, message []byte) (*Anchor, error) {
+	body, err := json.Marshal(map[string]string{
+		"ref_type": "AUDIT_CHECKPOINT",
+		"ref_id":   checkpointID,
+		"data":     string(message),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.baseURL+"/api/v1/blockchain/anchor", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wallet-service returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		TransactionID string `json:"transaction_id"`
+		Hash          string `json:"hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode anchor response: %w", err)
+	}
+
+	return &Anchor{TransactionID: result.TransactionID, Hash: result.Hash}, nil
+}
+
+// Checkpointer periodically signs the head of every service's chain
+type Checkpointer struct {
+	store    *Store
+	signer   *Signer
+	anchorer Anchorer // optional
+	logger   *logrus.Logger
+}
+
+// NewCheckpointer creates a new checkpointer. anchorer may be nil.
+func NewCheckpointer(store *Store, signer *Signer, anchorer Anchorer, logger *logrus.Logger) *Checkpointer {
+	return &Checkpointer{
+		store:    store,
+		signer:   signer,
+		anchorer: anchorer,
+		logger:   logger,
+	}
+}
+
+// Run creates checkpoints at the given interval until the context is cancelled
+func (c *Checkpointer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Checkpoint(ctx); err != nil && ctx.Err() == nil {
+				c.logger.WithError(err).Error("Failed to create audit checkpoints")
+			}
+		}
+	}
+}
+
+// Checkpoint signs every chain head that advanced since its last checkpoint
+// and anchors checkpoints that have not been anchored yet
+func (c *Checkpointer) Checkpoint(ctx context.Context) error {
+	query := `
+		SELECT h.service, h.last_seq, h.last_hash
+		FROM audit_chain_heads h
+		WHERE h.last_seq > COALESCE(
+			(SELECT MAX(cp.chain_seq) FROM audit_checkpoints cp WHERE cp.service = h.service), 0
+		)
+	`
+
+	rows, err := c.store.db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	type head struct {
+		service string
+		seq     int64
+		hash    string
+	}
+	var heads []head
+	for rows.Next() {
+		var h head
+		if err := rows.Scan(&h.service, &h.seq, &h.hash); err != nil {
+			rows.Close()
+			return err
+		}
+		heads = append(heads, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, h := range heads {
+		createdAt := time.Now().UTC().Truncate(time.Microsecond)
+		signature := c.signer.Sign(checkpointMessage(h.service, h.seq, h.hash, createdAt))
+
+		_, err := c.store.db.ExecContext(ctx, `
+			INSERT INTO audit_checkpoints (service, chain_seq, entry_hash, signature, key_id, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (service, chain_seq) DO NOTHING
+		`, h.service, h.seq, h.hash, signature, c.signer.KeyID(), createdAt)
+		if err != nil {
+			return err
+		}
+
+		c.logger.WithFields(logrus.Fields{
+			"service":   h.service,
+			"chain_seq": h.seq,
+		}).Info("Audit checkpoint created")
+	}
+
+	if c.anchorer != nil {
+		return c.anchorPending(ctx)
+	}
+	return nil
+}
+
+// anchorPending anchors checkpoints that are not anchored yet. Failures are
+// retried on the next run.
+func (c *Checkpointer) anchorPending(ctx context.Context) error {
+	rows, err := c.store.db.QueryContext(ctx, `
+		SELECT id, service, chain_seq, entry_hash, signature, key_id, created_at
+		FROM audit_checkpoints
+		WHERE anchor_tx_id IS NULL
+		ORDER BY created_at
+		LIMIT 100
+	`)
+	if err != nil {
+		return err
+	}
+
+	var pending []Checkpoint
+	for rows.Next() {
+		var cp Checkpoint
+		if err := rows.Scan(&cp.ID, &cp.Service, &cp.ChainSeq, &cp.EntryHash, &cp.Signature, &cp.KeyID, &cp.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, cp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, cp := range pending {
+		// The anchored data includes the signature so the ledger pins both
+		message := append(checkpointMessage(cp.Service, cp.ChainSeq, cp.EntryHash, cp.CreatedAt), []byte("|"+cp.Signature)...)
+
+		anchor, err := c.anchorer.Anchor(ctx, cp.ID, message)
+		if err != nil {
+			c.logger.WithError(err).WithField("checkpoint_id", cp.ID).Warn("Failed to anchor audit checkpoint, will retry")
+			continue
+		}
+
+		if _, err := c.store.db.ExecContext(ctx, `
+			UPDATE audit_checkpoints SET anchor_tx_id = $2, anchor_hash = $3, anchored_at = NOW()
+			WHERE id = $1
+		`, cp.ID, anchor.TransactionID, anchor.Hash); err != nil {
+			return err
+		}
+
+		c.logger.WithFields(logrus.Fields{
+			"checkpoint_id":  cp.ID,
+			"transaction_id": anchor.TransactionID,
+		}).Info("Audit checkpoint anchored")
+	}
+
+	return nil
+}
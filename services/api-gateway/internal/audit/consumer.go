@@ -0,0 +1,218 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/kafka"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// Event is the envelope published on audit.trail.v1 by every service
+type Event struct {
+	EventID   string                 `json:"eventId"`
+	EventType string                 `json:"eventType"`
+	UserID    string                 `json:"userId"`
+	ClientIP  string                 `json:"clientIP"`
+	Timestamp time.Time              `json:"timestamp"`
+	Service   string                 `json:"service"`
+	Resource  string                 `json:"resource,omitempty"`
+	Action    string                 `json:"action,omitempty"`
+	Status    string                 `json:"status,omitempty"`
+	RequestID string                 `json:"requestId,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// Consumer persists audit events from Kafka into the audit store
+type Consumer struct {
+	store    *Store
+	brokers  []string
+	security kafka.SecurityConfig
+	groupID  string
+	logger   *logrus.Logger
+}
+
+// NewConsumer creates a new audit trail consumer
+func NewConsumer(store *Store, brokers []string, security kafka.SecurityConfig, groupID string, logger *logrus.Logger) *Consumer {
+	return &Consumer{
+		store:    store,
+		brokers:  brokers,
+		security: security,
+		groupID:  groupID,
+		logger:   logger,
+	}
+}
+
+// Run consumes the audit topic until the context is cancelled. Offsets are
+// committed only after the event has been stored; redeliveries are absorbed
+// by the event ID constraint.
+func (c *Consumer) Run(ctx context.Context, topic string) {
+	consumer, err := kafka.NewConsumer(c.brokers, c.security, topic, c.groupID, c.logger)
+	if err != nil {
+		c.logger.WithError(err).Errorf("Failed to create consumer for topic %s", topic)
+		return
+	}
+	defer consumer.Close()
+
+	c.logger.WithField("topic", topic).Info("Starting audit trail ingestion")
+
+	for {
+		msg, err := consumer.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.WithError(err).Errorf("Failed to fetch message from topic %s", topic)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		msgCtx, span := tracing.StartConsumerSpan(ctx, msg, c.groupID)
+		if id := requestid.FromKafka(msg); id != "" {
+			msgCtx = requestid.NewContext(msgCtx, id)
+		}
+		err = c.handle(msgCtx, topic, msg.Value)
+		tracing.End(span, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.WithError(err).WithFields(logrus.Fields{
+				"topic":     topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+			}).Error("Failed to persist audit event")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if err := consumer.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			c.logger.WithError(err).Errorf("Failed to commit offset on topic %s", topic)
+		}
+	}
+}
+
+// handle validates an event and stores it. Malformed events are logged and
+// skipped so that they do not block the partition.
+func (c *Consumer) handle(ctx context.Context, topic string, value []byte) error {
+	var event Event
+	if err := json.Unmarshal(value, &event); err != nil {
+		c.logger.WithContext(ctx).WithError(err).WithField("topic", topic).Warn("Skipping malformed audit event")
+		return nil
+	}
+
+	if err := validateEvent(event); err != nil {
+		c.logger.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"topic":    topic,
+			"event_id": event.EventID,
+		}).Warn("Skipping invalid audit event")
+		return nil
+	}
+
+	// Events from producers that predate the requestId field still carry it
+	// in the message headers
+	if event.RequestID == "" {
+		event.RequestID = requestid.FromContext(ctx)
+	}
+
+	created, err := c.store.Insert(ctx, recordFromEvent(event))
+	if err != nil {
+		return err
+	}
+
+	c.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"event_id":   event.EventID,
+		"event_type": event.EventType,
+		"service":    event.Service,
+		"duplicate":  !created,
+	}).Debug("Audit event persisted")
+
+	return nil
+}
+
+// recordFromEvent maps an event to a record, deriving the resource and action
+// from the event data when the producer did not set them explicitly
+func recordFromEvent(event Event) Record {
+	record := Record{
+		EventID:   event.EventID,
+		EventType: event.EventType,
+		UserID:    event.UserID,
+		ClientIP:  event.ClientIP,
+		Timestamp: event.Timestamp,
+		Service:   event.Service,
+		Resource:  event.Resource,
+		Action:    event.Action,
+		Status:    event.Status,
+		RequestID: event.RequestID,
+		Data:      event.Data,
+	}
+
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+
+	if record.Resource == "" {
+		record.Resource = resourceFromData(event.Data)
+	}
+
+	if record.Action == "" {
+		// Event types are dotted, e.g. fhir.patient.read or coverage.create
+		if idx := strings.LastIndex(event.EventType, "."); idx >= 0 {
+			record.Action = event.EventType[idx+1:]
+		}
+	}
+
+	if record.Status == "" {
+		record.Status = "success"
+		if success, ok := event.Data["success"].(bool); ok && !success {
+			record.Status = "failure"
+		}
+	}
+
+	return record
+}
+
+// resourceFromData builds a Type/id reference from common event data keys
+func resourceFromData(data map[string]interface{}) string {
+	if resource, ok := data["resource"].(string); ok && resource != "" {
+		return resource
+	}
+
+	resourceType, _ := data["resourceType"].(string)
+	for _, key := range []string{"resourceId", "id"} {
+		if id, ok := data[key].(string); ok && id != "" && resourceType != "" {
+			return resourceType + "/" + id
+		}
+	}
+
+	for _, pair := range [][2]string{
+		{"coverage_id", "Coverage"},
+		{"member_id", "Patient"},
+		{"patientID", "Patient"},
+		{"claimID", "Claim"},
+	} {
+		if id, ok := data[pair[0]].(string); ok && id != "" {
+			return pair[1] + "/" + id
+		}
+	}
+
+	return ""
+}
+
+func validateEvent(event Event) error {
+	if event.EventID == "" {
+		return errors.New("missing eventId")
+	}
+	if event.EventType == "" {
+		return errors.New("missing eventType")
+	}
+	if event.Service == "" {
+		return errors.New("missing service")
+	}
+	return nil
+}
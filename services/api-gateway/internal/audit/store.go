@@ -0,0 +1,299 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Record is a persisted audit log entry
+type Record struct {
+	ID        string
+	EventID   string
+	EventType string
+	UserID    string
+	ClientIP  string
+	Timestamp time.Time
+	Service   string
+	Resource  string
+	Action    string
+	Status    string
+	RequestID string
+	Data      map[string]interface{}
+}
+
+// Filter selects audit records. Zero values are ignored.
+type Filter struct {
+	From      time.Time // inclusive
+	To        time.Time // exclusive
+	UserID    string
+	EventType string
+	Resource  string
+	Service   string
+	RequestID string
+	Cursor    string // opaque cursor returned by a previous query
+	Limit     int
+}
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Store persists and queries audit records
+type Store struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+// NewStore creates a new audit store
+func NewStore(db *sql.DB, logger *logrus.Logger) *Store {
+	return &Store{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Insert appends a record to its service's hash chain. Records are
+// deduplicated by event ID, so inserting the same event twice is a no-op; it
+// reports whether a row was written.
+func (s *Store) Insert(ctx context.Context, record Record) (bool, error) {
+	record = normalizeRecord(record)
+
+	data, err := json.Marshal(record.Data)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal audit data: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Appends to one service's chain are serialized on its head row
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO audit_chain_heads (service) VALUES ($1) ON CONFLICT (service) DO NOTHING`,
+		record.Service,
+	); err != nil {
+		return false, err
+	}
+
+	var lastSeq int64
+	var lastHash string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT last_seq, last_hash FROM audit_chain_heads WHERE service = $1 FOR UPDATE`,
+		record.Service,
+	).Scan(&lastSeq, &lastHash); err != nil {
+		return false, err
+	}
+
+	seq := lastSeq + 1
+	entryHash, err := computeHash(seq, lastHash, record)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		INSERT INTO audit_logs (
+			event_id, event_type, user_id, client_ip, timestamp, service, resource, action, status, data,
+			request_id, chain_seq, prev_hash, entry_hash
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (event_id) DO NOTHING
+	`
+
+	result, err := tx.ExecContext(ctx, query,
+		record.EventID,
+		record.EventType,
+		nullString(record.UserID),
+		nullString(record.ClientIP),
+		record.Timestamp,
+		record.Service,
+		nullString(record.Resource),
+		nullString(record.Action),
+		nullString(record.Status),
+		data,
+		nullString(record.RequestID),
+		seq,
+		lastHash,
+		entryHash,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		// Duplicate event; leave the chain untouched
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE audit_chain_heads SET last_seq = $2, last_hash = $3, updated_at = NOW() WHERE service = $1`,
+		record.Service, seq, entryHash,
+	); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Query returns records matching the filter, newest first, and the cursor for
+// the next page. The cursor is empty on the last page.
+func (s *Store) Query(ctx context.Context, filter Filter) ([]Record, string, error) {
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if !filter.From.IsZero() {
+		addCondition("timestamp >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("timestamp < $%d", filter.To)
+	}
+	if filter.UserID != "" {
+		addCondition("user_id = $%d", filter.UserID)
+	}
+	if filter.EventType != "" {
+		addCondition("event_type = $%d", filter.EventType)
+	}
+	if filter.Resource != "" {
+		addCondition("resource = $%d", filter.Resource)
+	}
+	if filter.Service != "" {
+		addCondition("service = $%d", filter.Service)
+	}
+	if filter.RequestID != "" {
+		addCondition("request_id = $%d", filter.RequestID)
+	}
+
+	if filter.Cursor != "" {
+		cursorTime, cursorID, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, cursorTime, cursorID)
+		conditions = append(conditions, fmt.Sprintf("(timestamp, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := "SELECT " + recordColumns + " FROM audit_logs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Fetch one extra row to know whether there is a next page
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(" ORDER BY timestamp DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		record, err := s.scanRecord(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var nextCursor string
+	if len(records) > filter.Limit {
+		records = records[:filter.Limit]
+		last := records[len(records)-1]
+		nextCursor = encodeCursor(last.Timestamp, last.ID)
+	}
+
+	return records, nextCursor, nil
+}
+
+// recordColumns are the columns read by scanRecord, in order
+const recordColumns = `id, event_id, event_type, user_id, host(client_ip), timestamp, service,
+	resource, action, status, data, request_id`
+
+// scanRecord reads recordColumns followed by any extra destinations
+func (s *Store) scanRecord(rows *sql.Rows, extra ...interface{}) (Record, error) {
+	var record Record
+	var userID, clientIP, resource, action, status, requestID sql.NullString
+	var data []byte
+
+	dest := []interface{}{
+		&record.ID,
+		&record.EventID,
+		&record.EventType,
+		&userID,
+		&clientIP,
+		&record.Timestamp,
+		&record.Service,
+		&resource,
+		&action,
+		&status,
+		&data,
+		&requestID,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return Record{}, err
+	}
+
+	record.UserID = userID.String
+	record.ClientIP = clientIP.String
+	record.Resource = resource.String
+	record.Action = action.String
+	record.Status = status.String
+	record.RequestID = requestID.String
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &record.Data); err != nil {
+			s.logger.WithError(err).WithField("event_id", record.EventID).Warn("Failed to decode audit data")
+		}
+	}
+
+	return record, nil
+}
+
+func encodeCursor(timestamp time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(timestamp.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(parts[1]); err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+
+	return timestamp, parts[1], nil
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
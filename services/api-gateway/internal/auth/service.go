@@ -13,10 +13,26 @@ type Service struct {
 	expiration time.Duration
 }
 
+// Identity describes who a token is issued to. TenantID is the payer the
+// token is scoped to; it is empty for platform users that are not bound to a
+// payer. Role and OrganizationID are evaluated by the access policies.
+// BreakGlass is the emergency access session an elevated token belongs to.
+type Identity struct {
+	UserID         string   `json:"user_id"`
+	Role           string   `json:"role,omitempty"`
+	TenantID       string   `json:"tenant_id,omitempty"`
+	OrganizationID string   `json:"organization_id,omitempty"`
+	Scopes         []string `json:"scopes"`
+	BreakGlass     string   `json:"break_glass,omitempty"`
+}
+
+// ErrBreakGlassRefresh is returned when refreshing a break-glass token, whose
+// lifetime is fixed when the session is opened
+var ErrBreakGlassRefresh = errors.New("break-glass tokens cannot be refreshed")
+
 // Claims represents JWT claims
 type Claims struct {
-	UserID string   `json:"user_id"`
-	Scopes []string `json:"scopes"`
+	Identity
 	jwt.RegisteredClaims
 }
 
@@ -28,18 +44,23 @@ func NewService(secretKey string, expiration time.Duration) *Service {
 	}
 }
 
-// GenerateToken generates a JWT token for a user
-func (s *Service) GenerateToken(userID string, scopes []string) (string, error) {
+// GenerateToken generates a JWT token for an identity
+func (s *Service) GenerateToken(identity Identity) (string, error) {
+	return s.GenerateTokenUntil(identity, time.Now().Add(s.expiration))
+}
+
+// GenerateTokenUntil generates a JWT token for an identity that expires at
+// expiresAt instead of after the service's default lifetime
+func (s *Service) GenerateTokenUntil(identity Identity, expiresAt time.Time) (string, error) {
 	now := time.Now()
 	claims := Claims{
-		UserID: userID,
-		Scopes: scopes,
+		Identity: identity,
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(s.expiration)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "nphies-api-gateway",
-			Subject:   userID,
+			Subject:   identity.UserID,
 		},
 	}
 
@@ -73,9 +94,12 @@ func (s *Service) RefreshToken(tokenString string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if claims.BreakGlass != "" {
+		return "", ErrBreakGlassRefresh
+	}
 
-	// Generate new token with same user and scopes
-	return s.GenerateToken(claims.UserID, claims.Scopes)
+	// Generate new token for the same identity
+	return s.GenerateToken(claims.Identity)
 }
 
 // HasScope checks if the user has a specific scope
@@ -91,4 +115,4 @@ func (c *Claims) HasScope(scope string) bool {
 // IsAdmin checks if the user has admin privileges
 func (c *Claims) IsAdmin() bool {
 	return c.HasScope("admin")
-}
\ No newline at end of file
+}
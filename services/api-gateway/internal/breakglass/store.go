@@ -0,0 +1,292 @@
+// Package breakglass records emergency access sessions. A clinician who must
+// read a patient's coverage outside their usual scope opens a session with a
+// reason code and a justification and receives a short-lived elevated token.
+// Every use of the token is counted against the session, and each session
+// waits in a review queue until an administrator signs it off.
+package breakglass
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Session statuses
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+)
+
+// Review statuses. A session is pending until a reviewer approves the access
+// as justified or flags it for follow-up.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewFlagged  = "flagged"
+)
+
+var (
+	// ErrNotFound is returned for unknown session IDs
+	ErrNotFound = errors.New("break-glass session not found")
+	// ErrSessionRevoked is returned when using a revoked session
+	ErrSessionRevoked = errors.New("break-glass session revoked")
+	// ErrSessionExpired is returned when using a session past its expiry
+	ErrSessionExpired = errors.New("break-glass session expired")
+	// ErrAlreadyReviewed is returned when reviewing a session twice
+	ErrAlreadyReviewed = errors.New("break-glass session already reviewed")
+	// ErrSelfReview is returned when the session holder reviews their own session
+	ErrSelfReview = errors.New("break-glass sessions cannot be reviewed by their holder")
+)
+
+// Session is an emergency access session
+type Session struct {
+	ID             string
+	UserID         string
+	Role           string // the holder's role when the session was opened
+	HomeTenantID   string // the tenant of the holder's regular token
+	TenantID       string // the tenant the session grants access to
+	OrganizationID string
+	PatientID      string // the only patient the session may read
+	ReasonCode     string
+	Justification  string
+	Status         string
+	ClientIP       string
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+	UseCount       int
+	LastUsedAt     *time.Time
+	RevokedAt      *time.Time
+	RevokedBy      string
+	ReviewStatus   string
+	ReviewedBy     string
+	ReviewedAt     *time.Time
+	ReviewNotes    string
+}
+
+// Active reports whether the session can still be used at now
+func (s *Session) Active(now time.Time) bool {
+	return s.Status == StatusActive && now.Before(s.ExpiresAt)
+}
+
+// NewSession describes a session to open
+type NewSession struct {
+	UserID         string
+	Role           string
+	HomeTenantID   string
+	TenantID       string
+	OrganizationID string
+	PatientID      string
+	ReasonCode     string
+	Justification  string
+	ClientIP       string
+	ExpiresAt      time.Time // also the expiry of the elevated token
+}
+
+// Filter selects sessions. Zero values are ignored.
+type Filter struct {
+	ReviewStatus string
+	UserID       string
+}
+
+// Store persists break-glass sessions
+type Store struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+// NewStore creates a new break-glass session store
+func NewStore(db *sql.DB, logger *logrus.Logger) *Store {
+	return &Store{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create opens a session, pending review
+func (s *Store) Create(ctx context.Context, newSession NewSession) (*Session, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO break_glass_sessions (
+			user_id, role, home_tenant_id, tenant_id, organization_id, patient_id,
+			reason_code, justification, client_ip, expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id
+	`,
+		newSession.UserID,
+		newSession.Role,
+		nullString(newSession.HomeTenantID),
+		nullString(newSession.TenantID),
+		nullString(newSession.OrganizationID),
+		nullString(newSession.PatientID),
+		newSession.ReasonCode,
+		newSession.Justification,
+		nullString(newSession.ClientIP),
+		newSession.ExpiresAt,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Get returns a session by ID
+func (s *Store) Get(ctx context.Context, id string) (*Session, error) {
+	session, err := scanSession(s.db.QueryRowContext(ctx,
+		`SELECT `+sessionColumns+` FROM break_glass_sessions WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return session, err
+}
+
+// List returns the sessions matching filter, oldest first, so that the
+// pending ones read as a queue
+func (s *Store) List(ctx context.Context, filter Filter) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sessionColumns+` FROM break_glass_sessions
+		WHERE ($1 = '' OR review_status = $1)
+		  AND ($2 = '' OR user_id = $2)
+		ORDER BY created_at
+		LIMIT 1000`, filter.ReviewStatus, filter.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// RecordUse counts a use of an active session. Revoked and expired sessions
+// yield ErrSessionRevoked and ErrSessionExpired and are not counted.
+func (s *Store) RecordUse(ctx context.Context, id string) (*Session, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE break_glass_sessions SET use_count = use_count + 1, last_used_at = NOW()
+		WHERE id = $1 AND status = $2 AND expires_at > NOW()
+	`, id, StatusActive)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		if session.Status != StatusActive {
+			return session, ErrSessionRevoked
+		}
+		return session, ErrSessionExpired
+	}
+	return session, nil
+}
+
+// Revoke ends a session before it expires. Revoking a revoked session is a no-op.
+func (s *Store) Revoke(ctx context.Context, id, actor string) (*Session, error) {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE break_glass_sessions SET status = $2, revoked_at = NOW(), revoked_by = $3
+		WHERE id = $1 AND status <> $2
+	`, id, StatusRevoked, nullString(actor)); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Review signs off a pending session as approved or flagged. The holder of a
+// session cannot review it.
+func (s *Store) Review(ctx context.Context, id, reviewer, outcome, notes string) (*Session, error) {
+	session, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == reviewer {
+		return nil, ErrSelfReview
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE break_glass_sessions SET review_status = $2, reviewed_by = $3, reviewed_at = NOW(), review_notes = $4
+		WHERE id = $1 AND review_status = $5
+	`, id, outcome, reviewer, nullString(notes), ReviewPending)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrAlreadyReviewed
+	}
+	return s.Get(ctx, id)
+}
+
+// sessionColumns are the columns read by scanSession, in order
+const sessionColumns = `id, user_id, role, home_tenant_id, tenant_id, organization_id, patient_id,
+	reason_code, justification, status, host(client_ip), expires_at, created_at, use_count,
+	last_used_at, revoked_at, revoked_by, review_status, reviewed_by, reviewed_at, review_notes`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row scanner) (*Session, error) {
+	var session Session
+	var homeTenantID, tenantID, organizationID, patientID, clientIP sql.NullString
+	var revokedBy, reviewedBy, reviewNotes sql.NullString
+	var lastUsedAt, revokedAt, reviewedAt sql.NullTime
+
+	if err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Role,
+		&homeTenantID,
+		&tenantID,
+		&organizationID,
+		&patientID,
+		&session.ReasonCode,
+		&session.Justification,
+		&session.Status,
+		&clientIP,
+		&session.ExpiresAt,
+		&session.CreatedAt,
+		&session.UseCount,
+		&lastUsedAt,
+		&revokedAt,
+		&revokedBy,
+		&session.ReviewStatus,
+		&reviewedBy,
+		&reviewedAt,
+		&reviewNotes,
+	); err != nil {
+		return nil, err
+	}
+
+	session.HomeTenantID = homeTenantID.String
+	session.TenantID = tenantID.String
+	session.OrganizationID = organizationID.String
+	session.PatientID = patientID.String
+	session.ClientIP = clientIP.String
+	session.RevokedBy = revokedBy.String
+	session.ReviewedBy = reviewedBy.String
+	session.ReviewNotes = reviewNotes.String
+	session.LastUsedAt = nullTime(lastUsedAt)
+	session.RevokedAt = nullTime(revokedAt)
+	session.ReviewedAt = nullTime(reviewedAt)
+	return &session, nil
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
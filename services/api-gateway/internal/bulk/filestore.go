@@ -0,0 +1,125 @@
+// Package bulk implements FHIR Bulk Data $export. A kick-off request creates
+// a job; a background runner claims it, asks each source for the resources of
+// the requested types and writes them as NDJSON files to a file store, from
+// which clients download them once the job's manifest lists them.
+package bulk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidName is returned for file names that are not relative paths
+// inside the store
+var ErrInvalidName = errors.New("invalid file name")
+
+// FileWriter writes a new file. Close publishes it; Abort discards it, as
+// when the export of its resources failed.
+type FileWriter interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// FileStore holds export files. Names are slash-separated relative paths such
+// as "<job ID>/Patient.ndjson".
+type FileStore interface {
+	// Create returns a writer for a new file. The file only becomes visible
+	// once the writer is closed, and never if it is aborted.
+	Create(ctx context.Context, name string) (FileWriter, error)
+	// Open returns a reader for a file
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Remove deletes a file, or a directory with all files below it.
+	// Removing a name that does not exist is not an error.
+	Remove(ctx context.Context, name string) error
+}
+
+// LocalFileStore keeps files in a directory of the local filesystem. With
+// more than one gateway replica the directory must be shared.
+type LocalFileStore struct {
+	root string
+}
+
+// NewLocalFileStore creates a store rooted at dir, creating it if needed
+func NewLocalFileStore(dir string) (*LocalFileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &LocalFileStore{root: dir}, nil
+}
+
+// Create implements FileStore. The file is written under a temporary name and
+// renamed into place on Close.
+func (s *LocalFileStore) Create(ctx context.Context, name string) (FileWriter, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), ".partial-*")
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{File: file, path: path}, nil
+}
+
+// Open implements FileStore
+func (s *LocalFileStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Remove implements FileStore
+func (s *LocalFileStore) Remove(ctx context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// path maps a name to a path below the root, refusing names that would
+// escape it
+func (s *LocalFileStore) path(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return "", ErrInvalidName
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", ErrInvalidName
+		}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name)), nil
+}
+
+// localFile renames the temporary file into place when it is closed, and
+// removes it when it is aborted
+type localFile struct {
+	*os.File
+	path string
+}
+
+func (f *localFile) Abort() error {
+	f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+func (f *localFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Rename(f.File.Name(), f.path); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package bulk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Export levels
+const (
+	LevelSystem  = "system"  // [base]/$export
+	LevelPatient = "patient" // [base]/Patient/$export
+	LevelGroup   = "group"   // [base]/Group/[id]/$export
+)
+
+// Job statuses
+const (
+	StatusAccepted   = "accepted"
+	StatusInProgress = "in-progress"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	StatusCancelled  = "cancelled"
+)
+
+// ErrJobNotFound is returned for unknown export jobs
+var ErrJobNotFound = errors.New("export job not found")
+
+// OutputFile is a file written by an export job
+type OutputFile struct {
+	Type  string `json:"type"`
+	File  string `json:"file"` // name in the file store
+	Count int    `json:"count"`
+}
+
+// Job is a bulk export requested by a client
+type Job struct {
+	ID              string
+	Level           string
+	GroupID         string
+	Types           []string
+	Since           *time.Time
+	RequestURL      string
+	TenantID        string // payer the export is scoped to; empty for all
+	RequestedBy     string
+	Status          string
+	Progress        string
+	Error           string
+	Output          []OutputFile
+	Attempts        int
+	TransactionTime *time.Time
+	CreatedAt       time.Time
+	CompletedAt     *time.Time
+	ExpiresAt       *time.Time
+}
+
+// Active reports whether the job is still waiting or running
+func (j *Job) Active() bool {
+	return j.Status == StatusAccepted || j.Status == StatusInProgress
+}
+
+// NewJob describes an export to queue
+type NewJob struct {
+	Level       string
+	GroupID     string
+	Types       []string
+	Since       *time.Time
+	RequestURL  string
+	TenantID    string
+	RequestedBy string
+}
+
+// JobStore persists export jobs
+type JobStore struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+// NewJobStore creates a new export job store
+func NewJobStore(db *sql.DB, logger *logrus.Logger) *JobStore {
+	return &JobStore{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create queues an export job
+func (s *JobStore) Create(ctx context.Context, newJob NewJob) (*Job, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO bulk_export_jobs (level, group_id, types, since, request_url, tenant_id, requested_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`,
+		newJob.Level,
+		nullString(newJob.GroupID),
+		pq.Array(newJob.Types),
+		newJob.Since,
+		newJob.RequestURL,
+		nullString(newJob.TenantID),
+		newJob.RequestedBy,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Get returns an export job
+func (s *JobStore) Get(ctx context.Context, id string) (*Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM bulk_export_jobs WHERE id::text = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// CountActive returns the number of waiting or running jobs of a requester
+// in a tenant
+func (s *JobStore) CountActive(ctx context.Context, tenantID, requestedBy string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM bulk_export_jobs
+		WHERE COALESCE(tenant_id, '') = $1 AND requested_by = $2 AND status IN ($3, $4)
+	`, tenantID, requestedBy, StatusAccepted, StatusInProgress).Scan(&count)
+	return count, err
+}
+
+// Cancel cancels a job and reports whether there was one to cancel. A running
+// job notices at its next progress update and stops. The job's files are
+// removed once it expires after the retention period, which should outlast a
+// lease so that a stopping runner has finished writing.
+func (s *JobStore) Cancel(ctx context.Context, id string, retention time.Duration) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE bulk_export_jobs
+		SET status = $2, progress = NULL, completed_at = COALESCE(completed_at, NOW()), expires_at = $3
+		WHERE id::text = $1 AND status <> $2
+	`, id, StatusCancelled, time.Now().Add(retention))
+	if err != nil {
+		return false, err
+	}
+	cancelled, err := result.RowsAffected()
+	return cancelled > 0, err
+}
+
+// Claim leases the oldest waiting job, or a running job whose lease expired
+// because its runner stopped. It returns nil when no job is due.
+func (s *JobStore) Claim(ctx context.Context, lease time.Duration) (*Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `
+		UPDATE bulk_export_jobs
+		SET status = $1,
+		    attempts = attempts + 1,
+		    lease_expires_at = $2,
+		    transaction_time = NOW(),
+		    progress = NULL
+		WHERE id = (
+			SELECT id FROM bulk_export_jobs
+			WHERE status = $3 OR (status = $1 AND lease_expires_at < NOW())
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		StatusInProgress, time.Now().Add(lease), StatusAccepted))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Heartbeat records the progress of a running job and extends its lease. It
+// reports false once the job is no longer running, e.g. after a cancel.
+func (s *JobStore) Heartbeat(ctx context.Context, id, progress string, lease time.Duration) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE bulk_export_jobs
+		SET progress = $2, lease_expires_at = $3
+		WHERE id = $1 AND status = $4
+	`, id, progress, time.Now().Add(lease), StatusInProgress)
+	if err != nil {
+		return false, err
+	}
+	running, err := result.RowsAffected()
+	return running > 0, err
+}
+
+// Complete records the output of a finished job. Its files are kept for the
+// retention period.
+func (s *JobStore) Complete(ctx context.Context, id string, output []OutputFile, retention time.Duration) error {
+	outputJSON, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE bulk_export_jobs
+		SET status = $2, output = $3, progress = NULL, completed_at = NOW(), expires_at = $4
+		WHERE id = $1 AND status = $5
+	`, id, StatusCompleted, outputJSON, time.Now().Add(retention), StatusInProgress)
+	return err
+}
+
+// Fail records why a job failed. A job that has attempts left is queued
+// again instead.
+func (s *JobStore) Fail(ctx context.Context, id, message string, maxAttempts int, retention time.Duration) (bool, error) {
+	var status string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE bulk_export_jobs
+		SET status = CASE WHEN attempts >= $3 THEN $4 ELSE $5 END,
+		    error = $2,
+		    progress = NULL,
+		    completed_at = CASE WHEN attempts >= $3 THEN NOW() END,
+		    expires_at = CASE WHEN attempts >= $3 THEN $6::timestamptz END
+		WHERE id = $1 AND status = $7
+		RETURNING status
+	`, id, message, maxAttempts, StatusFailed, StatusAccepted, time.Now().Add(retention), StatusInProgress).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return status == StatusFailed, err
+}
+
+// Expired returns the IDs of finished jobs past their retention
+func (s *JobStore) Expired(ctx context.Context, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id FROM bulk_export_jobs
+		WHERE expires_at < NOW()
+		ORDER BY expires_at
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Delete removes a job
+func (s *JobStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM bulk_export_jobs WHERE id = $1`, id)
+	return err
+}
+
+// jobColumns are the columns read by scanJob, in order
+const jobColumns = `id, level, group_id, types, since, request_url, tenant_id, requested_by, status,
+	progress, error, output, attempts, transaction_time, created_at, completed_at, expires_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var job Job
+	var groupID, tenantID, progress, jobError sql.NullString
+	var since, transactionTime, completedAt, expiresAt sql.NullTime
+	var output []byte
+
+	if err := row.Scan(
+		&job.ID,
+		&job.Level,
+		&groupID,
+		pq.Array(&job.Types),
+		&since,
+		&job.RequestURL,
+		&tenantID,
+		&job.RequestedBy,
+		&job.Status,
+		&progress,
+		&jobError,
+		&output,
+		&job.Attempts,
+		&transactionTime,
+		&job.CreatedAt,
+		&completedAt,
+		&expiresAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(output, &job.Output); err != nil {
+		return nil, err
+	}
+	job.GroupID = groupID.String
+	job.TenantID = tenantID.String
+	job.Progress = progress.String
+	job.Error = jobError.String
+	job.Since = nullTime(since)
+	job.TransactionTime = nullTime(transactionTime)
+	job.CompletedAt = nullTime(completedAt)
+	job.ExpiresAt = nullTime(expiresAt)
+	return &job, nil
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
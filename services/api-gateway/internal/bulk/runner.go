@@ -0,0 +1,246 @@
+package bulk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// heartbeatEvery is how many resources a job writes between progress updates
+const heartbeatEvery = 1000
+
+// errJobStopped is returned when a job was cancelled while running
+var errJobStopped = errors.New("export job is no longer running")
+
+// RunnerOptions tune the export runner
+type RunnerOptions struct {
+	PollInterval time.Duration
+	Lease        time.Duration // how long a job stays claimed without a progress update
+	MaxAttempts  int
+	Retention    time.Duration // how long finished jobs and their files are kept
+}
+
+// Runner claims queued export jobs and writes their files. Every gateway
+// replica may run one; jobs are leased so each is exported by one runner.
+type Runner struct {
+	jobs    *JobStore
+	files   FileStore
+	sources []Source
+	logger  *logrus.Logger
+	options RunnerOptions
+}
+
+// NewRunner creates a new export runner
+func NewRunner(jobs *JobStore, files FileStore, sources []Source, logger *logrus.Logger, options RunnerOptions) *Runner {
+	return &Runner{
+		jobs:    jobs,
+		files:   files,
+		sources: sources,
+		logger:  logger,
+		options: options,
+	}
+}
+
+// Types returns the resource types the sources export, in source order
+func Types(sources []Source) []string {
+	var types []string
+	for _, source := range sources {
+		types = append(types, source.Types()...)
+	}
+	return types
+}
+
+// Run exports queued jobs until the context is cancelled
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+
+	purgeTicker := time.NewTicker(time.Hour)
+	defer purgeTicker.Stop()
+
+	r.logger.Info("Starting bulk export runner")
+
+	for {
+		job, err := r.jobs.Claim(ctx, r.options.Lease)
+		if err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Error("Failed to claim export job")
+		}
+		if job != nil {
+			r.run(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-purgeTicker.C:
+			r.purge(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// run exports a claimed job and records its outcome
+func (r *Runner) run(ctx context.Context, job *Job) {
+	logger := r.logger.WithFields(logrus.Fields{
+		"job_id":   job.ID,
+		"level":    job.Level,
+		"attempts": job.Attempts,
+	})
+	logger.Info("Running export job")
+
+	output, err := r.export(ctx, job)
+	switch {
+	case err == nil:
+		if err := r.jobs.Complete(ctx, job.ID, output, r.options.Retention); err != nil {
+			logger.WithError(err).Error("Failed to complete export job")
+			return
+		}
+		logger.WithField("files", len(output)).Info("Export job completed")
+
+	case errors.Is(err, errJobStopped):
+		// Cancelled; the files are removed when the job expires
+		logger.Info("Export job stopped")
+
+	case ctx.Err() != nil:
+		// Shutting down; another runner picks the job up once its lease expires
+
+	default:
+		if err := r.files.Remove(ctx, job.ID); err != nil {
+			logger.WithError(err).Warn("Failed to remove files of failed export job")
+		}
+		failed, failErr := r.jobs.Fail(ctx, job.ID, err.Error(), r.options.MaxAttempts, r.options.Retention)
+		if failErr != nil {
+			logger.WithError(failErr).Error("Failed to record export job failure")
+			return
+		}
+		if failed {
+			logger.WithError(err).Error("Export job failed")
+		} else {
+			logger.WithError(err).Warn("Export job failed; it will be retried")
+		}
+	}
+}
+
+// export writes one NDJSON file per requested type. Types without resources
+// get no file.
+func (r *Runner) export(ctx context.Context, job *Job) ([]OutputFile, error) {
+	query := Query{
+		Level:    job.Level,
+		GroupID:  job.GroupID,
+		TenantID: job.TenantID,
+		Since:    job.Since,
+	}
+	if job.Level == LevelGroup {
+		patients, err := r.groupPatients(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve group %s: %w", job.GroupID, err)
+		}
+		query.Patients = patients
+	}
+
+	output := []OutputFile{}
+	total := 0
+	for i, resourceType := range job.Types {
+		source := r.source(resourceType)
+		if source == nil {
+			return nil, fmt.Errorf("no source exports %s", resourceType)
+		}
+
+		progress := func() error {
+			running, err := r.jobs.Heartbeat(ctx, job.ID,
+				fmt.Sprintf("Exporting %s (type %d of %d, %d resources written)", resourceType, i+1, len(job.Types), total),
+				r.options.Lease)
+			if err != nil {
+				return err
+			}
+			if !running {
+				return errJobStopped
+			}
+			return nil
+		}
+		if err := progress(); err != nil {
+			return nil, err
+		}
+
+		name := job.ID + "/" + resourceType + ".ndjson"
+		file, err := r.files.Create(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(file)
+		count := 0
+		err = source.Export(ctx, resourceType, query, func(resource interface{}) error {
+			if err := encoder.Encode(resource); err != nil {
+				return err
+			}
+			count++
+			total++
+			if count%heartbeatEvery == 0 {
+				return progress()
+			}
+			return nil
+		})
+		if err != nil {
+			file.Abort()
+			return nil, err
+		}
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			if err := r.files.Remove(ctx, name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		output = append(output, OutputFile{Type: resourceType, File: name, Count: count})
+	}
+	return output, nil
+}
+
+func (r *Runner) groupPatients(ctx context.Context, query Query) (map[string]bool, error) {
+	for _, source := range r.sources {
+		if resolver, ok := source.(GroupResolver); ok {
+			return resolver.GroupPatients(ctx, query)
+		}
+	}
+	return nil, errors.New("no source resolves groups")
+}
+
+func (r *Runner) source(resourceType string) Source {
+	for _, source := range r.sources {
+		for _, t := range source.Types() {
+			if t == resourceType {
+				return source
+			}
+		}
+	}
+	return nil
+}
+
+// purge removes finished jobs past their retention along with their files
+func (r *Runner) purge(ctx context.Context) {
+	ids, err := r.jobs.Expired(ctx, 100)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to list expired export jobs")
+		return
+	}
+	for _, id := range ids {
+		if err := r.files.Remove(ctx, id); err != nil {
+			r.logger.WithError(err).WithField("job_id", id).Error("Failed to remove export files")
+			continue
+		}
+		if err := r.jobs.Delete(ctx, id); err != nil {
+			r.logger.WithError(err).WithField("job_id", id).Error("Failed to delete export job")
+		}
+	}
+	if len(ids) > 0 {
+		r.logger.WithField("rows", len(ids)).Info("Purged expired export jobs")
+	}
+}
@@ -0,0 +1,513 @@
+package bulk
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
+)
+
+// patientIdentifierSystem identifies patients by national ID or Iqama number
+const patientIdentifierSystem = "https://nphies.sa/patient-id"
+
+// Query selects the resources of an export job
+type Query struct {
+	Level    string
+	GroupID  string
+	TenantID string
+	Since    *time.Time
+	Patients map[string]bool // patient IDs of the group, for group exports
+	Patient  string          // only the resources of this patient, for Patient/$everything
+}
+
+// includesPatient reports whether resources of a patient belong to the
+// export. System exports include resources without a patient as well.
+func (q Query) includesPatient(patientID string) bool {
+	if q.Patient != "" {
+		return patientID == q.Patient
+	}
+	switch q.Level {
+	case LevelGroup:
+		return q.Patients[patientID]
+	case LevelPatient:
+		return patientID != ""
+	default:
+		return true
+	}
+}
+
+// Source exports the resources of some resource types
+type Source interface {
+	Types() []string
+	Export(ctx context.Context, resourceType string, query Query, emit func(resource interface{}) error) error
+	// Page reads up to limit resources of a type, from the first or after the
+	// cursor returned with the previous page. The cursor is empty after the
+	// last page; pages may be short before it.
+	Page(ctx context.Context, resourceType string, query Query, after string, limit int) ([]interface{}, string, error)
+}
+
+// GroupResolver lists the patients of a group
+type GroupResolver interface {
+	GroupPatients(ctx context.Context, query Query) (map[string]bool, error)
+}
+
+// EligibilitySource exports patients and coverage from the eligibility
+// service. A group is the set of members with coverage of a group number.
+type EligibilitySource struct {
+	baseURL  string
+	client   *http.Client
+	pageSize int
+}
+
+// NewEligibilitySource creates a source for the eligibility service at
+// baseURL. The client must forward the tenant of the request context.
+func NewEligibilitySource(baseURL string, client *http.Client, pageSize int) *EligibilitySource {
+	return &EligibilitySource{
+		baseURL:  strings.TrimRight(baseURL, "/"),
+		client:   client,
+		pageSize: pageSize,
+	}
+}
+
+// member and coverage are the records of the eligibility service's export
+// pages
+type member struct {
+	Identifier  string                 `json:"identifier"`
+	Name        json.RawMessage        `json:"name"`
+	BirthDate   time.Time              `json:"birth_date"`
+	Gender      string                 `json:"gender"`
+	ContactInfo map[string]interface{} `json:"contact_info"`
+	Address     json.RawMessage        `json:"address"`
+	Status      string                 `json:"status"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+type coverage struct {
+	ID             string     `json:"id"`
+	MemberID       string     `json:"member_id"`
+	PayerID        string     `json:"payer_id"`
+	PolicyNumber   string     `json:"policy_number"`
+	GroupNumber    string     `json:"group_number"`
+	Status         string     `json:"status"`
+	Type           string     `json:"type"`
+	EffectiveDate  time.Time  `json:"effective_date"`
+	ExpirationDate *time.Time `json:"expiration_date"`
+	Network        string     `json:"network"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// Types implements Source
+func (s *EligibilitySource) Types() []string {
+	return []string{"Patient", "Coverage"}
+}
+
+// Export implements Source
+func (s *EligibilitySource) Export(ctx context.Context, resourceType string, query Query, emit func(resource interface{}) error) error {
+	switch resourceType {
+	case "Patient":
+		return s.members(ctx, query, true, func(m member) error {
+			if !query.includesPatient(m.Identifier) {
+				return nil
+			}
+			return emit(patientResource(m))
+		})
+	case "Coverage":
+		return s.pages(ctx, "coverage", query, true, func(body []byte) (string, error) {
+			var page struct {
+				Coverage []coverage `json:"coverage"`
+				Next     string     `json:"next"`
+			}
+			if err := json.Unmarshal(body, &page); err != nil {
+				return "", err
+			}
+			for _, c := range page.Coverage {
+				if !query.includesPatient(c.MemberID) {
+					continue
+				}
+				if err := emit(coverageResource(c)); err != nil {
+					return "", err
+				}
+			}
+			return page.Next, nil
+		})
+	default:
+		return fmt.Errorf("unsupported resource type %s", resourceType)
+	}
+}
+
+// Page implements Source
+func (s *EligibilitySource) Page(ctx context.Context, resourceType string, query Query, after string, limit int) ([]interface{}, string, error) {
+	var resources []interface{}
+	switch resourceType {
+	case "Patient":
+		var page struct {
+			Members []member `json:"members"`
+			Next    string   `json:"next"`
+		}
+		if err := s.page(ctx, "members", query, true, after, limit, &page); err != nil {
+			return nil, "", err
+		}
+		for _, m := range page.Members {
+			if query.includesPatient(m.Identifier) {
+				resources = append(resources, patientResource(m))
+			}
+		}
+		return resources, page.Next, nil
+	case "Coverage":
+		var page struct {
+			Coverage []coverage `json:"coverage"`
+			Next     string     `json:"next"`
+		}
+		if err := s.page(ctx, "coverage", query, true, after, limit, &page); err != nil {
+			return nil, "", err
+		}
+		for _, c := range page.Coverage {
+			if query.includesPatient(c.MemberID) {
+				resources = append(resources, coverageResource(c))
+			}
+		}
+		return resources, page.Next, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported resource type %s", resourceType)
+	}
+}
+
+// GroupPatients implements GroupResolver. Group IDs are coverage group
+// numbers; the group's patients are all its members, whenever updated.
+func (s *EligibilitySource) GroupPatients(ctx context.Context, query Query) (map[string]bool, error) {
+	patients := make(map[string]bool)
+	err := s.members(ctx, query, false, func(m member) error {
+		patients[m.Identifier] = true
+		return nil
+	})
+	return patients, err
+}
+
+func (s *EligibilitySource) members(ctx context.Context, query Query, since bool, each func(member) error) error {
+	return s.pages(ctx, "members", query, since, func(body []byte) (string, error) {
+		var page struct {
+			Members []member `json:"members"`
+			Next    string   `json:"next"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return "", err
+		}
+		for _, m := range page.Members {
+			if err := each(m); err != nil {
+				return "", err
+			}
+		}
+		return page.Next, nil
+	})
+}
+
+// PatientCriteria select patients by national ID, birth date (YYYY-MM-DD)
+// and name; empty criteria are ignored. Names match across Arabic and Latin
+// spellings.
+type PatientCriteria struct {
+	Identifier string
+	BirthDate  string
+	Name       string
+}
+
+// FindPatients returns the patients meeting the criteria, for Patient search
+// and $match. At most limit patients are returned.
+func (s *EligibilitySource) FindPatients(ctx context.Context, tenantID string, criteria PatientCriteria, limit int) ([]fhir.Patient, error) {
+	ctx = tenant.NewContext(ctx, tenantID)
+	params := url.Values{}
+	params.Set("_count", strconv.Itoa(limit))
+	if criteria.Identifier != "" {
+		params.Set("member", criteria.Identifier)
+	}
+	if criteria.BirthDate != "" {
+		params.Set("birth_date", criteria.BirthDate)
+	}
+	if criteria.Name != "" {
+		params.Set("name", criteria.Name)
+	}
+
+	body, err := s.fetch(ctx, "/api/v1/export/members?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("members request failed: %w", err)
+	}
+	var page struct {
+		Members []member `json:"members"`
+	}
+	if err := json.Unmarshal(body, &page); err != nil {
+		return nil, err
+	}
+
+	patients := make([]fhir.Patient, 0, len(page.Members))
+	for _, m := range page.Members {
+		patients = append(patients, patientResource(m))
+	}
+	return patients, nil
+}
+
+// pages fetches the pages of an export endpoint until the last one
+func (s *EligibilitySource) pages(ctx context.Context, endpoint string, query Query, since bool, each func(body []byte) (string, error)) error {
+	ctx = tenant.NewContext(ctx, query.TenantID)
+	params := exportParams(query, since, s.pageSize)
+
+	for {
+		body, err := s.fetch(ctx, "/api/v1/export/"+endpoint+"?"+params.Encode())
+		if err != nil {
+			return fmt.Errorf("%s export request failed: %w", endpoint, err)
+		}
+
+		next, err := each(body)
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		params.Set("after", next)
+	}
+}
+
+// page fetches one page of an export endpoint into v
+func (s *EligibilitySource) page(ctx context.Context, endpoint string, query Query, since bool, after string, limit int, v interface{}) error {
+	ctx = tenant.NewContext(ctx, query.TenantID)
+	params := exportParams(query, since, limit)
+	if after != "" {
+		params.Set("after", after)
+	}
+
+	body, err := s.fetch(ctx, "/api/v1/export/"+endpoint+"?"+params.Encode())
+	if err != nil {
+		return fmt.Errorf("%s export request failed: %w", endpoint, err)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// exportParams are the query parameters of the eligibility service's export
+// endpoints for a query
+func exportParams(query Query, since bool, count int) url.Values {
+	params := url.Values{}
+	params.Set("_count", strconv.Itoa(count))
+	if since && query.Since != nil {
+		params.Set("_since", query.Since.UTC().Format(time.RFC3339))
+	}
+	if query.Level == LevelGroup {
+		params.Set("group", query.GroupID)
+	}
+	if query.Patient != "" {
+		params.Set("member", query.Patient)
+	}
+	return params
+}
+
+func (s *EligibilitySource) fetch(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("eligibility-service returned status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func patientResource(m member) fhir.Patient {
+	active := m.Status == "active"
+	patient := fhir.Patient{
+		ResourceType: "Patient",
+		ID:           m.Identifier,
+		Meta:         &fhir.Meta{LastUpdated: m.UpdatedAt.UTC().Format(time.RFC3339)},
+		Identifier:   []fhir.Identifier{{System: patientIdentifierSystem, Value: m.Identifier}},
+		Active:       &active,
+		Gender:       m.Gender,
+		BirthDate:    m.BirthDate.Format("2006-01-02"),
+	}
+
+	var name fhir.HumanName
+	if json.Unmarshal(m.Name, &name) == nil && (name.Family != "" || len(name.Given) > 0 || name.Text != "") {
+		patient.Name = []fhir.HumanName{name}
+	}
+	var address fhir.Address
+	if json.Unmarshal(m.Address, &address) == nil && (len(address.Line) > 0 || address.City != "") {
+		patient.Address = []fhir.Address{address}
+	}
+	for _, system := range []string{"phone", "email"} {
+		if value, ok := m.ContactInfo[system].(string); ok && value != "" {
+			patient.Telecom = append(patient.Telecom, fhir.ContactPoint{System: system, Value: value})
+		}
+	}
+	return patient
+}
+
+func coverageResource(c coverage) fhir.Coverage {
+	resource := fhir.Coverage{
+		ResourceType: "Coverage",
+		ID:           c.ID,
+		Meta:         &fhir.Meta{LastUpdated: c.UpdatedAt.UTC().Format(time.RFC3339)},
+		Status:       c.Status,
+		Type:         &fhir.CodeableConcept{Text: c.Type},
+		SubscriberID: c.PolicyNumber,
+		Beneficiary:  fhir.Reference{Reference: "Patient/" + c.MemberID},
+		Period:       &fhir.Period{Start: c.EffectiveDate.Format("2006-01-02")},
+		Payor:        []fhir.Reference{{Reference: "Organization/" + c.PayerID}},
+		Network:      c.Network,
+	}
+	if c.ExpirationDate != nil {
+		resource.Period.End = c.ExpirationDate.Format("2006-01-02")
+	}
+	if c.GroupNumber != "" {
+		resource.Class = []fhir.CoverageClass{{
+			Type: fhir.CodeableConcept{Coding: []fhir.Coding{{
+				System: "http://terminology.hl7.org/CodeSystem/coverage-class",
+				Code:   "group",
+			}}},
+			Value: c.GroupNumber,
+		}}
+	}
+	return resource
+}
+
+// PollSource exports the claim responses, eligibility and prior authorization
+// responses and communications retained in the poll queue. Payers only see
+// the responses they issued.
+type PollSource struct {
+	db *sql.DB
+}
+
+// NewPollSource creates a source for the poll queue
+func NewPollSource(db *sql.DB) *PollSource {
+	return &PollSource{db: db}
+}
+
+// Types implements Source
+func (s *PollSource) Types() []string {
+	return []string{"ClaimResponse", "CoverageEligibilityResponse", "Communication"}
+}
+
+// pollMessagesWhere selects the poll messages of a resource type ($1)
+// created since $2, issued by the tenant $3 and about the patient $4
+const pollMessagesWhere = `
+		WHERE message_type = $1
+		  AND ($2::timestamptz IS NULL OR created_at >= $2)
+		  AND ($3::text = ''
+		       OR $3 IN (payload->'insurer'->'identifier'->>'value', payload->'sender'->'identifier'->>'value')
+		       OR 'Organization/' || $3 IN (payload->'insurer'->>'reference', payload->'sender'->>'reference'))
+		  AND ($4::text = ''
+		       OR $4 IN (payload->'patient'->'identifier'->>'value', payload->'subject'->'identifier'->>'value')
+		       OR 'Patient/' || $4 IN (payload->'patient'->>'reference', payload->'subject'->>'reference'))`
+
+// Export implements Source
+func (s *PollSource) Export(ctx context.Context, resourceType string, query Query, emit func(resource interface{}) error) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT payload FROM poll_messages`+pollMessagesWhere+`
+		ORDER BY created_at, id
+	`, resourceType, query.Since, query.TenantID, query.Patient)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&payload); err != nil {
+			return err
+		}
+		if !query.includesPatient(payloadPatient(payload)) {
+			continue
+		}
+		if err := emit(json.RawMessage(payload)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Page implements Source. Cursors are the creation time and ID of the last
+// message read.
+func (s *PollSource) Page(ctx context.Context, resourceType string, query Query, after string, limit int) ([]interface{}, string, error) {
+	var afterCreated sql.NullTime
+	var afterID sql.NullString
+	if after != "" {
+		created, id, ok := strings.Cut(after, "_")
+		parsed, err := time.Parse(time.RFC3339Nano, created)
+		if !ok || err != nil {
+			return nil, "", fmt.Errorf("invalid poll message cursor %q", after)
+		}
+		afterCreated = sql.NullTime{Time: parsed, Valid: true}
+		afterID = sql.NullString{String: id, Valid: true}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, created_at, payload FROM poll_messages`+pollMessagesWhere+`
+		  AND ($5::timestamptz IS NULL OR (created_at, id) > ($5, $6::uuid))
+		ORDER BY created_at, id
+		LIMIT $7
+	`, resourceType, query.Since, query.TenantID, query.Patient, afterCreated, afterID, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var resources []interface{}
+	var read int
+	var last string
+	for rows.Next() {
+		var id string
+		var created time.Time
+		var payload []byte
+		if err := rows.Scan(&id, &created, &payload); err != nil {
+			return nil, "", err
+		}
+		read++
+		last = created.UTC().Format(time.RFC3339Nano) + "_" + id
+		if query.includesPatient(payloadPatient(payload)) {
+			resources = append(resources, json.RawMessage(payload))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if read < limit {
+		last = ""
+	}
+	return resources, last, nil
+}
+
+// payloadPatient returns the patient ID a resource refers to in its patient
+// or subject element, by reference or by identifier
+func payloadPatient(payload []byte) string {
+	var elements struct {
+		Patient *fhir.Reference `json:"patient"`
+		Subject *fhir.Reference `json:"subject"`
+	}
+	if json.Unmarshal(payload, &elements) != nil {
+		return ""
+	}
+	reference := elements.Patient
+	if reference == nil {
+		reference = elements.Subject
+	}
+	switch {
+	case reference == nil:
+		return ""
+	case strings.HasPrefix(reference.Reference, "Patient/"):
+		return strings.TrimPrefix(reference.Reference, "Patient/")
+	case reference.Identifier != nil:
+		return reference.Identifier.Value
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+const (
+	// scanCount is the COUNT hint passed to SCAN
+	scanCount = 500
+
+	// maxReportedKeys caps the keys listed in an invalidation result
+	maxReportedKeys = 1000
+)
+
+// ErrInvalidSelector is returned for invalidation requests that cannot be
+// turned into a safe key pattern
+var ErrInvalidSelector = errors.New("invalid cache selector")
+
+// Selector chooses the keys to invalidate. All fields are optional; an empty
+// selector matches every key of the service. MemberID and Pattern are
+// mutually exclusive.
+type Selector struct {
+	// Namespace is the first key segment, e.g. "coverage"
+	Namespace string
+	// MemberID matches keys whose second segment is the member ID
+	MemberID string
+	// Pattern is a Redis glob matched against the key after the namespace
+	Pattern string
+}
+
+// Invalidation describes the keys an invalidation matched
+type Invalidation struct {
+	Patterns  []string
+	Matched   int64
+	Deleted   int64
+	DryRun    bool
+	Keys      []string
+	Truncated bool
+}
+
+// pattern builds the key pattern for a selector, relative to the prefix
+func (s Selector) pattern() (string, error) {
+	if strings.ContainsAny(s.Namespace, "*?[]\\:") {
+		return "", ErrInvalidSelector
+	}
+	if s.MemberID != "" && s.Pattern != "" {
+		return "", ErrInvalidSelector
+	}
+
+	namespace := s.Namespace
+	if namespace == "" {
+		namespace = "*"
+	}
+
+	switch {
+	case s.MemberID != "":
+		return namespace + ":" + escapePattern(s.MemberID) + ":*", nil
+	case s.Pattern != "":
+		return namespace + ":" + s.Pattern, nil
+	case s.Namespace != "":
+		return namespace + ":*", nil
+	default:
+		return "*", nil
+	}
+}
+
+// Invalidate deletes the keys chosen by the selector. With dryRun set nothing
+// is deleted and the result reports what would have been. On a manager not
+// scoped to a tenant the selector also matches the keys of every tenant.
+func (m *Manager) Invalidate(ctx context.Context, selector Selector, dryRun bool) (*Invalidation, error) {
+	pattern, err := selector.pattern()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Invalidation{Patterns: []string{m.key(pattern)}, DryRun: dryRun}
+	// Tenant keys ("tenant:<id>:<namespace>:...") only match patterns that
+	// start with a wildcard, so namespaced ones are repeated under them
+	if m.tenantID == "" && selector.Namespace != "" {
+		result.Patterns = append(result.Patterns, m.key("tenant:*:"+pattern))
+	}
+
+	report := func(batch []string) {
+		result.Matched += int64(len(batch))
+		for _, key := range batch {
+			if len(result.Keys) == maxReportedKeys {
+				result.Truncated = true
+				return
+			}
+			result.Keys = append(result.Keys, strings.TrimPrefix(key, m.prefix+":"))
+		}
+	}
+
+	for _, pattern := range result.Patterns {
+		err := m.scan(ctx, pattern, func(batch []string) error {
+			report(batch)
+			if dryRun {
+				return nil
+			}
+			deleted, err := m.client.Unlink(ctx, batch...).Result()
+			result.Deleted += deleted
+			return err
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// deleteMatching deletes every key matching an absolute pattern
+func (m *Manager) deleteMatching(ctx context.Context, pattern string) (int64, error) {
+	var deleted int64
+	err := m.scan(ctx, pattern, func(batch []string) error {
+		n, err := m.client.Unlink(ctx, batch...).Result()
+		deleted += n
+		return err
+	})
+	return deleted, err
+}
+
+// scan walks the keyspace with SCAN and calls fn for each non-empty batch of
+// keys matching an absolute pattern. Keys may be reported more than once if
+// the keyspace is rehashed during the scan, which is harmless for deletion.
+func (m *Manager) scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := m.client.Scan(ctx, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// escapePattern escapes glob characters so that a value matches literally
+func escapePattern(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
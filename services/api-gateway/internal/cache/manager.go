@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Manager handles caching operations. Every key is stored under the service
+// prefix ("<prefix>:<key>") so that services sharing a Redis never touch each
+// other's data.
+type Manager struct {
+	client   *redis.Client
+	prefix   string
+	tenantID string // set on managers returned by ForTenant
+	ttl      time.Duration
+}
+
+// NewManager creates a new cache manager for the given key prefix
+func NewManager(client *redis.Client, prefix string, ttl time.Duration) *Manager {
+	return &Manager{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// Prefix returns the prefix all keys of this manager are stored under
+func (m *Manager) Prefix() string {
+	return m.prefix
+}
+
+// ForTenant returns a manager whose keys are stored under the tenant's own
+// prefix ("<prefix>:tenant:<tenantID>:<key>"), so that cached data of one
+// payer is never served to another. An empty tenant returns m.
+// Invalidations on m also match the keys of every tenant.
+func (m *Manager) ForTenant(tenantID string) *Manager {
+	if tenantID == "" {
+		return m
+	}
+	return &Manager{
+		client:   m.client,
+		prefix:   m.prefix + ":tenant:" + tenantID,
+		tenantID: tenantID,
+		ttl:      m.ttl,
+	}
+}
+
+// key returns the stored form of a key
+func (m *Manager) key(key string) string {
+	return m.prefix + ":" + key
+}
+
+// Key joins a namespace and key parts into a cache key, e.g.
+// Key("coverage", memberID, date) is "coverage:<memberID>:<date>"
+func Key(namespace string, parts ...string) string {
+	return strings.Join(append([]string{namespace}, parts...), ":")
+}
+
+// Get retrieves a value from cache
+func (m *Manager) Get(ctx context.Context, key string) (string, error) {
+	return m.client.Get(ctx, m.key(key)).Result()
+}
+
+// Set stores a value in cache with TTL
+func (m *Manager) Set(ctx context.Context, key, value string) error {
+	return m.client.Set(ctx, m.key(key), value, m.ttl).Err()
+}
+
+// SetWithTTL stores a value in cache with custom TTL
+func (m *Manager) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return m.client.Set(ctx, m.key(key), value, ttl).Err()
+}
+
+// Delete removes a value from cache
+func (m *Manager) Delete(ctx context.Context, key string) error {
+	return m.client.Del(ctx, m.key(key)).Err()
+}
+
+// GetJSON retrieves and unmarshals a JSON value from cache
+func (m *Manager) GetJSON(ctx context.Context, key string, dest interface{}) error {
+	val, err := m.client.Get(ctx, m.key(key)).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(val), dest)
+}
+
+// SetJSON marshals and stores a JSON value in cache
+func (m *Manager) SetJSON(ctx context.Context, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return m.client.Set(ctx, m.key(key), data, m.ttl).Err()
+}
+
+// Exists checks if a key exists in cache
+func (m *Manager) Exists(ctx context.Context, key string) (bool, error) {
+	count, err := m.client.Exists(ctx, m.key(key)).Result()
+	return count > 0, err
+}
+
+// TTL returns the TTL of a key
+func (m *Manager) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return m.client.TTL(ctx, m.key(key)).Result()
+}
+
+// Expire sets a new TTL for a key
+func (m *Manager) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return m.client.Expire(ctx, m.key(key), ttl).Err()
+}
+
+// Keys returns all keys matching a pattern, without the prefix. Keys are
+// collected with SCAN so that Redis is never blocked.
+func (m *Manager) Keys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	err := m.scan(ctx, m.key(pattern), func(batch []string) error {
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, m.prefix+":"))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// DeletePattern deletes all keys matching a pattern
+func (m *Manager) DeletePattern(ctx context.Context, pattern string) error {
+	_, err := m.deleteMatching(ctx, m.key(pattern))
+	return err
+}
+
+// IncrementCounter increments a counter key
+func (m *Manager) IncrementCounter(ctx context.Context, key string) (int64, error) {
+	return m.client.Incr(ctx, m.key(key)).Result()
+}
+
+// IncrementCounterWithTTL increments a counter key and sets its TTL in one
+// round trip, so that counters of fixed time windows expire on their own
+func (m *Manager) IncrementCounterWithTTL(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := m.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, m.key(key))
+		pipe.Expire(ctx, m.key(key), ttl)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// GetCounter gets the value of a counter key
+func (m *Manager) GetCounter(ctx context.Context, key string) (int64, error) {
+	result := m.client.Get(ctx, m.key(key))
+	if result.Err() == redis.Nil {
+		return 0, nil
+	}
+	if result.Err() != nil {
+		return 0, result.Err()
+	}
+
+	return result.Int64()
+}
+
+// SetCounter sets a counter value
+func (m *Manager) SetCounter(ctx context.Context, key string, value int64) error {
+	return m.client.Set(ctx, m.key(key), value, m.ttl).Err()
+}
+
+// GetInfo returns cache information
+func (m *Manager) GetInfo(ctx context.Context) (*redis.StringCmd, error) {
+	return m.client.Info(ctx), nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -17,14 +18,34 @@ type Config struct {
 	}
 	
 	Redis struct {
-		URL      string
-		Password string
-		DB       int
+		URL       string
+		Password  string
+		DB        int
+		TTL       int    // Cache TTL in seconds
+		KeyPrefix string // Namespace for all keys of this service
 	}
 	
 	Kafka struct {
 		Brokers []string
 		Topics  KafkaTopics
+
+		// Security
+		SASLMechanism         string // PLAIN, SCRAM-SHA-256, SCRAM-SHA-512
+		Username              string
+		Password              string
+		TLSEnabled            bool
+		TLSCAPath             string
+		TLSCertPath           string
+		TLSKeyPath            string
+		TLSInsecureSkipVerify bool
+
+		// Delivery
+		RequiredAcks   string // none, one, all
+		Compression    string // none, gzip, snappy, lz4, zstd
+		TopicOverrides string // topic=acks:compression,...
+		BatchSize      int
+		BatchTimeoutMs int
+		WriteTimeout   int // seconds
 	}
 	
 	RateLimit struct {
@@ -43,23 +64,163 @@ type Config struct {
 		ClientSecret string
 	}
 	
+	Policy struct {
+		Source            string // builtin, file or db
+		File              string // JSON policy document for the file source
+		ReloadInterval    int    // seconds between policy reloads for the file and db sources
+		DecisionCacheTTL  int    // seconds a decision is cached; 0 disables the cache
+		DecisionCacheSize int
+	}
+	
+	APIKeys struct {
+		Pepper                string // HMAC key for stored key hashes
+		DefaultQuotaPerMinute int    // for keys without a quota of their own; 0 is unlimited
+		RotationGraceSeconds  int    // how long a rotated key keeps working by default
+		UsageFlushInterval    int    // seconds between last-used writes
+	}
+	
+	Tenancy struct {
+		Required bool // reject callers whose credentials are not scoped to a payer
+	}
+	
+	Consent struct {
+		Enabled        bool     // check member consent before disclosing data to third parties
+		CacheTTL       int      // seconds a consent decision is cached; 0 disables the cache
+		DefaultPurpose string   // purpose of use of requests without X-Purpose-Of-Use
+		ExemptRoles    []string // roles never treated as third-party recipients
+	}
+	
+	BreakGlass struct {
+		Enabled     bool
+		TTL         int      // minutes a session lasts unless the caller asks for less
+		MaxTTL      int      // longest session a caller may ask for, minutes
+		ReasonCodes []string // reasons a caller may give for breaking the glass
+	}
+	
 	Services struct {
 		EligibilityURL   string
 		ClaimsURL        string
 		TerminologyURL   string
+		WalletURL        string
 	}
 	
 	Security struct {
-		EnableMTLS     bool
+		TLSEnabled     bool     // serve HTTPS; implied by EnableMTLS
+		EnableMTLS     bool     // verify client certificates and map them to clients
 		TLSCertPath    string
 		TLSKeyPath     string
-		TrustedCACerts []string
+		TrustedCACerts []string // CA bundles that issue client certificates
+		ClientMap      string   // identity=clientID entries separated by ';'
+		ReloadInterval int      // seconds between certificate file checks
+
+		// Client certificate presented to upstream services
+		UpstreamMTLS     bool
+		UpstreamCertPath string
+		UpstreamKeyPath  string
+		UpstreamCACerts  []string
+
+		// Secret shared with upstream services to sign requests to them
+		ServiceAuthSecret string
+	}
+	
+	CORS struct {
+		AllowedOrigins      []string // public API origins; "https://*.example.sa" matches subdomains
+		AdminAllowedOrigins []string // origins allowed to call /api/v1/admin
+		AllowedHeaders      []string // request headers allowed in preflight requests
+		AllowCredentials    bool
+		MaxAge              int // seconds browsers may cache a preflight result
 	}
 	
 	Monitoring struct {
-		MetricsEnabled bool
-		TracingEnabled bool
-		LogLevel       string
+		MetricsEnabled   bool
+		TracingEnabled   bool
+		LogLevel         string
+		TraceExporter    string  // otlp, stdout or none
+		OTLPEndpoint     string
+		OTLPInsecure     bool
+		TraceSampleRatio float64
+	}
+	
+	Redaction struct {
+		Enabled       bool
+		Mode          string   // mask or tokenize
+		AllowlistMode bool     // redact every log field that is not allowlisted
+		Fields        []string // PHI field names in addition to the defaults
+		Allowlist     []string // field names logged as-is in allowlist mode
+		TokenKey      string   // HMAC key for tokenize mode
+	}
+	
+	Poll struct {
+		DefaultBatchSize  int
+		MaxBatchSize      int
+		LeaseSeconds      int
+		MessageTTLHours   int
+		AckRetentionHours int
+		SweepInterval     int // seconds
+		ConsumerGroup     string
+	}
+	
+	Audit struct {
+		ConsumerGroup      string
+		DefaultPageSize    int
+		MaxPageSize        int
+		CheckpointInterval int      // seconds
+		SigningKey         string   // base64 Ed25519 seed
+		TrustedKeys        []string // base64 Ed25519 public keys of retired signing keys
+		AnchorCheckpoints  bool     // anchor checkpoints through the wallet-service blockchain API
+	}
+	
+	Outbox struct {
+		PollIntervalMs    int
+		BatchSize         int
+		MaxAttempts       int
+		MaxBackoffSeconds int
+		RetentionHours    int
+	}
+
+	Subscriptions struct {
+		Enabled               bool
+		ConsumerGroup         string
+		DeliveryIntervalMs    int
+		BatchSize             int
+		MaxAttempts           int
+		MaxBackoffSeconds     int
+		RetentionHours        int
+		DefaultTimeout        int // seconds per delivery attempt
+		MaxTimeout            int
+		MinHeartbeatPeriod    int // seconds
+		MaxPerOrganization    int
+		AllowHTTPEndpoints    bool
+		AllowPrivateEndpoints bool // endpoints resolving to loopback or private addresses
+	}
+
+	BulkExport struct {
+		Enabled               bool
+		FileStore             string // local
+		Directory             string
+		PollIntervalMs        int
+		LeaseSeconds          int
+		MaxAttempts           int
+		RetentionHours        int
+		PageSize              int // records per eligibility-service export page
+		MaxActivePerRequester int
+		BaseURL               string // public gateway URL for status and file links; taken from the request when empty
+		FileTimeoutSeconds    int    // to send an export file, beyond the server's write timeout
+	}
+
+	Attachments struct {
+		Enabled            bool
+		ObjectStore        string // local
+		Directory          string
+		MaxSizeMB          int
+		MaxInlineKB        int // largest document claims may still carry inline
+		AllowedTypes       []string
+		Scanner            string // none, clamd
+		ClamdAddress       string // host:port or Unix socket path
+		ScanTimeoutSeconds int
+		// To upload or download an attachment, beyond the server's read and
+		// write timeouts; uploads include the scan
+		TransferTimeoutSeconds int
 	}
 }
 
@@ -69,6 +230,7 @@ type KafkaTopics struct {
 	EligibilityResponses string
 	PriorAuthRequests   string
 	PriorAuthStatus     string
+	ClaimsResponses     string
 	FraudAlerts         string
 	AuditTrail          string
 }
@@ -90,18 +252,35 @@ func Load() (*Config, error) {
 	cfg.Redis.URL = getEnv("REDIS_URL", "redis://localhost:6379")
 	cfg.Redis.Password = getEnv("REDIS_PASSWORD", "")
 	cfg.Redis.DB = getEnvInt("REDIS_DB", 0)
+	cfg.Redis.TTL = getEnvInt("REDIS_TTL", 300)
+	cfg.Redis.KeyPrefix = getEnv("REDIS_KEY_PREFIX", "api-gateway")
 
 	// Kafka configuration
-	cfg.Kafka.Brokers = []string{getEnv("KAFKA_BROKERS", "localhost:9092")}
+	cfg.Kafka.Brokers = getEnvList("KAFKA_BROKERS", []string{"localhost:9092"})
 	cfg.Kafka.Topics = KafkaTopics{
 		ClaimsIntake:         "claims.intake.v1",
 		EligibilityRequests:  "eligibility.requests.v1",
 		EligibilityResponses: "eligibility.responses.v1",
 		PriorAuthRequests:    "priorauth.requests.v1",
 		PriorAuthStatus:      "priorauth.status.v1",
+		ClaimsResponses:      "claims.responses.v1",
 		FraudAlerts:          "fraud.alerts.v1",
 		AuditTrail:           "audit.trail.v1",
 	}
+	cfg.Kafka.SASLMechanism = getEnv("KAFKA_SASL_MECHANISM", "")
+	cfg.Kafka.Username = getEnv("KAFKA_USERNAME", "")
+	cfg.Kafka.Password = getEnv("KAFKA_PASSWORD", "")
+	cfg.Kafka.TLSEnabled = getEnvBool("KAFKA_TLS_ENABLED", false)
+	cfg.Kafka.TLSCAPath = getEnv("KAFKA_TLS_CA_PATH", "")
+	cfg.Kafka.TLSCertPath = getEnv("KAFKA_TLS_CERT_PATH", "")
+	cfg.Kafka.TLSKeyPath = getEnv("KAFKA_TLS_KEY_PATH", "")
+	cfg.Kafka.TLSInsecureSkipVerify = getEnvBool("KAFKA_TLS_INSECURE_SKIP_VERIFY", false)
+	cfg.Kafka.RequiredAcks = getEnv("KAFKA_REQUIRED_ACKS", "all")
+	cfg.Kafka.Compression = getEnv("KAFKA_COMPRESSION", "snappy")
+	cfg.Kafka.TopicOverrides = getEnv("KAFKA_TOPIC_OVERRIDES", "")
+	cfg.Kafka.BatchSize = getEnvInt("KAFKA_BATCH_SIZE", 100)
+	cfg.Kafka.BatchTimeoutMs = getEnvInt("KAFKA_BATCH_TIMEOUT_MS", 50)
+	cfg.Kafka.WriteTimeout = getEnvInt("KAFKA_WRITE_TIMEOUT", 10)
 
 	// Rate limiting
 	cfg.RateLimit.RequestsPerMinute = getEnvInt("RATE_LIMIT_RPM", 500)
@@ -116,20 +295,162 @@ func Load() (*Config, error) {
 	cfg.Auth.ClientID = getEnv("OAUTH_CLIENT_ID", "")
 	cfg.Auth.ClientSecret = getEnv("OAUTH_CLIENT_SECRET", "")
 
+	// Access policy configuration
+	cfg.Policy.File = getEnv("POLICY_FILE", "")
+	defaultPolicySource := "builtin"
+	if cfg.Policy.File != "" {
+		defaultPolicySource = "file"
+	}
+	cfg.Policy.Source = getEnv("POLICY_SOURCE", defaultPolicySource)
+	cfg.Policy.ReloadInterval = getEnvInt("POLICY_RELOAD_INTERVAL", 60)
+	cfg.Policy.DecisionCacheTTL = getEnvInt("POLICY_DECISION_CACHE_TTL", 30)
+	cfg.Policy.DecisionCacheSize = getEnvInt("POLICY_DECISION_CACHE_SIZE", 10000)
+
 	// Service URLs
 	cfg.Services.EligibilityURL = getEnv("ELIGIBILITY_SERVICE_URL", "http://localhost:8090")
 	cfg.Services.ClaimsURL = getEnv("CLAIMS_SERVICE_URL", "http://localhost:8092")
 	cfg.Services.TerminologyURL = getEnv("TERMINOLOGY_SERVICE_URL", "http://localhost:8091")
+	cfg.Services.WalletURL = getEnv("WALLET_SERVICE_URL", "http://localhost:8093")
 
 	// Security configuration
 	cfg.Security.EnableMTLS = getEnvBool("ENABLE_MTLS", false)
+	cfg.Security.TLSEnabled = getEnvBool("TLS_ENABLED", cfg.Security.EnableMTLS)
 	cfg.Security.TLSCertPath = getEnv("TLS_CERT_PATH", "")
 	cfg.Security.TLSKeyPath = getEnv("TLS_KEY_PATH", "")
+	cfg.Security.TrustedCACerts = getEnvList("TLS_CA_CERTS", nil)
+	cfg.Security.ClientMap = getEnv("MTLS_CLIENT_MAP", "")
+	cfg.Security.ReloadInterval = getEnvInt("TLS_RELOAD_INTERVAL", 30)
+	cfg.Security.UpstreamMTLS = getEnvBool("UPSTREAM_MTLS_ENABLED", false)
+	cfg.Security.UpstreamCertPath = getEnv("UPSTREAM_TLS_CERT_PATH", cfg.Security.TLSCertPath)
+	cfg.Security.UpstreamKeyPath = getEnv("UPSTREAM_TLS_KEY_PATH", cfg.Security.TLSKeyPath)
+	cfg.Security.UpstreamCACerts = getEnvList("UPSTREAM_TLS_CA_CERTS", cfg.Security.TrustedCACerts)
+	cfg.Security.ServiceAuthSecret = getEnv("SERVICE_AUTH_SECRET", "")
+
+	// API key configuration
+	cfg.APIKeys.Pepper = getEnv("API_KEY_PEPPER", "")
+	cfg.APIKeys.DefaultQuotaPerMinute = getEnvInt("API_KEY_DEFAULT_QUOTA_PER_MINUTE", 600)
+	cfg.APIKeys.RotationGraceSeconds = getEnvInt("API_KEY_ROTATION_GRACE_SECONDS", 86400) // 24 hours
+	cfg.APIKeys.UsageFlushInterval = getEnvInt("API_KEY_USAGE_FLUSH_INTERVAL", 30)
+
+	// Tenancy configuration
+	cfg.Tenancy.Required = getEnvBool("TENANT_REQUIRED", true)
+
+	cfg.Consent.Enabled = getEnvBool("CONSENT_ENFORCEMENT_ENABLED", cfg.Environment != "development")
+	cfg.Consent.CacheTTL = getEnvInt("CONSENT_CACHE_TTL", 60)
+	cfg.Consent.DefaultPurpose = getEnv("CONSENT_DEFAULT_PURPOSE", "TREAT")
+	cfg.Consent.ExemptRoles = getEnvList("CONSENT_EXEMPT_ROLES", []string{"admin", "auditor", "break_glass"})
+
+	cfg.BreakGlass.Enabled = getEnvBool("BREAK_GLASS_ENABLED", true)
+	cfg.BreakGlass.TTL = getEnvInt("BREAK_GLASS_TTL_MINUTES", 30)
+	cfg.BreakGlass.MaxTTL = getEnvInt("BREAK_GLASS_MAX_TTL_MINUTES", 120)
+	cfg.BreakGlass.ReasonCodes = getEnvList("BREAK_GLASS_REASON_CODES", []string{
+		"emergency_treatment", "unconscious_patient", "urgent_coverage_verification", "system_outage",
+	})
+
+	// CORS configuration. No origin is allowed unless configured, except for
+	// local frontends during development.
+	var devOrigins []string
+	if cfg.Environment == "development" {
+		devOrigins = []string{"http://localhost:3000", "http://127.0.0.1:3000"}
+	}
+	cfg.CORS.AllowedOrigins = getEnvList("CORS_ALLOWED_ORIGINS", devOrigins)
+	cfg.CORS.AdminAllowedOrigins = getEnvList("CORS_ADMIN_ALLOWED_ORIGINS", devOrigins)
+	cfg.CORS.AllowedHeaders = getEnvList("CORS_ALLOWED_HEADERS", []string{
+		"Authorization", "Content-Type", "Accept", "Accept-Language", "Cache-Control",
+		"X-Requested-With", "X-Request-ID", "X-Correlation-ID", "X-Tenant-ID", "X-Purpose-Of-Use",
+	})
+	cfg.CORS.AllowCredentials = getEnvBool("CORS_ALLOW_CREDENTIALS", true)
+	cfg.CORS.MaxAge = getEnvInt("CORS_MAX_AGE", 600)
 
 	// Monitoring configuration
 	cfg.Monitoring.MetricsEnabled = getEnvBool("METRICS_ENABLED", true)
 	cfg.Monitoring.TracingEnabled = getEnvBool("TRACING_ENABLED", true)
 	cfg.Monitoring.LogLevel = getEnv("LOG_LEVEL", "info")
+	cfg.Monitoring.TraceExporter = getEnv("OTEL_TRACES_EXPORTER", "otlp")
+	cfg.Monitoring.OTLPEndpoint = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	cfg.Monitoring.OTLPInsecure = getEnvBool("OTEL_EXPORTER_OTLP_INSECURE", true)
+	cfg.Monitoring.TraceSampleRatio = getEnvFloat("OTEL_TRACES_SAMPLER_ARG", 1.0)
+
+	// Log redaction is on outside development; production logs only
+	// allowlisted fields as-is
+	cfg.Redaction.Enabled = getEnvBool("LOG_REDACTION_ENABLED", cfg.Environment != "development")
+	cfg.Redaction.Mode = getEnv("LOG_REDACTION_MODE", "mask")
+	cfg.Redaction.AllowlistMode = getEnvBool("LOG_REDACTION_ALLOWLIST_MODE", cfg.Environment == "production")
+	cfg.Redaction.Fields = getEnvList("LOG_REDACTION_FIELDS", nil)
+	cfg.Redaction.Allowlist = getEnvList("LOG_REDACTION_ALLOWLIST", nil)
+	cfg.Redaction.TokenKey = getEnv("LOG_REDACTION_TOKEN_KEY", "")
+
+	// Poll queue configuration
+	cfg.Poll.DefaultBatchSize = getEnvInt("POLL_BATCH_SIZE", 10)
+	cfg.Poll.MaxBatchSize = getEnvInt("POLL_MAX_BATCH_SIZE", 50)
+	cfg.Poll.LeaseSeconds = getEnvInt("POLL_LEASE_SECONDS", 300)         // 5 minutes
+	cfg.Poll.MessageTTLHours = getEnvInt("POLL_MESSAGE_TTL_HOURS", 720)  // 30 days
+	cfg.Poll.AckRetentionHours = getEnvInt("POLL_ACK_RETENTION_HOURS", 168) // 7 days
+	cfg.Poll.SweepInterval = getEnvInt("POLL_SWEEP_INTERVAL", 300)
+	cfg.Poll.ConsumerGroup = getEnv("POLL_CONSUMER_GROUP", "api-gateway-poll")
+
+	// Audit trail configuration
+	cfg.Audit.ConsumerGroup = getEnv("AUDIT_CONSUMER_GROUP", "api-gateway-audit")
+	cfg.Audit.DefaultPageSize = getEnvInt("AUDIT_PAGE_SIZE", 100)
+	cfg.Audit.MaxPageSize = getEnvInt("AUDIT_MAX_PAGE_SIZE", 1000)
+	cfg.Audit.CheckpointInterval = getEnvInt("AUDIT_CHECKPOINT_INTERVAL", 3600) // 1 hour
+	cfg.Audit.SigningKey = getEnv("AUDIT_SIGNING_KEY", "")
+	cfg.Audit.TrustedKeys = getEnvList("AUDIT_TRUSTED_KEYS", nil)
+	cfg.Audit.AnchorCheckpoints = getEnvBool("AUDIT_ANCHOR_CHECKPOINTS", false)
+
+	// Outbox relay configuration
+	cfg.Outbox.PollIntervalMs = getEnvInt("OUTBOX_POLL_INTERVAL_MS", 500)
+	cfg.Outbox.BatchSize = getEnvInt("OUTBOX_BATCH_SIZE", 100)
+	cfg.Outbox.MaxAttempts = getEnvInt("OUTBOX_MAX_ATTEMPTS", 20)
+	cfg.Outbox.MaxBackoffSeconds = getEnvInt("OUTBOX_MAX_BACKOFF_SECONDS", 300)
+	cfg.Outbox.RetentionHours = getEnvInt("OUTBOX_RETENTION_HOURS", 72)
+
+	// FHIR Subscriptions with REST-hook delivery. Plain HTTP and private
+	// endpoints are only allowed by default in development.
+	cfg.Subscriptions.Enabled = getEnvBool("SUBSCRIPTIONS_ENABLED", true)
+	cfg.Subscriptions.ConsumerGroup = getEnv("SUBSCRIPTION_CONSUMER_GROUP", "api-gateway-subscriptions")
+	cfg.Subscriptions.DeliveryIntervalMs = getEnvInt("SUBSCRIPTION_DELIVERY_INTERVAL_MS", 1000)
+	cfg.Subscriptions.BatchSize = getEnvInt("SUBSCRIPTION_BATCH_SIZE", 50)
+	cfg.Subscriptions.MaxAttempts = getEnvInt("SUBSCRIPTION_MAX_ATTEMPTS", 10)
+	cfg.Subscriptions.MaxBackoffSeconds = getEnvInt("SUBSCRIPTION_MAX_BACKOFF_SECONDS", 3600)
+	cfg.Subscriptions.RetentionHours = getEnvInt("SUBSCRIPTION_RETENTION_HOURS", 168) // 7 days
+	cfg.Subscriptions.DefaultTimeout = getEnvInt("SUBSCRIPTION_DEFAULT_TIMEOUT", 10)
+	cfg.Subscriptions.MaxTimeout = getEnvInt("SUBSCRIPTION_MAX_TIMEOUT", 60)
+	cfg.Subscriptions.MinHeartbeatPeriod = getEnvInt("SUBSCRIPTION_MIN_HEARTBEAT_PERIOD", 60)
+	cfg.Subscriptions.MaxPerOrganization = getEnvInt("SUBSCRIPTION_MAX_PER_ORGANIZATION", 20)
+	cfg.Subscriptions.AllowHTTPEndpoints = getEnvBool("SUBSCRIPTION_ALLOW_HTTP_ENDPOINTS", cfg.Environment == "development")
+	cfg.Subscriptions.AllowPrivateEndpoints = getEnvBool("SUBSCRIPTION_ALLOW_PRIVATE_ENDPOINTS", cfg.Environment == "development")
+
+	// FHIR Bulk Data $export. NDJSON files are written to the file store and
+	// kept for the retention period.
+	cfg.BulkExport.Enabled = getEnvBool("BULK_EXPORT_ENABLED", true)
+	cfg.BulkExport.FileStore = getEnv("BULK_EXPORT_FILE_STORE", "local")
+	cfg.BulkExport.Directory = getEnv("BULK_EXPORT_DIRECTORY", "/var/lib/nphies/bulk-export")
+	cfg.BulkExport.PollIntervalMs = getEnvInt("BULK_EXPORT_POLL_INTERVAL_MS", 5000)
+	cfg.BulkExport.LeaseSeconds = getEnvInt("BULK_EXPORT_LEASE_SECONDS", 120)
+	cfg.BulkExport.MaxAttempts = getEnvInt("BULK_EXPORT_MAX_ATTEMPTS", 3)
+	cfg.BulkExport.RetentionHours = getEnvInt("BULK_EXPORT_RETENTION_HOURS", 24)
+	cfg.BulkExport.PageSize = getEnvInt("BULK_EXPORT_PAGE_SIZE", 500)
+	cfg.BulkExport.MaxActivePerRequester = getEnvInt("BULK_EXPORT_MAX_ACTIVE_PER_REQUESTER", 3)
+	cfg.BulkExport.BaseURL = getEnv("BULK_EXPORT_BASE_URL", "")
+	cfg.BulkExport.FileTimeoutSeconds = getEnvInt("BULK_EXPORT_FILE_TIMEOUT_SECONDS", 1800)
+
+	// Claim attachments are uploaded to the object store and referenced by
+	// URL. Uploads are scanned when a scanner is configured.
+	cfg.Attachments.Enabled = getEnvBool("ATTACHMENTS_ENABLED", true)
+	cfg.Attachments.ObjectStore = getEnv("ATTACHMENT_OBJECT_STORE", "local")
+	cfg.Attachments.Directory = getEnv("ATTACHMENT_DIRECTORY", "/var/lib/nphies/attachments")
+	cfg.Attachments.MaxSizeMB = getEnvInt("ATTACHMENT_MAX_SIZE_MB", 50)
+	cfg.Attachments.MaxInlineKB = getEnvInt("ATTACHMENT_MAX_INLINE_KB", 256)
+	cfg.Attachments.AllowedTypes = getEnvList("ATTACHMENT_ALLOWED_TYPES", []string{
+		"application/pdf", "image/jpeg", "image/png", "image/tiff", "application/dicom", "text/plain",
+	})
+	cfg.Attachments.Scanner = getEnv("ATTACHMENT_SCANNER", "none")
+	cfg.Attachments.ClamdAddress = getEnv("ATTACHMENT_CLAMD_ADDRESS", "clamav:3310")
+	cfg.Attachments.ScanTimeoutSeconds = getEnvInt("ATTACHMENT_SCAN_TIMEOUT_SECONDS", 60)
+	// Enough for the largest attachment at 1 Mbit/s, and its scan
+	cfg.Attachments.TransferTimeoutSeconds = getEnvInt("ATTACHMENT_TRANSFER_TIMEOUT_SECONDS",
+		cfg.Attachments.MaxSizeMB*8+cfg.Attachments.ScanTimeoutSeconds)
 
 	return cfg, nil
 }
@@ -151,6 +472,21 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvList(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			return items
+		}
+	}
+	return defaultValue
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -158,4 +494,12 @@ func getEnvBool(key string, defaultValue bool) bool {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,117 @@
+// Package consent asks the wallet service whether a member has consented to
+// a disclosure. Decisions are cached briefly, so that a revoked consent stops
+// permitting disclosures within the cache TTL.
+package consent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/cache"
+	"github.com/sirupsen/logrus"
+)
+
+// Data types of the gateway's FHIR resources, as listed in consents
+const (
+	DataTypeDemographics = "DEMOGRAPHICS" // Patient
+	DataTypeCoverage     = "COVERAGE"     // Coverage and eligibility
+	DataTypeClaims       = "CLAIMS"       // Claim and ClaimResponse
+)
+
+// Request describes a disclosure of a member's data to a recipient
+type Request struct {
+	MemberID  string `json:"member_id"`
+	Recipient string `json:"recipient"`
+	DataType  string `json:"data_type"`
+	Purpose   string `json:"purpose,omitempty"`
+}
+
+// Decision is the wallet service's answer to a Request
+type Decision struct {
+	Permit    bool   `json:"permit"`
+	ConsentID string `json:"consent_id,omitempty"`
+	Reason    string `json:"reason"`
+	Cached    bool   `json:"-"`
+}
+
+// Client requests consent decisions from the wallet service
+type Client struct {
+	baseURL string
+	client  *http.Client
+	cache   *cache.Manager
+	ttl     time.Duration
+	logger  *logrus.Logger
+}
+
+// NewClient creates a client for the wallet service at baseURL. Decisions are
+// cached for ttl; a zero ttl disables caching.
+func NewClient(baseURL string, client *http.Client, cacheManager *cache.Manager, ttl time.Duration, logger *logrus.Logger) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+		cache:   cacheManager,
+		ttl:     ttl,
+		logger:  logger,
+	}
+}
+
+// Decide returns whether a consent permits the disclosure. An error means no
+// decision could be obtained; callers must then deny the disclosure.
+func (c *Client) Decide(ctx context.Context, req Request) (Decision, error) {
+	key := cache.Key("consent", req.MemberID, req.Recipient, req.DataType, req.Purpose)
+	if c.ttl > 0 {
+		var decision Decision
+		if err := c.cache.GetJSON(ctx, key, &decision); err == nil {
+			decision.Cached = true
+			return decision, nil
+		}
+	}
+
+	decision, err := c.fetch(ctx, req)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	if c.ttl > 0 {
+		if data, err := json.Marshal(decision); err == nil {
+			if err := c.cache.SetWithTTL(ctx, key, string(data), c.ttl); err != nil {
+				c.logger.WithContext(ctx).Warnf("Failed to cache consent decision: %v", err)
+			}
+		}
+	}
+	return decision, nil
+}
+
+func (c *Client) fetch(ctx context.Context, req Request) (Decision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/consent/decision", bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return Decision{}, fmt.Errorf("consent decision request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Decision{}, fmt.Errorf("consent decision request failed with status %d", resp.StatusCode)
+	}
+
+	var decision Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, fmt.Errorf("invalid consent decision: %w", err)
+	}
+	return decision, nil
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/apikey"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// API key administration - static keys for systems that cannot use OAuth
+
+// CreateAPIKey godoc
+// @Summary Issue an API key
+// @Description Issue an API key bound to an active organization. The key is returned once and cannot be retrieved again.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.CreateAPIKeyRequest true "API key to issue"
+// @Success 201 {object} models.IssuedAPIKey
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/api-keys [post]
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid API key request",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if !h.validScopes(c, req.Scopes) {
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid API key request",
+			Message:   "expires_at must be in the future",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	key, fullKey, err := h.apiKeys.Create(c.Request.Context(), apikey.NewKey{
+		OrganizationID: req.OrganizationID,
+		Name:           req.Name,
+		Scopes:         req.Scopes,
+		QuotaPerMinute: req.QuotaPerMinute,
+		ExpiresAt:      req.ExpiresAt,
+		CreatedBy:      c.GetString("userID"),
+	})
+	if err != nil {
+		if errors.Is(err, apikey.ErrOrganizationNotFound) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Unknown organization",
+				Message:   "Organization " + req.OrganizationID + " does not exist or is not active",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to issue API key: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "API key issue failed",
+			Message:   "Unable to issue API key",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	h.logAuditEvent(c.Request.Context(), "apikey.issued", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"apiKeyID":       key.ID,
+		"prefix":         key.Prefix,
+		"organizationID": key.OrganizationID,
+		"scopes":         key.Scopes,
+		"quotaPerMinute": key.QuotaPerMinute,
+	})
+
+	c.JSON(http.StatusCreated, models.IssuedAPIKey{APIKey: apiKeyModel(key), Key: fullKey})
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description List API keys, newest first, optionally filtered by organization and status
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param organization_id query string false "Organization ID"
+// @Param status query string false "active or revoked"
+// @Success 200 {object} models.APIKeyListResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/api-keys [get]
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	filter := apikey.Filter{
+		OrganizationID: c.Query("organization_id"),
+		Status:         c.Query("status"),
+	}
+	if filter.OrganizationID != "" {
+		if _, err := uuid.Parse(filter.OrganizationID); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid parameter",
+				Message:   "Parameter organization_id must be a UUID",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+	}
+	if filter.Status != "" && filter.Status != apikey.StatusActive && filter.Status != apikey.StatusRevoked {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid parameter",
+			Message:   "Parameter status must be active or revoked",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	keys, err := h.apiKeys.List(c.Request.Context(), filter)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to list API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "API key query failed",
+			Message:   "Unable to list API keys",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	response := models.APIKeyListResponse{Keys: make([]models.APIKey, 0, len(keys))}
+	for i := range keys {
+		response.Keys = append(response.Keys, apiKeyModel(&keys[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetAPIKey godoc
+// @Summary Get an API key
+// @Description Get an API key's organization, scopes, quota, status and last use
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/api-keys/{id} [get]
+func (h *Handler) GetAPIKey(c *gin.Context) {
+	id, ok := h.apiKeyID(c)
+	if !ok {
+		return
+	}
+
+	key, err := h.apiKeys.Get(c.Request.Context(), id)
+	if err != nil {
+		h.apiKeyError(c, err, "Unable to retrieve API key")
+		return
+	}
+	c.JSON(http.StatusOK, apiKeyModel(key))
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate an API key
+// @Description Issue a replacement key with the same organization, scopes and quota. The old key keeps working for the grace period. The new key is returned once.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "API key ID"
+// @Param request body models.RotateAPIKeyRequest false "Rotation options"
+// @Success 201 {object} models.IssuedAPIKey
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/api-keys/{id}/rotate [post]
+func (h *Handler) RotateAPIKey(c *gin.Context) {
+	id, ok := h.apiKeyID(c)
+	if !ok {
+		return
+	}
+
+	var req models.RotateAPIKeyRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid rotation request",
+				Message:   err.Error(),
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+	}
+
+	grace := time.Duration(h.config.APIKeys.RotationGraceSeconds) * time.Second
+	if req.GracePeriodSeconds != nil {
+		grace = time.Duration(*req.GracePeriodSeconds) * time.Second
+	}
+
+	key, fullKey, err := h.apiKeys.Rotate(c.Request.Context(), id, grace, c.GetString("userID"))
+	if err != nil {
+		h.apiKeyError(c, err, "Unable to rotate API key")
+		return
+	}
+
+	h.logAuditEvent(c.Request.Context(), "apikey.rotated", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"apiKeyID":           key.ID,
+		"prefix":             key.Prefix,
+		"rotatedFrom":        id,
+		"organizationID":     key.OrganizationID,
+		"gracePeriodSeconds": int(grace.Seconds()),
+	})
+
+	c.JSON(http.StatusCreated, models.IssuedAPIKey{APIKey: apiKeyModel(key), Key: fullKey})
+}
+
+// UpdateAPIKey godoc
+// @Summary Change an API key's scopes or quota
+// @Description Replace the scopes and/or per-minute quota of an API key. Omitted fields are left unchanged; a quota of 0 uses the gateway default.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "API key ID"
+// @Param request body models.UpdateAPIKeyRequest true "Scopes and quota"
+// @Success 200 {object} models.APIKey
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/api-keys/{id} [patch]
+func (h *Handler) UpdateAPIKey(c *gin.Context) {
+	id, ok := h.apiKeyID(c)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid API key update",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if req.Scopes != nil && len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid API key update",
+			Message:   "At least one scope is required",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if req.Scopes != nil && !h.validScopes(c, req.Scopes) {
+		return
+	}
+
+	key, err := h.apiKeys.Update(c.Request.Context(), id, req.Scopes, req.QuotaPerMinute)
+	if err != nil {
+		h.apiKeyError(c, err, "Unable to update API key")
+		return
+	}
+
+	h.logAuditEvent(c.Request.Context(), "apikey.updated", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"apiKeyID":       key.ID,
+		"prefix":         key.Prefix,
+		"organizationID": key.OrganizationID,
+		"scopes":         key.Scopes,
+		"quotaPerMinute": key.QuotaPerMinute,
+	})
+
+	c.JSON(http.StatusOK, apiKeyModel(key))
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Revoke an API key immediately. Revoking a revoked key has no effect.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	id, ok := h.apiKeyID(c)
+	if !ok {
+		return
+	}
+
+	key, err := h.apiKeys.Revoke(c.Request.Context(), id, c.GetString("userID"))
+	if err != nil {
+		h.apiKeyError(c, err, "Unable to revoke API key")
+		return
+	}
+
+	h.logAuditEvent(c.Request.Context(), "apikey.revoked", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"apiKeyID":       key.ID,
+		"prefix":         key.Prefix,
+		"organizationID": key.OrganizationID,
+	})
+
+	c.JSON(http.StatusOK, apiKeyModel(key))
+}
+
+// apiKeyID returns the key ID path parameter, answering 404 if it cannot be
+// a key ID
+func (h *Handler) apiKeyID(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "API key not found",
+			Message:   "No API key with ID " + id,
+			RequestID: requestid.Get(c),
+		})
+		return "", false
+	}
+	return id, true
+}
+
+// validScopes answers 400 unless every scope can be granted
+func (h *Handler) validScopes(c *gin.Context, scopes []string) bool {
+	for _, scope := range scopes {
+		if !apikey.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid scope",
+				Message:   "Scope " + scope + " is not one of read, write",
+				RequestID: requestid.Get(c),
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// apiKeyError answers with the status matching an API key store error
+func (h *Handler) apiKeyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, apikey.ErrNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "API key not found",
+			Message:   "No API key with ID " + c.Param("id"),
+			RequestID: requestid.Get(c),
+		})
+	case errors.Is(err, apikey.ErrKeyRevoked), errors.Is(err, apikey.ErrKeyExpired):
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:     "API key not active",
+			Message:   "Revoked or expired API keys cannot be rotated",
+			RequestID: requestid.Get(c),
+		})
+	default:
+		h.logger.WithContext(c.Request.Context()).Errorf("API key operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "API key operation failed",
+			Message:   message,
+			RequestID: requestid.Get(c),
+		})
+	}
+}
+
+func apiKeyModel(key *apikey.Key) models.APIKey {
+	return models.APIKey{
+		ID:                     key.ID,
+		OrganizationID:         key.OrganizationID,
+		OrganizationIdentifier: key.OrganizationIdentifier,
+		Name:                   key.Name,
+		Prefix:                 key.Prefix,
+		Scopes:                 key.Scopes,
+		QuotaPerMinute:         key.QuotaPerMinute,
+		Status:                 key.Status,
+		ExpiresAt:              key.ExpiresAt,
+		RotatedFrom:            key.RotatedFrom,
+		CreatedBy:              key.CreatedBy,
+		CreatedAt:              key.CreatedAt,
+		RevokedAt:              key.RevokedAt,
+		LastUsedAt:             key.LastUsedAt,
+		LastUsedIP:             key.LastUsedIP,
+	}
+}
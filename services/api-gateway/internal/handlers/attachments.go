@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/attachment"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/middleware"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/policy"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Claim attachments, uploaded once and referenced by URL
+
+// attachmentsPath is the path attachment URLs start with
+const attachmentsPath = "/api/v1/attachments"
+
+// UploadAttachment godoc
+// @Summary Upload an attachment
+// @Description Upload a document to attach to claims, such as an imaging report, as the raw request body. The content type is sniffed from the contents and must be accepted and match the declared Content-Type; uploads above the size limit or containing malware are rejected. Claims reference the attachment by the returned URL.
+// @Tags attachments
+// @Security OAuth2Application
+// @Accept application/pdf,image/jpeg,image/png,image/tiff,application/dicom,text/plain
+// @Produce json
+// @Param title query string false "Title of the document"
+// @Success 201 {object} models.StoredAttachment
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 413 {object} models.ErrorResponse
+// @Failure 415 {object} models.ErrorResponse
+// @Failure 422 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
+// @Router /api/v1/attachments [post]
+func (h *Handler) UploadAttachment(c *gin.Context) {
+	maxSize := int64(h.config.Attachments.MaxSizeMB) << 20
+	if c.Request.ContentLength > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{
+			Error:     "Attachment too large",
+			Message:   fmt.Sprintf("Attachments may be at most %d MB", h.config.Attachments.MaxSizeMB),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	// Attachments belong to the uploader's tenant and organization, which
+	// decide who may read them
+	tenantID, organizationID := tenant.Get(c), c.GetString("organizationIdentifier")
+	if tenantID == "" || organizationID == "" {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:     "Organization required",
+			Message:   "Attachments are uploaded by organizations of a payer; the credentials carry no tenant or organization",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	stored, err := h.attachments.Upload(ctx, c.Request.Body, attachment.NewUpload{
+		TenantID:       tenantID,
+		OrganizationID: organizationID,
+		UploadedBy:     c.GetString("userID"),
+		Title:          c.Query("title"),
+		ContentType:    c.GetHeader("Content-Type"),
+	})
+	var typeErr *attachment.TypeError
+	var malwareErr *attachment.MalwareError
+	switch {
+	case err == nil:
+	case errors.Is(err, attachment.ErrEmpty):
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Empty attachment",
+			Message:   "Send the document as the request body",
+			RequestID: requestid.Get(c),
+		})
+		return
+	case errors.Is(err, attachment.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{
+			Error:     "Attachment too large",
+			Message:   fmt.Sprintf("Attachments may be at most %d MB", h.config.Attachments.MaxSizeMB),
+			RequestID: requestid.Get(c),
+		})
+		return
+	case errors.As(err, &typeErr):
+		c.JSON(http.StatusUnsupportedMediaType, models.ErrorResponse{
+			Error:     "Unsupported attachment type",
+			Message:   fmt.Sprintf("%s; accepted types are %s", typeErr.Error(), strings.Join(h.config.Attachments.AllowedTypes, ", ")),
+			RequestID: requestid.Get(c),
+		})
+		return
+	case errors.As(err, &malwareErr):
+		h.logAuditEvent(ctx, "attachment.rejected", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+			"reason":    "malware",
+			"signature": malwareErr.Signature,
+		})
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+			Error:     "Attachment rejected",
+			Message:   "The document contains malware",
+			RequestID: requestid.Get(c),
+		})
+		return
+	case errors.Is(err, attachment.ErrScanFailed):
+		h.logger.WithContext(ctx).Errorf("Failed to scan attachment: %v", err)
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{
+			Error:     "Scanner unavailable",
+			Message:   "The document could not be scanned; try again later",
+			RequestID: requestid.Get(c),
+		})
+		return
+	default:
+		h.logger.WithContext(ctx).Errorf("Failed to upload attachment: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Upload failed",
+			Message:   "Unable to store the attachment",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	h.logAuditEvent(ctx, "attachment.uploaded", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"attachmentID": stored.ID,
+		"contentType":  stored.ContentType,
+		"size":         stored.Size,
+		"sha256":       stored.SHA256,
+		"scanStatus":   stored.ScanStatus,
+	})
+
+	response := h.storedAttachment(c, stored)
+	c.Header("Location", response.URL)
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetAttachment godoc
+// @Summary Get attachment metadata
+// @Description Get the content type, size, hash and scan status of an attachment
+// @Tags attachments
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Attachment ID"
+// @Success 200 {object} models.StoredAttachment
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/attachments/{id} [get]
+func (h *Handler) GetAttachment(c *gin.Context) {
+	stored, ok := h.findAttachment(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, h.storedAttachment(c, stored))
+}
+
+// GetAttachmentContent godoc
+// @Summary Download an attachment
+// @Description Download the contents of an attachment. The ETag is the SHA-256 hash of the contents.
+// @Tags attachments
+// @Security OAuth2Application
+// @Produce application/octet-stream
+// @Param id path string true "Attachment ID"
+// @Success 200 {file} file
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/attachments/{id}/content [get]
+func (h *Handler) GetAttachmentContent(c *gin.Context) {
+	stored, ok := h.findAttachment(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	contents, err := h.attachments.Open(ctx, stored)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to open attachment %s: %v", stored.ID, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Download failed",
+			Message:   "Unable to read the attachment",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	defer contents.Close()
+
+	h.logAuditEvent(ctx, "attachment.downloaded", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"attachmentID": stored.ID,
+		"size":         stored.Size,
+	})
+
+	// Documents are downloaded, never rendered by the browser
+	disposition := "attachment"
+	if stored.Title != "" {
+		if formatted := mime.FormatMediaType("attachment", map[string]string{"filename": stored.Title}); formatted != "" {
+			disposition = formatted
+		}
+	}
+	c.DataFromReader(http.StatusOK, stored.Size, stored.ContentType, contents, map[string]string{
+		"Content-Disposition":    disposition,
+		"X-Content-Type-Options": "nosniff",
+		"ETag":                   `"` + stored.SHA256 + `"`,
+	})
+}
+
+// claimAttachment checks a document a claim attaches, either by the URL of
+// an uploaded attachment or inline, and returns the attachment referenced.
+// Inline documents above the inline limit must be uploaded instead. It
+// answers the request and returns false when the document is not acceptable.
+func (h *Handler) claimAttachment(c *gin.Context, ref, data string) (*attachment.Attachment, bool) {
+	if ref == "" {
+		maxInline := h.config.Attachments.MaxInlineKB << 10
+		if h.attachments != nil && base64.StdEncoding.DecodedLen(len(data)) > maxInline {
+			c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{
+				Error:     "Attachment too large",
+				Message:   fmt.Sprintf("Documents above %d KB must be uploaded to %s and referenced by URL", h.config.Attachments.MaxInlineKB, attachmentsPath),
+				RequestID: requestid.Get(c),
+			})
+			return nil, false
+		}
+		return nil, true
+	}
+
+	if h.attachments == nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid attachment",
+			Message:   "Attachments by URL are not enabled",
+			RequestID: requestid.Get(c),
+		})
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+	var stored *attachment.Attachment
+	id := h.attachmentID(c, ref)
+	if id != "" {
+		var err error
+		stored, err = h.attachments.Get(ctx, id)
+		if err != nil && !errors.Is(err, attachment.ErrNotFound) {
+			h.logger.WithContext(ctx).Errorf("Failed to load attachment: %v", err)
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+				Error:     "Attachment lookup failed",
+				Message:   "Unable to load the attachment",
+				RequestID: requestid.Get(c),
+			})
+			return nil, false
+		}
+	}
+	if stored == nil || !h.canReadAttachment(c, stored) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid attachment",
+			Message:   "No attachment at " + ref + "; upload documents to " + attachmentsPath,
+			RequestID: requestid.Get(c),
+		})
+		return nil, false
+	}
+	return stored, true
+}
+
+// findAttachment loads the attachment in the path, answering 404 when it
+// does not exist or the caller may not read it
+func (h *Handler) findAttachment(c *gin.Context) (*attachment.Attachment, bool) {
+	ctx := c.Request.Context()
+	stored, err := h.attachments.Get(ctx, c.Param("id"))
+	if err != nil && !errors.Is(err, attachment.ErrNotFound) {
+		h.logger.WithContext(ctx).Errorf("Failed to load attachment: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Attachment lookup failed",
+			Message:   "Unable to load the attachment",
+			RequestID: requestid.Get(c),
+		})
+		return nil, false
+	}
+	if stored == nil || !h.canReadAttachment(c, stored) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "Not found",
+			Message:   "No attachment with ID " + c.Param("id"),
+			RequestID: requestid.Get(c),
+		})
+		return nil, false
+	}
+	return stored, true
+}
+
+// canReadAttachment decides by policy whether the caller may read an
+// attachment, given its tenant and organization
+func (h *Handler) canReadAttachment(c *gin.Context, stored *attachment.Attachment) bool {
+	return h.policies.Evaluate(policy.Request{
+		Subject: middleware.SubjectOf(c),
+		Action:  policy.ActionRead,
+		Resource: policy.Resource{
+			Type:           policy.ResourceAttachment,
+			Scoped:         true,
+			TenantID:       stored.TenantID,
+			OrganizationID: stored.OrganizationID,
+		},
+	}).Allowed
+}
+
+// attachmentID returns the ID of the attachment a URL refers to: a URL of
+// this gateway's attachments, absolute or relative. Other URLs give "".
+func (h *Handler) attachmentID(c *gin.Context, ref string) string {
+	parsed, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if parsed.Scheme != "" || parsed.Host != "" {
+		base, err := url.Parse(h.bulkBaseURL(c))
+		if err != nil || !strings.EqualFold(parsed.Scheme, base.Scheme) || !strings.EqualFold(parsed.Host, base.Host) {
+			return ""
+		}
+	}
+	id, ok := strings.CutPrefix(parsed.Path, attachmentsPath+"/")
+	if !ok {
+		return ""
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return ""
+	}
+	return id
+}
+
+func (h *Handler) storedAttachment(c *gin.Context, stored *attachment.Attachment) models.StoredAttachment {
+	return models.StoredAttachment{
+		ID:          stored.ID,
+		URL:         h.bulkBaseURL(c) + attachmentsPath + "/" + stored.ID,
+		Title:       stored.Title,
+		ContentType: stored.ContentType,
+		Size:        stored.Size,
+		SHA256:      stored.SHA256,
+		ScanStatus:  stored.ScanStatus,
+		CreatedAt:   stored.CreatedAt,
+	}
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/auth"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/breakglass"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/policy"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Break-glass emergency access
+
+// breakGlassSeverity marks the audit events of break-glass sessions for
+// alerting and review
+const breakGlassSeverity = "high"
+
+// RequestBreakGlass godoc
+// @Summary Open a break-glass session
+// @Description Exchange a regular token for a short-lived elevated token that reads one patient's record, coverage and eligibility within one tenant, outside the caller's usual scope. A reason code and justification are required; every use is audited and the session is queued for review.
+// @Tags auth
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.BreakGlassRequest true "Reason for breaking the glass"
+// @Success 201 {object} models.BreakGlassTokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/auth/break-glass [post]
+func (h *Handler) RequestBreakGlass(c *gin.Context) {
+	if c.GetString("breakGlassSessionID") != "" {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:     "Break-glass not allowed",
+			Message:   "A break-glass token cannot open another session",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	var req models.BreakGlassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid break-glass request",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if !h.validReasonCode(req.ReasonCode) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid reason code",
+			Message:   "reason_code must be one of " + strings.Join(h.config.BreakGlass.ReasonCodes, ", "),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if !tenant.Valid(req.TenantID) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid tenant",
+			Message:   "tenant_id may only contain letters, digits, '-', '_' and '.'",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	duration := h.config.BreakGlass.TTL
+	if req.DurationMinutes > 0 {
+		duration = req.DurationMinutes
+	}
+	if duration > h.config.BreakGlass.MaxTTL {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid break-glass request",
+			Message:   fmt.Sprintf("duration_minutes may be at most %d", h.config.BreakGlass.MaxTTL),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+	session, err := h.breakGlass.Create(ctx, breakglass.NewSession{
+		UserID:         userID,
+		Role:           c.GetString("userRole"),
+		HomeTenantID:   c.GetString("tenantID"),
+		TenantID:       req.TenantID,
+		OrganizationID: c.GetString("organizationIdentifier"),
+		PatientID:      req.PatientID,
+		ReasonCode:     req.ReasonCode,
+		Justification:  req.Justification,
+		ClientIP:       c.ClientIP(),
+		ExpiresAt:      time.Now().Add(time.Duration(duration) * time.Minute),
+	})
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to open break-glass session: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Break-glass failed",
+			Message:   "Unable to open break-glass session",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	token, err := h.auth.GenerateTokenUntil(auth.Identity{
+		UserID:         userID,
+		Role:           policy.RoleBreakGlass,
+		TenantID:       session.TenantID,
+		OrganizationID: session.OrganizationID,
+		Scopes:         []string{"read"},
+		BreakGlass:     session.ID,
+	}, session.ExpiresAt)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to generate break-glass token: %v", err)
+		// A session without a token is of no use to anyone
+		if _, err := h.breakGlass.Revoke(ctx, session.ID, userID); err != nil {
+			h.logger.WithContext(ctx).Errorf("Failed to revoke break-glass session %s: %v", session.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Token generation failed",
+			Message:   "Unable to generate break-glass token",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	h.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"session_id":  session.ID,
+		"reason_code": session.ReasonCode,
+		"tenant_id":   session.TenantID,
+		"client_ip":   c.ClientIP(),
+	}).Warn("Break-glass session opened")
+
+	h.logAuditEvent(ctx, "breakglass.opened", userID, c.ClientIP(), map[string]interface{}{
+		"severity":      breakGlassSeverity,
+		"sessionID":     session.ID,
+		"role":          session.Role,
+		"reasonCode":    session.ReasonCode,
+		"justification": session.Justification,
+		"targetTenant":  session.TenantID,
+		"patientID":     session.PatientID,
+		"expiresAt":     session.ExpiresAt,
+	})
+
+	c.JSON(http.StatusCreated, models.BreakGlassTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(session.ExpiresAt).Seconds()),
+		Session:     breakGlassModel(session),
+	})
+}
+
+// RecordBreakGlassUse counts and audits a request made with a break-glass
+// token. It fails when the session has been revoked or has expired, or when
+// break-glass access has been disabled since the token was issued.
+func (h *Handler) RecordBreakGlassUse(c *gin.Context, sessionID string) error {
+	ctx := c.Request.Context()
+	if !h.config.BreakGlass.Enabled {
+		return errors.New("break-glass access is disabled")
+	}
+
+	session, err := h.breakGlass.RecordUse(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, breakglass.ErrSessionRevoked) || errors.Is(err, breakglass.ErrSessionExpired) {
+			h.logAuditEvent(ctx, "breakglass.rejected", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+				"severity":  breakGlassSeverity,
+				"sessionID": sessionID,
+				"reason":    err.Error(),
+				"method":    c.Request.Method,
+				"route":     c.FullPath(),
+			})
+		}
+		return err
+	}
+	// Member data routes only disclose the session's patient
+	c.Set("breakGlassPatientID", session.PatientID)
+
+	h.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"session_id": session.ID,
+		"method":     c.Request.Method,
+		"path":       c.FullPath(),
+		"client_ip":  c.ClientIP(),
+	}).Warn("Break-glass access")
+
+	h.logAuditEvent(ctx, "breakglass.access", session.UserID, c.ClientIP(), map[string]interface{}{
+		"severity":   breakGlassSeverity,
+		"sessionID":  session.ID,
+		"reasonCode": session.ReasonCode,
+		"method":     c.Request.Method,
+		"route":      c.FullPath(),
+		"useCount":   session.UseCount,
+	})
+	return nil
+}
+
+// ListBreakGlassSessions godoc
+// @Summary List break-glass sessions
+// @Description List break-glass sessions, oldest first. Without a review_status the review queue of sessions pending sign-off is returned; "all" lists every session.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param review_status query string false "pending (default), approved, flagged or all"
+// @Param user_id query string false "Session holder"
+// @Success 200 {object} models.BreakGlassSessionListResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/break-glass [get]
+func (h *Handler) ListBreakGlassSessions(c *gin.Context) {
+	filter := breakglass.Filter{
+		ReviewStatus: c.DefaultQuery("review_status", breakglass.ReviewPending),
+		UserID:       c.Query("user_id"),
+	}
+	switch filter.ReviewStatus {
+	case breakglass.ReviewPending, breakglass.ReviewApproved, breakglass.ReviewFlagged:
+	case "all":
+		filter.ReviewStatus = ""
+	default:
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid review status",
+			Message:   "review_status must be one of pending, approved, flagged, all",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	sessions, err := h.breakGlass.List(c.Request.Context(), filter)
+	if err != nil {
+		h.breakGlassError(c, err, "Unable to list break-glass sessions")
+		return
+	}
+
+	response := models.BreakGlassSessionListResponse{Sessions: make([]models.BreakGlassSession, 0, len(sessions))}
+	for i := range sessions {
+		response.Sessions = append(response.Sessions, breakGlassModel(&sessions[i]))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetBreakGlassSession godoc
+// @Summary Get a break-glass session
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} models.BreakGlassSession
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/break-glass/{id} [get]
+func (h *Handler) GetBreakGlassSession(c *gin.Context) {
+	id, ok := h.breakGlassID(c)
+	if !ok {
+		return
+	}
+
+	session, err := h.breakGlass.Get(c.Request.Context(), id)
+	if err != nil {
+		h.breakGlassError(c, err, "Unable to get break-glass session")
+		return
+	}
+
+	c.JSON(http.StatusOK, breakGlassModel(session))
+}
+
+// ReviewBreakGlassSession godoc
+// @Summary Review a break-glass session
+// @Description Sign off a pending break-glass session as approved or flag it for follow-up. Holders cannot review their own sessions.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param id path string true "Session ID"
+// @Param request body models.BreakGlassReviewRequest true "Review outcome"
+// @Success 200 {object} models.BreakGlassSession
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/break-glass/{id}/review [post]
+func (h *Handler) ReviewBreakGlassSession(c *gin.Context) {
+	id, ok := h.breakGlassID(c)
+	if !ok {
+		return
+	}
+
+	var req models.BreakGlassReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid review",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	reviewer := c.GetString("userID")
+	session, err := h.breakGlass.Review(c.Request.Context(), id, reviewer, req.Outcome, req.Notes)
+	if err != nil {
+		h.breakGlassError(c, err, "Unable to review break-glass session")
+		return
+	}
+
+	h.logAuditEvent(c.Request.Context(), "breakglass.reviewed", reviewer, c.ClientIP(), map[string]interface{}{
+		"sessionID": session.ID,
+		"holder":    session.UserID,
+		"outcome":   session.ReviewStatus,
+		"useCount":  session.UseCount,
+	})
+
+	c.JSON(http.StatusOK, breakGlassModel(session))
+}
+
+// RevokeBreakGlassSession godoc
+// @Summary Revoke a break-glass session
+// @Description End a break-glass session before it expires. Its token stops working immediately; the session stays in the review queue.
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} models.BreakGlassSession
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/break-glass/{id} [delete]
+func (h *Handler) RevokeBreakGlassSession(c *gin.Context) {
+	id, ok := h.breakGlassID(c)
+	if !ok {
+		return
+	}
+
+	session, err := h.breakGlass.Revoke(c.Request.Context(), id, c.GetString("userID"))
+	if err != nil {
+		h.breakGlassError(c, err, "Unable to revoke break-glass session")
+		return
+	}
+
+	h.logAuditEvent(c.Request.Context(), "breakglass.revoked", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"severity":  breakGlassSeverity,
+		"sessionID": session.ID,
+		"holder":    session.UserID,
+	})
+
+	c.JSON(http.StatusOK, breakGlassModel(session))
+}
+
+// validReasonCode reports whether code is one of the configured reason codes
+func (h *Handler) validReasonCode(code string) bool {
+	for _, allowed := range h.config.BreakGlass.ReasonCodes {
+		if code == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// breakGlassID returns the session ID path parameter, answering 404 if it
+// cannot be a session ID
+func (h *Handler) breakGlassID(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "Break-glass session not found",
+			Message:   "No break-glass session with ID " + id,
+			RequestID: requestid.Get(c),
+		})
+		return "", false
+	}
+	return id, true
+}
+
+// breakGlassError answers with the status matching a break-glass store error
+func (h *Handler) breakGlassError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, breakglass.ErrNotFound):
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "Break-glass session not found",
+			Message:   "No break-glass session with ID " + c.Param("id"),
+			RequestID: requestid.Get(c),
+		})
+	case errors.Is(err, breakglass.ErrAlreadyReviewed):
+		c.JSON(http.StatusConflict, models.ErrorResponse{
+			Error:     "Break-glass session already reviewed",
+			Message:   "Only pending sessions can be reviewed",
+			RequestID: requestid.Get(c),
+		})
+	case errors.Is(err, breakglass.ErrSelfReview):
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:     "Self review not allowed",
+			Message:   "Break-glass sessions must be reviewed by someone other than their holder",
+			RequestID: requestid.Get(c),
+		})
+	default:
+		h.logger.WithContext(c.Request.Context()).Errorf("Break-glass operation failed: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Break-glass operation failed",
+			Message:   message,
+			RequestID: requestid.Get(c),
+		})
+	}
+}
+
+func breakGlassModel(session *breakglass.Session) models.BreakGlassSession {
+	return models.BreakGlassSession{
+		ID:             session.ID,
+		UserID:         session.UserID,
+		Role:           session.Role,
+		HomeTenantID:   session.HomeTenantID,
+		TenantID:       session.TenantID,
+		OrganizationID: session.OrganizationID,
+		PatientID:      session.PatientID,
+		ReasonCode:     session.ReasonCode,
+		Justification:  session.Justification,
+		Status:         session.Status,
+		Active:         session.Active(time.Now()),
+		ClientIP:       session.ClientIP,
+		ExpiresAt:      session.ExpiresAt,
+		CreatedAt:      session.CreatedAt,
+		UseCount:       session.UseCount,
+		LastUsedAt:     session.LastUsedAt,
+		RevokedAt:      session.RevokedAt,
+		RevokedBy:      session.RevokedBy,
+		ReviewStatus:   session.ReviewStatus,
+		ReviewedBy:     session.ReviewedBy,
+		ReviewedAt:     session.ReviewedAt,
+		ReviewNotes:    session.ReviewNotes,
+	}
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/bulk"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/policy"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// FHIR Bulk Data $export endpoints
+
+const (
+	// bulkRetryAfter is how long clients are asked to wait between status
+	// requests while an export runs
+	bulkRetryAfter = 10 * time.Second
+
+	bulkStatusPath = "/api/v1/fhir/bulk/status/"
+	bulkFilesPath  = "/api/v1/fhir/bulk/files/"
+)
+
+// bulkOutputFormats are the accepted spellings of the NDJSON output format
+var bulkOutputFormats = map[string]bool{
+	"application/fhir+ndjson": true,
+	"application/ndjson":      true,
+	"ndjson":                  true,
+}
+
+// bulkParameters are the kick-off parameters the export supports
+var bulkParameters = map[string]bool{
+	"_outputFormat": true,
+	"_since":        true,
+	"_type":         true,
+}
+
+// ExportSystem godoc
+// @Summary Export all data
+// @Description Start a FHIR Bulk Data export of all patients, coverage, claim responses, eligibility responses and communications the caller may see. Requires "Prefer: respond-async"; the response's Content-Location is the status URL to poll.
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param Prefer header string true "respond-async, optionally with handling=lenient"
+// @Param _outputFormat query string false "application/fhir+ndjson"
+// @Param _since query string false "Only resources updated at or after this instant"
+// @Param _type query string false "Comma-separated resource types"
+// @Success 202 {object} fhir.OperationOutcome
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/$export [get]
+func (h *Handler) ExportSystem(c *gin.Context) {
+	h.kickOffExport(c, bulk.LevelSystem, "")
+}
+
+// ExportPatients godoc
+// @Summary Export patient data
+// @Description Start a FHIR Bulk Data export of all patients and the resources about them. Requires "Prefer: respond-async".
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param Prefer header string true "respond-async, optionally with handling=lenient"
+// @Param _outputFormat query string false "application/fhir+ndjson"
+// @Param _since query string false "Only resources updated at or after this instant"
+// @Param _type query string false "Comma-separated resource types"
+// @Success 202 {object} fhir.OperationOutcome
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/Patient/$export [get]
+func (h *Handler) ExportPatients(c *gin.Context) {
+	h.kickOffExport(c, bulk.LevelPatient, "")
+}
+
+// ExportGroup godoc
+// @Summary Export group data
+// @Description Start a FHIR Bulk Data export of the members of a group and the resources about them. Groups are coverage group numbers. Requires "Prefer: respond-async".
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Coverage group number"
+// @Param Prefer header string true "respond-async, optionally with handling=lenient"
+// @Param _outputFormat query string false "application/fhir+ndjson"
+// @Param _since query string false "Only resources updated at or after this instant"
+// @Param _type query string false "Comma-separated resource types"
+// @Success 202 {object} fhir.OperationOutcome
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/Group/{id}/$export [get]
+func (h *Handler) ExportGroup(c *gin.Context) {
+	h.kickOffExport(c, bulk.LevelGroup, c.Param("id"))
+}
+
+// kickOffExport validates an export request and queues its job
+func (h *Handler) kickOffExport(c *gin.Context, level, groupID string) {
+	prefer := preferences(c.GetHeader("Prefer"))
+	if !prefer["respond-async"] {
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "required", `Bulk export requires the "Prefer: respond-async" header`))
+		return
+	}
+	lenient := prefer["handling=lenient"]
+
+	query := c.Request.URL.Query()
+	if !lenient {
+		for name := range query {
+			if !bulkParameters[name] {
+				c.JSON(http.StatusBadRequest, fhirOutcome("error", "not-supported", "Unsupported parameter "+name))
+				return
+			}
+		}
+	}
+
+	if format := query.Get("_outputFormat"); format != "" && !bulkOutputFormats[format] {
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "not-supported", "Unsupported _outputFormat "+format+"; only application/fhir+ndjson is supported"))
+		return
+	}
+
+	var since *time.Time
+	if value := query.Get("_since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "_since must be a FHIR instant, e.g. 2024-01-15T10:30:00Z"))
+			return
+		}
+		since = &parsed
+	}
+
+	supported := bulk.Types(h.bulkSources)
+	known := make(map[string]bool)
+	for _, resourceType := range supported {
+		known[resourceType] = true
+	}
+	var types []string
+	requested := make(map[string]bool)
+	for _, value := range query["_type"] {
+		for _, resourceType := range strings.Split(value, ",") {
+			resourceType = strings.TrimSpace(resourceType)
+			switch {
+			case resourceType == "" || requested[resourceType]:
+			case known[resourceType]:
+				requested[resourceType] = true
+				types = append(types, resourceType)
+			case !lenient:
+				c.JSON(http.StatusBadRequest, fhirOutcome("error", "not-supported", fmt.Sprintf("Unsupported _type %s; supported types are %s", resourceType, strings.Join(supported, ", "))))
+				return
+			}
+		}
+	}
+	if len(types) == 0 {
+		types = supported
+	}
+
+	userID := c.GetString("userID")
+	ctx := c.Request.Context()
+	active, err := h.bulkJobs.CountActive(ctx, tenant.Get(c), userID)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to count export jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to start export"))
+		return
+	}
+	if active >= h.config.BulkExport.MaxActivePerRequester {
+		c.Header("Retry-After", strconv.Itoa(int(bulkRetryAfter.Seconds())))
+		c.JSON(http.StatusTooManyRequests, fhirOutcome("error", "throttled",
+			fmt.Sprintf("At most %d exports may run at a time; wait for one to finish or cancel it", h.config.BulkExport.MaxActivePerRequester)))
+		return
+	}
+
+	job, err := h.bulkJobs.Create(ctx, bulk.NewJob{
+		Level:       level,
+		GroupID:     groupID,
+		Types:       types,
+		Since:       since,
+		RequestURL:  h.bulkBaseURL(c) + c.Request.URL.RequestURI(),
+		TenantID:    tenant.Get(c),
+		RequestedBy: userID,
+	})
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to create export job: %v", err)
+		c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to start export"))
+		return
+	}
+
+	h.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"job_id": job.ID,
+		"level":  level,
+	}).Info("Export job accepted")
+
+	h.logAuditEvent(ctx, "bulk.export.requested", userID, c.ClientIP(), map[string]interface{}{
+		"jobID":   job.ID,
+		"level":   level,
+		"groupID": groupID,
+		"types":   types,
+		"since":   query.Get("_since"),
+		"tenant":  job.TenantID,
+	})
+
+	c.Header("Content-Location", h.bulkBaseURL(c)+bulkStatusPath+job.ID)
+	c.JSON(http.StatusAccepted, fhirOutcome("information", "informational", "Export job "+job.ID+" accepted"))
+}
+
+// GetExportStatus godoc
+// @Summary Get export status
+// @Description Poll an export started by the caller. Answers 202 with X-Progress while it runs and 200 with the manifest of NDJSON files once it completed.
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Export job ID"
+// @Success 200 {object} models.BulkExportManifest
+// @Success 202 "Export in progress"
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/bulk/status/{id} [get]
+func (h *Handler) GetExportStatus(c *gin.Context) {
+	job, ok := h.findExportJob(c)
+	if !ok {
+		return
+	}
+
+	switch job.Status {
+	case bulk.StatusAccepted, bulk.StatusInProgress:
+		progress := job.Progress
+		if progress == "" {
+			progress = "Queued"
+		}
+		c.Header("X-Progress", progress)
+		c.Header("Retry-After", strconv.Itoa(int(bulkRetryAfter.Seconds())))
+		c.Status(http.StatusAccepted)
+
+	case bulk.StatusCompleted:
+		manifest := models.BulkExportManifest{
+			Request:             job.RequestURL,
+			RequiresAccessToken: true,
+			Output:              []models.BulkExportOutput{},
+			Error:               []models.BulkExportOutput{},
+		}
+		if job.TransactionTime != nil {
+			manifest.TransactionTime = job.TransactionTime.UTC().Format(time.RFC3339)
+		}
+		for _, file := range job.Output {
+			manifest.Output = append(manifest.Output, models.BulkExportOutput{
+				Type:  file.Type,
+				URL:   h.bulkBaseURL(c) + bulkFilesPath + file.File,
+				Count: file.Count,
+			})
+		}
+		if job.ExpiresAt != nil {
+			c.Header("Expires", job.ExpiresAt.UTC().Format(http.TimeFormat))
+		}
+		c.JSON(http.StatusOK, manifest)
+
+	case bulk.StatusFailed:
+		c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "The export failed; start a new export"))
+
+	default:
+		c.JSON(http.StatusNotFound, fhirOutcome("error", "not-found", "No export with ID "+job.ID))
+	}
+}
+
+// CancelExport godoc
+// @Summary Cancel an export
+// @Description Cancel an export started by the caller, or delete the files of a completed one
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Export job ID"
+// @Success 202 {object} fhir.OperationOutcome
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/bulk/status/{id} [delete]
+func (h *Handler) CancelExport(c *gin.Context) {
+	job, ok := h.findExportJob(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	cancelled, err := h.bulkJobs.Cancel(ctx, job.ID, time.Duration(h.config.BulkExport.LeaseSeconds)*time.Second)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to cancel export job: %v", err)
+		c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to cancel export"))
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusNotFound, fhirOutcome("error", "not-found", "No export with ID "+job.ID))
+		return
+	}
+
+	h.logAuditEvent(ctx, "bulk.export.cancelled", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"jobID":  job.ID,
+		"status": job.Status,
+	})
+
+	c.JSON(http.StatusAccepted, fhirOutcome("information", "informational", "Export job "+job.ID+" cancelled"))
+}
+
+// GetExportFile godoc
+// @Summary Download an export file
+// @Description Download an NDJSON file listed in the manifest of a completed export
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce application/fhir+ndjson
+// @Param id path string true "Export job ID"
+// @Param file path string true "File name, e.g. Coverage.ndjson"
+// @Success 200 {file} file
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/bulk/files/{id}/{file} [get]
+func (h *Handler) GetExportFile(c *gin.Context) {
+	job, ok := h.findExportJob(c)
+	if !ok {
+		return
+	}
+
+	name := job.ID + "/" + c.Param("file")
+	var output *bulk.OutputFile
+	for i := range job.Output {
+		if job.Output[i].File == name {
+			output = &job.Output[i]
+		}
+	}
+	if job.Status != bulk.StatusCompleted || output == nil {
+		c.JSON(http.StatusNotFound, fhirOutcome("error", "not-found", "No export file "+c.Param("file")))
+		return
+	}
+
+	ctx := c.Request.Context()
+	file, err := h.bulkFiles.Open(ctx, name)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to open export file %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to read export file"))
+		return
+	}
+	defer file.Close()
+
+	h.logAuditEvent(ctx, "bulk.export.downloaded", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"jobID":        job.ID,
+		"resourceType": output.Type,
+		"count":        output.Count,
+	})
+
+	c.DataFromReader(http.StatusOK, -1, "application/fhir+ndjson", file, nil)
+}
+
+// findExportJob loads the export job in the path, answering 404 when it does
+// not exist or was started by someone else, or in another tenant, as user IDs
+// are only unique within a tenant. Administrators see every job.
+func (h *Handler) findExportJob(c *gin.Context) (*bulk.Job, bool) {
+	ctx := c.Request.Context()
+	job, err := h.bulkJobs.Get(ctx, c.Param("id"))
+	if err != nil && !errors.Is(err, bulk.ErrJobNotFound) {
+		h.logger.WithContext(ctx).Errorf("Failed to load export job: %v", err)
+		c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to load export"))
+		return nil, false
+	}
+	owner := job != nil && job.RequestedBy == c.GetString("userID") && job.TenantID == tenant.Get(c)
+	if job == nil || (!owner && c.GetString("userRole") != policy.RoleAdmin) {
+		c.JSON(http.StatusNotFound, fhirOutcome("error", "not-found", "No export with ID "+c.Param("id")))
+		return nil, false
+	}
+	return job, true
+}
+
+// bulkBaseURL returns the public URL of the gateway, from configuration or
+// from the request as seen by the client
+func (h *Handler) bulkBaseURL(c *gin.Context) string {
+	if h.config.BulkExport.BaseURL != "" {
+		return strings.TrimRight(h.config.BulkExport.BaseURL, "/")
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := c.GetHeader("X-Forwarded-Proto"); forwarded == "http" || forwarded == "https" {
+		scheme = forwarded
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// preferences returns the tokens of a Prefer header, e.g. "respond-async"
+// and "handling=lenient"
+func preferences(header string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, token := range strings.FieldsFunc(header, func(r rune) bool { return r == ',' || r == ';' }) {
+		tokens[strings.ToLower(strings.ReplaceAll(strings.TrimSpace(token), " ", ""))] = true
+	}
+	return tokens
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/hijri"
+	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
+	"github.com/gin-gonic/gin"
+)
+
+// Hijri dates in FHIR requests and responses. Search parameters are written
+// in the Umm al-Qura calendar with calendar=islamic-umalqura (or
+// calendar=hijri), and dates of resources with the date-calendar extension
+// on the date. dual_calendar=true adds the hijri-date extension, with the
+// Hijri date, to the dates of the resources returned.
+
+const (
+	calendarParam     = "calendar"
+	dualCalendarParam = "dual_calendar"
+)
+
+// queryDate returns a date search parameter in the Gregorian calendar,
+// converting it from Hijri when the request's calendar says so. Dates that
+// do not exist in their calendar are rejected.
+func queryDate(c *gin.Context, value string) (string, error) {
+	return calendarDate(c.Query(calendarParam), value)
+}
+
+// fhirDate returns a FHIR date in the Gregorian calendar, converting it from
+// the calendar of its date-calendar extension
+func fhirDate(value string, element *fhir.Element) (string, error) {
+	calendar := ""
+	if element != nil {
+		for _, extension := range element.Extension {
+			if extension.URL == hijri.CalendarExtension {
+				calendar = extension.ValueCode
+			}
+		}
+	}
+	return calendarDate(calendar, value)
+}
+
+func calendarDate(calendar, value string) (string, error) {
+	switch {
+	case hijri.IsHijri(calendar):
+		return hijri.ToGregorian(value)
+	case calendar == "" || calendar == "gregorian":
+		if _, err := time.Parse(hijri.Layout, value); err != nil {
+			return "", fmt.Errorf("invalid date %q: expected YYYY-MM-DD", value)
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("unsupported calendar %q; use gregorian or %s", calendar, hijri.Calendar)
+	}
+}
+
+// gregorianBirthDate converts the birth date of a patient to the Gregorian
+// calendar, dropping its date-calendar extension once converted
+func gregorianBirthDate(patient *fhir.Patient) error {
+	if patient.BirthDate == "" {
+		return nil
+	}
+	date, err := fhirDate(patient.BirthDate, patient.BirthDateElement)
+	if err != nil {
+		return err
+	}
+	patient.BirthDate = date
+
+	if patient.BirthDateElement != nil {
+		var extensions []fhir.Extension
+		for _, extension := range patient.BirthDateElement.Extension {
+			if extension.URL != hijri.CalendarExtension {
+				extensions = append(extensions, extension)
+			}
+		}
+		patient.BirthDateElement.Extension = extensions
+		if len(extensions) == 0 {
+			patient.BirthDateElement = nil
+		}
+	}
+	return nil
+}
+
+// dualCalendar reports whether the response carries Hijri dates as well
+func dualCalendar(c *gin.Context) bool {
+	return c.Query(dualCalendarParam) == "true"
+}
+
+// withHijriDates returns a resource with the hijri-date extension on its
+// dates: the birth date of patients and the period of coverage. Other
+// resources are returned as they are.
+func withHijriDates(resource interface{}) interface{} {
+	switch r := resource.(type) {
+	case fhir.Patient:
+		r.BirthDateElement = hijriElement(r.BirthDate, r.BirthDateElement)
+		return r
+	case fhir.Coverage:
+		if r.Period != nil {
+			period := *r.Period
+			period.StartElement = hijriElement(period.Start, period.StartElement)
+			period.EndElement = hijriElement(period.End, period.EndElement)
+			r.Period = &period
+		}
+		return r
+	default:
+		return resource
+	}
+}
+
+// hijriElement adds the Hijri date of a Gregorian date to the date's
+// element. Partial dates and dates outside the Umm al-Qura table are left
+// without one.
+func hijriElement(date string, element *fhir.Element) *fhir.Element {
+	if date == "" {
+		return element
+	}
+	value, err := hijri.ToHijri(date)
+	if err != nil {
+		return element
+	}
+
+	annotated := fhir.Element{}
+	if element != nil {
+		for _, extension := range element.Extension {
+			if extension.URL != hijri.DateExtension {
+				annotated.Extension = append(annotated.Extension, extension)
+			}
+		}
+	}
+	annotated.Extension = append(annotated.Extension, fhir.Extension{URL: hijri.DateExtension, ValueString: value})
+	return &annotated
+}
@@ -1,11 +1,20 @@
 package handlers
 
 import (
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/bulk"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/match"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/middleware"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/names"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
 	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -13,16 +22,25 @@ import (
 
 // FHIR Patient endpoints
 
+// patientSearchLimit bounds the patients a search reads; results are paged
+// within them
+const patientSearchLimit = 200
+
 // SearchPatients godoc
 // @Summary Search patients
-// @Description Search for patients using FHIR parameters
+// @Description Search for patients using FHIR parameters. Names match across diacritics, hamza and ta marbuta forms, the "ال" article and Latin transliterations, and patients found by name come closest spelling first.
 // @Tags fhir
 // @Security OAuth2Application
 // @Accept json
 // @Produce json
-// @Param name query string false "Patient name"
+// @Param _id query string false "Patient ID (national ID)"
+// @Param name query string false "Patient name, in Arabic or Latin letters"
+// @Param given query string false "Patient given name"
+// @Param family query string false "Patient family name"
 // @Param identifier query string false "Patient identifier"
 // @Param birthdate query string false "Patient birth date"
+// @Param calendar query string false "Calendar of birthdate (gregorian or islamic-umalqura)"
+// @Param dual_calendar query bool false "Add Hijri dates to the patients returned"
 // @Param _count query int false "Number of results to return" default(20)
 // @Param _offset query int false "Offset for pagination" default(0)
 // @Success 200 {object} fhir.Bundle
@@ -54,49 +72,110 @@ func (h *Handler) SearchPatients(c *gin.Context) {
 		}
 	}
 
-	// TODO: Implement actual database query
-	// For now, return a mock FHIR Bundle
+	// Identifiers may be given as system|value
+	criteria := bulk.PatientCriteria{Identifier: c.Query("_id")}
+	if criteria.Identifier == "" {
+		criteria.Identifier = c.Query("identifier")
+	}
+	if i := strings.LastIndex(criteria.Identifier, "|"); i >= 0 {
+		criteria.Identifier = criteria.Identifier[i+1:]
+	}
+
+	var nameParts []string
+	for _, param := range []string{"name", "given", "family"} {
+		if value := c.Query(param); value != "" {
+			nameParts = append(nameParts, value)
+		}
+	}
+	criteria.Name = strings.Join(nameParts, " ")
+	if criteria.Name != "" && len(names.Keys(criteria.Name)) == 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid search parameter",
+			Message:   "Names must contain a part of at least two consonants",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	if birthDate := strings.TrimPrefix(c.Query("birthdate"), "eq"); birthDate != "" {
+		date, err := queryDate(c, birthDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid search parameter",
+				Message:   "birthdate must be a date, e.g. 1985-03-15: " + err.Error(),
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+		criteria.BirthDate = date
+	}
+
+	if criteria == (bulk.PatientCriteria{}) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Missing search parameter",
+			Message:   "Search patients by _id, identifier, name, given, family or birthdate",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	patients, err := h.patients.FindPatients(ctx, tenant.Get(c), criteria, patientSearchLimit)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to search patients: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Failed to search patients",
+			Message:   "Unable to search patients",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	// The eligibility service finds names by phonetic key; the closest
+	// spellings come first
+	scores := make(map[string]float64)
+	if criteria.Name != "" {
+		for _, patient := range patients {
+			scores[patient.ID] = match.NameSearchScore(criteria.Name, patient.Name)
+		}
+		sort.SliceStable(patients, func(i, j int) bool {
+			return scores[patients[i].ID] > scores[patients[j].ID]
+		})
+	}
+
+	total := len(patients)
 	bundle := fhir.Bundle{
 		ResourceType: "Bundle",
 		ID:           uuid.New().String(),
 		Type:         "searchset",
-		Total:        1,
+		Total:        total,
 		Link: []fhir.BundleLink{
 			{
 				Relation: "self",
 				URL:      c.Request.URL.String(),
 			},
 		},
-		Entry: []fhir.BundleEntry{
-			{
-				Resource: fhir.Patient{
-					ResourceType: "Patient",
-					ID:           "patient-1",
-					Identifier: []fhir.Identifier{
-						{
-							System: "https://nphies.sa/patient-id",
-							Value:  "12345678901",
-						},
-					},
-					Name: []fhir.HumanName{
-						{
-							Use:    "official",
-							Family: "العتيبي",
-							Given:  []string{"أحمد", "محمد"},
-						},
-					},
-					BirthDate: "1985-03-15",
-					Gender:    "male",
-				},
-			},
-		},
+	}
+	for _, patient := range patients[min(offset, total):min(offset+count, total)] {
+		entry := fhir.BundleEntry{Resource: patient}
+		if dualCalendar(c) {
+			entry.Resource = withHijriDates(patient)
+		}
+		if criteria.Name != "" {
+			entry.Search = &fhir.BundleEntrySearch{
+				Mode:  "match",
+				Score: math.Round(scores[patient.ID]*100) / 100,
+			}
+		}
+		bundle.Entry = append(bundle.Entry, entry)
 	}
 
 	// Log the search operation
-	h.logAuditEvent("fhir.patient.search", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(ctx, "fhir.patient.search", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
 		"parameters": params,
 		"count":      count,
 		"offset":     offset,
+		"total":      total,
 	})
 
 	c.JSON(http.StatusOK, bundle)
@@ -119,8 +198,9 @@ func (h *Handler) CreatePatient(c *gin.Context) {
 	var patient fhir.Patient
 	if err := c.ShouldBindJSON(&patient); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid patient data",
-			Message: err.Error(),
+			Error:     "Invalid patient data",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
@@ -128,8 +208,9 @@ func (h *Handler) CreatePatient(c *gin.Context) {
 	// Validate required fields
 	if len(patient.Identifier) == 0 {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Missing required field",
-			Message: "Patient must have at least one identifier",
+			Error:     "Missing required field",
+			Message:   "Patient must have at least one identifier",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
@@ -146,7 +227,7 @@ func (h *Handler) CreatePatient(c *gin.Context) {
 	// TODO: Save to database
 
 	// Log the creation
-	h.logAuditEvent("fhir.patient.create", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "fhir.patient.create", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
 		"patientID": patient.ID,
 	})
 
@@ -171,8 +252,9 @@ func (h *Handler) GetPatient(c *gin.Context) {
 	patientID := c.Param("id")
 	if patientID == "" {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Missing patient ID",
-			Message: "Patient ID is required",
+			Error:     "Missing patient ID",
+			Message:   "Patient ID is required",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
@@ -204,7 +286,7 @@ func (h *Handler) GetPatient(c *gin.Context) {
 	}
 
 	// Log the access
-	h.logAuditEvent("fhir.patient.read", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "fhir.patient.read", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
 		"patientID": patientID,
 	})
 
@@ -231,8 +313,9 @@ func (h *Handler) UpdatePatient(c *gin.Context) {
 	var patient fhir.Patient
 	if err := c.ShouldBindJSON(&patient); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid patient data",
-			Message: err.Error(),
+			Error:     "Invalid patient data",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
@@ -249,7 +332,7 @@ func (h *Handler) UpdatePatient(c *gin.Context) {
 	// TODO: Update in database
 
 	// Log the update
-	h.logAuditEvent("fhir.patient.update", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "fhir.patient.update", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
 		"patientID": patientID,
 	})
 
@@ -275,7 +358,7 @@ func (h *Handler) DeletePatient(c *gin.Context) {
 	// TODO: Check if patient exists and delete from database
 
 	// Log the deletion
-	h.logAuditEvent("fhir.patient.delete", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "fhir.patient.delete", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
 		"patientID": patientID,
 	})
 
@@ -287,40 +370,46 @@ func (h *Handler) DeletePatient(c *gin.Context) {
 func (h *Handler) SearchCoverage(c *gin.Context) {
 	// TODO: Implement coverage search
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Coverage search functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Coverage search functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) CreateCoverage(c *gin.Context) {
 	// TODO: Implement coverage creation
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Coverage creation functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Coverage creation functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) GetCoverage(c *gin.Context) {
-	// TODO: Implement coverage retrieval
+	// TODO: Implement coverage retrieval; check h.consentPermitsRead against
+	// the beneficiary of the coverage before returning it
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Coverage retrieval functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Coverage retrieval functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) UpdateCoverage(c *gin.Context) {
 	// TODO: Implement coverage update
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Coverage update functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Coverage update functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) DeleteCoverage(c *gin.Context) {
 	// TODO: Implement coverage deletion
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Coverage deletion functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Coverage deletion functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -329,40 +418,71 @@ func (h *Handler) DeleteCoverage(c *gin.Context) {
 func (h *Handler) SearchClaims(c *gin.Context) {
 	// TODO: Implement claim search
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim search functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Claim search functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) CreateClaim(c *gin.Context) {
+	var claim fhir.Claim
+	if err := c.ShouldBindJSON(&claim); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid claim data",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	// Supporting documents are referenced by the URL they were uploaded to
+	for _, info := range claim.SupportingInfo {
+		if info.ValueAttachment == nil {
+			continue
+		}
+		stored, ok := h.claimAttachment(c, info.ValueAttachment.URL, info.ValueAttachment.Data)
+		if !ok {
+			return
+		}
+		if stored != nil {
+			info.ValueAttachment.ContentType = stored.ContentType
+			info.ValueAttachment.Size = int(stored.Size)
+		}
+	}
+
 	// TODO: Implement claim creation with Kafka publishing
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim creation functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Claim creation functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) GetClaim(c *gin.Context) {
-	// TODO: Implement claim retrieval
+	// TODO: Implement claim retrieval; check h.consentPermitsRead against
+	// the patient of the claim before returning it
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim retrieval functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Claim retrieval functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) UpdateClaim(c *gin.Context) {
 	// TODO: Implement claim update
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim update functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Claim update functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) DeleteClaim(c *gin.Context) {
 	// TODO: Implement claim deletion
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim deletion functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Claim deletion functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -371,16 +491,19 @@ func (h *Handler) DeleteClaim(c *gin.Context) {
 func (h *Handler) SearchClaimResponses(c *gin.Context) {
 	// TODO: Implement claim response search
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "ClaimResponse search functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "ClaimResponse search functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) GetClaimResponse(c *gin.Context) {
-	// TODO: Implement claim response retrieval
+	// TODO: Implement claim response retrieval; check h.consentPermitsRead against
+	// the patient of the claim response before returning it
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "ClaimResponse retrieval functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "ClaimResponse retrieval functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -389,31 +512,67 @@ func (h *Handler) GetClaimResponse(c *gin.Context) {
 func (h *Handler) SearchPriorAuthorizations(c *gin.Context) {
 	// TODO: Implement prior authorization search
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Prior authorization search functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Prior authorization search functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) CreatePriorAuthorization(c *gin.Context) {
 	// TODO: Implement prior authorization creation
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Prior authorization creation functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Prior authorization creation functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) GetPriorAuthorization(c *gin.Context) {
 	// TODO: Implement prior authorization retrieval
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Prior authorization retrieval functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Prior authorization retrieval functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
 func (h *Handler) UpdatePriorAuthorization(c *gin.Context) {
 	// TODO: Implement prior authorization update
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Prior authorization update functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Prior authorization update functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
-}
\ No newline at end of file
+}
+
+// fhirOutcome returns an OperationOutcome with a single issue
+func fhirOutcome(severity, code, diagnostics string) fhir.OperationOutcome {
+	return fhir.OperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue: []fhir.OperationOutcomeIssue{
+			{
+				Severity:    severity,
+				Code:        code,
+				Diagnostics: diagnostics,
+			},
+		},
+	}
+}
+
+// consentPermitsRead checks the consent check a route deferred against the
+// member of the resource the handler loaded, as the request does not name
+// the member of a resource read by ID. It answers the request when consent
+// does not permit the read or cannot be verified.
+func (h *Handler) consentPermitsRead(c *gin.Context, memberID string) bool {
+	permitted, err := middleware.ConsentPermits(c, memberID)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Consent decision unavailable")
+		c.JSON(http.StatusServiceUnavailable, fhirOutcome("error", "transient", "Consent could not be verified; try again later"))
+		return false
+	}
+	if !permitted {
+		c.JSON(http.StatusForbidden, fhirOutcome("error", "forbidden", "No active consent permits this disclosure"))
+		return false
+	}
+	return true
+}
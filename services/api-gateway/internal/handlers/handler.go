@@ -1,16 +1,33 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/apikey"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/attachment"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/audit"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/auth"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/breakglass"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/bulk"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/cache"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/config"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/consent"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/kafka"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/mtls"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/outbox"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/policy"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/poll"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/subscription"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
@@ -21,13 +38,32 @@ import (
 )
 
 type Handler struct {
-	config   *config.Config
-	logger   *logrus.Logger
-	db       *sql.DB
-	redis    *redis.Client
-	kafka    *kafka.Producer
-	auth     *auth.Service
-	metrics  *MetricsCollector
+	config        *config.Config
+	logger        *logrus.Logger
+	db            *sql.DB
+	redis         *redis.Client
+	cache         *cache.Manager
+	kafka         *kafka.Producer
+	auth          *auth.Service
+	policies      *policy.Engine
+	apiKeys       *apikey.Store
+	quota         *apikey.Quota
+	breakGlass    *breakglass.Store
+	consents      *consent.Client // nil when consent enforcement is disabled
+	poll          *poll.Queue
+	subscriptions *subscription.Store
+	patients      *bulk.EligibilitySource // members and coverage in the eligibility service
+	bulkJobs      *bulk.JobStore
+	bulkFiles     bulk.FileStore // nil when bulk export is disabled
+	bulkSources   []bulk.Source
+	attachments   *attachment.Service // nil when attachments are disabled
+	audit         *audit.Store
+	signer        *audit.Signer
+	metrics       *MetricsCollector
+	stats         *statsSampler
+	client        *http.Client
+	upstream      *mtls.Store // client certificate for upstream services, if any
+	started       time.Time
 }
 
 type MetricsCollector struct {
@@ -39,7 +75,7 @@ type MetricsCollector struct {
 // NewHandler creates a new handler instance
 func NewHandler(cfg *config.Config, logger *logrus.Logger) (*Handler, error) {
 	// Initialize database connection
-	db, err := sql.Open("postgres", cfg.Database.URL)
+	db, err := tracing.OpenDB(cfg.Database.URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -59,13 +95,30 @@ func NewHandler(cfg *config.Config, logger *logrus.Logger) (*Handler, error) {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
 	redisClient := redis.NewClient(redisOpts)
+	tracing.InstrumentRedis(redisClient)
 
 	if err := redisClient.Ping(redisClient.Context()).Err(); err != nil {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
 	// Initialize Kafka producer
-	kafkaProducer, err := kafka.NewProducer(cfg.Kafka.Brokers, logger)
+	topicOverrides, err := kafka.ParseTopicOverrides(cfg.Kafka.TopicOverrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Kafka topic overrides: %w", err)
+	}
+
+	kafkaProducer, err := kafka.NewProducer(kafka.ProducerConfig{
+		Brokers:  cfg.Kafka.Brokers,
+		Security: kafkaSecurityConfig(cfg),
+		Defaults: kafka.TopicConfig{
+			RequiredAcks: cfg.Kafka.RequiredAcks,
+			Compression:  cfg.Kafka.Compression,
+		},
+		Topics:       topicOverrides,
+		BatchSize:    cfg.Kafka.BatchSize,
+		BatchTimeout: time.Duration(cfg.Kafka.BatchTimeoutMs) * time.Millisecond,
+		WriteTimeout: time.Duration(cfg.Kafka.WriteTimeout) * time.Second,
+	}, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
 	}
@@ -73,6 +126,52 @@ func NewHandler(cfg *config.Config, logger *logrus.Logger) (*Handler, error) {
 	// Initialize auth service
 	authService := auth.NewService(cfg.JWT.Secret, time.Duration(cfg.JWT.Expiration)*time.Second)
 
+	// Initialize access policies
+	var policyLoader policy.Loader
+	switch cfg.Policy.Source {
+	case "builtin":
+		policyLoader = policy.Static(policy.DefaultPolicies())
+	case "file":
+		policyLoader = policy.File{Path: cfg.Policy.File}
+	case "db":
+		policyLoader = policy.DB{DB: db}
+	default:
+		return nil, fmt.Errorf("unknown POLICY_SOURCE %q, expected builtin, file or db", cfg.Policy.Source)
+	}
+	policyEngine, err := policy.NewEngine(context.Background(), policyLoader,
+		time.Duration(cfg.Policy.DecisionCacheTTL)*time.Second, cfg.Policy.DecisionCacheSize, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize access policies: %w", err)
+	}
+
+	// Initialize audit checkpoint signer
+	auditSigner, ephemeral, err := audit.NewSigner(cfg.Audit.SigningKey, cfg.Audit.TrustedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize audit signer: %w", err)
+	}
+	if ephemeral {
+		logger.Warn("AUDIT_SIGNING_KEY not set, using an ephemeral key; audit checkpoints will not verify after restart")
+	}
+
+	// Initialize the client for upstream services, presenting a client
+	// certificate when service-to-service mTLS is enabled
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	var upstreamTLS *mtls.Store
+	if cfg.Security.UpstreamMTLS {
+		upstreamTLS, err = mtls.NewStore(cfg.Security.UpstreamCertPath, cfg.Security.UpstreamKeyPath, cfg.Security.UpstreamCACerts, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load upstream TLS certificates: %w", err)
+		}
+		transport.TLSClientConfig = upstreamTLS.ClientConfig()
+	}
+
+	// Initialize poll queue
+	pollQueue := poll.NewQueue(db, logger, poll.Options{
+		LeaseDuration: time.Duration(cfg.Poll.LeaseSeconds) * time.Second,
+		MessageTTL:    time.Duration(cfg.Poll.MessageTTLHours) * time.Hour,
+		AckRetention:  time.Duration(cfg.Poll.AckRetentionHours) * time.Hour,
+	})
+
 	// Initialize metrics
 	metrics := &MetricsCollector{
 		RequestsTotal: prometheus.NewCounterVec(
@@ -101,17 +200,240 @@ func NewHandler(cfg *config.Config, logger *logrus.Logger) (*Handler, error) {
 	prometheus.MustRegister(metrics.RequestDuration)
 	prometheus.MustRegister(metrics.ActiveRequests)
 
+	if cfg.APIKeys.Pepper == "" {
+		logger.Warn("API_KEY_PEPPER not set; API key hashes are unkeyed")
+	}
+	cacheManager := cache.NewManager(redisClient, cfg.Redis.KeyPrefix, time.Duration(cfg.Redis.TTL)*time.Second)
+	// Quota counters live under a prefix of their own, which cache
+	// invalidation never scans, so clearing the cache does not reset them
+	quotaCache := cache.NewManager(redisClient, cfg.Redis.KeyPrefix+"-quota", time.Duration(cfg.Redis.TTL)*time.Second)
+	if cfg.Security.ServiceAuthSecret == "" {
+		logger.Warn("SERVICE_AUTH_SECRET not set; requests to upstream services are not signed")
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second, Transport: tracing.Transport(requestid.Transport(tenant.Transport(serviceauth.Transport(cfg.Security.ServiceAuthSecret, transport))))}
+
+	var consentClient *consent.Client
+	if cfg.Consent.Enabled {
+		consentClient = consent.NewClient(cfg.Services.WalletURL, httpClient, cacheManager,
+			time.Duration(cfg.Consent.CacheTTL)*time.Second, logger)
+	}
+
+	// Initialize the file store of bulk exports
+	var bulkFiles bulk.FileStore
+	if cfg.BulkExport.Enabled {
+		switch cfg.BulkExport.FileStore {
+		case "local":
+			bulkFiles, err = bulk.NewLocalFileStore(cfg.BulkExport.Directory)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create bulk export directory: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown BULK_EXPORT_FILE_STORE %q, expected local", cfg.BulkExport.FileStore)
+		}
+	}
+
+	// Initialize the object store and malware scanner of claim attachments
+	var attachments *attachment.Service
+	if cfg.Attachments.Enabled {
+		var objects attachment.ObjectStore
+		switch cfg.Attachments.ObjectStore {
+		case "local":
+			objects, err = attachment.NewLocalObjectStore(cfg.Attachments.Directory)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create attachment directory: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown ATTACHMENT_OBJECT_STORE %q, expected local", cfg.Attachments.ObjectStore)
+		}
+
+		var scanner attachment.Scanner
+		switch cfg.Attachments.Scanner {
+		case "none":
+			logger.Warn("ATTACHMENT_SCANNER is none; attachments are accepted without a malware scan")
+		case "clamd":
+			scanner = attachment.NewClamdScanner(cfg.Attachments.ClamdAddress, time.Duration(cfg.Attachments.ScanTimeoutSeconds)*time.Second)
+		default:
+			return nil, fmt.Errorf("unknown ATTACHMENT_SCANNER %q, expected none or clamd", cfg.Attachments.Scanner)
+		}
+
+		attachments = attachment.NewService(attachment.NewStore(db, logger), objects, scanner, logger, attachment.Options{
+			MaxSize:      int64(cfg.Attachments.MaxSizeMB) << 20,
+			AllowedTypes: cfg.Attachments.AllowedTypes,
+		})
+	}
+
+	// Patients and coverage come from the eligibility service
+	patients := bulk.NewEligibilitySource(cfg.Services.EligibilityURL, httpClient, cfg.BulkExport.PageSize)
+
 	return &Handler{
-		config:  cfg,
-		logger:  logger,
-		db:      db,
-		redis:   redisClient,
-		kafka:   kafkaProducer,
-		auth:    authService,
-		metrics: metrics,
+		config:        cfg,
+		logger:        logger,
+		db:            db,
+		redis:         redisClient,
+		cache:         cacheManager,
+		kafka:         kafkaProducer,
+		auth:          authService,
+		policies:      policyEngine,
+		apiKeys:       apikey.NewStore(db, logger, cfg.APIKeys.Pepper),
+		quota:         apikey.NewQuota(quotaCache, cfg.APIKeys.DefaultQuotaPerMinute),
+		breakGlass:    breakglass.NewStore(db, logger),
+		consents:      consentClient,
+		poll:          pollQueue,
+		subscriptions: subscription.NewStore(db, logger),
+		patients:      patients,
+		bulkJobs:      bulk.NewJobStore(db, logger),
+		bulkFiles:     bulkFiles,
+		bulkSources: []bulk.Source{
+			patients,
+			bulk.NewPollSource(db),
+		},
+		attachments:   attachments,
+		audit:         audit.NewStore(db, logger),
+		signer:        auditSigner,
+		metrics:       metrics,
+		stats:         &statsSampler{},
+		client:        httpClient,
+		upstream:      upstreamTLS,
+		started:       time.Now(),
 	}, nil
 }
 
+// Metrics returns the HTTP request collectors
+func (h *Handler) Metrics() *MetricsCollector {
+	return h.metrics
+}
+
+// APIKeys returns the API key store and the per-key quota
+func (h *Handler) APIKeys() (*apikey.Store, *apikey.Quota) {
+	return h.apiKeys, h.quota
+}
+
+// Policies returns the access policy engine
+func (h *Handler) Policies() *policy.Engine {
+	return h.policies
+}
+
+// Consents returns the consent decision client, or nil when consent
+// enforcement is disabled
+func (h *Handler) Consents() *consent.Client {
+	return h.consents
+}
+
+// AuditConsentDenied records a disclosure refused for lack of consent. It is
+// passed to the consent guard.
+func (h *Handler) AuditConsentDenied(c *gin.Context, req consent.Request, decision consent.Decision) {
+	h.logAuditEvent(c.Request.Context(), "consent.denied", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"memberID":  req.MemberID,
+		"recipient": req.Recipient,
+		"dataType":  req.DataType,
+		"purpose":   req.Purpose,
+		"reason":    decision.Reason,
+		"method":    c.Request.Method,
+		"path":      c.FullPath(),
+	})
+}
+
+// AuditAccessDenied records a request denied by the access policies. It is
+// passed to the policy middleware.
+func (h *Handler) AuditAccessDenied(c *gin.Context, req policy.Request, decision policy.Decision) {
+	h.logAuditEvent(c.Request.Context(), "access.denied", req.Subject.ID, c.ClientIP(), map[string]interface{}{
+		"role":        req.Subject.Role,
+		"action":      req.Action,
+		"resource":    req.Resource.Type,
+		"tenant_id":   req.Subject.TenantID,
+		"policy_id":   decision.PolicyID,
+		"reason":      decision.Reason,
+		"auth_method": req.Subject.AuthMethod,
+		"method":      c.Request.Method,
+		"path":        c.FullPath(),
+	})
+}
+
+// AuditCrossTenantAccess records an attempt by a caller scoped to tenantID to
+// reach data of the requested tenant. It is passed to TenantMiddleware.
+func (h *Handler) AuditCrossTenantAccess(c *gin.Context, tenantID, requested string) {
+	h.logAuditEvent(c.Request.Context(), "tenant.cross_access_denied", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"tenant_id":           tenantID,
+		"requested_tenant_id": requested,
+		"auth_method":         c.GetString("authMethod"),
+		"method":              c.Request.Method,
+		"path":                c.FullPath(),
+	})
+}
+
+// StartWorkers starts background consumers and maintenance jobs. They stop
+// when the context is cancelled.
+func (h *Handler) StartWorkers(ctx context.Context) {
+	// Request and CPU rates for the admin stats endpoint
+	go h.stats.Run(ctx, h.totalRequests)
+
+	// Final answers for pended claims and prior authorizations
+	pollIngestor := poll.NewIngestor(h.poll, h.config.Kafka.Brokers, kafkaSecurityConfig(h.config), h.config.Poll.ConsumerGroup, h.logger)
+	pollIngestor.Run(ctx, h.config.Kafka.Topics.ClaimsResponses, h.config.Kafka.Topics.PriorAuthStatus)
+	go h.poll.RunSweeper(ctx, time.Duration(h.config.Poll.SweepInterval)*time.Second)
+
+	// Notifications for FHIR Subscriptions on the same topics
+	if h.config.Subscriptions.Enabled {
+		matcher := subscription.NewMatcher(h.subscriptions, h.config.Kafka.Brokers, kafkaSecurityConfig(h.config), h.config.Subscriptions.ConsumerGroup, h.logger)
+		matcher.Run(ctx, h.config.Kafka.Topics.ClaimsResponses, h.config.Kafka.Topics.PriorAuthStatus)
+
+		dispatcher := subscription.NewDispatcher(h.db, h.logger, subscription.DispatcherOptions{
+			PollInterval:          time.Duration(h.config.Subscriptions.DeliveryIntervalMs) * time.Millisecond,
+			BatchSize:             h.config.Subscriptions.BatchSize,
+			MaxAttempts:           h.config.Subscriptions.MaxAttempts,
+			MaxBackoff:            time.Duration(h.config.Subscriptions.MaxBackoffSeconds) * time.Second,
+			Retention:             time.Duration(h.config.Subscriptions.RetentionHours) * time.Hour,
+			AllowPrivateEndpoints: h.config.Subscriptions.AllowPrivateEndpoints,
+		})
+		go dispatcher.Run(ctx)
+	}
+
+	// Files of FHIR Bulk Data exports
+	if h.config.BulkExport.Enabled {
+		runner := bulk.NewRunner(h.bulkJobs, h.bulkFiles, h.bulkSources, h.logger, bulk.RunnerOptions{
+			PollInterval: time.Duration(h.config.BulkExport.PollIntervalMs) * time.Millisecond,
+			Lease:        time.Duration(h.config.BulkExport.LeaseSeconds) * time.Second,
+			MaxAttempts:  h.config.BulkExport.MaxAttempts,
+			Retention:    time.Duration(h.config.BulkExport.RetentionHours) * time.Hour,
+		})
+		go runner.Run(ctx)
+	}
+
+	// Audit trail from all services
+	auditConsumer := audit.NewConsumer(h.audit, h.config.Kafka.Brokers, kafkaSecurityConfig(h.config), h.config.Audit.ConsumerGroup, h.logger)
+	go auditConsumer.Run(ctx, h.config.Kafka.Topics.AuditTrail)
+
+	var anchorer audit.Anchorer
+	if h.config.Audit.AnchorCheckpoints {
+		anchorer = audit.NewWalletAnchorer(h.config.Services.WalletURL, h.client)
+	}
+	checkpointer := audit.NewCheckpointer(h.audit, h.signer, anchorer, h.logger)
+	go checkpointer.Run(ctx, time.Duration(h.config.Audit.CheckpointInterval)*time.Second)
+
+	// Access policies kept in a file or the database
+	if h.config.Policy.Source != "builtin" {
+		go h.policies.Watch(ctx, time.Duration(h.config.Policy.ReloadInterval)*time.Second)
+	}
+
+	// Last-used times of API keys
+	go h.apiKeys.RunUsageFlusher(ctx, time.Duration(h.config.APIKeys.UsageFlushInterval)*time.Second)
+
+	// Rotated upstream client certificates
+	if h.upstream != nil {
+		go h.upstream.Watch(ctx, time.Duration(h.config.Security.ReloadInterval)*time.Second)
+	}
+
+	// Audit and domain events written to the outbox
+	relay := outbox.NewRelay(h.db, h.kafka, h.logger, outbox.RelayOptions{
+		PollInterval: time.Duration(h.config.Outbox.PollIntervalMs) * time.Millisecond,
+		BatchSize:    h.config.Outbox.BatchSize,
+		MaxAttempts:  h.config.Outbox.MaxAttempts,
+		MaxBackoff:   time.Duration(h.config.Outbox.MaxBackoffSeconds) * time.Second,
+		Retention:    time.Duration(h.config.Outbox.RetentionHours) * time.Hour,
+	})
+	go relay.Run(ctx)
+}
+
 // Close closes all connections
 func (h *Handler) Close() error {
 	if h.db != nil {
@@ -143,14 +465,14 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 	if err := h.db.Ping(); err != nil {
 		status = "unhealthy"
 		statusCode = http.StatusServiceUnavailable
-		h.logger.Errorf("Database health check failed: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Database health check failed: %v", err)
 	}
 
 	// Check Redis connection
 	if err := h.redis.Ping(c.Request.Context()).Err(); err != nil {
 		status = "unhealthy"
 		statusCode = http.StatusServiceUnavailable
-		h.logger.Errorf("Redis health check failed: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Redis health check failed: %v", err)
 	}
 
 	c.JSON(statusCode, gin.H{
@@ -225,8 +547,9 @@ func (h *Handler) GetToken(c *gin.Context) {
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Invalid request format",
-			Message: err.Error(),
+			Error:     "Invalid request format",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
@@ -235,26 +558,38 @@ func (h *Handler) GetToken(c *gin.Context) {
 	// For now, using a simple mock implementation
 	if req.Username == "" || req.Password == "" {
 		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "Missing credentials",
-			Message: "Username and password are required",
+			Error:     "Missing credentials",
+			Message:   "Username and password are required",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
+	// The password is not verified, so mock tokens only carry the user role
+	// and are not scoped to a tenant or organization. Privileged and scoped
+	// identities come from the identity provider or from API keys.
+	role := policy.RoleUser
+
 	// Generate JWT token
-	token, err := h.auth.GenerateToken(req.Username, []string{"read", "write"})
+	token, err := h.auth.GenerateToken(auth.Identity{
+		UserID: req.Username,
+		Role:   role,
+		Scopes: []string{"read", "write"},
+	})
 	if err != nil {
-		h.logger.Errorf("Failed to generate token: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to generate token: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Token generation failed",
-			Message: "Unable to generate authentication token",
+			Error:     "Token generation failed",
+			Message:   "Unable to generate authentication token",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	// Log successful authentication
-	h.logAuditEvent("auth.login", req.Username, c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "auth.login", req.Username, c.ClientIP(), map[string]interface{}{
 		"username": req.Username,
+		"role":     role,
 		"success":  true,
 	})
 
@@ -281,30 +616,61 @@ func (h *Handler) GetToken(c *gin.Context) {
 func (h *Handler) RefreshToken(c *gin.Context) {
 	// TODO: Implement refresh token logic
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Refresh token functionality is not yet implemented",
+		Error:     "Not implemented",
+		Message:   "Refresh token functionality is not yet implemented",
+		RequestID: requestid.Get(c),
 	})
 }
 
-// logAuditEvent logs an audit event to Kafka
-func (h *Handler) logAuditEvent(eventType, userID, clientIP string, data map[string]interface{}) {
+// kafkaSecurityConfig extracts the broker security settings from the configuration
+func kafkaSecurityConfig(cfg *config.Config) kafka.SecurityConfig {
+	return kafka.SecurityConfig{
+		SASLMechanism:         cfg.Kafka.SASLMechanism,
+		Username:              cfg.Kafka.Username,
+		Password:              cfg.Kafka.Password,
+		TLSEnabled:            cfg.Kafka.TLSEnabled,
+		TLSCAPath:             cfg.Kafka.TLSCAPath,
+		TLSCertPath:           cfg.Kafka.TLSCertPath,
+		TLSKeyPath:            cfg.Kafka.TLSKeyPath,
+		TLSInsecureSkipVerify: cfg.Kafka.TLSInsecureSkipVerify,
+	}
+}
+
+// logAuditEvent records an audit event in the outbox for relay to Kafka
+func (h *Handler) logAuditEvent(ctx context.Context, eventType, userID, clientIP string, data map[string]interface{}) {
+	// The event is recorded even if the client has already gone away
+	if err := h.logAuditEventTx(context.WithoutCancel(ctx), h.db, eventType, userID, clientIP, data); err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to record audit event: %v", err)
+	}
+}
+
+// logAuditEventTx records an audit event using the given executor, so that it
+// commits or rolls back together with the caller's transaction
+func (h *Handler) logAuditEventTx(ctx context.Context, exec outbox.Execer, eventType, userID, clientIP string, data map[string]interface{}) error {
+	eventID := uuid.New().String()
 	auditEvent := map[string]interface{}{
-		"eventId":     uuid.New().String(),
+		"eventId":     eventID,
 		"eventType":   eventType,
 		"userId":      userID,
 		"clientIP":    clientIP,
 		"timestamp":   time.Now().UTC(),
 		"service":     "api-gateway",
+		"requestId":   requestid.FromContext(ctx),
+		"tenantId":    tenant.FromContext(ctx),
 		"data":        data,
 	}
 
 	eventData, err := json.Marshal(auditEvent)
 	if err != nil {
-		h.logger.Errorf("Failed to marshal audit event: %v", err)
-		return
+		return fmt.Errorf("failed to marshal audit event: %w", err)
 	}
 
-	if err := h.kafka.Publish(h.config.Kafka.Topics.AuditTrail, string(eventData)); err != nil {
-		h.logger.Errorf("Failed to publish audit event: %v", err)
-	}
-}
\ No newline at end of file
+	return outbox.Add(ctx, exec, outbox.Event{
+		ID:            eventID,
+		Topic:         h.config.Kafka.Topics.AuditTrail,
+		AggregateType: "audit",
+		AggregateID:   eventType,
+		EventType:     eventType,
+		Payload:       eventData,
+	})
+}
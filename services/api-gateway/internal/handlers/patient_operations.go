@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/bulk"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/match"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/middleware"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/names"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// FHIR Patient $everything and $match operations
+
+const (
+	defaultEverythingPageSize = 50
+	maxEverythingPageSize     = 500
+
+	defaultMatchCount = 10
+	maxMatchCount     = 100
+
+	// matchCandidateLimit bounds the candidates read for each of the national
+	// ID, the birth date and the names of a $match
+	matchCandidateLimit = 200
+)
+
+// PatientEverything godoc
+// @Summary Get everything about a patient
+// @Description Return the patient with their coverage, claim responses, eligibility and prior authorization responses and communications as a searchset Bundle, paged with _count and the _cursor of the next link. Claims are not stored by the gateway and are not returned; claim responses are returned as such. Patient IDs are national IDs.
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Patient ID"
+// @Param _since query string false "Only resources updated at or after this instant"
+// @Param _type query string false "Comma-separated resource types"
+// @Param _count query int false "Number of resources per page" default(50)
+// @Param _cursor query string false "Cursor of the next page, from the next link"
+// @Param dual_calendar query bool false "Add Hijri dates to the patient and coverage"
+// @Success 200 {object} fhir.Bundle
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} fhir.OperationOutcome
+// @Failure 500 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/Patient/{id}/$everything [get]
+func (h *Handler) PatientEverything(c *gin.Context) {
+	patientID := c.Param("id")
+
+	count := defaultEverythingPageSize
+	if countStr := c.Query("_count"); countStr != "" {
+		if parsedCount, err := strconv.Atoi(countStr); err == nil && parsedCount > 0 {
+			count = min(parsedCount, maxEverythingPageSize)
+		}
+	}
+
+	// Cursors name the resource type and the position in it of the page
+	cursor := c.Query("_cursor")
+	cursorType, after, _ := strings.Cut(cursor, ":")
+
+	var since *time.Time
+	if value := c.Query("_since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "_since must be a FHIR instant, e.g. 2024-01-15T10:30:00Z"))
+			return
+		}
+		since = &parsed
+	}
+
+	supported := bulk.Types(h.bulkSources)
+	known := make(map[string]bool)
+	for _, resourceType := range supported {
+		known[resourceType] = true
+	}
+	requested := make(map[string]bool)
+	for _, value := range c.QueryArray("_type") {
+		for _, resourceType := range strings.Split(value, ",") {
+			resourceType = strings.TrimSpace(resourceType)
+			switch {
+			case resourceType == "":
+			case known[resourceType]:
+				requested[resourceType] = true
+			default:
+				c.JSON(http.StatusBadRequest, fhirOutcome("error", "not-supported", fmt.Sprintf("Unsupported _type %s; supported types are %s", resourceType, strings.Join(supported, ", "))))
+				return
+			}
+		}
+	}
+	includes := func(resourceType string) bool {
+		return len(requested) == 0 || requested[resourceType]
+	}
+
+	ctx := c.Request.Context()
+	query := bulk.Query{
+		Level:    bulk.LevelPatient,
+		TenantID: tenant.Get(c),
+		Patient:  patientID,
+	}
+
+	// The patient is read whatever the filters, so that unknown patients and
+	// patients outside the caller's tenant are not found
+	var patient interface{}
+	err := h.patients.Export(ctx, "Patient", query, func(resource interface{}) error {
+		patient = resource
+		return nil
+	})
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to read patient: %v", err)
+		c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to read the patient"))
+		return
+	}
+	if patient == nil {
+		c.JSON(http.StatusNotFound, fhirOutcome("error", "not-found", "No patient with ID "+patientID))
+		return
+	}
+
+	// The other resources are read type by type, one page at a time from
+	// their source, after the patient
+	type everythingType struct {
+		resourceType string
+		source       bulk.Source
+	}
+	var types []everythingType
+	for _, source := range h.bulkSources {
+		for _, resourceType := range source.Types() {
+			if resourceType != "Patient" && includes(resourceType) {
+				types = append(types, everythingType{resourceType, source})
+			}
+		}
+	}
+
+	next := 0
+	if cursor != "" {
+		for next < len(types) && types[next].resourceType != cursorType {
+			next++
+		}
+		if next == len(types) {
+			c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "Invalid _cursor"))
+			return
+		}
+	}
+
+	var resources []interface{}
+	if cursor == "" && includes("Patient") {
+		resources = append(resources, patient)
+	}
+	query.Since = since
+	nextCursor := ""
+	for ; next < len(types) && len(resources) < count; next++ {
+		resourceType := types[next].resourceType
+		page, pageAfter, err := types[next].source.Page(ctx, resourceType, query, after, count-len(resources))
+		if err != nil {
+			h.logger.WithContext(ctx).Errorf("Failed to read %s of patient: %v", resourceType, err)
+			c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to read the patient's "+resourceType+" resources"))
+			return
+		}
+		resources = append(resources, page...)
+		if pageAfter != "" {
+			nextCursor = resourceType + ":" + pageAfter
+			break
+		}
+		after = ""
+	}
+	if nextCursor == "" && next < len(types) {
+		nextCursor = types[next].resourceType + ":"
+	}
+
+	base := h.bulkBaseURL(c)
+	bundle := fhir.Bundle{
+		ResourceType: "Bundle",
+		ID:           uuid.New().String(),
+		Type:         "searchset",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		Link:         []fhir.BundleLink{{Relation: "self", URL: pageURL(c, base, cursor, count)}},
+	}
+	if nextCursor != "" {
+		bundle.Link = append(bundle.Link, fhir.BundleLink{Relation: "next", URL: pageURL(c, base, nextCursor, count)})
+	}
+	for _, resource := range resources {
+		if dualCalendar(c) {
+			resource = withHijriDates(resource)
+		}
+		bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+			FullURL:  resourceURL(base, resource),
+			Resource: resource,
+		})
+	}
+
+	h.logAuditEvent(ctx, "fhir.patient.everything", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"patientID":   patientID,
+		"cursor":      cursor,
+		"resultCount": len(bundle.Entry),
+		"since":       c.Query("_since"),
+		"consentID":   c.GetString("consentID"),
+	})
+
+	c.JSON(http.StatusOK, bundle)
+}
+
+// MatchPatients godoc
+// @Summary Match patients
+// @Description Find the patients that may be the given one, e.g. before registering a new patient. Candidates share the national ID, birth date or a name and are scored on national ID, Arabic and English names, birth date and phone. Each entry carries its score and match grade.
+// @Tags fhir
+// @Security OAuth2Application
+// @Accept json
+// @Produce json
+// @Param parameters body fhir.Parameters true "Parameters with the patient in resource, and optionally count and onlyCertainMatches. A Hijri birthDate carries the date-calendar extension."
+// @Param dual_calendar query bool false "Add Hijri dates to the patients returned"
+// @Success 200 {object} fhir.Bundle
+// @Failure 400 {object} fhir.OperationOutcome
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} fhir.OperationOutcome
+// @Failure 503 {object} fhir.OperationOutcome
+// @Router /api/v1/fhir/Patient/$match [post]
+func (h *Handler) MatchPatients(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "Unable to read the request body"))
+		return
+	}
+
+	var probe fhir.Resource
+	if err := json.Unmarshal(body, &probe); err != nil {
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "Invalid JSON: "+err.Error()))
+		return
+	}
+
+	// A bare Patient is accepted in place of Parameters
+	var input fhir.Patient
+	count := defaultMatchCount
+	onlyCertain := false
+	switch probe.ResourceType {
+	case "Parameters":
+		var parameters fhir.Parameters
+		if err := json.Unmarshal(body, &parameters); err != nil {
+			c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "Invalid Parameters: "+err.Error()))
+			return
+		}
+		for _, parameter := range parameters.Parameter {
+			switch parameter.Name {
+			case "resource":
+				if err := json.Unmarshal(parameter.Resource, &input); err != nil {
+					c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "Invalid resource parameter: "+err.Error()))
+					return
+				}
+			case "count":
+				if parameter.ValueInteger != nil && *parameter.ValueInteger > 0 {
+					count = min(*parameter.ValueInteger, maxMatchCount)
+				}
+			case "onlyCertainMatches":
+				onlyCertain = parameter.ValueBoolean != nil && *parameter.ValueBoolean
+			}
+		}
+	case "Patient":
+		if err := json.Unmarshal(body, &input); err != nil {
+			c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "Invalid Patient: "+err.Error()))
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "Expected Parameters with the patient in the resource parameter"))
+		return
+	}
+
+	if input.ResourceType != "Patient" {
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "required", "The resource parameter must be a Patient"))
+		return
+	}
+	nationalID := match.NationalID(input)
+	// Hijri birth dates are matched as their Gregorian date
+	if err := gregorianBirthDate(&input); err != nil {
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "invalid", "birthDate must be a date, e.g. 1985-03-15: "+err.Error()))
+		return
+	}
+	var criteria []bulk.PatientCriteria
+	if nationalID != "" {
+		criteria = append(criteria, bulk.PatientCriteria{Identifier: nationalID})
+	}
+	if input.BirthDate != "" {
+		criteria = append(criteria, bulk.PatientCriteria{BirthDate: input.BirthDate})
+	}
+	for _, name := range input.Name {
+		// The eligibility service searches by the name's phonetic keys
+		if text := match.NameText(name); len(names.Keys(text)) > 0 {
+			criteria = append(criteria, bulk.PatientCriteria{Name: text})
+		}
+	}
+	if len(criteria) == 0 {
+		c.JSON(http.StatusBadRequest, fhirOutcome("error", "required", "The patient must have a national ID, a birth date or a name to be matched"))
+		return
+	}
+
+	// Candidates share the national ID, the birth date or a name; phone
+	// numbers are only compared
+	ctx := c.Request.Context()
+	tenantID := tenant.Get(c)
+	var candidates []fhir.Patient
+	for _, criterion := range criteria {
+		patients, err := h.patients.FindPatients(ctx, tenantID, criterion, matchCandidateLimit)
+		if err != nil {
+			h.logger.WithContext(ctx).Errorf("Failed to read match candidates: %v", err)
+			c.JSON(http.StatusInternalServerError, fhirOutcome("error", "exception", "Unable to search for matching patients"))
+			return
+		}
+		candidates = append(candidates, patients...)
+	}
+
+	base := h.bulkBaseURL(c)
+	bundle := fhir.Bundle{
+		ResourceType: "Bundle",
+		ID:           uuid.New().String(),
+		Type:         "searchset",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, m := range match.Rank(input, candidates) {
+		if len(bundle.Entry) == count {
+			break
+		}
+		if onlyCertain && m.Grade != match.GradeCertain {
+			continue
+		}
+
+		permitted, err := middleware.ConsentPermits(c, m.Patient.ID)
+		if err != nil {
+			h.logger.WithContext(ctx).WithError(err).Error("Consent decision unavailable")
+			c.JSON(http.StatusServiceUnavailable, fhirOutcome("error", "transient", "Consent could not be verified; try again later"))
+			return
+		}
+		if !permitted {
+			continue
+		}
+
+		var resource interface{} = m.Patient
+		if dualCalendar(c) {
+			resource = withHijriDates(m.Patient)
+		}
+		bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+			FullURL:  resourceURL(base, m.Patient),
+			Resource: resource,
+			Search: &fhir.BundleEntrySearch{
+				Extension: []fhir.Extension{{URL: match.GradeExtension, ValueCode: m.Grade}},
+				Mode:      "match",
+				Score:     math.Round(m.Score*100) / 100,
+			},
+		})
+	}
+	bundle.Total = len(bundle.Entry)
+
+	h.logAuditEvent(ctx, "fhir.patient.match", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"candidateCount": len(candidates),
+		"resultCount":    len(bundle.Entry),
+		"onlyCertain":    onlyCertain,
+	})
+
+	c.JSON(http.StatusOK, bundle)
+}
+
+// pageURL returns the URL of the request with another page cursor
+func pageURL(c *gin.Context, base, cursor string, count int) string {
+	query := c.Request.URL.Query()
+	if cursor == "" {
+		query.Del("_cursor")
+	} else {
+		query.Set("_cursor", cursor)
+	}
+	query.Set("_count", strconv.Itoa(count))
+	return base + c.Request.URL.Path + "?" + query.Encode()
+}
+
+// resourceURL returns the absolute URL of a resource in the FHIR API
+func resourceURL(base string, resource interface{}) string {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return ""
+	}
+	var key fhir.Resource
+	if json.Unmarshal(data, &key) != nil || key.ResourceType == "" || key.ID == "" {
+		return ""
+	}
+	return base + "/api/v1/fhir/" + key.ResourceType + "/" + url.PathEscape(key.ID)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/policy"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// Access policy administration
+
+// ListPolicies godoc
+// @Summary List access policies
+// @Description List the access policies in force, in evaluation order
+// @Tags admin
+// @Security BearerAuth
+// @Produce json
+// @Success 200 {object} models.PolicyListResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/admin/policies [get]
+func (h *Handler) ListPolicies(c *gin.Context) {
+	policies := h.policies.Policies()
+
+	response := models.PolicyListResponse{
+		Source:   h.config.Policy.Source,
+		Policies: make([]models.AccessPolicy, 0, len(policies)),
+	}
+	for _, p := range policies {
+		response.Policies = append(response.Policies, models.AccessPolicy{
+			ID:          p.ID,
+			Description: p.Description,
+			Effect:      p.Effect,
+			Roles:       p.Roles,
+			Actions:     p.Actions,
+			Resources:   p.Resources,
+			Conditions: models.PolicyConditions{
+				SameTenant:        p.Conditions.SameTenant,
+				SameOrganization:  p.Conditions.SameOrganization,
+				OrganizationTypes: p.Conditions.OrganizationTypes,
+				AuthMethods:       p.Conditions.AuthMethods,
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// EvaluatePolicy godoc
+// @Summary Evaluate access policies
+// @Description Decide a hypothetical request against the access policies in force without performing it. Dry runs are not audited as denials.
+// @Tags admin
+// @Security BearerAuth
+// @Accept json
+// @Produce json
+// @Param request body models.PolicyEvaluationRequest true "Request to decide"
+// @Success 200 {object} models.PolicyEvaluationResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /api/v1/admin/policies/evaluate [post]
+func (h *Handler) EvaluatePolicy(c *gin.Context) {
+	var req models.PolicyEvaluationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid evaluation request",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	decision := h.policies.Evaluate(policy.Request{
+		Subject: policy.Subject{
+			Role:             req.Subject.Role,
+			TenantID:         req.Subject.TenantID,
+			OrganizationID:   req.Subject.OrganizationID,
+			OrganizationType: req.Subject.OrganizationType,
+			AuthMethod:       req.Subject.AuthMethod,
+		},
+		Action: req.Action,
+		Resource: policy.Resource{
+			Type:           req.Resource.Type,
+			Scoped:         req.Resource.TenantID != "" || req.Resource.OrganizationID != "",
+			TenantID:       req.Resource.TenantID,
+			OrganizationID: req.Resource.OrganizationID,
+		},
+	})
+
+	h.logAuditEvent(c.Request.Context(), "policy.evaluate", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"role":      req.Subject.Role,
+		"action":    req.Action,
+		"resource":  req.Resource.Type,
+		"allowed":   decision.Allowed,
+		"policy_id": decision.PolicyID,
+	})
+
+	c.JSON(http.StatusOK, models.PolicyEvaluationResponse{
+		Allowed:  decision.Allowed,
+		Effect:   decision.Effect,
+		PolicyID: decision.PolicyID,
+		Reason:   decision.Reason,
+	})
+}
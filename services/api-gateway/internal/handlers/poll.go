@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/poll"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Poll endpoints - asynchronous responses for pended claims and prior authorizations
+
+// PollMessages godoc
+// @Summary Poll for queued messages
+// @Description Retrieve a batch of queued ClaimResponse, CoverageEligibilityResponse and Communication messages for a provider. Returned messages are leased and redelivered unless acknowledged before the lease expires.
+// @Tags poll
+// @Security OAuth2Application
+// @Accept json
+// @Produce json
+// @Param request body models.PollRequest true "Poll request"
+// @Success 200 {object} models.PollResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/poll [post]
+func (h *Handler) PollMessages(c *gin.Context) {
+	var req models.PollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid poll request",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	providerID, ok := pollProvider(c, req.ProviderID)
+	if !ok {
+		return
+	}
+
+	for _, messageType := range req.MessageTypes {
+		if !poll.IsSupportedType(messageType) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Unsupported message type",
+				Message:   "Message type " + messageType + " cannot be polled",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+	}
+
+	count := req.Count
+	if count <= 0 {
+		count = h.config.Poll.DefaultBatchSize
+	}
+	if count > h.config.Poll.MaxBatchSize {
+		count = h.config.Poll.MaxBatchSize
+	}
+
+	ctx := c.Request.Context()
+	leaseUntil := time.Now().Add(time.Duration(h.config.Poll.LeaseSeconds) * time.Second)
+
+	messages, err := h.poll.Poll(ctx, providerID, req.MessageTypes, count)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to poll messages: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Poll failed",
+			Message:   "Unable to retrieve queued messages",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	remaining, err := h.poll.Pending(ctx, providerID, req.MessageTypes)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to count pending poll messages: %v", err)
+	}
+
+	response := models.PollResponse{
+		PollID:     uuid.New().String(),
+		ProviderID: providerID,
+		Messages:   make([]models.PollMessage, 0, len(messages)),
+		Remaining:  remaining,
+		LeaseUntil: leaseUntil.UTC(),
+	}
+
+	messageIDs := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		response.Messages = append(response.Messages, models.PollMessage{
+			MessageID:     msg.ID,
+			MessageType:   msg.MessageType,
+			ResourceID:    msg.ResourceID,
+			Resource:      msg.Payload,
+			DeliveryCount: msg.DeliveryCount,
+			CreatedAt:     msg.CreatedAt,
+			ExpiresAt:     msg.ExpiresAt,
+		})
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	h.logAuditEvent(c.Request.Context(), "poll.request", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"pollID":       response.PollID,
+		"providerID":   providerID,
+		"messageTypes": req.MessageTypes,
+		"messageIDs":   messageIDs,
+		"messageCount": len(messages),
+		"remaining":    remaining,
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AcknowledgePollMessages godoc
+// @Summary Acknowledge polled messages
+// @Description Acknowledge messages returned by a poll so they are not redelivered
+// @Tags poll
+// @Security OAuth2Application
+// @Accept json
+// @Produce json
+// @Param request body models.PollAckRequest true "Acknowledgement request"
+// @Success 200 {object} models.PollAckResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/poll/ack [post]
+func (h *Handler) AcknowledgePollMessages(c *gin.Context) {
+	var req models.PollAckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid acknowledgement request",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	if len(req.MessageIDs) == 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Missing required field",
+			Message:   "At least one message ID is required",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	providerID, ok := pollProvider(c, req.ProviderID)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// The acknowledgement and its audit event commit together
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to begin acknowledgement transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Acknowledgement failed",
+			Message:   "Unable to acknowledge messages",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	acknowledged, err := h.poll.AcknowledgeTx(ctx, tx, providerID, req.MessageIDs)
+	if err == nil {
+		err = h.logAuditEventTx(ctx, tx, "poll.acknowledge", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+			"providerID":   providerID,
+			"messageIDs":   req.MessageIDs,
+			"acknowledged": acknowledged,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to acknowledge poll messages: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Acknowledgement failed",
+			Message:   "Unable to acknowledge messages",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PollAckResponse{
+		Acknowledged: acknowledged,
+		Requested:    len(req.MessageIDs),
+	})
+}
+
+// pollProvider returns the caller's organization, whose queue is polled. A
+// provider ID in the request must name the same organization.
+func pollProvider(c *gin.Context, requested string) (string, bool) {
+	providerID := c.GetString("organizationIdentifier")
+	if providerID == "" {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:     "Organization required",
+			Message:   "Messages are polled by provider organizations; the credentials carry no organization",
+			RequestID: requestid.Get(c),
+		})
+		return "", false
+	}
+	if requested != "" && requested != providerID {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:     "Forbidden",
+			Message:   "provider_id does not match the organization of the credentials",
+			RequestID: requestid.Get(c),
+		})
+		return "", false
+	}
+	return providerID, true
+}
@@ -1,10 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/audit"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/cache"
 	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 // Service proxy handlers - these will forward requests to microservices
@@ -27,8 +35,9 @@ import (
 func (h *Handler) CheckEligibility(c *gin.Context) {
 	// TODO: Forward request to eligibility service
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Eligibility check functionality will be implemented when eligibility service is ready",
+		Error:     "Not implemented",
+		Message:   "Eligibility check functionality will be implemented when eligibility service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -52,8 +61,9 @@ func (h *Handler) GetMemberCoverage(c *gin.Context) {
 	_ = memberID
 	
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Member coverage retrieval functionality will be implemented when eligibility service is ready",
+		Error:     "Not implemented",
+		Message:   "Member coverage retrieval functionality will be implemented when eligibility service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -61,7 +71,7 @@ func (h *Handler) GetMemberCoverage(c *gin.Context) {
 
 // SubmitClaim godoc
 // @Summary Submit a claim
-// @Description Submit a claim for processing
+// @Description Submit a claim for processing. Attachments are referenced by the URL they were uploaded to; only small documents may be sent inline.
 // @Tags claims
 // @Security OAuth2Application
 // @Accept json
@@ -70,13 +80,40 @@ func (h *Handler) GetMemberCoverage(c *gin.Context) {
 // @Success 202 {object} models.ClaimSubmissionResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
+// @Failure 413 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/v1/claims/submit [post]
 func (h *Handler) SubmitClaim(c *gin.Context) {
+	var claim models.ClaimSubmission
+	if err := c.ShouldBindJSON(&claim); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid claim submission",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	// Attachments are forwarded as references carrying the stored content
+	// type, size and hash
+	for i := range claim.Attachments {
+		attached := &claim.Attachments[i]
+		stored, ok := h.claimAttachment(c, attached.URL, attached.Data)
+		if !ok {
+			return
+		}
+		if stored != nil {
+			attached.ContentType = stored.ContentType
+			attached.Size = stored.Size
+			attached.SHA256 = stored.SHA256
+		}
+	}
+
 	// TODO: Forward request to claims service
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim submission functionality will be implemented when claims service is ready",
+		Error:     "Not implemented",
+		Message:   "Claim submission functionality will be implemented when claims service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -100,8 +137,9 @@ func (h *Handler) GetClaimStatus(c *gin.Context) {
 	_ = claimID
 	
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim status retrieval functionality will be implemented when claims service is ready",
+		Error:     "Not implemented",
+		Message:   "Claim status retrieval functionality will be implemented when claims service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -125,8 +163,9 @@ func (h *Handler) ReprocessClaim(c *gin.Context) {
 	_ = claimID
 	
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Claim reprocessing functionality will be implemented when claims service is ready",
+		Error:     "Not implemented",
+		Message:   "Claim reprocessing functionality will be implemented when claims service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -146,8 +185,9 @@ func (h *Handler) ReprocessClaim(c *gin.Context) {
 func (h *Handler) GetCodeSystems(c *gin.Context) {
 	// TODO: Forward request to terminology service
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Code systems retrieval functionality will be implemented when terminology service is ready",
+		Error:     "Not implemented",
+		Message:   "Code systems retrieval functionality will be implemented when terminology service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -174,8 +214,9 @@ func (h *Handler) LookupCode(c *gin.Context) {
 	_ = code
 	
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Code lookup functionality will be implemented when terminology service is ready",
+		Error:     "Not implemented",
+		Message:   "Code lookup functionality will be implemented when terminology service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
@@ -200,86 +241,326 @@ func (h *Handler) ValidateCode(c *gin.Context) {
 	_ = system
 	
 	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Code validation functionality will be implemented when terminology service is ready",
+		Error:     "Not implemented",
+		Message:   "Code validation functionality will be implemented when terminology service is ready",
+		RequestID: requestid.Get(c),
 	})
 }
 
 // Administrative endpoints
 
-// GetSystemStats godoc
-// @Summary Get system statistics
-// @Description Get overall system statistics and metrics
+// GetAuditLogs godoc
+// @Summary Get audit logs
+// @Description Retrieve audit logs for compliance, newest first. Use next_cursor from the response to fetch the following page.
 // @Tags admin
 // @Security OAuth2Application
 // @Accept json
 // @Produce json
-// @Success 200 {object} models.SystemStats
+// @Param from query string false "Start time, inclusive (RFC 3339)"
+// @Param to query string false "End time, exclusive (RFC 3339)"
+// @Param user_id query string false "User ID"
+// @Param event_type query string false "Event type, e.g. fhir.patient.read"
+// @Param resource query string false "Resource reference, e.g. Patient/123"
+// @Param service query string false "Originating service, e.g. eligibility-service"
+// @Param request_id query string false "Request ID returned in the X-Request-ID header"
+// @Param cursor query string false "Pagination cursor"
+// @Param limit query int false "Maximum number of records" default(100)
+// @Success 200 {object} models.AuditLogResponse
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
-// @Router /api/v1/admin/stats [get]
-func (h *Handler) GetSystemStats(c *gin.Context) {
-	// TODO: Implement system statistics gathering
-	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "System statistics functionality is not yet implemented",
+// @Router /api/v1/admin/audit [get]
+func (h *Handler) GetAuditLogs(c *gin.Context) {
+	filter := audit.Filter{
+		UserID:    c.Query("user_id"),
+		EventType: c.Query("event_type"),
+		Resource:  c.Query("resource"),
+		Service:   c.Query("service"),
+		RequestID: c.Query("request_id"),
+		Cursor:    c.Query("cursor"),
+		Limit:     h.config.Audit.DefaultPageSize,
+	}
+
+	for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid parameter",
+				Message:   "Parameter " + param + " must be an RFC 3339 timestamp",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+		*target = parsed
+	}
+
+	if limitParam := c.Query("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid parameter",
+				Message:   "Parameter limit must be a positive integer",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+		filter.Limit = limit
+	}
+	if filter.Limit > h.config.Audit.MaxPageSize {
+		filter.Limit = h.config.Audit.MaxPageSize
+	}
+
+	records, nextCursor, err := h.audit.Query(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, audit.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid parameter",
+				Message:   "Parameter cursor is not a valid pagination cursor",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to query audit logs: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Audit query failed",
+			Message:   "Unable to retrieve audit logs",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	response := models.AuditLogResponse{
+		Logs:       make([]models.AuditLogEntry, 0, len(records)),
+		PageSize:   filter.Limit,
+		NextCursor: nextCursor,
+	}
+	for _, record := range records {
+		response.Logs = append(response.Logs, models.AuditLogEntry{
+			EventID:   record.EventID,
+			EventType: record.EventType,
+			UserID:    record.UserID,
+			ClientIP:  record.ClientIP,
+			Timestamp: record.Timestamp,
+			Service:   record.Service,
+			Resource:  record.Resource,
+			Action:    record.Action,
+			Status:    record.Status,
+			RequestID: record.RequestID,
+			Data:      record.Data,
+		})
+	}
+
+	// Access to the audit trail is itself audited
+	h.logAuditEvent(c.Request.Context(), "audit.query", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"from":            c.Query("from"),
+		"to":              c.Query("to"),
+		"filterUser":      filter.UserID,
+		"filterEventType": filter.EventType,
+		"filterResource":  filter.Resource,
+		"filterService":   filter.Service,
+		"filterRequestId": filter.RequestID,
+		"resultCount":     len(records),
 	})
+
+	c.JSON(http.StatusOK, response)
 }
 
-// GetAuditLogs godoc
-// @Summary Get audit logs
-// @Description Retrieve audit logs for compliance
+// VerifyAuditLogs godoc
+// @Summary Verify audit log integrity
+// @Description Recompute the hash chain of each service's audit log for a time range, check signed checkpoints, and report the first broken link
 // @Tags admin
 // @Security OAuth2Application
 // @Accept json
 // @Produce json
-// @Param from query string false "Start date (ISO 8601)"
-// @Param to query string false "End date (ISO 8601)"
-// @Param limit query int false "Maximum number of records" default(100)
-// @Success 200 {object} models.AuditLogResponse
+// @Param from query string false "Start time, inclusive (RFC 3339)"
+// @Param to query string false "End time, exclusive (RFC 3339)"
+// @Param service query string false "Only verify this service's chain"
+// @Success 200 {object} models.AuditVerificationResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
-// @Router /api/v1/admin/audit [get]
-func (h *Handler) GetAuditLogs(c *gin.Context) {
-	// TODO: Implement audit log retrieval
-	c.JSON(http.StatusNotImplemented, models.ErrorResponse{
-		Error:   "Not implemented",
-		Message: "Audit log retrieval functionality is not yet implemented",
+// @Router /api/v1/admin/audit/verify [get]
+func (h *Handler) VerifyAuditLogs(c *gin.Context) {
+	response := models.AuditVerificationResponse{
+		Valid:  true,
+		Chains: []models.AuditChainVerification{},
+	}
+
+	var from, to time.Time
+	for param, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid parameter",
+				Message:   "Parameter " + param + " must be an RFC 3339 timestamp",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+		*target = parsed
+	}
+	if !from.IsZero() {
+		response.From = &from
+	}
+	if !to.IsZero() {
+		response.To = &to
+	}
+
+	ctx := c.Request.Context()
+
+	services := []string{c.Query("service")}
+	if services[0] == "" {
+		var err error
+		services, err = h.audit.Services(ctx)
+		if err != nil {
+			h.logger.WithContext(c.Request.Context()).Errorf("Failed to list audit chains: %v", err)
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+				Error:     "Audit verification failed",
+				Message:   "Unable to list audit chains",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+	}
+
+	for _, service := range services {
+		result, err := h.audit.Verify(ctx, h.signer, service, from, to)
+		if err != nil {
+			h.logger.WithContext(c.Request.Context()).Errorf("Failed to verify audit chain for %s: %v", service, err)
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+				Error:     "Audit verification failed",
+				Message:   "Unable to verify audit chain for " + service,
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+
+		chain := models.AuditChainVerification{
+			Service:            result.Service,
+			Valid:              result.Valid,
+			FirstSeq:           result.FirstSeq,
+			LastSeq:            result.LastSeq,
+			EntriesChecked:     result.EntriesChecked,
+			CheckpointsChecked: result.CheckpointsChecked,
+		}
+		if link := result.FirstBrokenLink; link != nil {
+			chain.FirstBrokenLink = &models.AuditBrokenLink{
+				ChainSeq:     link.ChainSeq,
+				EventID:      link.EventID,
+				Reason:       link.Reason,
+				ExpectedHash: link.ExpectedHash,
+				ActualHash:   link.ActualHash,
+			}
+			response.Valid = false
+
+			h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+				"service":   service,
+				"chain_seq": link.ChainSeq,
+				"reason":    link.Reason,
+			}).Error("Audit chain verification failed")
+		}
+		response.Chains = append(response.Chains, chain)
+	}
+	response.VerifiedAt = time.Now().UTC()
+
+	h.logAuditEvent(c.Request.Context(), "audit.verify", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"from":     c.Query("from"),
+		"to":       c.Query("to"),
+		"services": services,
+		"valid":    response.Valid,
 	})
+
+	c.JSON(http.StatusOK, response)
 }
 
 // ClearCache godoc
-// @Summary Clear cache
-// @Description Clear Redis cache
+// @Summary Invalidate cache
+// @Description Invalidate gateway cache keys by namespace, member ID or key pattern. Keys of other services sharing the Redis are never touched. An empty body invalidates every gateway key; with tenant_id only the keys of that tenant are considered, and with dry_run the matching keys are reported but not deleted.
 // @Tags admin
 // @Security OAuth2Application
 // @Accept json
 // @Produce json
-// @Success 200 {object} map[string]string
+// @Param request body models.CacheInvalidationRequest false "Keys to invalidate"
+// @Success 200 {object} models.CacheInvalidationResponse
+// @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /api/v1/admin/cache/clear [post]
 func (h *Handler) ClearCache(c *gin.Context) {
-	if err := h.redis.FlushAll(c.Request.Context()).Err(); err != nil {
-		h.logger.Errorf("Failed to clear cache: %v", err)
+	var req models.CacheInvalidationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error:     "Invalid request",
+				Message:   err.Error(),
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+	}
+
+	if req.TenantID != "" && !tenant.Valid(req.TenantID) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid tenant",
+			Message:   "tenant_id may only contain letters, digits, '-', '_' and '.'",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	selector := cache.Selector{
+		Namespace: req.Namespace,
+		MemberID:  req.MemberID,
+		Pattern:   req.Pattern,
+	}
+
+	result, err := h.cache.ForTenant(req.TenantID).Invalidate(c.Request.Context(), selector, req.DryRun)
+	if errors.Is(err, cache.ErrInvalidSelector) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid cache selector",
+			Message:   "namespace must not contain wildcards or ':', and member_id and pattern cannot be combined",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to clear cache: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "Cache clear failed",
-			Message: "Unable to clear Redis cache",
+			Error:     "Cache clear failed",
+			Message:   "Unable to clear Redis cache",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	// Log the cache clear operation
-	h.logAuditEvent("admin.cache.clear", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
-		"action": "clear_all_cache",
+	h.logAuditEvent(c.Request.Context(), "admin.cache.clear", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"namespace":    req.Namespace,
+		"member_id":    req.MemberID,
+		"tenant_id":    req.TenantID,
+		"patterns":     result.Patterns,
+		"dry_run":      result.DryRun,
+		"matched_keys": result.Matched,
+		"deleted_keys": result.Deleted,
 	})
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Cache cleared successfully",
-		"status":  "success",
+	c.JSON(http.StatusOK, models.CacheInvalidationResponse{
+		Patterns:    result.Patterns,
+		DryRun:      result.DryRun,
+		MatchedKeys: result.Matched,
+		DeletedKeys: result.Deleted,
+		Keys:        result.Keys,
+		Truncated:   result.Truncated,
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,432 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/procstats"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	statsSampleInterval = 5 * time.Second
+	statsWindow         = time.Minute
+	dependencyTimeout   = 3 * time.Second
+)
+
+// statsSample is a point-in-time reading used to compute rates
+type statsSample struct {
+	at       time.Time
+	requests float64
+	cpu      time.Duration
+}
+
+// statsSampler keeps recent samples so that requests per second and CPU usage
+// reflect the last minute rather than the whole uptime
+type statsSampler struct {
+	mu      sync.Mutex
+	samples []statsSample
+}
+
+// Run records samples until the context is cancelled
+func (s *statsSampler) Run(ctx context.Context, requests func() float64) {
+	ticker := time.NewTicker(statsSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		s.record(requests())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *statsSampler) record(requests float64) {
+	cpu, _ := procstats.CPUTime()
+	sample := statsSample{at: time.Now(), requests: requests, cpu: cpu}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.samples = append(s.samples, sample)
+	cutoff := sample.at.Add(-statsWindow)
+	for len(s.samples) > 2 && s.samples[0].at.Before(cutoff) {
+		s.samples = s.samples[1:]
+	}
+}
+
+// rates returns requests per second and CPU usage percent (of all cores) over
+// the sampling window, measured up to the current values
+func (s *statsSampler) rates(requests float64) (float64, float64) {
+	current := statsSample{at: time.Now(), requests: requests}
+	current.cpu, _ = procstats.CPUTime()
+
+	s.mu.Lock()
+	var oldest statsSample
+	if len(s.samples) > 0 {
+		oldest = s.samples[0]
+	}
+	s.mu.Unlock()
+
+	elapsed := current.at.Sub(oldest.at).Seconds()
+	if oldest.at.IsZero() || elapsed <= 0 {
+		return 0, 0
+	}
+
+	rps := (current.requests - oldest.requests) / elapsed
+	cpu := (current.cpu - oldest.cpu).Seconds() / elapsed / float64(runtime.NumCPU()) * 100
+	return rps, cpu
+}
+
+// totalRequests sums the HTTP request counter over all label values
+func (h *Handler) totalRequests() float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return 0
+	}
+
+	var total float64
+	for _, family := range families {
+		if family.GetName() != "http_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			total += metric.GetCounter().GetValue()
+		}
+	}
+	return total
+}
+
+// GetSystemStats godoc
+// @Summary Get system statistics
+// @Description Get gateway request statistics, resource utilization and dependency status, plus a platform-wide view aggregated from downstream services
+// @Tags admin
+// @Security OAuth2Application
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.SystemStats
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/admin/stats [get]
+func (h *Handler) GetSystemStats(c *gin.Context) {
+	requestStats, err := h.requestStatistics()
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to gather request metrics: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Statistics unavailable",
+			Message:   "Unable to gather request metrics",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	stats := models.SystemStats{
+		Service:      "api-gateway",
+		Version:      "1.0.0",
+		Uptime:       time.Since(h.started).Round(time.Second).String(),
+		RequestStats: requestStats,
+		Resources:    h.resourceUtilization(float64(requestStats.TotalRequests)),
+		Dependencies: h.dependencyStatus(c.Request.Context()),
+	}
+	stats.Platform = h.platformStats(c.Request.Context(), stats.RequestStats)
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// requestStatistics derives request statistics from the HTTP collectors
+func (h *Handler) requestStatistics() (models.RequestStatistics, error) {
+	var stats models.RequestStatistics
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return stats, err
+	}
+
+	var totalErrors float64
+	var durationCount uint64
+	var durationSum float64
+	buckets := make(map[float64]uint64)
+
+	for _, family := range families {
+		switch family.GetName() {
+		case "http_requests_total":
+			for _, metric := range family.GetMetric() {
+				value := metric.GetCounter().GetValue()
+				stats.TotalRequests += int64(value)
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "status" && strings.HasPrefix(label.GetValue(), "5") {
+						totalErrors += value
+					}
+				}
+			}
+		case "http_request_duration_seconds":
+			// Merge the per-endpoint histograms; they share bucket bounds
+			for _, metric := range family.GetMetric() {
+				histogram := metric.GetHistogram()
+				durationCount += histogram.GetSampleCount()
+				durationSum += histogram.GetSampleSum()
+				for _, bucket := range histogram.GetBucket() {
+					buckets[bucket.GetUpperBound()] += bucket.GetCumulativeCount()
+				}
+			}
+		}
+	}
+
+	if stats.TotalRequests > 0 {
+		stats.ErrorRate = round(totalErrors/float64(stats.TotalRequests), 4)
+	}
+	if durationCount > 0 {
+		stats.AverageResponseTime = round(durationSum/float64(durationCount)*1000, 2)
+		stats.P50ResponseTime = round(histogramQuantile(0.50, buckets, durationCount)*1000, 2)
+		stats.P95ResponseTime = round(histogramQuantile(0.95, buckets, durationCount)*1000, 2)
+		stats.P99ResponseTime = round(histogramQuantile(0.99, buckets, durationCount)*1000, 2)
+	}
+
+	rps, _ := h.stats.rates(float64(stats.TotalRequests))
+	stats.RequestsPerSecond = round(rps, 2)
+
+	return stats, nil
+}
+
+// histogramQuantile estimates a quantile by linear interpolation within the
+// bucket that contains it, like PromQL's histogram_quantile
+func histogramQuantile(q float64, buckets map[float64]uint64, total uint64) float64 {
+	bounds := make([]float64, 0, len(buckets))
+	for bound := range buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * float64(total)
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, bound := range bounds {
+		count := float64(buckets[bound])
+		if count >= rank {
+			if math.IsInf(bound, 1) || count == lowerCount {
+				return lowerBound
+			}
+			return lowerBound + (bound-lowerBound)*(rank-lowerCount)/(count-lowerCount)
+		}
+		lowerBound, lowerCount = bound, count
+	}
+
+	// Observations above the highest bucket are reported at that bound
+	return lowerBound
+}
+
+// resourceUtilization reads process CPU and memory from /proc and the runtime
+func (h *Handler) resourceUtilization(requests float64) models.ResourceUtilization {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	resources := models.ResourceUtilization{
+		HeapAllocBytes: memStats.HeapAlloc,
+		Goroutines:     runtime.NumGoroutine(),
+		GCCycles:       memStats.NumGC,
+	}
+
+	_, cpu := h.stats.rates(requests)
+	resources.CPUUsage = round(cpu, 2)
+
+	if rss, err := procstats.RSS(); err == nil {
+		resources.MemoryRSSBytes = rss
+		if limit, err := procstats.MemoryLimit(); err == nil && limit > 0 {
+			resources.MemoryUsage = round(float64(rss)/float64(limit)*100, 2)
+		}
+	}
+
+	if disk, err := procstats.DiskUsage("/"); err == nil {
+		resources.DiskUsage = round(disk, 2)
+	}
+
+	return resources
+}
+
+// dependencyStatus checks infrastructure and upstream services concurrently
+func (h *Handler) dependencyStatus(ctx context.Context) models.DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+
+	status := models.DependencyStatus{Services: make(map[string]bool)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	check := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	check(func() {
+		ok := h.db.PingContext(ctx) == nil
+		mu.Lock()
+		status.Database = ok
+		mu.Unlock()
+	})
+	check(func() {
+		ok := h.redis.Ping(ctx).Err() == nil
+		mu.Lock()
+		status.Redis = ok
+		mu.Unlock()
+	})
+	check(func() {
+		ok := h.kafka.Ping(ctx) == nil
+		mu.Lock()
+		status.Kafka = ok
+		mu.Unlock()
+	})
+	for name, baseURL := range h.upstreams() {
+		name, baseURL := name, baseURL
+		check(func() {
+			ok := h.checkUpstream(ctx, baseURL) == nil
+			mu.Lock()
+			status.Services[name] = ok
+			mu.Unlock()
+		})
+	}
+
+	wg.Wait()
+	return status
+}
+
+// upstreams returns the base URLs of the services behind the gateway
+func (h *Handler) upstreams() map[string]string {
+	return map[string]string{
+		"eligibility-service": h.config.Services.EligibilityURL,
+		"claims-service":      h.config.Services.ClaimsURL,
+		"terminology-service": h.config.Services.TerminologyURL,
+		"wallet-service":      h.config.Services.WalletURL,
+	}
+}
+
+func (h *Handler) checkUpstream(ctx context.Context, baseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// downstreamStats is the subset of a service's /admin/stats response that is
+// aggregated; the full response is passed through as-is
+type downstreamStats struct {
+	RequestStats struct {
+		TotalRequests     int64   `json:"total_requests"`
+		RequestsPerSecond float64 `json:"requests_per_second"`
+		ErrorRate         float64 `json:"error_rate"`
+	} `json:"request_stats"`
+}
+
+// platformStats fetches /api/v1/admin/stats from every upstream and combines
+// them with the gateway's own request statistics
+func (h *Handler) platformStats(ctx context.Context, gateway models.RequestStatistics) *models.PlatformStats {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+
+	type result struct {
+		name  string
+		raw   json.RawMessage
+		stats downstreamStats
+		err   error
+	}
+
+	upstreams := h.upstreams()
+	results := make(chan result, len(upstreams))
+	for name, baseURL := range upstreams {
+		go func(name, baseURL string) {
+			raw, err := h.fetchDownstreamStats(ctx, baseURL)
+			r := result{name: name, raw: raw, err: err}
+			if err == nil {
+				r.err = json.Unmarshal(raw, &r.stats)
+			}
+			results <- r
+		}(name, baseURL)
+	}
+
+	platform := &models.PlatformStats{
+		TotalRequests:     gateway.TotalRequests,
+		RequestsPerSecond: gateway.RequestsPerSecond,
+		ServicesReporting: []string{"api-gateway"},
+		Services:          make(map[string]json.RawMessage),
+	}
+	errors := gateway.ErrorRate * float64(gateway.TotalRequests)
+
+	for range upstreams {
+		r := <-results
+		if r.err != nil {
+			h.logger.WithContext(ctx).WithError(r.err).Debugf("Statistics unavailable from %s", r.name)
+			platform.ServicesUnavailable = append(platform.ServicesUnavailable, r.name)
+			continue
+		}
+
+		platform.ServicesReporting = append(platform.ServicesReporting, r.name)
+		platform.Services[r.name] = r.raw
+		platform.TotalRequests += r.stats.RequestStats.TotalRequests
+		platform.RequestsPerSecond += r.stats.RequestStats.RequestsPerSecond
+		errors += r.stats.RequestStats.ErrorRate * float64(r.stats.RequestStats.TotalRequests)
+	}
+
+	sort.Strings(platform.ServicesReporting)
+	sort.Strings(platform.ServicesUnavailable)
+	platform.RequestsPerSecond = round(platform.RequestsPerSecond, 2)
+	if platform.TotalRequests > 0 {
+		platform.ErrorRate = round(errors/float64(platform.TotalRequests), 4)
+	}
+
+	return platform
+}
+
+func (h *Handler) fetchDownstreamStats(ctx context.Context, baseURL string) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/v1/admin/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stats endpoint returned status %d", resp.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/models"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/subscription"
+	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// FHIR Subscription endpoints
+
+const (
+	// subscriptionChannelSystem is the code system of Subscription.channelType
+	subscriptionChannelSystem = "http://terminology.hl7.org/CodeSystem/subscription-channel-type"
+
+	// subscriptionSecretExtension carries the signing secret in the response
+	// to a create; it is never returned again
+	subscriptionSecretExtension = "http://nphies.sa/fhir/StructureDefinition/subscription-signing-secret"
+
+	// subscriptionErrorLimit bounds the delivery errors in a status bundle
+	subscriptionErrorLimit = 20
+)
+
+// CreateSubscription godoc
+// @Summary Create a subscription
+// @Description Subscribe the caller's organization to a topic with REST-hook delivery. Notifications are posted to the endpoint as subscription-notification bundles signed with the secret returned in the subscription-signing-secret extension, which is only shown once. The subscription becomes active once its handshake is delivered.
+// @Tags fhir
+// @Security OAuth2Application
+// @Accept json
+// @Produce json
+// @Param subscription body fhir.Subscription true "Subscription resource"
+// @Success 201 {object} fhir.Subscription
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/fhir/Subscription [post]
+func (h *Handler) CreateSubscription(c *gin.Context) {
+	organizationID, ok := h.subscriptionOwner(c)
+	if !ok {
+		return
+	}
+
+	var resource fhir.Subscription
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid subscription data",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	newSubscription, err := h.newSubscription(resource)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error:     "Invalid subscription",
+			Message:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	newSubscription.OrganizationID = organizationID
+	newSubscription.TenantID = c.GetString("tenantID")
+	newSubscription.CreatedBy = c.GetString("userID")
+
+	ctx := c.Request.Context()
+	count, err := h.subscriptions.CountActive(ctx, organizationID)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to count subscriptions: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Subscription failed",
+			Message:   "Unable to create subscription",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if count >= h.config.Subscriptions.MaxPerOrganization {
+		c.JSON(http.StatusTooManyRequests, models.ErrorResponse{
+			Error:     "Too many subscriptions",
+			Message:   fmt.Sprintf("An organization may have at most %d subscriptions; delete unused ones first", h.config.Subscriptions.MaxPerOrganization),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	created, err := h.subscriptions.Create(ctx, newSubscription)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to create subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Subscription failed",
+			Message:   "Unable to create subscription",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	h.logger.WithContext(ctx).WithFields(logrus.Fields{
+		"subscription_id": created.ID,
+		"topic":           created.Topic,
+	}).Info("Subscription created")
+
+	h.logAuditEvent(ctx, "subscription.created", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"subscriptionID": created.ID,
+		"organizationID": organizationID,
+		"topic":          created.Topic,
+		"endpoint":       created.Endpoint,
+		"content":        created.Content,
+	})
+
+	response := subscriptionResource(created)
+	response.Extension = append(response.Extension, fhir.Extension{
+		URL:         subscriptionSecretExtension,
+		ValueString: created.Secret,
+	})
+	c.Header("Location", "Subscription/"+created.ID)
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListSubscriptions godoc
+// @Summary List subscriptions
+// @Description List the subscriptions of the caller's organization
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Success 200 {object} fhir.Bundle
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/fhir/Subscription [get]
+func (h *Handler) ListSubscriptions(c *gin.Context) {
+	organizationID, ok := h.subscriptionOwner(c)
+	if !ok {
+		return
+	}
+
+	subscriptions, err := h.subscriptions.List(c.Request.Context(), organizationID)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to list subscriptions: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Subscription lookup failed",
+			Message:   "Unable to list subscriptions",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	bundle := fhir.Bundle{
+		ResourceType: "Bundle",
+		ID:           uuid.New().String(),
+		Type:         "searchset",
+		Total:        len(subscriptions),
+		Link: []fhir.BundleLink{
+			{
+				Relation: "self",
+				URL:      c.Request.URL.String(),
+			},
+		},
+		Entry: make([]fhir.BundleEntry, 0, len(subscriptions)),
+	}
+	for i := range subscriptions {
+		bundle.Entry = append(bundle.Entry, fhir.BundleEntry{
+			Resource: subscriptionResource(&subscriptions[i]),
+			Search:   &fhir.BundleEntrySearch{Mode: "match"},
+		})
+	}
+
+	c.JSON(http.StatusOK, bundle)
+}
+
+// GetSubscription godoc
+// @Summary Get a subscription
+// @Description Get a subscription of the caller's organization
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} fhir.Subscription
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/fhir/Subscription/{id} [get]
+func (h *Handler) GetSubscription(c *gin.Context) {
+	found, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, subscriptionResource(found))
+}
+
+// DeleteSubscription godoc
+// @Summary Delete a subscription
+// @Description Turn a subscription off. Notifications still queued for it are not delivered.
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} fhir.Subscription
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/fhir/Subscription/{id} [delete]
+func (h *Handler) DeleteSubscription(c *gin.Context) {
+	organizationID, ok := h.subscriptionOwner(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	deactivated, err := h.subscriptions.Deactivate(ctx, c.Param("id"), organizationID)
+	if errors.Is(err, subscription.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "Subscription not found",
+			Message:   "No subscription with ID " + c.Param("id"),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to delete subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Subscription failed",
+			Message:   "Unable to delete subscription",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	h.logAuditEvent(ctx, "subscription.deleted", c.GetString("userID"), c.ClientIP(), map[string]interface{}{
+		"subscriptionID": deactivated.ID,
+		"organizationID": organizationID,
+		"topic":          deactivated.Topic,
+	})
+
+	c.JSON(http.StatusOK, subscriptionResource(deactivated))
+}
+
+// GetSubscriptionStatus godoc
+// @Summary Get subscription status
+// @Description Get the status of a subscription with its recent delivery errors, as a bundle holding a query-status SubscriptionStatus
+// @Tags fhir
+// @Security OAuth2Application
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} fhir.Bundle
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /api/v1/fhir/Subscription/{id}/$status [get]
+func (h *Handler) GetSubscriptionStatus(c *gin.Context) {
+	found, ok := h.findSubscription(c)
+	if !ok {
+		return
+	}
+
+	deliveryErrors, err := h.subscriptions.DeliveryErrors(c.Request.Context(), found.ID, subscriptionErrorLimit)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to read subscription delivery errors: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Subscription lookup failed",
+			Message:   "Unable to read subscription status",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	status := fhir.SubscriptionStatus{
+		ResourceType:                 "SubscriptionStatus",
+		Status:                       found.Status,
+		Type:                         "query-status",
+		EventsSinceSubscriptionStart: strconv.FormatInt(found.EventsSinceStart, 10),
+		Subscription:                 fhir.Reference{Reference: "Subscription/" + found.ID},
+		Topic:                        found.Topic,
+	}
+	for _, deliveryError := range deliveryErrors {
+		text := fmt.Sprintf("%s %s attempt %d (%s): %s",
+			deliveryError.At.UTC().Format(time.RFC3339), deliveryError.Type, deliveryError.Attempts, deliveryError.Status, deliveryError.Error)
+		if deliveryError.EventNumber > 0 {
+			text = fmt.Sprintf("%s [event %d]", text, deliveryError.EventNumber)
+		}
+		concept := fhir.CodeableConcept{Text: text}
+		if deliveryError.StatusCode != 0 {
+			concept.Coding = []fhir.Coding{{
+				System: "http://nphies.sa/fhir/CodeSystem/http-status",
+				Code:   strconv.Itoa(deliveryError.StatusCode),
+			}}
+		}
+		status.Error = append(status.Error, concept)
+	}
+
+	c.JSON(http.StatusOK, fhir.Bundle{
+		ResourceType: "Bundle",
+		ID:           uuid.New().String(),
+		Type:         "searchset",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		Total:        1,
+		Entry: []fhir.BundleEntry{{
+			Resource: status,
+			Search:   &fhir.BundleEntrySearch{Mode: "match"},
+		}},
+	})
+}
+
+// subscriptionOwner returns the caller's organization, which owns its
+// subscriptions and receives the events addressed to it
+func (h *Handler) subscriptionOwner(c *gin.Context) (string, bool) {
+	organizationID := c.GetString("organizationIdentifier")
+	if organizationID == "" {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Error:     "Organization required",
+			Message:   "Subscriptions are managed by provider organizations; the credentials carry no organization",
+			RequestID: requestid.Get(c),
+		})
+		return "", false
+	}
+	return organizationID, true
+}
+
+// findSubscription loads the subscription in the path, answering 404 when it
+// does not exist or belongs to another organization
+func (h *Handler) findSubscription(c *gin.Context) (*subscription.Subscription, bool) {
+	organizationID, ok := h.subscriptionOwner(c)
+	if !ok {
+		return nil, false
+	}
+
+	found, err := h.subscriptions.Get(c.Request.Context(), c.Param("id"), organizationID)
+	if errors.Is(err, subscription.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Error:     "Subscription not found",
+			Message:   "No subscription with ID " + c.Param("id"),
+			RequestID: requestid.Get(c),
+		})
+		return nil, false
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to read subscription: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error:     "Subscription lookup failed",
+			Message:   "Unable to read subscription",
+			RequestID: requestid.Get(c),
+		})
+		return nil, false
+	}
+	return found, true
+}
+
+// newSubscription validates a Subscription resource against the supported
+// topics and the delivery limits
+func (h *Handler) newSubscription(resource fhir.Subscription) (subscription.NewSubscription, error) {
+	limits := h.config.Subscriptions
+	var newSubscription subscription.NewSubscription
+
+	topic, ok := subscription.LookupTopic(resource.Topic)
+	if !ok {
+		return newSubscription, fmt.Errorf("unknown topic %q", resource.Topic)
+	}
+	if resource.ChannelType.Code != "rest-hook" {
+		return newSubscription, errors.New("channelType must be rest-hook")
+	}
+	if resource.ContentType != "" && resource.ContentType != "application/fhir+json" {
+		return newSubscription, errors.New("contentType must be application/fhir+json")
+	}
+	if err := subscription.ValidateEndpoint(resource.Endpoint, limits.AllowHTTPEndpoints); err != nil {
+		return newSubscription, err
+	}
+
+	var filters []subscription.Filter
+	for _, filterBy := range resource.FilterBy {
+		if filterBy.ResourceType != "" && filterBy.ResourceType != topic.ResourceType {
+			return newSubscription, fmt.Errorf("filterBy.resourceType must be %s", topic.ResourceType)
+		}
+		if (filterBy.Comparator != "" && filterBy.Comparator != "eq") || filterBy.Modifier != "" {
+			return newSubscription, errors.New("filterBy supports equality only")
+		}
+		filters = append(filters, subscription.Filter{Parameter: filterBy.FilterParameter, Value: filterBy.Value})
+	}
+	if err := topic.ValidateFilters(filters); err != nil {
+		return newSubscription, err
+	}
+
+	content := resource.Content
+	switch content {
+	case "":
+		content = subscription.ContentIDOnly
+	case subscription.ContentEmpty, subscription.ContentIDOnly, subscription.ContentFullResource:
+	default:
+		return newSubscription, errors.New("content must be empty, id-only or full-resource")
+	}
+
+	timeout := resource.Timeout
+	if timeout == 0 {
+		timeout = limits.DefaultTimeout
+	}
+	if timeout < 1 || timeout > limits.MaxTimeout {
+		return newSubscription, fmt.Errorf("timeout must be between 1 and %d seconds", limits.MaxTimeout)
+	}
+
+	if resource.HeartbeatPeriod != 0 && resource.HeartbeatPeriod < limits.MinHeartbeatPeriod {
+		return newSubscription, fmt.Errorf("heartbeatPeriod must be at least %d seconds", limits.MinHeartbeatPeriod)
+	}
+
+	var end *time.Time
+	if resource.End != "" {
+		parsed, err := time.Parse(time.RFC3339, resource.End)
+		if err != nil {
+			return newSubscription, errors.New("end must be an instant, e.g. 2025-01-31T00:00:00Z")
+		}
+		if !parsed.After(time.Now()) {
+			return newSubscription, errors.New("end must be in the future")
+		}
+		end = &parsed
+	}
+
+	return subscription.NewSubscription{
+		Topic:           topic,
+		Filters:         filters,
+		Endpoint:        resource.Endpoint,
+		Content:         content,
+		HeartbeatPeriod: resource.HeartbeatPeriod,
+		Timeout:         timeout,
+		Reason:          resource.Reason,
+		End:             end,
+	}, nil
+}
+
+// subscriptionResource renders a stored subscription as a FHIR Subscription.
+// The signing secret is left out.
+func subscriptionResource(s *subscription.Subscription) fhir.Subscription {
+	resource := fhir.Subscription{
+		ResourceType: "Subscription",
+		ID:           s.ID,
+		Meta: &fhir.Meta{
+			LastUpdated: s.UpdatedAt.UTC().Format(time.RFC3339),
+		},
+		Status:          s.Status,
+		Topic:           s.Topic,
+		Reason:          s.Reason,
+		ManagingEntity:  &fhir.Reference{Reference: "Organization/" + s.OrganizationID},
+		ChannelType:     fhir.Coding{System: subscriptionChannelSystem, Code: "rest-hook"},
+		Endpoint:        s.Endpoint,
+		HeartbeatPeriod: s.HeartbeatPeriod,
+		Timeout:         s.Timeout,
+		ContentType:     "application/fhir+json",
+		Content:         s.Content,
+	}
+	if s.End != nil {
+		resource.End = s.End.UTC().Format(time.RFC3339)
+	}
+	for _, filter := range s.Filters {
+		resource.FilterBy = append(resource.FilterBy, fhir.SubscriptionFilterBy{
+			FilterParameter: filter.Parameter,
+			Value:           filter.Value,
+		})
+	}
+	return resource
+}
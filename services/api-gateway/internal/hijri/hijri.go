@@ -0,0 +1,221 @@
+// Package hijri converts dates between the Gregorian calendar and Umm
+// al-Qura, the official Hijri calendar of Saudi Arabia. Month lengths come
+// from a table of the years 1300 to 1600 AH (1882 to 2174), generated from
+// the islamic-umalqura calendar of ICU; dates outside it are rejected rather
+// than estimated. Dates are written YYYY-MM-DD in both calendars.
+package hijri
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// Calendar is the CLDR identifier of the Umm al-Qura calendar
+	Calendar = "islamic-umalqura"
+
+	// CalendarExtension tags a FHIR date as written in the calendar of its
+	// valueCode, e.g. Calendar
+	CalendarExtension = "http://nphies.sa/fhir/StructureDefinition/date-calendar"
+
+	// DateExtension carries the Hijri date of a Gregorian FHIR date in its
+	// valueString
+	DateExtension = "http://nphies.sa/fhir/StructureDefinition/hijri-date"
+)
+
+// Layout is the layout of dates in both calendars
+const Layout = "2006-01-02"
+
+// ErrOutOfRange is returned for dates outside the Umm al-Qura table
+var ErrOutOfRange = errors.New("date outside the Umm al-Qura table (1300-1600 AH)")
+
+// firstYear is the Hijri year of the first entry of monthLengths, which
+// starts on the Gregorian date epoch
+const firstYear = 1300
+
+var epoch = time.Date(1882, time.November, 12, 0, 0, 0, 0, time.UTC)
+
+// monthLengths holds a bit per month of each year from firstYear, month 1
+// in the lowest bit: set for 30 days, clear for 29
+var monthLengths = [...]uint16{
+	0x555, 0x2ab, 0x937, 0x2b6, 0x576, 0x36c, 0xb55, 0xaaa, 0x956, 0x49e, // 1300-1309
+	0x95d, 0x2ba, 0x5b5, 0x3aa, 0xb4b, 0xa96, 0x52e, 0x2ad, 0x56d, 0xb5a, // 1310-1319
+	0x752, 0xf25, 0xe8a, 0xd16, 0xa56, 0xab5, 0x6b4, 0xda9, 0xb92, 0xb25, // 1320-1329
+	0x64b, 0xa9b, 0x35a, 0x6d9, 0x5d4, 0xda5, 0xd4a, 0xa95, 0x536, 0x975, // 1330-1339
+	0x2f4, 0x6e9, 0x6d4, 0x6a9, 0x535, 0x25d, 0x4bd, 0x9ba, 0x3b4, 0xb69, // 1340-1349
+	0xb2a, 0xa55, 0x4ad, 0xa5d, 0x2da, 0x6d9, 0xeaa, 0xe94, 0xd2a, 0xc56, // 1350-1359
+	0x4ae, 0xa6d, 0x56a, 0xd55, 0xd4a, 0xa93, 0x52b, 0xa5b, 0x53a, 0x6b5, // 1360-1369
+	0xea9, 0xd52, 0xd29, 0xa55, 0x4ad, 0x56d, 0xaea, 0x6e4, 0xed1, 0xda2, // 1370-1379
+	0xaaa, 0x95a, 0x2da, 0x5b9, 0xbb2, 0x764, 0x6c9, 0x555, 0x2ab, 0x4db, // 1380-1389
+	0xaba, 0x5b4, 0xda9, 0xd52, 0xaa5, 0x92d, 0x26d, 0x8ed, 0x2da, 0xad5, // 1390-1399
+	0xaa5, 0xa4b, 0x497, 0x937, 0x2b6, 0x975, 0xd69, 0xd52, 0xc95, 0x92b, // 1400-1409
+	0x25b, 0x4db, 0x9d5, 0x5d2, 0xda5, 0xd4a, 0xa95, 0x54d, 0xaad, 0x3aa, // 1410-1419
+	0xbd2, 0xbc4, 0xb89, 0xa95, 0x52d, 0x5ad, 0xb6a, 0x6d4, 0xdc9, 0xd92, // 1420-1429
+	0xaa6, 0x956, 0x2ae, 0x56d, 0x36a, 0xb55, 0xaaa, 0x94d, 0x49d, 0x95d, // 1430-1439
+	0x2ba, 0x5b5, 0x5aa, 0xd55, 0xa9a, 0x92e, 0x26e, 0x55d, 0xada, 0x6d4, // 1440-1449
+	0x6a5, 0xb27, 0xa4d, 0x4ad, 0x56d, 0xb5a, 0x754, 0xf49, 0xe92, 0xd26, // 1450-1459
+	0xa56, 0x356, 0x6b5, 0xbaa, 0xb92, 0xb25, 0x68b, 0xa9b, 0x55a, 0xada, // 1460-1469
+	0x5b4, 0xda9, 0xb52, 0xa9a, 0x536, 0x276, 0x575, 0xaf2, 0x6d4, 0x6a9, // 1470-1479
+	0x555, 0x2ad, 0x4bd, 0x9ba, 0x574, 0xb69, 0xb52, 0xa95, 0x52d, 0xa5d, // 1480-1489
+	0x4da, 0xad9, 0x6b2, 0xe95, 0xe2a, 0xc96, 0x92e, 0xaad, 0x56a, 0xd65, // 1490-1499
+	0xd4a, 0xd15, 0x62b, 0xc5b, 0x53a, 0x6b5, 0xdb2, 0xd64, 0xd29, 0xa55, // 1500-1509
+	0x4ad, 0x96d, 0xaea, 0x6e8, 0xed1, 0xda4, 0xd4a, 0xa6a, 0x2da, 0x5b9, // 1510-1519
+	0xb72, 0xb68, 0x6d1, 0x655, 0x4ab, 0x95b, 0x2ba, 0x5b5, 0xda9, 0xd52, // 1520-1529
+	0xca6, 0x94e, 0x46e, 0x95d, 0x4da, 0xad5, 0xaaa, 0xa4d, 0x49b, 0x937, // 1530-1539
+	0x4b6, 0x975, 0xd6a, 0xd52, 0xaa5, 0x94b, 0x2ab, 0x55b, 0xad9, 0x5d2, // 1540-1549
+	0xdc5, 0xd92, 0xb25, 0x555, 0xab5, 0x5b4, 0xba9, 0x7a2, 0x745, 0x593, // 1550-1559
+	0xaab, 0x4d6, 0x9d6, 0x5d2, 0xba5, 0xb4a, 0xa95, 0x4ad, 0x15d, 0x2dd, // 1560-1569
+	0x9da, 0x5b4, 0x5a9, 0x52d, 0x25b, 0x8b7, 0x176, 0x56d, 0xb6a, 0xaca, // 1570-1579
+	0xa96, 0x52b, 0x15b, 0x2bb, 0x5b6, 0xdaa, 0xb94, 0xd46, 0xa8d, 0x52d, // 1580-1589
+	0xa9d, 0x55a, 0x755, 0x749, 0xf13, 0xe4a, 0xa96, 0x556, 0x6b5, 0xbaa, // 1590-1599
+	0xb94, // 1600-1600
+}
+
+// yearStarts holds the days from epoch to the first day of each year, and
+// to the day after the last year
+var yearStarts = func() []int {
+	starts := make([]int, len(monthLengths)+1)
+	for i, lengths := range monthLengths {
+		days := 0
+		for month := 0; month < 12; month++ {
+			days += 29 + int(lengths>>month&1)
+		}
+		starts[i+1] = starts[i] + days
+	}
+	return starts
+}()
+
+// Date is a date of the Umm al-Qura calendar
+type Date struct {
+	Year  int
+	Month int
+	Day   int
+}
+
+// Parse parses and validates a Hijri date written YYYY-MM-DD
+func Parse(value string) (Date, error) {
+	var d Date
+	if len(value) != len(Layout) {
+		return d, fmt.Errorf("invalid Hijri date %q: expected YYYY-MM-DD", value)
+	}
+	// Every character but the dashes must be a digit, as signs and spaces
+	// would otherwise be read as part of the numbers
+	for i := 0; i < len(value); i++ {
+		if i == 4 || i == 7 {
+			if value[i] != '-' {
+				return d, fmt.Errorf("invalid Hijri date %q: expected YYYY-MM-DD", value)
+			}
+		} else if value[i] < '0' || value[i] > '9' {
+			return d, fmt.Errorf("invalid Hijri date %q: expected YYYY-MM-DD", value)
+		}
+	}
+	d.Year, _ = strconv.Atoi(value[0:4])
+	d.Month, _ = strconv.Atoi(value[5:7])
+	d.Day, _ = strconv.Atoi(value[8:10])
+	if err := d.Validate(); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// Validate rejects dates that do not exist, such as the 30th of a month of
+// 29 days
+func (d Date) Validate() error {
+	days, err := DaysInMonth(d.Year, d.Month)
+	if err != nil {
+		return err
+	}
+	if d.Day < 1 || d.Day > days {
+		return fmt.Errorf("invalid Hijri date %s: month %d of %d has %d days", d, d.Month, d.Year, days)
+	}
+	return nil
+}
+
+// String formats the date as YYYY-MM-DD
+func (d Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// Gregorian returns the Gregorian date of the day, at midnight UTC
+func (d Date) Gregorian() (time.Time, error) {
+	if err := d.Validate(); err != nil {
+		return time.Time{}, err
+	}
+	days := yearStarts[d.Year-firstYear]
+	lengths := monthLengths[d.Year-firstYear]
+	for month := 0; month < d.Month-1; month++ {
+		days += 29 + int(lengths>>month&1)
+	}
+	return epoch.AddDate(0, 0, days+d.Day-1), nil
+}
+
+// FromGregorian returns the Hijri date of the day of t
+func FromGregorian(t time.Time) (Date, error) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(day.Sub(epoch).Hours() / 24)
+	if days < 0 || days >= yearStarts[len(yearStarts)-1] {
+		return Date{}, ErrOutOfRange
+	}
+
+	// The year is the last one starting on or before the day
+	i := sort.Search(len(monthLengths), func(i int) bool { return yearStarts[i+1] > days })
+	d := Date{Year: firstYear + i, Month: 1}
+	days -= yearStarts[i]
+	for month := 0; month < 12; month++ {
+		length := 29 + int(monthLengths[i]>>month&1)
+		if days < length {
+			break
+		}
+		days -= length
+		d.Month++
+	}
+	d.Day = days + 1
+	return d, nil
+}
+
+// DaysInMonth returns the number of days of a Hijri month, 29 or 30
+func DaysInMonth(year, month int) (int, error) {
+	if year < firstYear || year >= firstYear+len(monthLengths) {
+		return 0, ErrOutOfRange
+	}
+	if month < 1 || month > 12 {
+		return 0, fmt.Errorf("invalid Hijri month %d", month)
+	}
+	return 29 + int(monthLengths[year-firstYear]>>(month-1)&1), nil
+}
+
+// ToGregorian converts a Hijri date to a Gregorian one, both YYYY-MM-DD
+func ToGregorian(value string) (string, error) {
+	d, err := Parse(value)
+	if err != nil {
+		return "", err
+	}
+	t, err := d.Gregorian()
+	if err != nil {
+		return "", err
+	}
+	return t.Format(Layout), nil
+}
+
+// ToHijri converts a Gregorian date to a Hijri one, both YYYY-MM-DD.
+// Impossible Gregorian dates such as 2023-02-29 are rejected.
+func ToHijri(value string) (string, error) {
+	t, err := time.Parse(Layout, value)
+	if err != nil {
+		return "", fmt.Errorf("invalid Gregorian date %q: %w", value, err)
+	}
+	d, err := FromGregorian(t)
+	if err != nil {
+		return "", err
+	}
+	return d.String(), nil
+}
+
+// IsHijri reports whether a calendar name of a request means Umm al-Qura:
+// its CLDR identifier or "hijri"
+func IsHijri(calendar string) bool {
+	return calendar == Calendar || calendar == "hijri"
+}
@@ -0,0 +1,130 @@
+package hijri
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestToGregorian(t *testing.T) {
+	tests := []struct {
+		hijri     string
+		gregorian string
+	}{
+		// First day of the table
+		{"1300-01-01", "1882-11-12"},
+		{"1400-01-01", "1979-11-21"},
+		{"1440-01-01", "2018-09-11"},
+		{"1444-09-01", "2023-03-23"},
+		{"1444-10-01", "2023-04-21"},
+		{"1445-09-01", "2024-03-11"},
+		{"1445-10-01", "2024-04-10"},
+		{"1445-12-10", "2024-06-16"},
+		{"1446-01-01", "2024-07-07"},
+	}
+	for _, tt := range tests {
+		got, err := ToGregorian(tt.hijri)
+		if err != nil {
+			t.Errorf("ToGregorian(%q) failed: %v", tt.hijri, err)
+			continue
+		}
+		if got != tt.gregorian {
+			t.Errorf("ToGregorian(%q) = %q, want %q", tt.hijri, got, tt.gregorian)
+		}
+		if back, err := ToHijri(tt.gregorian); err != nil || back != tt.hijri {
+			t.Errorf("ToHijri(%q) = %q, %v, want %q", tt.gregorian, back, err, tt.hijri)
+		}
+	}
+}
+
+func TestTableEdges(t *testing.T) {
+	if _, err := ToHijri("1882-11-11"); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("ToHijri of the day before the table: got %v, want ErrOutOfRange", err)
+	}
+
+	lastDays, err := DaysInMonth(1600, 12)
+	if err != nil {
+		t.Fatalf("DaysInMonth(1600, 12) failed: %v", err)
+	}
+	last := Date{Year: 1600, Month: 12, Day: lastDays}
+	lastDay, err := last.Gregorian()
+	if err != nil {
+		t.Fatalf("Gregorian of the last day of the table failed: %v", err)
+	}
+	if d, err := FromGregorian(lastDay); err != nil || d != last {
+		t.Errorf("FromGregorian(%s) = %v, %v, want %v", lastDay.Format(Layout), d, err, last)
+	}
+	if _, err := FromGregorian(lastDay.AddDate(0, 0, 1)); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("FromGregorian of the day after the table: got %v, want ErrOutOfRange", err)
+	}
+
+	for _, value := range []string{"1299-12-29", "1601-01-01"} {
+		if _, err := ToGregorian(value); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("ToGregorian(%q): got %v, want ErrOutOfRange", value, err)
+		}
+	}
+}
+
+func TestMonthsFollowEachOther(t *testing.T) {
+	// The day after the last day of every month is the first of the next
+	for year := firstYear; year < firstYear+len(monthLengths); year++ {
+		for month := 1; month <= 12; month++ {
+			days, _ := DaysInMonth(year, month)
+			end, err := Date{Year: year, Month: month, Day: days}.Gregorian()
+			if err != nil {
+				t.Fatalf("Gregorian of %d-%02d-%02d failed: %v", year, month, days, err)
+			}
+			next, err := FromGregorian(end.AddDate(0, 0, 1))
+			if year == firstYear+len(monthLengths)-1 && month == 12 {
+				continue
+			}
+			want := Date{Year: year, Month: month + 1, Day: 1}
+			if month == 12 {
+				want = Date{Year: year + 1, Month: 1, Day: 1}
+			}
+			if err != nil || next != want {
+				t.Fatalf("day after %d-%02d-%02d = %v, %v, want %v", year, month, days, next, err, want)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Date
+		ok    bool
+	}{
+		{"1445-09-01", Date{1445, 9, 1}, true},
+		{"1445-09-30", Date{1445, 9, 30}, true},
+		{"1445-1-01", Date{}, false},
+		{"1445-+1-01", Date{}, false},
+		{"1445-09-+1", Date{}, false},
+		{"+445-09-01", Date{}, false},
+		{"1445- 9-01", Date{}, false},
+		{"1445/09/01", Date{}, false},
+		{"1445-13-01", Date{}, false},
+		{"1445-00-01", Date{}, false},
+		{"1445-09-00", Date{}, false},
+		// Ramadan 1446 has 29 days
+		{"1446-09-30", Date{}, false},
+		{"", Date{}, false},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("Parse(%q) error = %v, want ok = %v", tt.value, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("Parse(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestToHijriRejectsImpossibleDates(t *testing.T) {
+	for _, value := range []string{"2023-02-29", "2024-13-01", "2024-3-11", ""} {
+		if _, err := ToHijri(value); err == nil {
+			t.Errorf("ToHijri(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -2,48 +2,244 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 )
 
-// Producer handles Kafka message publishing
+// TopicConfig holds per-topic delivery settings
+type TopicConfig struct {
+	RequiredAcks string // none, one, all
+	Compression  string // none, gzip, snappy, lz4, zstd
+}
+
+// ParseTopicOverrides parses per-topic settings in the form
+// "topic=acks:compression,topic=acks:compression". Either value may be left
+// empty to inherit the default.
+func ParseTopicOverrides(value string) (map[string]TopicConfig, error) {
+	overrides := make(map[string]TopicConfig)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		topic, settings, ok := strings.Cut(entry, "=")
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("invalid topic override %q", entry)
+		}
+
+		acks, compression, _ := strings.Cut(settings, ":")
+		overrides[strings.TrimSpace(topic)] = TopicConfig{
+			RequiredAcks: strings.TrimSpace(acks),
+			Compression:  strings.TrimSpace(compression),
+		}
+	}
+	return overrides, nil
+}
+
+// ProducerConfig holds broker, security and delivery settings for the producer
+type ProducerConfig struct {
+	Brokers      []string
+	Security     SecurityConfig
+	Defaults     TopicConfig
+	Topics       map[string]TopicConfig
+	BatchSize    int
+	BatchTimeout time.Duration
+	WriteTimeout time.Duration
+}
+
+// DeliveryCallback is called once an asynchronously published message has
+// been acknowledged by the brokers or has failed
+type DeliveryCallback func(msg kafka.Message, err error)
+
+// ProducerMetrics holds Prometheus collectors for publishing
+type ProducerMetrics struct {
+	PublishDuration *prometheus.HistogramVec
+	PublishedTotal  *prometheus.CounterVec
+	FailuresTotal   *prometheus.CounterVec
+}
+
+// Producer handles Kafka message publishing. It is safe for concurrent use.
 type Producer struct {
-	writers map[string]*kafka.Writer
-	logger  *logrus.Logger
+	config       ProducerConfig
+	transport    *kafka.Transport
+	mu           sync.Mutex
+	writers      map[string]*kafka.Writer
+	asyncWriters map[string]*kafka.Writer
+	metrics      *ProducerMetrics
+	logger       *logrus.Logger
 }
 
 // NewProducer creates a new Kafka producer
-func NewProducer(brokers []string, logger *logrus.Logger) (*Producer, error) {
+func NewProducer(cfg ProducerConfig, logger *logrus.Logger) (*Producer, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("at least one Kafka broker is required")
+	}
+
+	// Validate delivery settings up front so misconfiguration fails at startup
+	if _, err := parseRequiredAcks(cfg.Defaults.RequiredAcks); err != nil {
+		return nil, err
+	}
+	if _, err := parseCompression(cfg.Defaults.Compression); err != nil {
+		return nil, err
+	}
+	for topic, topicCfg := range cfg.Topics {
+		if _, err := parseRequiredAcks(topicCfg.RequiredAcks); err != nil {
+			return nil, fmt.Errorf("topic %s: %w", topic, err)
+		}
+		if _, err := parseCompression(topicCfg.Compression); err != nil {
+			return nil, fmt.Errorf("topic %s: %w", topic, err)
+		}
+	}
+
+	transport, err := cfg.Security.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+
+	metrics := &ProducerMetrics{
+		PublishDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "kafka_publish_duration_seconds",
+				Help: "Kafka publish latency in seconds",
+			},
+			[]string{"topic", "mode"},
+		),
+		PublishedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_messages_published_total",
+				Help: "Total number of messages published to Kafka",
+			},
+			[]string{"topic"},
+		),
+		FailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_publish_failures_total",
+				Help: "Total number of messages that failed to publish to Kafka",
+			},
+			[]string{"topic"},
+		),
+	}
+
+	prometheus.MustRegister(metrics.PublishDuration)
+	prometheus.MustRegister(metrics.PublishedTotal)
+	prometheus.MustRegister(metrics.FailuresTotal)
+
 	return &Producer{
-		writers: make(map[string]*kafka.Writer),
-		logger:  logger,
+		config:       cfg,
+		transport:    transport,
+		writers:      make(map[string]*kafka.Writer),
+		asyncWriters: make(map[string]*kafka.Writer),
+		metrics:      metrics,
+		logger:       logger,
 	}, nil
 }
 
+// topicConfig returns the delivery settings for a topic, falling back to the defaults
+func (p *Producer) topicConfig(topic string) TopicConfig {
+	topicCfg, ok := p.config.Topics[topic]
+	if !ok {
+		return p.config.Defaults
+	}
+	if topicCfg.RequiredAcks == "" {
+		topicCfg.RequiredAcks = p.config.Defaults.RequiredAcks
+	}
+	if topicCfg.Compression == "" {
+		topicCfg.Compression = p.config.Defaults.Compression
+	}
+	return topicCfg
+}
+
 // getWriter gets or creates a Kafka writer for a specific topic
-func (p *Producer) getWriter(topic string, brokers []string) *kafka.Writer {
-	if writer, exists := p.writers[topic]; exists {
+func (p *Producer) getWriter(topic string, async bool) *kafka.Writer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	writers := p.writers
+	if async {
+		writers = p.asyncWriters
+	}
+
+	if writer, exists := writers[topic]; exists {
 		return writer
 	}
 
+	// Settings were validated in NewProducer
+	topicCfg := p.topicConfig(topic)
+	requiredAcks, _ := parseRequiredAcks(topicCfg.RequiredAcks)
+	compression, _ := parseCompression(topicCfg.Compression)
+
 	writer := &kafka.Writer{
-		Addr:                   kafka.TCP(brokers...),
+		Addr:                   kafka.TCP(p.config.Brokers...),
 		Topic:                  topic,
-		Balancer:               &kafka.LeastBytes{},
-		RequiredAcks:           kafka.RequireOne,
-		WriteTimeout:           10 * time.Second,
-		ReadTimeout:            10 * time.Second,
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           requiredAcks,
+		WriteTimeout:           p.config.WriteTimeout,
+		ReadTimeout:            p.config.WriteTimeout,
 		ErrorLogger:            kafka.LoggerFunc(p.logger.Errorf),
-		Compression:            kafka.Snappy,
+		Compression:            compression,
+		Transport:              p.transport,
 		AllowAutoTopicCreation: true,
 	}
 
-	p.writers[topic] = writer
+	if async {
+		writer.Async = true
+		writer.BatchSize = p.config.BatchSize
+		writer.BatchTimeout = p.config.BatchTimeout
+		writer.Completion = p.completeAsync
+	} else {
+		// Synchronous writes should not wait for a batch to fill up
+		writer.BatchTimeout = 10 * time.Millisecond
+	}
+
+	writers[topic] = writer
 	return writer
 }
 
+// asyncDelivery is carried in WriterData so the completion handler can
+// report latency and invoke the caller's callback
+type asyncDelivery struct {
+	enqueued time.Time
+	callback DeliveryCallback
+}
+
+// completeAsync is the Completion handler of asynchronous writers
+func (p *Producer) completeAsync(messages []kafka.Message, err error) {
+	for _, msg := range messages {
+		delivery, _ := msg.WriterData.(*asyncDelivery)
+		if delivery != nil {
+			p.metrics.PublishDuration.WithLabelValues(msg.Topic, "async").Observe(time.Since(delivery.enqueued).Seconds())
+		}
+
+		if err != nil {
+			p.metrics.FailuresTotal.WithLabelValues(msg.Topic).Inc()
+			p.logger.WithError(err).WithFields(logrus.Fields{
+				"topic": msg.Topic,
+				"key":   string(msg.Key),
+			}).Error("Failed to deliver async message")
+		} else {
+			p.metrics.PublishedTotal.WithLabelValues(msg.Topic).Inc()
+		}
+
+		if delivery != nil && delivery.callback != nil {
+			delivery.callback(msg, err)
+		}
+	}
+}
+
 // Publish publishes a message to a Kafka topic
 func (p *Producer) Publish(topic, message string) error {
 	return p.PublishWithKey(topic, "", message)
@@ -51,22 +247,16 @@ func (p *Producer) Publish(topic, message string) error {
 
 // PublishWithKey publishes a message to a Kafka topic with a specific key
 func (p *Producer) PublishWithKey(topic, key, message string) error {
-	// For this implementation, we'll use a single broker configuration
-	brokers := []string{"localhost:9092"} // This should come from config
-
-	writer := p.getWriter(topic, brokers)
-
 	kafkaMessage := kafka.Message{
 		Key:   []byte(key),
 		Value: []byte(message),
 		Time:  time.Now(),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.WriteTimeout)
 	defer cancel()
 
-	err := writer.WriteMessages(ctx, kafkaMessage)
-	if err != nil {
+	if err := p.write(ctx, topic, kafkaMessage); err != nil {
 		p.logger.WithError(err).Errorf("Failed to publish message to topic %s", topic)
 		return err
 	}
@@ -81,29 +271,134 @@ func (p *Producer) PublishWithKey(topic, key, message string) error {
 
 // PublishBatch publishes multiple messages to a Kafka topic
 func (p *Producer) PublishBatch(topic string, messages []kafka.Message) error {
-	brokers := []string{"localhost:9092"} // This should come from config
-
-	writer := p.getWriter(topic, brokers)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*p.config.WriteTimeout)
 	defer cancel()
 
-	err := writer.WriteMessages(ctx, messages...)
-	if err != nil {
+	if err := p.write(ctx, topic, messages...); err != nil {
 		p.logger.WithError(err).Errorf("Failed to publish batch messages to topic %s", topic)
 		return err
 	}
 
 	p.logger.WithFields(logrus.Fields{
-		"topic":        topic,
+		"topic":         topic,
 		"message_count": len(messages),
 	}).Debug("Batch messages published successfully")
 
 	return nil
 }
 
-// Close closes all Kafka writers
+// PublishAsync queues a message for batched delivery and returns immediately.
+// The callback, if any, is invoked from a writer goroutine once the message has
+// been delivered or has failed.
+func (p *Producer) PublishAsync(topic, key string, message []byte, callback DeliveryCallback) error {
+	writer := p.getWriter(topic, true)
+
+	kafkaMessage := kafka.Message{
+		Key:   []byte(key),
+		Value: message,
+		Time:  time.Now(),
+		WriterData: &asyncDelivery{
+			enqueued: time.Now(),
+			callback: callback,
+		},
+	}
+
+	// Async writers never block, so the context only guards a closed writer
+	if err := writer.WriteMessages(context.Background(), kafkaMessage); err != nil {
+		p.metrics.FailuresTotal.WithLabelValues(topic).Inc()
+		return err
+	}
+
+	return nil
+}
+
+// WriteMessages synchronously publishes messages that carry their own
+// destination topic, preserving their order within each topic
+func (p *Producer) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	var topics []string
+	byTopic := make(map[string][]kafka.Message)
+	for _, msg := range messages {
+		if msg.Topic == "" {
+			return errors.New("message has no topic")
+		}
+		if _, seen := byTopic[msg.Topic]; !seen {
+			topics = append(topics, msg.Topic)
+		}
+		topic := msg.Topic
+		// Topic writers reject messages that also set a topic
+		msg.Topic = ""
+		byTopic[topic] = append(byTopic[topic], msg)
+	}
+
+	for _, topic := range topics {
+		if err := p.write(ctx, topic, byTopic[topic]...); err != nil {
+			p.logger.WithError(err).Errorf("Failed to publish messages to topic %s", topic)
+			return err
+		}
+	}
+	return nil
+}
+
+// write performs a synchronous write and records metrics
+func (p *Producer) write(ctx context.Context, topic string, messages ...kafka.Message) (err error) {
+	writer := p.getWriter(topic, false)
+
+	ctx, span := tracing.StartProducerSpan(ctx, topic, len(messages))
+	defer func() { tracing.End(span, err) }()
+
+	// Copy before adding trace and request ID headers so the caller's
+	// messages are untouched
+	traced := make([]kafka.Message, len(messages))
+	for i, msg := range messages {
+		msg.Headers = append([]kafka.Header(nil), msg.Headers...)
+		tracing.InjectKafka(ctx, &msg)
+		requestid.InjectKafka(ctx, &msg)
+		traced[i] = msg
+	}
+
+	start := time.Now()
+	err = writer.WriteMessages(ctx, traced...)
+	p.metrics.PublishDuration.WithLabelValues(topic, "sync").Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		p.metrics.FailuresTotal.WithLabelValues(topic).Add(float64(len(messages)))
+		return err
+	}
+
+	p.metrics.PublishedTotal.WithLabelValues(topic).Add(float64(len(messages)))
+	return nil
+}
+
+// Ping checks that at least one configured broker accepts connections
+func (p *Producer) Ping(ctx context.Context) error {
+	dialer, err := p.config.Security.dialer()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, broker := range p.config.Brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conn.Close()
+		return nil
+	}
+	return lastErr
+}
+
+// Close flushes pending asynchronous messages and closes all Kafka writers
 func (p *Producer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for topic, writer := range p.asyncWriters {
+		if err := writer.Close(); err != nil {
+			p.logger.WithError(err).Errorf("Failed to close async writer for topic %s", topic)
+		}
+	}
 	for topic, writer := range p.writers {
 		if err := writer.Close(); err != nil {
 			p.logger.WithError(err).Errorf("Failed to close writer for topic %s", topic)
@@ -119,9 +414,15 @@ type Consumer struct {
 }
 
 // NewConsumer creates a new Kafka consumer
-func NewConsumer(brokers []string, topic, groupID string, logger *logrus.Logger) *Consumer {
+func NewConsumer(brokers []string, security SecurityConfig, topic, groupID string, logger *logrus.Logger) (*Consumer, error) {
+	dialer, err := security.dialer()
+	if err != nil {
+		return nil, err
+	}
+
 	reader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:        brokers,
+		Dialer:         dialer,
 		Topic:          topic,
 		GroupID:        groupID,
 		MinBytes:       10e3, // 10KB
@@ -133,7 +434,7 @@ func NewConsumer(brokers []string, topic, groupID string, logger *logrus.Logger)
 	return &Consumer{
 		reader: reader,
 		logger: logger,
-	}
+	}, nil
 }
 
 // ReadMessage reads a single message from Kafka
@@ -141,6 +442,16 @@ func (c *Consumer) ReadMessage(ctx context.Context) (kafka.Message, error) {
 	return c.reader.ReadMessage(ctx)
 }
 
+// FetchMessage reads a single message without committing its offset
+func (c *Consumer) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	return c.reader.FetchMessage(ctx)
+}
+
+// CommitMessages commits the offsets of messages obtained with FetchMessage
+func (c *Consumer) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	return c.reader.CommitMessages(ctx, msgs...)
+}
+
 // Close closes the Kafka consumer
 func (c *Consumer) Close() error {
 	return c.reader.Close()
@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// SecurityConfig holds SASL and TLS settings for broker connections
+type SecurityConfig struct {
+	SASLMechanism         string // "", PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	Username              string
+	Password              string
+	TLSEnabled            bool
+	TLSCAPath             string
+	TLSCertPath           string
+	TLSKeyPath            string
+	TLSInsecureSkipVerify bool
+}
+
+// saslMechanism builds the configured SASL mechanism, or nil when SASL is disabled
+func (s SecurityConfig) saslMechanism() (sasl.Mechanism, error) {
+	switch strings.ToUpper(s.SASLMechanism) {
+	case "":
+		return nil, nil
+	case "PLAIN":
+		return plain.Mechanism{Username: s.Username, Password: s.Password}, nil
+	case "SCRAM-SHA-256":
+		return scram.Mechanism(scram.SHA256, s.Username, s.Password)
+	case "SCRAM-SHA-512":
+		return scram.Mechanism(scram.SHA512, s.Username, s.Password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q", s.SASLMechanism)
+	}
+}
+
+// tlsConfig builds the TLS configuration, or nil when TLS is disabled
+func (s SecurityConfig) tlsConfig() (*tls.Config, error) {
+	if !s.TLSEnabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: s.TLSInsecureSkipVerify,
+	}
+
+	if s.TLSCAPath != "" {
+		caCert, err := os.ReadFile(s.TLSCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Kafka CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", s.TLSCAPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if s.TLSCertPath != "" && s.TLSKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLSCertPath, s.TLSKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Kafka client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// transport builds a writer transport with the configured security settings
+func (s SecurityConfig) transport() (*kafka.Transport, error) {
+	mechanism, err := s.saslMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Transport{
+		SASL:        mechanism,
+		TLS:         tlsConfig,
+		DialTimeout: 10 * time.Second,
+	}, nil
+}
+
+// dialer builds a reader dialer with the configured security settings
+func (s SecurityConfig) dialer() (*kafka.Dialer, error) {
+	mechanism, err := s.saslMechanism()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &kafka.Dialer{
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		SASLMechanism: mechanism,
+		TLS:           tlsConfig,
+	}, nil
+}
+
+// parseRequiredAcks converts a configured acks value (none, one, all) to kafka-go's type
+func parseRequiredAcks(value string) (kafka.RequiredAcks, error) {
+	switch strings.ToLower(value) {
+	case "", "one", "1":
+		return kafka.RequireOne, nil
+	case "none", "0":
+		return kafka.RequireNone, nil
+	case "all", "-1":
+		return kafka.RequireAll, nil
+	default:
+		return kafka.RequireOne, fmt.Errorf("unsupported acks value %q", value)
+	}
+}
+
+// parseCompression converts a configured codec name to kafka-go's type
+func parseCompression(value string) (kafka.Compression, error) {
+	switch strings.ToLower(value) {
+	case "", "snappy":
+		return kafka.Snappy, nil
+	case "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return kafka.Snappy, fmt.Errorf("unsupported compression codec %q", value)
+	}
+}
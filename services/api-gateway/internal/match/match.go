@@ -0,0 +1,351 @@
+// Package match scores how likely two Patient resources describe the same
+// person, for Patient/$match. Each field both patients carry contributes by
+// its weight and similarity: national ID, name, birth date and phone. Names
+// are compared across Arabic and Latin spellings with package names. The
+// score is graded with the FHIR match-grade codes.
+package match
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/names"
+	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
+)
+
+// Match grades, from http://terminology.hl7.org/CodeSystem/match-grade
+const (
+	GradeCertain      = "certain"
+	GradeProbable     = "probable"
+	GradePossible     = "possible"
+	GradeCertainlyNot = "certainly-not"
+)
+
+// GradeExtension is the URL of the extension carrying a match grade
+const GradeExtension = "http://hl7.org/fhir/StructureDefinition/match-grade"
+
+// patientIdentifierSystem identifies patients by national ID or Iqama number
+const patientIdentifierSystem = "https://nphies.sa/patient-id"
+
+// Field weights; together they make a score of 1
+const (
+	identifierWeight = 0.4
+	nameWeight       = 0.3
+	birthDateWeight  = 0.2
+	phoneWeight      = 0.1
+
+	// identifierConflict is deducted when both patients carry a national ID
+	// and the IDs differ
+	identifierConflict = 0.3
+)
+
+// Grade thresholds
+const (
+	probableScore = 0.6
+	possibleScore = 0.4
+
+	// certainName is the name similarity that, with an equal national ID,
+	// makes a match certain without the birth date
+	certainName = 0.85
+)
+
+// phoneticMatch is the similarity of name parts that differ in spelling but
+// share a phonetic key, e.g. العتيبي and Alotaibi
+const phoneticMatch = 0.9
+
+// Match is a candidate patient with its score and grade
+type Match struct {
+	Patient fhir.Patient
+	Score   float64
+	Grade   string
+}
+
+// Score compares a candidate with the input patient
+func Score(input, candidate fhir.Patient) (float64, string) {
+	score := 0.0
+
+	inputIDs, candidateIDs := nationalIDs(input), nationalIDs(candidate)
+	sameID := false
+	if len(inputIDs) > 0 && len(candidateIDs) > 0 {
+		for id := range inputIDs {
+			if candidateIDs[id] {
+				sameID = true
+			}
+		}
+		if sameID {
+			score += identifierWeight
+		} else {
+			score -= identifierConflict
+		}
+	}
+
+	nameSimilarity := 0.0
+	if len(input.Name) > 0 && len(candidate.Name) > 0 {
+		nameSimilarity = NameSimilarity(input.Name, candidate.Name)
+		score += nameWeight * nameSimilarity
+	}
+
+	sameBirthDate := false
+	if input.BirthDate != "" && candidate.BirthDate != "" {
+		similarity := birthDateSimilarity(input.BirthDate, candidate.BirthDate)
+		sameBirthDate = similarity == 1
+		score += birthDateWeight * similarity
+	}
+
+	inputPhones, candidatePhones := phones(input), phones(candidate)
+	for phone := range inputPhones {
+		if candidatePhones[phone] {
+			score += phoneWeight
+			break
+		}
+	}
+
+	if score < 0 {
+		score = 0
+	}
+	if score > 1 {
+		score = 1
+	}
+
+	switch {
+	case sameID && (sameBirthDate || nameSimilarity >= certainName):
+		return score, GradeCertain
+	case score >= probableScore:
+		return score, GradeProbable
+	case score >= possibleScore:
+		return score, GradePossible
+	default:
+		return score, GradeCertainlyNot
+	}
+}
+
+// Rank scores the candidates and returns the possible matches and better,
+// best first. Candidates are deduplicated by ID.
+func Rank(input fhir.Patient, candidates []fhir.Patient) []Match {
+	seen := make(map[string]bool)
+	var matches []Match
+	for _, candidate := range candidates {
+		if seen[candidate.ID] {
+			continue
+		}
+		seen[candidate.ID] = true
+
+		score, grade := Score(input, candidate)
+		if grade == GradeCertainlyNot {
+			continue
+		}
+		matches = append(matches, Match{Patient: candidate, Score: score, Grade: grade})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// NationalID returns the national ID of a patient: the identifier of the
+// NPHIES patient ID system, or else the first identifier
+func NationalID(patient fhir.Patient) string {
+	id := ""
+	for _, identifier := range patient.Identifier {
+		if identifier.Value != "" && (id == "" || identifier.System == patientIdentifierSystem) {
+			id = identifier.Value
+		}
+	}
+	return id
+}
+
+// nationalIDs returns the identifier values of a patient that may be
+// national IDs: those of the NPHIES system and those without a system
+func nationalIDs(patient fhir.Patient) map[string]bool {
+	ids := make(map[string]bool)
+	for _, identifier := range patient.Identifier {
+		if identifier.Value != "" && (identifier.System == "" || identifier.System == patientIdentifierSystem) {
+			ids[strings.TrimSpace(identifier.Value)] = true
+		}
+	}
+	return ids
+}
+
+// NameSimilarity compares two sets of names, e.g. an Arabic and an English
+// one each, and returns the similarity of the closest pair from 0 to 1
+func NameSimilarity(a, b []fhir.HumanName) float64 {
+	best := 0.0
+	for _, nameA := range a {
+		tokensA := nameTokens(nameA)
+		for _, nameB := range b {
+			tokensB := nameTokens(nameB)
+			if len(tokensA) == 0 || len(tokensB) == 0 {
+				continue
+			}
+			similarity := (tokenSimilarity(tokensA, tokensB) + tokenSimilarity(tokensB, tokensA)) / 2
+			if similarity > best {
+				best = similarity
+			}
+		}
+	}
+	return best
+}
+
+// NameSearchScore scores how well a searched name matches a patient's
+// names, from 0 to 1: every searched part must be close to a part of one of
+// the names, in either script
+func NameSearchScore(query string, patientNames []fhir.HumanName) float64 {
+	queryTokens := names.Tokens(query)
+	if len(queryTokens) == 0 {
+		return 0
+	}
+	best := 0.0
+	for _, name := range patientNames {
+		tokens := nameTokens(name)
+		if len(tokens) == 0 {
+			continue
+		}
+		if similarity := tokenSimilarity(queryTokens, tokens); similarity > best {
+			best = similarity
+		}
+	}
+	return best
+}
+
+// tokenSimilarity averages, over the tokens of a, the similarity of the
+// closest token of b, so that the order of name parts does not matter
+func tokenSimilarity(a, b []string) float64 {
+	total := 0.0
+	for _, tokenA := range a {
+		best := 0.0
+		for _, tokenB := range b {
+			if similarity := partSimilarity(tokenA, tokenB); similarity > best {
+				best = similarity
+			}
+		}
+		total += best
+	}
+	return total / float64(len(a))
+}
+
+// partSimilarity compares two normalized name parts. Parts with the same
+// phonetic key are spelling variants, possibly in different scripts, and
+// score at least phoneticMatch.
+func partSimilarity(a, b string) float64 {
+	similarity := jaroWinkler(a, b)
+	if similarity < phoneticMatch {
+		if key := names.Key(a); key != "" && key == names.Key(b) {
+			return phoneticMatch
+		}
+	}
+	return similarity
+}
+
+// NameText returns a name as text: its given names and family name, or its
+// text when it has neither
+func NameText(name fhir.HumanName) string {
+	if name.Family == "" && len(name.Given) == 0 {
+		return name.Text
+	}
+	return strings.TrimSpace(strings.Join(append(append([]string{}, name.Given...), name.Family), " "))
+}
+
+// nameTokens returns the normalized parts of a name
+func nameTokens(name fhir.HumanName) []string {
+	return names.Tokens(NameText(name))
+}
+
+// birthDateSimilarity is 1 for equal dates and 0.5 for dates of the same
+// year with day and month swapped, a common data entry error
+func birthDateSimilarity(a, b string) float64 {
+	dateA, errA := time.Parse("2006-01-02", a)
+	dateB, errB := time.Parse("2006-01-02", b)
+	if errA != nil || errB != nil {
+		return 0
+	}
+	switch {
+	case dateA.Equal(dateB):
+		return 1
+	case dateA.Year() == dateB.Year() && int(dateA.Month()) == dateB.Day() && dateA.Day() == int(dateB.Month()):
+		return 0.5
+	}
+	return 0
+}
+
+// phones returns the phone numbers of a patient in national form: Saudi
+// mobile numbers as 5xxxxxxxx whether written with +966, 00966 or 0
+func phones(patient fhir.Patient) map[string]bool {
+	numbers := make(map[string]bool)
+	for _, telecom := range patient.Telecom {
+		if telecom.System != "phone" && telecom.System != "sms" {
+			continue
+		}
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, telecom.Value)
+		for _, prefix := range []string{"00966", "966", "0"} {
+			if strings.HasPrefix(digits, prefix) {
+				digits = strings.TrimPrefix(digits, prefix)
+				break
+			}
+		}
+		if len(digits) >= 7 {
+			numbers[digits] = true
+		}
+	}
+	return numbers
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of two strings, from 0 to 1
+func jaroWinkler(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	window := max(len(ra), len(rb))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		for j := max(0, i-window); j < min(len(rb), i+window+1); j++ {
+			if !matchedB[j] && ra[i] == rb[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions, j := 0, 0
+	for i := range ra {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/apikey"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/serviceauth"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyHeader carries static API keys
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyMiddleware authenticates requests that present an X-API-Key header and
+// sets the same context values as AuthMiddleware, which then lets them
+// through. Requests without the header are left to AuthMiddleware. Read
+// requests need the read scope and all others the write scope, and each key
+// is held to its per-minute quota. Keys of payer organizations are scoped to
+// that payer as their tenant.
+func APIKeyMiddleware(keys *apikey.Store, quota *apikey.Quota, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented := c.GetHeader(APIKeyHeader)
+		if presented == "" {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		key, err := keys.Authenticate(ctx, presented, c.ClientIP())
+		if err != nil {
+			if !isAPIKeyRejection(err) {
+				logger.WithContext(ctx).WithError(err).Error("Failed to authenticate API key")
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+					"error":      "authentication_unavailable",
+					"message":    "Unable to verify the API key",
+					"request_id": requestid.Get(c),
+				})
+				return
+			}
+
+			logger.WithContext(ctx).WithFields(logrus.Fields{
+				"key_prefix": apikey.Prefix(presented),
+				"client_ip":  c.ClientIP(),
+				"reason":     err.Error(),
+			}).Warn("Rejected API key")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":      "unauthorized",
+				"message":    "Invalid, expired or revoked API key",
+				"request_id": requestid.Get(c),
+			})
+			return
+		}
+
+		scope := apikey.ScopeWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = apikey.ScopeRead
+		}
+		if !key.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":      "insufficient_scope",
+				"message":    "API key lacks the " + scope + " scope",
+				"request_id": requestid.Get(c),
+			})
+			return
+		}
+
+		result, err := quota.Allow(ctx, key)
+		if err != nil {
+			// Fail open; the per-client rate limit still applies
+			logger.WithContext(ctx).WithError(err).WithField("api_key_id", key.ID).Warn("Failed to check API key quota")
+		}
+		if result.Limit > 0 {
+			c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		}
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":      "quota_exceeded",
+				"message":    "API key quota exceeded. Please try again later.",
+				"request_id": requestid.Get(c),
+			})
+			return
+		}
+
+		if !rejectForeignCredentials(c, key.OrganizationIdentifier) {
+			return
+		}
+
+		c.Set("userID", "apikey:"+key.ID)
+		c.Set("userRole", "api_client")
+		c.Set("userScopes", key.Scopes)
+		c.Set("organizationID", key.OrganizationID)
+		c.Set("organizationIdentifier", key.OrganizationIdentifier)
+		c.Set("organizationType", key.OrganizationType)
+		c.Set("apiKeyID", key.ID)
+		if key.OrganizationType == "payer" {
+			c.Set("tenantID", key.OrganizationIdentifier)
+		}
+		c.Set("authMethod", "api_key")
+		c.Request = c.Request.WithContext(serviceauth.WithPrincipal(c.Request.Context(), "apikey:"+key.ID))
+
+		c.Next()
+	}
+}
+
+// isAPIKeyRejection reports whether err means the key itself was refused, as
+// opposed to a failure to check it
+func isAPIKeyRejection(err error) bool {
+	return errors.Is(err, apikey.ErrInvalidKey) ||
+		errors.Is(err, apikey.ErrKeyRevoked) ||
+		errors.Is(err, apikey.ErrKeyExpired) ||
+		errors.Is(err, apikey.ErrOrganizationInactive)
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// BreakGlassRecorder counts and audits a request made with a break-glass
+// token. It fails when the session may no longer be used.
+type BreakGlassRecorder func(c *gin.Context, sessionID string) error
+
+// BreakGlassMiddleware records every request made with a break-glass token and
+// rejects those whose session has been revoked or has expired. Requests with
+// regular credentials pass through. It runs after AuthMiddleware.
+func BreakGlassMiddleware(record BreakGlassRecorder, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.GetString("breakGlassSessionID")
+		if sessionID == "" {
+			c.Next()
+			return
+		}
+
+		if err := record(c, sessionID); err != nil {
+			logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+				"session_id": sessionID,
+				"client_ip":  c.ClientIP(),
+			}).WithError(err).Warn("Break-glass token rejected")
+
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":      "break_glass_inactive",
+				"message":    "Break-glass session has expired or been revoked",
+				"request_id": requestid.Get(c),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,240 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/consent"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PurposeHeader carries the purpose of use of a request, e.g. TREAT or HPAYMT
+const PurposeHeader = "X-Purpose-Of-Use"
+
+// consentCheckKey holds the consent check a route deferred to its handler
+const consentCheckKey = "consentCheck"
+
+// ConsentDenyAuditor records a disclosure refused for lack of consent
+type ConsentDenyAuditor func(c *gin.Context, req consent.Request, decision consent.Decision)
+
+// MemberResolver returns the member whose data a request reads, or "" if the
+// request does not name one
+type MemberResolver func(c *gin.Context) string
+
+// PathMember resolves the member from a path parameter
+func PathMember(param string) MemberResolver {
+	return func(c *gin.Context) string {
+		return c.Param(param)
+	}
+}
+
+// QueryMember resolves the member from the first set query parameter. Token
+// values of the form system|value and references of the form Patient/<id>
+// resolve to the value and the ID.
+func QueryMember(params ...string) MemberResolver {
+	return func(c *gin.Context) string {
+		for _, param := range params {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			if i := strings.LastIndexAny(value, "|/"); i >= 0 {
+				value = value[i+1:]
+			}
+			return value
+		}
+		return ""
+	}
+}
+
+// ConsentGuard checks the member's consent before their data is returned to
+// a third-party recipient. Recipients are identified by the organization of
+// the caller; payers reading within the tenant their credentials are scoped
+// to and exempt roles are not third parties. Callers without an organization
+// are third parties that no consent can name, so they are denied.
+type ConsentGuard struct {
+	client         *consent.Client
+	exemptRoles    map[string]bool
+	defaultPurpose string
+	audit          ConsentDenyAuditor
+	logger         *logrus.Logger
+}
+
+// NewConsentGuard creates a consent guard. A nil client disables enforcement.
+// Requests without a PurposeHeader are decided for defaultPurpose.
+func NewConsentGuard(client *consent.Client, exemptRoles []string, defaultPurpose string, audit ConsentDenyAuditor, logger *logrus.Logger) *ConsentGuard {
+	exempt := make(map[string]bool, len(exemptRoles))
+	for _, role := range exemptRoles {
+		exempt[role] = true
+	}
+	return &ConsentGuard{
+		client:         client,
+		exemptRoles:    exempt,
+		defaultPurpose: defaultPurpose,
+		audit:          audit,
+		logger:         logger,
+	}
+}
+
+// Require allows a third-party recipient to read the data type of the member
+// resolved by member only if an active consent covers the disclosure. Reads
+// that do not name a member are denied to third parties, since no consent can
+// be established for them. Break-glass tokens only read their session's
+// patient. It runs after authorization and BreakGlassMiddleware.
+func (g *ConsentGuard) Require(dataType string, member MemberResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberID := member(c)
+		if !breakGlassPatient(c, memberID) {
+			g.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+				"session_id": c.GetString("breakGlassSessionID"),
+				"client_ip":  c.ClientIP(),
+			}).Warn("Break-glass read outside the session patient denied")
+			c.AbortWithStatusJSON(http.StatusForbidden, operationOutcome("forbidden",
+				"Break-glass access is limited to the patient of the session"))
+			return
+		}
+		if !g.thirdParty(c) {
+			c.Next()
+			return
+		}
+
+		req := g.request(c, dataType, memberID)
+		if req.Recipient == "" {
+			g.deny(c, req, consent.Decision{Reason: "the caller is not identified by an organization"},
+				"Member data is only disclosed to callers identified by an organization")
+			return
+		}
+		if req.MemberID == "" {
+			g.deny(c, req, consent.Decision{Reason: "the request does not identify the member"},
+				"Third-party reads must identify the member so that consent can be verified")
+			return
+		}
+
+		decision, err := g.client.Decide(c.Request.Context(), req)
+		if err != nil {
+			g.logger.WithContext(c.Request.Context()).WithError(err).Error("Consent decision unavailable")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, operationOutcome("transient",
+				"Consent could not be verified; try again later"))
+			return
+		}
+		if !decision.Permit {
+			g.deny(c, req, decision, "No active consent permits this disclosure")
+			return
+		}
+
+		c.Set("consentID", decision.ConsentID)
+		c.Next()
+	}
+}
+
+// Defer leaves the consent check of a data type to the handler, for requests
+// that only learn the members they return while running, such as
+// Patient/$match. The handler checks each member with ConsentPermits.
+func (g *ConsentGuard) Defer(dataType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(consentCheckKey, func(memberID string) (bool, error) {
+			if !breakGlassPatient(c, memberID) {
+				return false, nil
+			}
+			if !g.thirdParty(c) {
+				return true, nil
+			}
+
+			req := g.request(c, dataType, memberID)
+			if req.Recipient == "" {
+				if g.audit != nil {
+					g.audit(c, req, consent.Decision{Reason: "the caller is not identified by an organization"})
+				}
+				return false, nil
+			}
+			decision, err := g.client.Decide(c.Request.Context(), req)
+			if err != nil {
+				return false, err
+			}
+			if !decision.Permit && g.audit != nil {
+				g.audit(c, req, decision)
+			}
+			return decision.Permit, nil
+		})
+		c.Next()
+	}
+}
+
+// ConsentPermits reports whether the consent check a route deferred to its
+// handler permits disclosing the member's data. Requests without a deferred
+// check are permitted.
+func ConsentPermits(c *gin.Context, memberID string) (bool, error) {
+	check, ok := c.Get(consentCheckKey)
+	if !ok {
+		return true, nil
+	}
+	return check.(func(string) (bool, error))(memberID)
+}
+
+// breakGlassPatient reports whether a request may read the member's data as
+// far as break-glass is concerned: requests with break-glass tokens only read
+// the patient of their session, others are not restricted
+func breakGlassPatient(c *gin.Context, memberID string) bool {
+	if c.GetString("breakGlassSessionID") == "" {
+		return true
+	}
+	patientID := c.GetString("breakGlassPatientID")
+	return patientID != "" && memberID == patientID
+}
+
+// thirdParty reports whether the caller is a third-party recipient whose
+// reads need the member's consent
+func (g *ConsentGuard) thirdParty(c *gin.Context) bool {
+	if g.client == nil || g.exemptRoles[c.GetString("userRole")] {
+		return false
+	}
+	// A payer is only a first party within the tenant of its credentials
+	recipient := c.GetString("organizationIdentifier")
+	firstParty := recipient != "" && recipient == c.GetString("tenantID") && recipient == tenant.Get(c)
+	return !firstParty
+}
+
+// request builds the consent request for a read of a member's data
+func (g *ConsentGuard) request(c *gin.Context, dataType, memberID string) consent.Request {
+	req := consent.Request{
+		MemberID:  memberID,
+		Recipient: c.GetString("organizationIdentifier"),
+		DataType:  dataType,
+		Purpose:   c.GetHeader(PurposeHeader),
+	}
+	if req.Purpose == "" {
+		req.Purpose = g.defaultPurpose
+	}
+	return req
+}
+
+func (g *ConsentGuard) deny(c *gin.Context, req consent.Request, decision consent.Decision, message string) {
+	g.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"recipient": req.Recipient,
+		"data_type": req.DataType,
+		"purpose":   req.Purpose,
+		"reason":    decision.Reason,
+		"client_ip": c.ClientIP(),
+	}).Warn("Disclosure denied for lack of consent")
+	if g.audit != nil {
+		g.audit(c, req, decision)
+	}
+
+	c.AbortWithStatusJSON(http.StatusForbidden, operationOutcome("forbidden", message))
+}
+
+func operationOutcome(code, diagnostics string) fhir.OperationOutcome {
+	return fhir.OperationOutcome{
+		ResourceType: "OperationOutcome",
+		Issue: []fhir.OperationOutcomeIssue{
+			{
+				Severity:    "error",
+				Code:        code,
+				Diagnostics: diagnostics,
+			},
+		},
+	}
+}
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CORSPolicy describes which cross-origin requests are allowed for the routes
+// under PathPrefix
+type CORSPolicy struct {
+	Name             string
+	PathPrefix       string
+	AllowedOrigins   []string // exact origins, "https://*.example.sa" for subdomains, or "*"
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool // never sent for the "*" origin
+	MaxAge           int  // seconds
+}
+
+// corsPolicy is a CORSPolicy prepared for matching
+type corsPolicy struct {
+	CORSPolicy
+	anyOrigin bool
+	origins   map[string]bool
+	wildcards []originPattern
+	methods   map[string]bool
+	headers   map[string]bool
+
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// originPattern matches the subdomains of a host, e.g. https://*.example.sa
+type originPattern struct {
+	scheme string
+	suffix string // ".example.sa"
+	port   string
+}
+
+// CORSMiddleware handles Cross-Origin Resource Sharing. The first policy whose
+// path prefix matches the request applies; requests matching no policy get no
+// CORS headers. Only allowlisted origins are echoed back, and preflight
+// requests are checked against the policy's methods and headers. Rejected
+// origins are logged and answered with 403.
+//
+// It must run before routing-dependent middleware, since preflight requests
+// do not match any route.
+func CORSMiddleware(logger *logrus.Logger, policies ...CORSPolicy) gin.HandlerFunc {
+	compiled := make([]*corsPolicy, 0, len(policies))
+	for _, policy := range policies {
+		compiled = append(compiled, compileCORSPolicy(policy))
+	}
+
+	return func(c *gin.Context) {
+		var policy *corsPolicy
+		for _, p := range compiled {
+			if strings.HasPrefix(c.Request.URL.Path, p.PathPrefix) {
+				policy = p
+				break
+			}
+		}
+		if policy == nil {
+			c.Next()
+			return
+		}
+
+		// Responses differ by origin, so caches must key on it even when the
+		// request has none
+		c.Writer.Header().Add("Vary", "Origin")
+
+		origin := c.Request.Header.Get("Origin")
+		if origin == "" {
+			// Same-origin or non-browser request
+			c.Next()
+			return
+		}
+
+		preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+		if !policy.allowsOrigin(origin) {
+			rejectCORS(c, logger, policy, origin, "origin not allowed")
+			return
+		}
+
+		if preflight {
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+			c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			method := strings.ToUpper(c.Request.Header.Get("Access-Control-Request-Method"))
+			if !policy.methods[method] {
+				rejectCORS(c, logger, policy, origin, "method not allowed: "+method)
+				return
+			}
+			for _, header := range strings.Split(c.Request.Header.Get("Access-Control-Request-Headers"), ",") {
+				header = strings.ToLower(strings.TrimSpace(header))
+				if header != "" && !policy.headers[header] {
+					rejectCORS(c, logger, policy, origin, "header not allowed: "+header)
+					return
+				}
+			}
+
+			policy.setOriginHeaders(c, origin)
+			c.Header("Access-Control-Allow-Methods", policy.allowMethods)
+			if policy.allowHeaders != "" {
+				c.Header("Access-Control-Allow-Headers", policy.allowHeaders)
+			}
+			c.Header("Access-Control-Max-Age", policy.maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		policy.setOriginHeaders(c, origin)
+		if policy.exposeHeaders != "" {
+			c.Header("Access-Control-Expose-Headers", policy.exposeHeaders)
+		}
+		c.Next()
+	}
+}
+
+func compileCORSPolicy(policy CORSPolicy) *corsPolicy {
+	p := &corsPolicy{
+		CORSPolicy: policy,
+		origins:    make(map[string]bool),
+		methods:    make(map[string]bool),
+		headers:    make(map[string]bool),
+	}
+
+	for _, origin := range policy.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*.")
+			host, port, _ := strings.Cut(host, ":")
+			p.wildcards = append(p.wildcards, originPattern{scheme: scheme, suffix: "." + host, port: port})
+		case origin != "":
+			p.origins[origin] = true
+		}
+	}
+
+	for _, method := range policy.AllowedMethods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range policy.AllowedHeaders {
+		p.headers[strings.ToLower(header)] = true
+	}
+
+	p.allowMethods = strings.Join(policy.AllowedMethods, ", ")
+	p.allowHeaders = strings.Join(policy.AllowedHeaders, ", ")
+	p.exposeHeaders = strings.Join(policy.ExposedHeaders, ", ")
+	p.maxAge = strconv.Itoa(policy.MaxAge)
+	return p
+}
+
+// allowsOrigin reports whether origin is allowlisted. Origins are compared
+// after lowercasing; wildcard patterns match subdomains at any depth but not
+// the bare domain.
+func (p *corsPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	if len(p.wildcards) == 0 {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.Path != "" || u.User != nil {
+		return false
+	}
+	for _, pattern := range p.wildcards {
+		if u.Scheme == pattern.scheme && u.Port() == pattern.port &&
+			strings.HasSuffix(u.Hostname(), pattern.suffix) && len(u.Hostname()) > len(pattern.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) setOriginHeaders(c *gin.Context, origin string) {
+	if p.anyOrigin {
+		// Browsers refuse credentials with a wildcard origin
+		c.Header("Access-Control-Allow-Origin", "*")
+		return
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	if p.AllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func rejectCORS(c *gin.Context, logger *logrus.Logger, policy *corsPolicy, origin, reason string) {
+	path := c.FullPath()
+	if path == "" {
+		path = c.Request.URL.Path
+	}
+	logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"origin":    origin,
+		"policy":    policy.Name,
+		"method":    c.Request.Method,
+		"path":      path,
+		"client_ip": c.ClientIP(),
+		"reason":    reason,
+	}).Warn("Rejected cross-origin request")
+
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":      "cors_rejected",
+		"message":    "Cross-origin request not allowed",
+		"request_id": requestid.Get(c),
+	})
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TransferDeadline gives a route that moves large bodies, such as attachment
+// uploads and export downloads, timeout to read the request and write the
+// response instead of the server's read and write timeouts, which are sized
+// for API calls
+func TransferDeadline(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deadline := time.Now().Add(timeout)
+		controller := http.NewResponseController(c.Writer)
+		// Writers without deadlines, as in tests, keep the server's timeouts
+		_ = controller.SetReadDeadline(deadline)
+		_ = controller.SetWriteDeadline(deadline)
+		c.Next()
+	}
+}
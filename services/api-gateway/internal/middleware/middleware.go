@@ -2,34 +2,51 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/auth"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/mtls"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/policy"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
-// LoggerMiddleware provides structured logging for HTTP requests
+// LoggerMiddleware provides structured logging for HTTP requests. The route
+// template is logged instead of the raw path and query, which carry member
+// and resource identifiers.
 func LoggerMiddleware(logger *logrus.Logger) gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		logger.WithFields(logrus.Fields{
-			"method":     param.Method,
-			"path":       param.Path,
-			"status":     param.StatusCode,
-			"latency":    param.Latency,
-			"client_ip":  param.ClientIP,
-			"user_agent": param.Request.UserAgent(),
-			"error":      param.ErrorMessage,
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+
+		logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"method":     c.Request.Method,
+			"path":       path,
+			"status":     c.Writer.Status(),
+			"latency":    time.Since(start),
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
+			"error":      c.Errors.ByType(gin.ErrorTypePrivate).String(),
 		}).Info("HTTP Request")
-		
-		return ""
-	})
+	}
 }
 
 // RecoveryMiddleware provides panic recovery
 func RecoveryMiddleware(logger *logrus.Logger) gin.HandlerFunc {
 	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered interface{}) {
-		logger.WithFields(logrus.Fields{
+		logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 			"method":    c.Request.Method,
 			"path":      c.Request.URL.Path,
 			"client_ip": c.ClientIP(),
@@ -37,33 +54,13 @@ func RecoveryMiddleware(logger *logrus.Logger) gin.HandlerFunc {
 		}).Error("Panic recovered")
 		
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "internal_server_error",
-			"message": "An internal server error occurred",
+			"error":      "internal_server_error",
+			"message":    "An internal server error occurred",
+			"request_id": requestid.Get(c),
 		})
 	})
 }
 
-// CORSMiddleware handles Cross-Origin Resource Sharing
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		origin := c.Request.Header.Get("Origin")
-		
-		// In production, configure specific allowed origins
-		c.Header("Access-Control-Allow-Origin", origin)
-		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
-		c.Header("Access-Control-Max-Age", "86400")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-			return
-		}
-
-		c.Next()
-	}
-}
-
 // SecurityHeadersMiddleware adds security headers
 func SecurityHeadersMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -122,8 +119,9 @@ func RateLimitMiddleware(config RateLimitConfig) gin.HandlerFunc {
 		// Check rate limit
 		if len(clients[clientIP]) >= config.RequestsPerMinute {
 			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":   "rate_limit_exceeded",
-				"message": "Too many requests. Please try again later.",
+				"error":      "rate_limit_exceeded",
+				"message":    "Too many requests. Please try again later.",
+				"request_id": requestid.Get(c),
 			})
 			c.Abort()
 			return
@@ -136,63 +134,125 @@ func RateLimitMiddleware(config RateLimitConfig) gin.HandlerFunc {
 	}
 }
 
-// MetricsMiddleware collects metrics for requests
-func MetricsMiddleware() gin.HandlerFunc {
+// MetricsMiddleware records request counts, latency and in-flight requests
+func MetricsMiddleware(requests *prometheus.CounterVec, duration *prometheus.HistogramVec, active prometheus.Gauge) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
-		
+		active.Inc()
+
 		c.Next()
-		
-		// Record metrics (this would typically update Prometheus metrics)
-		duration := time.Since(start)
-		status := c.Writer.Status()
-		
-		// In a real implementation, you would update Prometheus metrics here
-		_ = duration
-		_ = status
+
+		active.Dec()
+
+		// Use the route template rather than the raw path to bound label cardinality
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+
+		method := c.Request.Method
+		requests.WithLabelValues(method, endpoint, strconv.Itoa(c.Writer.Status())).Inc()
+		duration.WithLabelValues(method, endpoint).Observe(time.Since(start).Seconds())
 	}
 }
 
-// RequestIDMiddleware adds a unique request ID to each request
-func RequestIDMiddleware() gin.HandlerFunc {
+// ClientCertMiddleware requires a verified client certificate and maps its
+// SANs or subject to a registered client, whose ID is the organization the
+// certificate was issued to. AuthMiddleware and APIKeyMiddleware then only
+// accept credentials of that organization over the connection. Without
+// registered clients every verified certificate is accepted and bound to no
+// organization.
+func ClientCertMiddleware(clients map[string]string, logger *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requestID := c.Request.Header.Get("X-Request-ID")
-		if requestID == "" {
-			// Generate a simple request ID (in production, use UUID)
-			requestID = generateRequestID()
+		if mtls.Exempt(c.Request.URL.Path) {
+			c.Next()
+			return
 		}
-		
-		c.Header("X-Request-ID", requestID)
-		c.Set("request_id", requestID)
-		
+
+		cert := mtls.PeerCertificate(c.Request)
+		if cert == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error":      "client_certificate_required",
+				"message":    "A valid client certificate is required",
+				"request_id": requestid.Get(c),
+			})
+			c.Abort()
+			return
+		}
+
+		clientID := cert.Subject.CommonName
+		if len(clients) > 0 {
+			clientID = ""
+			for _, identity := range mtls.Identities(cert) {
+				if id, ok := clients[identity]; ok {
+					clientID = id
+					break
+				}
+			}
+		}
+
+		if clientID == "" {
+			logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+				"subject":   cert.Subject.String(),
+				"serial":    cert.SerialNumber.String(),
+				"client_ip": c.ClientIP(),
+			}).Warn("Rejected unregistered client certificate")
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":      "forbidden",
+				"message":    "Client certificate is not registered",
+				"request_id": requestid.Get(c),
+			})
+			c.Abort()
+			return
+		}
+
+		if len(clients) > 0 {
+			c.Set(clientOrganizationKey, clientID)
+		}
+
 		c.Next()
 	}
 }
 
-// generateRequestID generates a simple request ID
-func generateRequestID() string {
-	// Simple implementation - in production, use proper UUID generation
-	return "req_" + time.Now().Format("20060102150405") + "_" + randomString(8)
-}
+// clientOrganizationKey names the organization the client certificate of a
+// request is registered to in gin contexts
+const clientOrganizationKey = "clientOrganization"
 
-// randomString generates a random string (simplified implementation)
-func randomString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	result := make([]byte, length)
-	for i := range result {
-		result[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+// rejectForeignCredentials rejects credentials of an organization other than
+// the one the request's client certificate is registered to, so that a
+// certificate cannot carry another organization's token or key. It reports
+// whether the request may proceed.
+func rejectForeignCredentials(c *gin.Context, organization string) bool {
+	if bound, ok := c.Get(clientOrganizationKey); !ok || bound == organization {
+		return true
 	}
-	return string(result)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error":      "forbidden",
+		"message":    "Credentials do not belong to the organization of the client certificate",
+		"request_id": requestid.Get(c),
+	})
+	return false
 }
 
-// AuthMiddleware verifies JWT tokens
+// AuthMiddleware verifies JWT tokens and sets the user, role, scopes, tenant
+// and organization of the token on the context. Requests already authenticated by
+// APIKeyMiddleware pass through.
 func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+	tokens := auth.NewService(jwtSecret, 0)
+
 	return func(c *gin.Context) {
+		// Already authenticated by APIKeyMiddleware
+		if c.GetString("authMethod") == "api_key" {
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "unauthorized",
-				"message": "Authorization header is required",
+				"error":      "unauthorized",
+				"message":    "Authorization header is required",
+				"request_id": requestid.Get(c),
 			})
 			c.Abort()
 			return
@@ -202,61 +262,46 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		tokenParts := strings.Split(authHeader, " ")
 		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "unauthorized",
-				"message": "Invalid authorization header format",
+				"error":      "unauthorized",
+				"message":    "Invalid authorization header format",
+				"request_id": requestid.Get(c),
 			})
 			c.Abort()
 			return
 		}
 
-		token := tokenParts[1]
-		
-		// TODO: Implement proper JWT validation
-		// For now, accept any non-empty token
-		if token == "" {
+		claims, err := tokens.ValidateToken(tokenParts[1])
+		if err != nil || (claims.TenantID != "" && !tenant.Valid(claims.TenantID)) {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "unauthorized",
-				"message": "Invalid or expired token",
+				"error":      "unauthorized",
+				"message":    "Invalid or expired token",
+				"request_id": requestid.Get(c),
 			})
 			c.Abort()
 			return
 		}
 
-		// In a real implementation, you would:
-		// 1. Parse and validate the JWT token
-		// 2. Extract user information from the token
-		// 3. Set user context for downstream handlers
-		
-		// Mock user context
-		c.Set("userID", "mock_user")
-		c.Set("userRole", "user")
-		c.Set("userScopes", []string{"read", "write"})
-
-		c.Next()
-	}
-}
-
-// AdminMiddleware restricts access to admin users only
-func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userRole, exists := c.Get("userRole")
-		if !exists {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":   "forbidden",
-				"message": "User role not found",
-			})
-			c.Abort()
+		if !rejectForeignCredentials(c, claims.OrganizationID) {
 			return
 		}
 
-		if userRole != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error":   "forbidden",
-				"message": "Admin access required",
-			})
-			c.Abort()
-			return
+		role := claims.Role
+		if role == "" {
+			role = policy.RoleUser
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("userRole", role)
+		c.Set("userScopes", claims.Scopes)
+		c.Set("tenantID", claims.TenantID)
+		if claims.OrganizationID != "" {
+			c.Set("organizationIdentifier", claims.OrganizationID)
+		}
+		if claims.BreakGlass != "" {
+			c.Set("breakGlassSessionID", claims.BreakGlass)
 		}
+		c.Set("authMethod", "jwt")
+		c.Request = c.Request.WithContext(serviceauth.WithPrincipal(c.Request.Context(), claims.UserID))
 
 		c.Next()
 	}
@@ -269,8 +314,9 @@ func ContentTypeValidationMiddleware() gin.HandlerFunc {
 			contentType := c.GetHeader("Content-Type")
 			if !strings.Contains(contentType, "application/json") && !strings.Contains(contentType, "application/fhir+json") {
 				c.JSON(http.StatusUnsupportedMediaType, gin.H{
-					"error":   "unsupported_media_type",
-					"message": "Content-Type must be application/json or application/fhir+json",
+					"error":      "unsupported_media_type",
+					"message":    "Content-Type must be application/json or application/fhir+json",
+					"request_id": requestid.Get(c),
 				})
 				c.Abort()
 				return
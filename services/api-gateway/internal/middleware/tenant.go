@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CrossTenantAuditor records a rejected attempt by a caller scoped to tenantID
+// to reach data of the requested tenant
+type CrossTenantAuditor func(c *gin.Context, tenantID, requested string)
+
+// TenantMiddleware scopes authenticated requests to the tenant set by
+// AuthMiddleware or APIKeyMiddleware and forwards it to the services in the
+// X-Tenant-ID header. A caller bound to a tenant that names another one, in
+// the header or the payer_id query parameter, is rejected and audited. The
+// tenant only comes from verified credentials: callers without one cannot
+// pick one with the header, and are rejected when required is set.
+func TenantMiddleware(required bool, audit CrossTenantAuditor, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID := c.GetString("tenantID")
+		requested := c.GetHeader(tenant.Header)
+		if requested == "" {
+			requested = c.Query("payer_id")
+		}
+
+		if tenantID == "" {
+			if required {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error":      "tenant_required",
+					"message":    "Credentials are not scoped to a payer",
+					"request_id": requestid.Get(c),
+				})
+				return
+			}
+			c.Request.Header.Del(tenant.Header)
+			c.Next()
+			return
+		}
+
+		if !tenant.Valid(tenantID) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":      "invalid_tenant",
+				"message":    "Tenant IDs may only contain letters, digits, '-', '_' and '.'",
+				"request_id": requestid.Get(c),
+			})
+			return
+		}
+
+		if requested != "" && requested != tenantID {
+			logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+				"tenant_id":           tenantID,
+				"requested_tenant_id": requested,
+				"client_ip":           c.ClientIP(),
+			}).Warn("Rejected cross-tenant access")
+			if audit != nil {
+				audit(c, tenantID, requested)
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":      "cross_tenant_access",
+				"message":    "Access to data of another payer is not allowed",
+				"request_id": requestid.Get(c),
+			})
+			return
+		}
+
+		tenant.Set(c, tenantID)
+
+		c.Next()
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Authentication models
 
@@ -24,8 +27,9 @@ type RefreshTokenRequest struct {
 // Error response model
 
 type ErrorResponse struct {
-	Error   string `json:"error" example:"invalid_request"`
-	Message string `json:"message" example:"The request is missing a required parameter"`
+	Error     string `json:"error" example:"invalid_request"`
+	Message   string `json:"message" example:"The request is missing a required parameter"`
+	RequestID string `json:"request_id,omitempty" example:"01920f3e-7a4c-7cc2-9b1e-3f5a8d2c6e10"`
 }
 
 // Eligibility models
@@ -107,11 +111,30 @@ type DiagnosisCode struct {
 	Primary  bool   `json:"primary" example:"true"`
 }
 
+// Attachment is a document attached to a claim. Documents are uploaded to
+// /api/v1/attachments and referenced by URL; the gateway fills in their
+// content type, size and hash. Only small documents may be sent inline in
+// Data.
 type Attachment struct {
 	Type        string `json:"type" example:"medical_record"`
 	Description string `json:"description" example:"Patient X-ray"`
+	URL         string `json:"url,omitempty" example:"https://api.nphies.sa/api/v1/attachments/6f1c2a9e-3b4d-4e8f-9a1b-2c3d4e5f6a7b"`
 	ContentType string `json:"content_type" example:"image/jpeg"`
-	Data        string `json:"data" example:"base64_encoded_data"`
+	Size        int64  `json:"size,omitempty" example:"482133"`
+	SHA256      string `json:"sha256,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Data        string `json:"data,omitempty" example:"base64_encoded_data"`
+}
+
+// StoredAttachment describes an uploaded attachment
+type StoredAttachment struct {
+	ID          string    `json:"id" example:"6f1c2a9e-3b4d-4e8f-9a1b-2c3d4e5f6a7b"`
+	URL         string    `json:"url" example:"https://api.nphies.sa/api/v1/attachments/6f1c2a9e-3b4d-4e8f-9a1b-2c3d4e5f6a7b"`
+	Title       string    `json:"title,omitempty" example:"Chest X-ray report"`
+	ContentType string    `json:"content_type" example:"application/pdf"`
+	Size        int64     `json:"size" example:"482133"`
+	SHA256      string    `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	ScanStatus  string    `json:"scan_status" example:"clean"`
+	CreatedAt   time.Time `json:"created_at" example:"2025-08-13T10:30:00Z"`
 }
 
 type ClaimSubmissionResponse struct {
@@ -200,32 +223,50 @@ type SystemStats struct {
 	RequestStats RequestStatistics      `json:"request_stats"`
 	Resources    ResourceUtilization    `json:"resources"`
 	Dependencies DependencyStatus       `json:"dependencies"`
+	Platform     *PlatformStats         `json:"platform,omitempty"`
 }
 
 type RequestStatistics struct {
 	TotalRequests       int64   `json:"total_requests" example:"125000"`
 	RequestsPerSecond   float64 `json:"requests_per_second" example:"45.2"`
 	AverageResponseTime float64 `json:"average_response_time_ms" example:"120.5"`
+	P50ResponseTime     float64 `json:"p50_response_time_ms" example:"95.0"`
+	P95ResponseTime     float64 `json:"p95_response_time_ms" example:"250.0"`
+	P99ResponseTime     float64 `json:"p99_response_time_ms" example:"400.0"`
 	ErrorRate           float64 `json:"error_rate" example:"0.02"`
 }
 
 type ResourceUtilization struct {
-	CPUUsage    float64 `json:"cpu_usage_percent" example:"35.2"`
-	MemoryUsage float64 `json:"memory_usage_percent" example:"68.5"`
-	DiskUsage   float64 `json:"disk_usage_percent" example:"25.1"`
+	CPUUsage       float64 `json:"cpu_usage_percent" example:"35.2"`
+	MemoryUsage    float64 `json:"memory_usage_percent" example:"68.5"`
+	DiskUsage      float64 `json:"disk_usage_percent" example:"25.1"`
+	MemoryRSSBytes uint64  `json:"memory_rss_bytes" example:"73400320"`
+	HeapAllocBytes uint64  `json:"heap_alloc_bytes" example:"31457280"`
+	Goroutines     int     `json:"goroutines" example:"42"`
+	GCCycles       uint32  `json:"gc_cycles" example:"118"`
 }
 
 type DependencyStatus struct {
-	Database bool `json:"database" example:"true"`
-	Redis    bool `json:"redis" example:"true"`
-	Kafka    bool `json:"kafka" example:"true"`
+	Database bool            `json:"database" example:"true"`
+	Redis    bool            `json:"redis" example:"true"`
+	Kafka    bool            `json:"kafka" example:"true"`
+	Services map[string]bool `json:"services,omitempty"`
+}
+
+// PlatformStats aggregates the statistics reported by downstream services
+type PlatformStats struct {
+	TotalRequests       int64                      `json:"total_requests" example:"480000"`
+	RequestsPerSecond   float64                    `json:"requests_per_second" example:"160.4"`
+	ErrorRate           float64                    `json:"error_rate" example:"0.015"`
+	ServicesReporting   []string                   `json:"services_reporting"`
+	ServicesUnavailable []string                   `json:"services_unavailable,omitempty"`
+	Services            map[string]json.RawMessage `json:"services" swaggertype:"object"`
 }
 
 type AuditLogResponse struct {
 	Logs       []AuditLogEntry `json:"logs"`
-	TotalCount int             `json:"total_count" example:"1500"`
-	Page       int             `json:"page" example:"1"`
 	PageSize   int             `json:"page_size" example:"100"`
+	NextCursor string          `json:"next_cursor,omitempty" example:"MjAyNS0wOC0xM1QxMDozMDowMFp8..."`
 }
 
 type AuditLogEntry struct {
@@ -238,9 +279,246 @@ type AuditLogEntry struct {
 	Resource    string                 `json:"resource,omitempty" example:"Patient/123"`
 	Action      string                 `json:"action" example:"read"`
 	Status      string                 `json:"status" example:"success"`
+	RequestID   string                 `json:"request_id,omitempty" example:"01920f3e-7a4c-7cc2-9b1e-3f5a8d2c6e10"`
 	Data        map[string]interface{} `json:"data,omitempty"`
 }
 
+type AuditVerificationResponse struct {
+	Valid      bool                     `json:"valid" example:"true"`
+	From       *time.Time               `json:"from,omitempty" example:"2025-08-01T00:00:00Z"`
+	To         *time.Time               `json:"to,omitempty" example:"2025-09-01T00:00:00Z"`
+	Chains     []AuditChainVerification `json:"chains"`
+	VerifiedAt time.Time                `json:"verified_at" example:"2025-09-01T08:00:00Z"`
+}
+
+type AuditChainVerification struct {
+	Service            string           `json:"service" example:"api-gateway"`
+	Valid              bool             `json:"valid" example:"true"`
+	FirstSeq           int64            `json:"first_seq" example:"1"`
+	LastSeq            int64            `json:"last_seq" example:"15230"`
+	EntriesChecked     int64            `json:"entries_checked" example:"15230"`
+	CheckpointsChecked int              `json:"checkpoints_checked" example:"24"`
+	FirstBrokenLink    *AuditBrokenLink `json:"first_broken_link,omitempty"`
+}
+
+type AuditBrokenLink struct {
+	ChainSeq     int64  `json:"chain_seq" example:"1042"`
+	EventID      string `json:"event_id,omitempty" example:"evt-123456"`
+	Reason       string `json:"reason" example:"entry content does not match its hash"`
+	ExpectedHash string `json:"expected_hash,omitempty"`
+	ActualHash   string `json:"actual_hash,omitempty"`
+}
+
+// Poll models
+
+type PollRequest struct {
+	ProviderID   string   `json:"provider_id,omitempty" example:"PRV001"` // must match the credentials when given
+	MessageTypes []string `json:"message_types,omitempty" example:"ClaimResponse,Communication"`
+	Count        int      `json:"count,omitempty" example:"10"`
+}
+
+type PollResponse struct {
+	PollID     string        `json:"poll_id" example:"b6f4a0c2-6d1e-4a0e-9a57-3c1f7f1f9d10"`
+	ProviderID string        `json:"provider_id" example:"PRV001"`
+	Messages   []PollMessage `json:"messages"`
+	Remaining  int           `json:"remaining" example:"12"`
+	LeaseUntil time.Time     `json:"lease_until" example:"2025-08-13T10:35:00Z"`
+}
+
+type PollMessage struct {
+	MessageID     string          `json:"message_id" example:"4e2b8f6c-1a3d-4c5e-8f7a-9b0c1d2e3f40"`
+	MessageType   string          `json:"message_type" example:"ClaimResponse"`
+	ResourceID    string          `json:"resource_id,omitempty" example:"CLM123456"`
+	Resource      json.RawMessage `json:"resource" swaggertype:"object"`
+	DeliveryCount int             `json:"delivery_count" example:"1"`
+	CreatedAt     time.Time       `json:"created_at" example:"2025-08-13T10:30:00Z"`
+	ExpiresAt     time.Time       `json:"expires_at" example:"2025-09-12T10:30:00Z"`
+}
+
+type PollAckRequest struct {
+	ProviderID string   `json:"provider_id,omitempty" example:"PRV001"` // must match the credentials when given
+	MessageIDs []string `json:"message_ids" binding:"required" example:"4e2b8f6c-1a3d-4c5e-8f7a-9b0c1d2e3f40"`
+}
+
+type PollAckResponse struct {
+	Acknowledged int64 `json:"acknowledged" example:"10"`
+	Requested    int   `json:"requested" example:"10"`
+}
+
+// API key models
+
+type APIKey struct {
+	ID                     string     `json:"id" example:"7d1c2b3a-4e5f-4a6b-8c7d-9e0f1a2b3c4d"`
+	OrganizationID         string     `json:"organization_id" example:"0b6f1c2d-3e4f-4a5b-8c6d-7e8f9a0b1c2d"`
+	OrganizationIdentifier string     `json:"organization_identifier" example:"PRV001"`
+	Name                   string     `json:"name" example:"HIS integration"`
+	Prefix                 string     `json:"prefix" example:"nphies_3f9a1c2b7d4e"`
+	Scopes                 []string   `json:"scopes" example:"read,write"`
+	QuotaPerMinute         int        `json:"quota_per_minute" example:"600"`
+	Status                 string     `json:"status" example:"active"`
+	ExpiresAt              *time.Time `json:"expires_at,omitempty" example:"2026-08-13T10:30:00Z"`
+	RotatedFrom            string     `json:"rotated_from,omitempty" example:"5a4b3c2d-1e0f-4a9b-8c7d-6e5f4a3b2c1d"`
+	CreatedBy              string     `json:"created_by,omitempty" example:"admin@nphies.sa"`
+	CreatedAt              time.Time  `json:"created_at" example:"2025-08-13T10:30:00Z"`
+	RevokedAt              *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt             *time.Time `json:"last_used_at,omitempty" example:"2025-08-14T08:00:00Z"`
+	LastUsedIP             string     `json:"last_used_ip,omitempty" example:"10.20.30.40"`
+}
+
+// IssuedAPIKey is returned when a key is issued or rotated. The key itself is
+// not stored and cannot be retrieved again.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key" example:"nphies_3f9a1c2b7d4e_9c1f..."`
+}
+
+type CreateAPIKeyRequest struct {
+	OrganizationID string     `json:"organization_id" binding:"required,uuid" example:"0b6f1c2d-3e4f-4a5b-8c6d-7e8f9a0b1c2d"`
+	Name           string     `json:"name" binding:"required,max=255" example:"HIS integration"`
+	Scopes         []string   `json:"scopes" binding:"required,min=1" example:"read,write"`
+	QuotaPerMinute int        `json:"quota_per_minute,omitempty" binding:"min=0" example:"600"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty" example:"2026-08-13T10:30:00Z"`
+}
+
+type UpdateAPIKeyRequest struct {
+	Scopes         []string `json:"scopes,omitempty" example:"read"`
+	QuotaPerMinute *int     `json:"quota_per_minute,omitempty" binding:"omitempty,min=0" example:"120"`
+}
+
+type RotateAPIKeyRequest struct {
+	// How long the old key keeps working; defaults to the configured grace period
+	GracePeriodSeconds *int `json:"grace_period_seconds,omitempty" binding:"omitempty,min=0" example:"86400"`
+}
+
+type APIKeyListResponse struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// Access policy models
+
+type AccessPolicy struct {
+	ID          string           `json:"id" example:"payer-adjuster-adjudicate"`
+	Description string           `json:"description,omitempty" example:"Payer adjusters adjudicate claims of their payer"`
+	Effect      string           `json:"effect" example:"allow"`
+	Roles       []string         `json:"roles" example:"payer_adjuster"`
+	Actions     []string         `json:"actions" example:"read,write"`
+	Resources   []string         `json:"resources" example:"fhir.Claim,claims"`
+	Conditions  PolicyConditions `json:"conditions"`
+}
+
+type PolicyConditions struct {
+	SameTenant        bool     `json:"same_tenant,omitempty" example:"true"`
+	SameOrganization  bool     `json:"same_organization,omitempty"`
+	OrganizationTypes []string `json:"organization_types,omitempty" example:"payer"`
+	AuthMethods       []string `json:"auth_methods,omitempty" example:"jwt"`
+}
+
+type PolicyListResponse struct {
+	Source   string         `json:"source" example:"builtin"`
+	Policies []AccessPolicy `json:"policies"`
+}
+
+// PolicyEvaluationRequest describes a request to decide without performing it
+type PolicyEvaluationRequest struct {
+	Subject  PolicySubject  `json:"subject" binding:"required"`
+	Action   string         `json:"action" binding:"required,oneof=read write delete" example:"write"`
+	Resource PolicyResource `json:"resource" binding:"required"`
+}
+
+type PolicySubject struct {
+	Role             string `json:"role" binding:"required" example:"payer_adjuster"`
+	TenantID         string `json:"tenant_id,omitempty" example:"PAYER-001"`
+	OrganizationID   string `json:"organization_id,omitempty" example:"PAYER-001"`
+	OrganizationType string `json:"organization_type,omitempty" example:"payer"`
+	AuthMethod       string `json:"auth_method,omitempty" example:"jwt"`
+}
+
+// PolicyResource is evaluated as a loaded resource, with the tenant and
+// organization conditions checked, when either attribute is given
+type PolicyResource struct {
+	Type           string `json:"type" binding:"required" example:"fhir.Claim"`
+	TenantID       string `json:"tenant_id,omitempty" example:"PAYER-002"`
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+type PolicyEvaluationResponse struct {
+	Allowed  bool   `json:"allowed" example:"false"`
+	Effect   string `json:"effect" example:"deny"`
+	PolicyID string `json:"policy_id,omitempty"`
+	Reason   string `json:"reason" example:"no policy allows payer_adjuster to write fhir.Claim"`
+}
+
+// Break-glass models
+
+// BreakGlassRequest opens an emergency access session. The reason code must be
+// one of the configured codes.
+type BreakGlassRequest struct {
+	ReasonCode      string `json:"reason_code" binding:"required" example:"emergency_treatment"`
+	Justification   string `json:"justification" binding:"required,min=20,max=2000" example:"Unconscious patient in ER, verifying coverage before surgery"`
+	TenantID        string `json:"tenant_id" binding:"required" example:"PAYER-002"`   // payer whose data is needed
+	PatientID       string `json:"patient_id" binding:"required" example:"1234567890"` // the only patient the token may read
+	DurationMinutes int    `json:"duration_minutes,omitempty" binding:"min=0" example:"30"`
+}
+
+// BreakGlassTokenResponse carries the elevated token of a new session
+type BreakGlassTokenResponse struct {
+	AccessToken string            `json:"access_token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	TokenType   string            `json:"token_type" example:"Bearer"`
+	ExpiresIn   int               `json:"expires_in" example:"1800"`
+	Session     BreakGlassSession `json:"session"`
+}
+
+type BreakGlassSession struct {
+	ID             string     `json:"id" example:"3c2b1a0f-9e8d-4c7b-a6f5-e4d3c2b1a0f9"`
+	UserID         string     `json:"user_id" example:"dr.ahmed@hospital.sa"`
+	Role           string     `json:"role" example:"provider_clerk"`
+	HomeTenantID   string     `json:"home_tenant_id,omitempty" example:"PAYER-001"`
+	TenantID       string     `json:"tenant_id,omitempty" example:"PAYER-002"`
+	OrganizationID string     `json:"organization_id,omitempty" example:"PRV001"`
+	PatientID      string     `json:"patient_id,omitempty" example:"1234567890"`
+	ReasonCode     string     `json:"reason_code" example:"emergency_treatment"`
+	Justification  string     `json:"justification" example:"Unconscious patient in ER, verifying coverage before surgery"`
+	Status         string     `json:"status" example:"active"`
+	Active         bool       `json:"active" example:"true"`
+	ClientIP       string     `json:"client_ip,omitempty" example:"10.20.30.40"`
+	ExpiresAt      time.Time  `json:"expires_at" example:"2025-08-13T11:00:00Z"`
+	CreatedAt      time.Time  `json:"created_at" example:"2025-08-13T10:30:00Z"`
+	UseCount       int        `json:"use_count" example:"4"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy      string     `json:"revoked_by,omitempty"`
+	ReviewStatus   string     `json:"review_status" example:"pending"`
+	ReviewedBy     string     `json:"reviewed_by,omitempty"`
+	ReviewedAt     *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNotes    string     `json:"review_notes,omitempty"`
+}
+
+type BreakGlassSessionListResponse struct {
+	Sessions []BreakGlassSession `json:"sessions"`
+}
+
+// BreakGlassReviewRequest signs off a session
+type BreakGlassReviewRequest struct {
+	Outcome string `json:"outcome" binding:"required,oneof=approved flagged" example:"approved"`
+	Notes   string `json:"notes,omitempty" binding:"max=2000" example:"Confirmed with ER attending"`
+}
+
+// BulkExportManifest lists the files of a completed FHIR Bulk Data export
+type BulkExportManifest struct {
+	TransactionTime     string             `json:"transactionTime" example:"2024-01-15T10:30:00Z"`
+	Request             string             `json:"request" example:"https://api.nphies.sa/api/v1/fhir/$export?_type=Coverage"`
+	RequiresAccessToken bool               `json:"requiresAccessToken" example:"true"`
+	Output              []BulkExportOutput `json:"output"`
+	Error               []BulkExportOutput `json:"error"`
+}
+
+// BulkExportOutput is an NDJSON file of one resource type
+type BulkExportOutput struct {
+	Type  string `json:"type" example:"Coverage"`
+	URL   string `json:"url" example:"https://api.nphies.sa/api/v1/fhir/bulk/files/4f1c.../Coverage.ndjson"`
+	Count int    `json:"count,omitempty" example:"1250"`
+}
+
 // Common models
 
 type ResponseMessage struct {
@@ -248,4 +526,24 @@ type ResponseMessage struct {
 	Code    string `json:"code" example:"INFO001"`
 	Message string `json:"message" example:"Request processed successfully"`
 	Details string `json:"details,omitempty"`
-}
\ No newline at end of file
+}
+
+// CacheInvalidationRequest selects the cache keys to invalidate. An empty
+// request invalidates every key of the gateway.
+type CacheInvalidationRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	MemberID  string `json:"member_id,omitempty" example:"1234567890"`
+	Pattern   string `json:"pattern,omitempty" example:"provider-*"`
+	TenantID  string `json:"tenant_id,omitempty" example:"PAYER-001"` // restrict to one tenant's keys
+	DryRun    bool   `json:"dry_run" example:"true"`
+}
+
+// CacheInvalidationResponse reports the keys an invalidation matched
+type CacheInvalidationResponse struct {
+	Patterns    []string `json:"patterns" example:"api-gateway:*:1234567890:*"`
+	DryRun      bool     `json:"dry_run" example:"true"`
+	MatchedKeys int64    `json:"matched_keys" example:"12"`
+	DeletedKeys int64    `json:"deleted_keys" example:"0"`
+	Keys        []string `json:"keys,omitempty"`
+	Truncated   bool     `json:"truncated,omitempty"`
+}
@@ -0,0 +1,96 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// exemptPaths are served without a client certificate so that probes and
+// scrapers do not need one
+var exemptPaths = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// PeerCertificate returns the verified client certificate of a request, or
+// nil if the client did not present one
+func PeerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// Identities returns the names a certificate can be mapped by, most specific
+// first: SAN URIs, DNS names and email addresses, then the subject common
+// name and the full subject DN, e.g. "URI:spiffe://nphies/provider/PRV001",
+// "DNS:clinic.example.sa", "CN:clinic-a" or "SUBJECT:CN=clinic-a,O=Clinic A"
+func Identities(cert *x509.Certificate) []string {
+	var identities []string
+	for _, uri := range cert.URIs {
+		identities = append(identities, "URI:"+uri.String())
+	}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, "EMAIL:"+email)
+	}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, "CN:"+cert.Subject.CommonName)
+	}
+	return append(identities, "SUBJECT:"+cert.Subject.String())
+}
+
+// ParseClientMap parses semicolon separated identity=clientID entries, e.g.
+// "DNS:clinic.example.sa=PRV001;CN:payer-gw=PAY001". The client ID follows
+// the last '=' so that subject DNs can be mapped as well.
+func ParseClientMap(spec string) (map[string]string, error) {
+	clients := make(map[string]string)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		invalid := fmt.Errorf("invalid client certificate mapping %q, expected KIND:value=clientID", entry)
+
+		idx := strings.LastIndex(entry, "=")
+		if idx <= 0 {
+			return nil, invalid
+		}
+		clientID := strings.TrimSpace(entry[idx+1:])
+		kind, value, ok := strings.Cut(strings.TrimSpace(entry[:idx]), ":")
+		if !ok || value == "" || clientID == "" {
+			return nil, invalid
+		}
+		clients[strings.ToUpper(kind)+":"+value] = clientID
+	}
+	return clients, nil
+}
+
+// RequireClientCert rejects requests without a verified client certificate.
+// Health, readiness and metrics endpoints are exempt.
+func RequireClientCert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if exemptPaths[c.Request.URL.Path] || PeerCertificate(c.Request) != nil {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error":      "client_certificate_required",
+			"message":    "A valid client certificate is required",
+			"request_id": requestid.Get(c),
+		})
+	}
+}
+
+// Exempt reports whether path is served without a client certificate
+func Exempt(path string) bool {
+	return exemptPaths[path]
+}
@@ -0,0 +1,354 @@
+package mtls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// authority is a CA generated for a test
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key
+}
+
+func newAuthority(t *testing.T, name string) *authority {
+	t.Helper()
+	serial++
+	key := newKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse CA: %v", err)
+	}
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a leaf certificate and returns it with its PEM certificate and
+// key
+func (a *authority) issue(t *testing.T, template *x509.Certificate) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+	serial++
+	key := newKey(t)
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newStore writes a certificate issued by issuer and the CA bundle to dir and
+// loads them
+func newStore(t *testing.T, dir string, issuer *authority, template *x509.Certificate, ca *authority) *Store {
+	t.Helper()
+	certFile, keyFile, caFile := writeFiles(t, dir, issuer, template, ca)
+	store, err := NewStore(certFile, keyFile, []string{caFile}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	return store
+}
+
+func writeFiles(t *testing.T, dir string, issuer *authority, template *x509.Certificate, ca *authority) (string, string, string) {
+	t.Helper()
+	_, certPEM, keyPEM := issuer.issue(t, template)
+	files := map[string][]byte{
+		"tls.crt": certPEM,
+		"tls.key": keyPEM,
+		"ca.crt":  ca.pem,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+}
+
+// handshake connects a client to a server and returns the server's handshake
+// error and connection state
+func handshake(server, client *tls.Config) (tls.ConnectionState, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	go func() {
+		defer clientConn.Close()
+		conn := tls.Client(clientConn, client)
+		if conn.Handshake() == nil {
+			// Under TLS 1.3 the client finishes first; wait for the server
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	conn := tls.Server(serverConn, server)
+	err := conn.Handshake()
+	return conn.ConnectionState(), err
+}
+
+func TestParseClientMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", "", map[string]string{}, false},
+		{
+			"several kinds",
+			" dns:clinic.example.sa=PRV001 ; CN:payer-gw = PAY001;URI:spiffe://nphies/provider/PRV002=PRV002;",
+			map[string]string{
+				"DNS:clinic.example.sa":               "PRV001",
+				"CN:payer-gw":                         "PAY001",
+				"URI:spiffe://nphies/provider/PRV002": "PRV002",
+			},
+			false,
+		},
+		{
+			"subject DN",
+			"SUBJECT:CN=clinic-a,O=Clinic A=PRV003",
+			map[string]string{"SUBJECT:CN=clinic-a,O=Clinic A": "PRV003"},
+			false,
+		},
+		{"missing client ID", "CN:payer-gw=", nil, true},
+		{"missing kind", "payer-gw=PAY001", nil, true},
+		{"missing value", "CN:=PAY001", nil, true},
+		{"missing identity", "=PAY001", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseClientMap(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ParseClientMap(%q) error = %v, wantErr %v", tt.name, tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ParseClientMap(%q) = %v, want %v", tt.name, tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestIdentities(t *testing.T) {
+	ca := newAuthority(t, "Test CA")
+	spiffe, _ := url.Parse("spiffe://nphies/provider/PRV001")
+	cert, _, _ := ca.issue(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "clinic-a", Organization: []string{"Clinic A"}},
+		URIs:           []*url.URL{spiffe},
+		DNSNames:       []string{"clinic.example.sa"},
+		EmailAddresses: []string{"it@clinic.example.sa"},
+	})
+
+	want := []string{
+		"URI:spiffe://nphies/provider/PRV001",
+		"DNS:clinic.example.sa",
+		"EMAIL:it@clinic.example.sa",
+		"CN:clinic-a",
+		"SUBJECT:CN=clinic-a,O=Clinic A",
+	}
+	if got := Identities(cert); !reflect.DeepEqual(got, want) {
+		t.Errorf("Identities = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		want string
+	}{
+		{"by SAN URI", "URI:spiffe://nphies/provider/PRV001=PRV001;CN:clinic-a=OTHER", "PRV001"},
+		{"by DNS name", "DNS:clinic.example.sa=PRV002", "PRV002"},
+		{"by email", "EMAIL:it@clinic.example.sa=PRV003", "PRV003"},
+		{"by common name", "CN:clinic-a=PRV004", "PRV004"},
+		{"by subject", "SUBJECT:CN=clinic-a,O=Clinic A=PRV005", "PRV005"},
+		{"unmapped", "CN:clinic-b=PRV006", ""},
+	}
+	for _, tt := range tests {
+		clients, err := ParseClientMap(tt.spec)
+		if err != nil {
+			t.Fatalf("%s: ParseClientMap failed: %v", tt.name, err)
+		}
+		got := ""
+		for _, identity := range Identities(cert) {
+			if clientID, ok := clients[identity]; ok {
+				got = clientID
+				break
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%s: mapped client = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestServerConfigVerifiesClients(t *testing.T) {
+	ca := newAuthority(t, "Test CA")
+	unknown := newAuthority(t, "Unknown CA")
+	server := newStore(t, t.TempDir(), ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "gateway"},
+		DNSNames:    []string{"gateway.nphies.local"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, ca)
+
+	tests := []struct {
+		name     string
+		issuer   *authority
+		wantErr  bool
+		wantPeer string
+	}{
+		{"trusted CA", ca, false, "clinic-a"},
+		{"unknown CA", unknown, true, ""},
+	}
+	for _, tt := range tests {
+		client := newStore(t, t.TempDir(), tt.issuer, &x509.Certificate{Subject: pkix.Name{CommonName: "clinic-a"}}, ca)
+		config := client.ClientConfig()
+		config.ServerName = "gateway.nphies.local"
+
+		state, err := handshake(server.ServerConfig(true), config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: handshake error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(state.VerifiedChains) == 0 || state.VerifiedChains[0][0].Subject.CommonName != tt.wantPeer {
+			t.Errorf("%s: verified chains = %v, want a chain for %s", tt.name, state.VerifiedChains, tt.wantPeer)
+		}
+	}
+}
+
+func TestWatchReloadsRotatedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	oldCA := newAuthority(t, "Old CA")
+	newCA := newAuthority(t, "New CA")
+	template := func() *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}, DNSNames: []string{"gateway.nphies.local"}}
+	}
+	server := newStore(t, dir, oldCA, template(), oldCA)
+	before := server.Certificate()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Watch(ctx, 10*time.Millisecond)
+
+	// Rotate the certificate and the CA bundle, moving the modification
+	// times forward in case the file system's resolution hides the change
+	certFile, keyFile, caFile := writeFiles(t, dir, newCA, template(), newCA)
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile, caFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatalf("failed to touch %s: %v", file, err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for server.Certificate() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("rotated certificate was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	leaf, err := x509.ParseCertificate(server.Certificate().Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse reloaded certificate: %v", err)
+	}
+	if leaf.Issuer.CommonName != "New CA" {
+		t.Errorf("reloaded certificate issuer = %q, want New CA", leaf.Issuer.CommonName)
+	}
+
+	// Clients are now verified against the rotated CA bundle
+	tests := []struct {
+		name    string
+		issuer  *authority
+		wantErr bool
+	}{
+		{"client of the new CA", newCA, false},
+		{"client of the old CA", oldCA, true},
+	}
+	for _, tt := range tests {
+		client := newStore(t, t.TempDir(), tt.issuer, &x509.Certificate{Subject: pkix.Name{CommonName: "clinic-a"}}, newCA)
+		config := client.ClientConfig()
+		config.ServerName = "gateway.nphies.local"
+		if _, err := handshake(server.ServerConfig(true), config); (err != nil) != tt.wantErr {
+			t.Errorf("%s: handshake error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWatchKeepsCertificateOnFailedReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newAuthority(t, "Test CA")
+	server := newStore(t, dir, ca, &x509.Certificate{Subject: pkix.Name{CommonName: "gateway"}}, ca)
+	before := server.Certificate()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Watch(ctx, 10*time.Millisecond)
+
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(keyFile, later, later); err != nil {
+		t.Fatalf("failed to touch key: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if server.Certificate() != before {
+		t.Error("certificate was replaced by a failed reload")
+	}
+}
@@ -0,0 +1,213 @@
+// Package mtls serves TLS with optional client certificate verification and
+// reloads certificates and CA bundles when their files change, so that
+// rotated certificates are picked up without a restart.
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store holds a certificate and the CA pool used to verify peers, reloading
+// both when the underlying files change
+type Store struct {
+	certFile string
+	keyFile  string
+	caFiles  []string
+	logger   *logrus.Logger
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool // nil means the system roots
+	modTimes map[string]time.Time
+}
+
+// NewStore loads a certificate, its key and the CA bundles trusted for peers.
+// Without CA files, servers are verified against the system roots and client
+// certificates cannot be verified.
+func NewStore(certFile, keyFile string, caFiles []string, logger *logrus.Logger) (*Store, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS requires a certificate and a key file")
+	}
+
+	s := &Store{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFiles:  caFiles,
+		logger:   logger,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads all files and swaps them in together
+func (s *Store) load() error {
+	modTimes, err := s.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", s.certFile, err)
+	}
+
+	var pool *x509.CertPool
+	if len(s.caFiles) > 0 {
+		pool = x509.NewCertPool()
+		for _, file := range s.caFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read CA bundle %s: %w", file, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in CA bundle %s", file)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.cert = &cert
+	s.pool = pool
+	s.modTimes = modTimes
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Store) stat() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, file := range append([]string{s.certFile, s.keyFile}, s.caFiles...) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// changed reports whether any file was modified since it was last loaded
+func (s *Store) changed() bool {
+	modTimes, err := s.stat()
+	if err != nil {
+		// Mid-rotation a file may briefly be missing; try again next tick
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for file, modTime := range modTimes {
+		if !modTime.Equal(s.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch polls the files until the context is cancelled and reloads them when
+// they change. Polling rather than file notifications also follows the
+// symlink swaps Kubernetes uses to update mounted secrets. A failed reload
+// keeps the previous certificate.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+			if err := s.load(); err != nil {
+				s.logger.WithError(err).Error("Failed to reload TLS certificates")
+				continue
+			}
+			s.logger.WithField("cert_file", s.certFile).Info("Reloaded TLS certificates")
+		}
+	}
+}
+
+// Certificate returns the current certificate
+func (s *Store) Certificate() *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert
+}
+
+// roots returns the current CA pool
+func (s *Store) roots() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pool
+}
+
+// ServerConfig returns a server TLS configuration that always presents the
+// current certificate. With verifyClients, client certificates are verified
+// against the current CA pool when presented; requests without one are left to
+// RequireClientCert, so that health probes keep working.
+func (s *Store) ServerConfig(verifyClients bool) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.Certificate(), nil
+		},
+	}
+	if verifyClients {
+		// ClientCAs is read once per handshake, so a per-connection config
+		// is needed to follow CA reloads
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			connConfig := config.Clone()
+			connConfig.GetConfigForClient = nil
+			connConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			connConfig.ClientCAs = s.roots()
+			return connConfig, nil
+		}
+	}
+	return config
+}
+
+// ClientConfig returns a client TLS configuration that presents the current
+// certificate and verifies servers against the current CA pool
+func (s *Store) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.Certificate(), nil
+		},
+		// RootCAs is fixed once the transport is built, so the built-in
+		// verification is replaced by VerifyConnection, which uses the
+		// current pool and performs the same chain and hostname checks
+		InsecureSkipVerify: true,
+		VerifyConnection:   s.verifyServer,
+	}
+}
+
+func (s *Store) verifyServer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         s.roots(),
+		DNSName:       state.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
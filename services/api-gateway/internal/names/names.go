@@ -0,0 +1,288 @@
+// Package names normalizes personal names for search and matching. Arabic
+// names are written with or without diacritics, with several forms of alef
+// and hamza, with ta marbuta or ha and with or without the "ال" article, and
+// are transliterated into Latin in many ways. Normalize folds the spelling
+// variants within a script; Key reduces a name part to a phonetic key shared
+// by its Arabic and Latin spellings, so that العتيبي, Al-Otaibi and
+// Alotaibi, or محمد, Mohammed and Muhammad, have the same key.
+package names
+
+import (
+	"strings"
+	"unicode"
+)
+
+// folds maps letter variants to the form names are compared in: Arabic alef,
+// hamza, ya and ta marbuta forms, Persian letters used in Arabic names and
+// accented Latin letters of transliterations
+var folds = map[rune]rune{
+	'أ': 'ا', 'إ': 'ا', 'آ': 'ا', 'ٱ': 'ا',
+	'ؤ': 'و', 'ئ': 'ي', 'ى': 'ي', 'ة': 'ه',
+	'ک': 'ك', 'ی': 'ي', 'ې': 'ي', 'ە': 'ه',
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a',
+	'ç': 'c',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ē': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ī': 'i',
+	'ñ': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ō': 'o',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u',
+	'ý': 'y', 'ÿ': 'y',
+	'ḥ': 'h', 'ṣ': 's', 'ḍ': 'd', 'ṭ': 't', 'ẓ': 'z', 'ġ': 'g',
+}
+
+// dropped are written hamza on the line and the marks transliterations use
+// for ayn and hamza, which are left out rather than split a word
+var dropped = map[rune]bool{
+	'ء': true, 'ـ': true,
+	'\'': true, '`': true, 'ʿ': true, 'ʾ': true, '‘': true, '’': true,
+}
+
+// connectors are the words for "son of", "daughter of" and "family of" (آل)
+// and the detached article, which some spellings of a name carry and others
+// leave out
+var connectors = map[string]bool{
+	"بن": true, "ابن": true, "بنت": true, "ال": true,
+	"bin": true, "ibn": true, "bint": true, "bn": true,
+	"al": true, "el": true,
+	// The article assimilated to a sun letter, as in ar-Rashid
+	"ad": true, "adh": true, "an": true, "ar": true, "as": true, "ash": true,
+	"at": true, "ath": true, "az": true,
+}
+
+// compounds are the first words of compound names, e.g. عبد الرحمن and
+// Abu Bakr, written joined or apart
+var compounds = map[string]bool{
+	"عبد": true, "ابو": true,
+	"abd": true, "abdul": true, "abdel": true, "abdal": true, "abdol": true, "abdu": true,
+	"abu": true, "abou": true,
+}
+
+// Normalize folds a name to lower case without diacritics, with one form of
+// each Arabic letter variant and with words separated by single spaces
+func Normalize(name string) string {
+	folded := strings.Map(func(r rune) rune {
+		if dropped[r] {
+			return -1
+		}
+		r = unicode.ToLower(r)
+		if folded, ok := folds[r]; ok {
+			return folded
+		}
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Arabic harakat, shadda, sukun and superscript alef
+			return -1
+		case unicode.IsLetter(r):
+			return r
+		default:
+			return ' '
+		}
+	}, name)
+	return strings.Join(strings.Fields(folded), " ")
+}
+
+// Tokens returns the normalized parts of a name, without connectors and
+// articles and with compound names joined
+func Tokens(name string) []string {
+	var words []string
+	for _, word := range strings.Fields(Normalize(name)) {
+		if !connectors[word] {
+			words = append(words, word)
+		}
+	}
+
+	var tokens []string
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if compounds[word] && i+1 < len(words) {
+			word += words[i+1]
+			i++
+		}
+		tokens = append(tokens, stripArticle(word))
+	}
+	return tokens
+}
+
+// Keys returns the distinct phonetic keys of the parts of a name. Parts
+// without a key are left out, so a name may have none.
+func Keys(name string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, token := range Tokens(name) {
+		if key := Key(token); key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// stripArticle removes the article from the start of a name part and from
+// the second word of a joined compound: العتيبي and Alotaibi become عتيبي
+// and otaibi, عبدالرحمن and Abdulrahman become عبدرحمن and abdrahman
+func stripArticle(token string) string {
+	for _, prefix := range []string{"عبد", "ابو"} {
+		if rest := strings.TrimPrefix(token, prefix); rest != token {
+			return prefix + stripArabicArticle(rest)
+		}
+	}
+	if strings.HasPrefix(token, "abd") && len(token) > 3 {
+		rest := token[3:]
+		for _, article := range []string{"ul", "el", "al", "ol", "u", "e", "a", "o"} {
+			if strings.HasPrefix(rest, article) && len(rest)-len(article) >= 3 {
+				rest = rest[len(article):]
+				break
+			}
+		}
+		return "abd" + rest
+	}
+	if rest := stripArabicArticle(token); rest != token {
+		return rest
+	}
+
+	// Al-, El- and the article assimilated to a doubled sun letter, as in
+	// Arrashid, only from Latin spellings of Arabic names: names such as Ali,
+	// Alaa and Alyaa are too short to carry it, and Allen and Alexander do
+	// not carry it
+	if (strings.HasPrefix(token, "al") || strings.HasPrefix(token, "el")) && len(token) >= 6 && arabicShaped(token[2:]) {
+		return token[2:]
+	}
+	if len(token) >= 5 && token[0] == 'a' && token[1] == token[2] && strings.ContainsRune(sunLetters, rune(token[1])) && arabicShaped(token[2:]) {
+		return token[2:]
+	}
+	return token
+}
+
+// sunLetters are the Latin letters of the sun letters the article is
+// assimilated to in doubled spellings. Lam is left out: Allatif keeps its
+// article as Al-, and Allen has none.
+const sunLetters = "tdrzsn"
+
+// arabicShaped reports whether a Latin word could spell an Arabic name: it
+// has none of the letters transliterations do not use and does not start
+// with two consonants other than a digraph
+func arabicShaped(word string) bool {
+	if strings.ContainsAny(word, "cpvx") {
+		return false
+	}
+	for _, r := range word {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	if len(word) >= 2 && !isVowel(rune(word[0])) && !isVowel(rune(word[1])) {
+		if _, ok := latinDigraphs[word[:2]]; !ok && word[0] != word[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// stripArabicArticle removes ال from a word that is longer than the article
+func stripArabicArticle(word string) string {
+	if rest := strings.TrimPrefix(word, "ال"); rest != word && len([]rune(rest)) >= 2 {
+		return rest
+	}
+	return word
+}
+
+// arabicKeys are the consonant classes of Arabic letters. Emphatic and plain
+// letters commonly transliterated alike share a class; ayn and alef have
+// none. Waw and ya are consonants only at the start of a word. Tha is
+// transliterated th or s, as in Othman and Osman, and dhal and za dh, d or z,
+// as in Dhikra and Zikra, so they share the classes of both.
+var arabicKeys = map[rune]byte{
+	'ب': 'B', 'پ': 'B',
+	'ت': 'T', 'ط': 'T',
+	'ج': 'J', 'چ': 'J',
+	'ح': 'H', 'ه': 'H',
+	'خ': 'K', 'ق': 'K', 'ك': 'K', 'گ': 'K',
+	'د': 'D', 'ذ': 'D', 'ض': 'D', 'ظ': 'D', 'ز': 'D',
+	'ر': 'R',
+	'س': 'S', 'ص': 'S', 'ث': 'S', 'ش': 'X',
+	'غ': 'G',
+	'ف': 'F', 'ڤ': 'F',
+	'ل': 'L', 'م': 'M', 'ن': 'N',
+}
+
+// latinDigraphs are the two-letter transliterations of single Arabic letters
+var latinDigraphs = map[string]byte{
+	"kh": 'K', "sh": 'X', "ch": 'X', "th": 'S', "dh": 'D', "gh": 'G', "ph": 'F',
+}
+
+// latinKeys are the consonant classes of Latin letters, matching arabicKeys
+var latinKeys = map[rune]byte{
+	'b': 'B', 'p': 'B',
+	't': 'T',
+	'j': 'J', 'g': 'J',
+	'h': 'H',
+	'k': 'K', 'q': 'K', 'c': 'K',
+	'd': 'D', 'z': 'D',
+	'r': 'R',
+	's': 'S',
+	'f': 'F', 'v': 'F',
+	'l': 'L', 'm': 'M', 'n': 'N',
+}
+
+// minKeyLength is the fewest consonant classes a key has. Shorter keys, such
+// as the L of Ali, Alaa and Ella, are shared by too many unrelated names to
+// match or index them by.
+const minKeyLength = 2
+
+// Key returns the phonetic key of a normalized name part: its consonant
+// classes, with vowels, ayn and hamza left out, repeated classes collapsed
+// and a final ha or ta marbuta dropped. Arabic and Latin spellings of a name
+// share a key. Parts with fewer than minKeyLength classes have no key.
+func Key(token string) string {
+	runes := []rune(token)
+	var key []byte
+	emit := func(class byte) {
+		if len(key) == 0 || key[len(key)-1] != class {
+			key = append(key, class)
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if class, ok := arabicKeys[r]; ok {
+			emit(class)
+			continue
+		}
+		if i+1 < len(runes) {
+			if class, ok := latinDigraphs[string(runes[i:i+2])]; ok {
+				emit(class)
+				i++
+				continue
+			}
+		}
+		if class, ok := latinKeys[r]; ok {
+			emit(class)
+			continue
+		}
+		switch r {
+		case 'و', 'w':
+			if i == 0 {
+				emit('W')
+			}
+		case 'ي', 'y':
+			if i == 0 {
+				emit('Y')
+			}
+		case 'x':
+			emit('K')
+			emit('S')
+		}
+	}
+
+	if len(key) > 1 && key[len(key)-1] == 'H' {
+		key = key[:len(key)-1]
+	}
+	if len(key) < minKeyLength {
+		return ""
+	}
+	return string(key)
+}
+
+func isVowel(r rune) bool {
+	return strings.ContainsRune("aeiouy", r)
+}
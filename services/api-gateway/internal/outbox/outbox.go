@@ -0,0 +1,78 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tracing"
+)
+
+// Event statuses
+const (
+	StatusPending   = "pending"
+	StatusPublished = "published"
+	StatusFailed    = "failed"
+)
+
+// Header names set on relayed Kafka messages
+const (
+	HeaderEventID       = "event-id"
+	HeaderEventType     = "event-type"
+	HeaderAggregateType = "aggregate-type"
+)
+
+// Execer is satisfied by both *sql.DB and *sql.Tx, so events can be written
+// in the same transaction as the state change they describe
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Event is a message waiting to be relayed to Kafka
+type Event struct {
+	ID            string          // unique event ID, used by consumers for deduplication
+	Topic         string          // destination Kafka topic
+	AggregateType string          // e.g. "audit", "coverage"
+	AggregateID   string          // Kafka message key; ordering is preserved per aggregate
+	EventType     string          // e.g. "coverage.create"
+	Payload       json.RawMessage // message value
+}
+
+// Add writes an event to the outbox
+func Add(ctx context.Context, exec Execer, event Event) error {
+	if event.ID == "" || event.Topic == "" || event.AggregateID == "" {
+		return errors.New("outbox event requires an ID, topic and aggregate ID")
+	}
+
+	// The trace context and request ID of the request are stored with the
+	// event so that consumers continue the same trace once the relay
+	// publishes it
+	var traceContext []byte
+	carrier := tracing.InjectMap(ctx)
+	requestid.InjectMap(ctx, carrier)
+	if len(carrier) > 0 {
+		encoded, err := json.Marshal(carrier)
+		if err != nil {
+			return err
+		}
+		traceContext = encoded
+	}
+
+	query := `
+		INSERT INTO outbox_events (id, topic, aggregate_type, aggregate_id, event_type, payload, trace_context)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := exec.ExecContext(ctx, query,
+		event.ID,
+		event.Topic,
+		event.AggregateType,
+		event.AggregateID,
+		event.EventType,
+		[]byte(event.Payload),
+		traceContext,
+	)
+	return err
+}
@@ -0,0 +1,187 @@
+package policy
+
+// Resource types of the gateway routes
+const (
+	ResourcePatient         = "fhir.Patient"
+	ResourceCoverage        = "fhir.Coverage"
+	ResourceClaim           = "fhir.Claim"
+	ResourceClaimResponse   = "fhir.ClaimResponse"
+	ResourcePriorAuth       = "fhir.CoverageEligibilityRequest"
+	ResourceSubscription    = "fhir.Subscription"
+	ResourceEligibility     = "eligibility"
+	ResourceClaimsProxy     = "claims"
+	ResourcePoll            = "poll"
+	ResourceTerminology     = "terminology"
+	ResourceBreakGlass      = "break-glass"
+	ResourceBulkExport      = "bulk-export"
+	ResourceAttachment      = "attachment"
+	ResourceAdminStats      = "admin.stats"
+	ResourceAdminAudit      = "admin.audit"
+	ResourceAdminCache      = "admin.cache"
+	ResourceAdminAPIKeys    = "admin.api-keys"
+	ResourceAdminPolicies   = "admin.policies"
+	ResourceAdminBreakGlass = "admin.break-glass"
+)
+
+// DefaultPolicies are used when no policy file or table is configured. They
+// keep token holders and API clients on the non-admin routes they have always
+// had and grant the named roles their duties.
+//
+// Route checks do not know which tenant or organization a resource belongs
+// to, so policies of route resources carry no SameTenant or SameOrganization
+// condition: TenantMiddleware confines those routes to the tenant of the
+// caller's credentials, and the services scope their data to it. Only
+// attachments are checked against their owners, by their handlers.
+func DefaultPolicies() []Policy {
+	return []Policy{
+		{
+			ID:          "deny-api-clients-admin",
+			Description: "API keys never reach administrative endpoints",
+			Effect:      EffectDeny,
+			Roles:       []string{RoleAPIClient},
+			Actions:     []string{"*"},
+			Resources:   []string{"admin.*"},
+		},
+		{
+			ID:          "admin-full-access",
+			Description: "Administrators may do everything",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleAdmin},
+			Actions:     []string{"*"},
+			Resources:   []string{"*"},
+		},
+		{
+			ID:          "auditor-read-audit",
+			Description: "Auditors read the audit trail, statistics, policies and break-glass sessions",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleAuditor},
+			Actions:     []string{ActionRead},
+			Resources:   []string{ResourceAdminAudit, ResourceAdminStats, ResourceAdminPolicies, ResourceAdminBreakGlass},
+		},
+		{
+			ID:          "provider-clerk-submit",
+			Description: "Provider clerks register patients and submit claims and prior authorizations",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleProviderClerk},
+			Actions:     []string{ActionRead, ActionWrite},
+			Resources: []string{
+				ResourcePatient, ResourceClaim, ResourcePriorAuth,
+				ResourceEligibility, ResourceClaimsProxy, ResourcePoll,
+			},
+		},
+		{
+			ID:          "provider-clerk-attachments",
+			Description: "Provider clerks upload claim attachments and read those of their organization",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleProviderClerk},
+			Actions:     []string{ActionRead, ActionWrite},
+			Resources:   []string{ResourceAttachment},
+			Conditions:  Conditions{SameOrganization: true},
+		},
+		{
+			ID:          "provider-clerk-read",
+			Description: "Provider clerks read coverage, claim responses and code systems",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleProviderClerk},
+			Actions:     []string{ActionRead},
+			Resources:   []string{ResourceCoverage, ResourceClaimResponse, ResourceTerminology},
+		},
+		{
+			ID:          "provider-clerk-subscriptions",
+			Description: "Provider clerks manage subscriptions",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleProviderClerk},
+			Actions:     []string{ActionRead, ActionWrite, ActionDelete},
+			Resources:   []string{ResourceSubscription},
+		},
+		{
+			ID:          "payer-adjuster-adjudicate",
+			Description: "Payer adjusters manage coverage and adjudicate claims",
+			Effect:      EffectAllow,
+			Roles:       []string{RolePayerAdjuster},
+			Actions:     []string{ActionRead, ActionWrite},
+			Resources: []string{
+				ResourceCoverage, ResourceClaim, ResourceClaimResponse,
+				ResourceEligibility, ResourceClaimsProxy, ResourcePoll,
+			},
+		},
+		{
+			ID:          "payer-adjuster-read",
+			Description: "Payer adjusters read patients, prior authorizations and code systems",
+			Effect:      EffectAllow,
+			Roles:       []string{RolePayerAdjuster},
+			Actions:     []string{ActionRead},
+			Resources:   []string{ResourcePatient, ResourcePriorAuth, ResourceTerminology},
+		},
+		{
+			ID:          "payer-adjuster-attachments",
+			Description: "Payer adjusters read the claim attachments of their payer",
+			Effect:      EffectAllow,
+			Roles:       []string{RolePayerAdjuster},
+			Actions:     []string{ActionRead},
+			Resources:   []string{ResourceAttachment},
+			Conditions:  Conditions{SameTenant: true},
+		},
+		{
+			ID:          "payer-adjuster-bulk-export",
+			Description: "Payer adjusters export the data of their payer in bulk",
+			Effect:      EffectAllow,
+			Roles:       []string{RolePayerAdjuster},
+			Actions:     []string{ActionRead, ActionDelete},
+			Resources:   []string{ResourceBulkExport},
+		},
+		{
+			ID:          "api-client-bulk-export",
+			Description: "Payer and regulator systems export data in bulk, payers only their own",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleAPIClient},
+			Actions:     []string{ActionRead, ActionDelete},
+			Resources:   []string{ResourceBulkExport},
+			Conditions:  Conditions{OrganizationTypes: []string{"payer", "regulator"}},
+		},
+		{
+			ID:          "regulator-bulk-export",
+			Description: "Regulators export claims and coverage of all payers in bulk",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleRegulator},
+			Actions:     []string{ActionRead, ActionDelete},
+			Resources:   []string{ResourceBulkExport},
+		},
+		{
+			ID:          "clients-api-access",
+			Description: "Token holders and API clients use the FHIR and service routes",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleUser, RoleAPIClient},
+			Actions:     []string{ActionRead, ActionWrite, ActionDelete},
+			Resources: []string{
+				"fhir.*", ResourceEligibility, ResourceClaimsProxy, ResourcePoll, ResourceTerminology,
+			},
+		},
+		{
+			ID:          "clients-attachments",
+			Description: "Token holders and API clients upload claim attachments and read those of their tenant",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleUser, RoleAPIClient},
+			Actions:     []string{ActionRead, ActionWrite, ActionDelete},
+			Resources:   []string{ResourceAttachment},
+			Conditions:  Conditions{SameTenant: true},
+		},
+		{
+			ID:          "break-glass-request",
+			Description: "Clinical and claims staff may open a break-glass session in an emergency",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleProviderClerk, RolePayerAdjuster},
+			Actions:     []string{ActionWrite},
+			Resources:   []string{ResourceBreakGlass},
+			Conditions:  Conditions{AuthMethods: []string{"jwt"}},
+		},
+		{
+			ID:          "break-glass-read",
+			Description: "Break-glass sessions read the session patient's record, coverage and eligibility outside the holder's usual scope",
+			Effect:      EffectAllow,
+			Roles:       []string{RoleBreakGlass},
+			Actions:     []string{ActionRead},
+			Resources:   []string{ResourcePatient, ResourceCoverage, ResourceEligibility},
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Loader supplies the current set of policies
+type Loader interface {
+	Load(ctx context.Context) ([]Policy, error)
+}
+
+// Engine evaluates requests against the policies of a loader. Decisions are
+// cached until they expire or the policies are reloaded.
+type Engine struct {
+	loader Loader
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	policies []Policy
+	cache    *decisionCache
+}
+
+// NewEngine creates an engine and loads its policies. Decisions are cached for
+// cacheTTL, at most cacheSize of them; a zero TTL disables the cache.
+func NewEngine(ctx context.Context, loader Loader, cacheTTL time.Duration, cacheSize int, logger *logrus.Logger) (*Engine, error) {
+	e := &Engine{
+		loader: loader,
+		logger: logger,
+		cache:  newDecisionCache(cacheTTL, cacheSize),
+	}
+	if err := e.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Evaluate decides a request
+func (e *Engine) Evaluate(req Request) Decision {
+	// Decisions do not depend on who the subject is, only on its attributes
+	key := req
+	key.Subject.ID = ""
+
+	// Held throughout so that no decision of replaced policies is cached
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if decision, ok := e.cache.get(key); ok {
+		decision.Cached = true
+		return decision
+	}
+
+	decision := Evaluate(e.policies, req)
+	e.cache.put(key, decision)
+	return decision
+}
+
+// Policies returns the loaded policies
+func (e *Engine) Policies() []Policy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]Policy(nil), e.policies...)
+}
+
+// Reload loads the policies again and drops cached decisions. Invalid policy
+// sets are rejected as a whole and the current policies stay in force.
+func (e *Engine) Reload(ctx context.Context) error {
+	policies, err := e.loader.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load policies: %w", err)
+	}
+	ids := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if ids[p.ID] {
+			return fmt.Errorf("duplicate policy id %s", p.ID)
+		}
+		ids[p.ID] = true
+	}
+
+	e.mu.Lock()
+	e.policies = policies
+	e.cache.clear()
+	e.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the policies every interval until the context is cancelled
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Reload(ctx); err != nil {
+				e.logger.WithError(err).Error("Failed to reload access policies")
+			}
+		}
+	}
+}
+
+// decisionCache keeps recent decisions in memory. When full, expired entries
+// are dropped, and if that frees nothing the cache starts over.
+type decisionCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[Request]cachedDecision
+}
+
+type cachedDecision struct {
+	decision Decision
+	expires  time.Time
+}
+
+func newDecisionCache(ttl time.Duration, size int) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[Request]cachedDecision),
+	}
+}
+
+func (c *decisionCache) get(req Request) (Decision, bool) {
+	if c.ttl <= 0 {
+		return Decision{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[req]
+	if !ok || time.Now().After(entry.expires) {
+		return Decision{}, false
+	}
+	return entry.decision, true
+}
+
+func (c *decisionCache) put(req Request, decision Decision) {
+	if c.ttl <= 0 || c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= c.size {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.size {
+			c.entries = make(map[Request]cachedDecision)
+		}
+	}
+	c.entries[req] = cachedDecision{decision: decision, expires: now.Add(c.ttl)}
+}
+
+func (c *decisionCache) clear() {
+	c.mu.Lock()
+	c.entries = make(map[Request]cachedDecision)
+	c.mu.Unlock()
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/lib/pq"
+)
+
+// Static serves a fixed set of policies
+type Static []Policy
+
+// Load returns the policies
+func (s Static) Load(ctx context.Context) ([]Policy, error) {
+	return append([]Policy(nil), s...), nil
+}
+
+// File loads policies from a JSON document of the form
+// {"policies": [{"id": "...", "effect": "allow", ...}]}
+type File struct {
+	Path string
+}
+
+// Load reads and parses the file
+func (f File) Load(ctx context.Context) ([]Policy, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Policies []Policy `json:"policies"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.Path, err)
+	}
+	return doc.Policies, nil
+}
+
+// DB loads the enabled policies of the access_policies table, in priority order
+type DB struct {
+	DB *sql.DB
+}
+
+// Load queries the policies
+func (d DB) Load(ctx context.Context) ([]Policy, error) {
+	rows, err := d.DB.QueryContext(ctx, `
+		SELECT id, description, effect, roles, actions, resources, conditions
+		FROM access_policies
+		WHERE enabled
+		ORDER BY priority, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []Policy
+	for rows.Next() {
+		var p Policy
+		var description sql.NullString
+		var conditions []byte
+		if err := rows.Scan(&p.ID, &description, &p.Effect, pq.Array(&p.Roles), pq.Array(&p.Actions),
+			pq.Array(&p.Resources), &conditions); err != nil {
+			return nil, err
+		}
+		p.Description = description.String
+		if len(conditions) > 0 {
+			if err := json.Unmarshal(conditions, &p.Conditions); err != nil {
+				return nil, fmt.Errorf("policy %s: invalid conditions: %w", p.ID, err)
+			}
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
@@ -0,0 +1,204 @@
+// Package policy decides whether a subject may perform an action on a
+// resource. Declarative policies grant or deny actions on resource types to
+// roles, optionally only when subject and resource attributes such as the
+// tenant or organization match. Anything not allowed by a policy is denied,
+// and a matching deny overrides every allow.
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Effects
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Actions, derived from the HTTP method
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+)
+
+// Roles
+const (
+	RoleAdmin         = "admin"
+	RoleAuditor       = "auditor"
+	RoleProviderClerk = "provider_clerk"
+	RolePayerAdjuster = "payer_adjuster"
+	RoleUser          = "user"        // token holders without a specific role
+	RoleAPIClient     = "api_client"  // organizations authenticated by API key
+	RoleBreakGlass    = "break_glass" // holders of a break-glass emergency token
+	RoleRegulator     = "regulator"   // regulator staff receiving market-wide extracts
+)
+
+// Policy allows or denies actions on resource types to roles. Roles, actions
+// and resources match exactly or with "*"; a resource pattern ending in ".*"
+// matches every type with that prefix, e.g. "fhir.*".
+type Policy struct {
+	ID          string     `json:"id"`
+	Description string     `json:"description,omitempty"`
+	Effect      string     `json:"effect"`
+	Roles       []string   `json:"roles"`
+	Actions     []string   `json:"actions"`
+	Resources   []string   `json:"resources"`
+	Conditions  Conditions `json:"conditions,omitempty"`
+}
+
+// Conditions restrict a policy to requests with matching attributes. All set
+// conditions must hold.
+type Conditions struct {
+	// SameTenant requires the resource to belong to the subject's tenant. It
+	// is only checked for scoped resources; a scoped resource without a
+	// tenant belongs to no one.
+	SameTenant bool `json:"same_tenant,omitempty"`
+	// SameOrganization requires the resource to belong to the subject's
+	// organization, checked like SameTenant.
+	SameOrganization bool `json:"same_organization,omitempty"`
+	// OrganizationTypes lists the subject organization types, e.g. "payer"
+	OrganizationTypes []string `json:"organization_types,omitempty"`
+	// AuthMethods lists the authentication methods, "jwt" or "api_key"
+	AuthMethods []string `json:"auth_methods,omitempty"`
+}
+
+// Subject is the caller of a request
+type Subject struct {
+	ID               string `json:"id,omitempty"`
+	Role             string `json:"role"`
+	TenantID         string `json:"tenant_id,omitempty"`
+	OrganizationID   string `json:"organization_id,omitempty"`
+	OrganizationType string `json:"organization_type,omitempty"`
+	AuthMethod       string `json:"auth_method,omitempty"`
+}
+
+// Resource is what a request acts on. Route checks only know the resource
+// type and leave Scoped unset, so the tenant and organization conditions
+// hold; they are only enforced by handlers that check a loaded resource with
+// its attributes and Scoped set, as those of attachments do.
+type Resource struct {
+	Type           string `json:"type"`
+	Scoped         bool   `json:"scoped,omitempty"`
+	TenantID       string `json:"tenant_id,omitempty"`
+	OrganizationID string `json:"organization_id,omitempty"`
+}
+
+// Request asks whether a subject may perform an action on a resource
+type Request struct {
+	Subject  Subject  `json:"subject"`
+	Action   string   `json:"action"`
+	Resource Resource `json:"resource"`
+}
+
+// Decision is the outcome of evaluating a request. PolicyID names the policy
+// that decided it and is empty when nothing matched.
+type Decision struct {
+	Allowed  bool   `json:"allowed"`
+	Effect   string `json:"effect"`
+	PolicyID string `json:"policy_id,omitempty"`
+	Reason   string `json:"reason"`
+	Cached   bool   `json:"cached"`
+}
+
+// Validate checks that a policy can be evaluated
+func (p Policy) Validate() error {
+	if p.ID == "" {
+		return fmt.Errorf("policy without id")
+	}
+	if p.Effect != EffectAllow && p.Effect != EffectDeny {
+		return fmt.Errorf("policy %s: effect must be %q or %q", p.ID, EffectAllow, EffectDeny)
+	}
+	if len(p.Roles) == 0 || len(p.Actions) == 0 || len(p.Resources) == 0 {
+		return fmt.Errorf("policy %s: roles, actions and resources are required", p.ID)
+	}
+	return nil
+}
+
+// Matches reports whether the policy applies to a request
+func (p Policy) Matches(req Request) bool {
+	return matchAny(p.Roles, req.Subject.Role) &&
+		matchAny(p.Actions, req.Action) &&
+		matchAny(p.Resources, req.Resource.Type) &&
+		p.Conditions.hold(req)
+}
+
+func (c Conditions) hold(req Request) bool {
+	if c.SameTenant && req.Resource.Scoped && !sameOwner(req.Resource.TenantID, req.Subject.TenantID) {
+		return false
+	}
+	if c.SameOrganization && req.Resource.Scoped && !sameOwner(req.Resource.OrganizationID, req.Subject.OrganizationID) {
+		return false
+	}
+	if len(c.OrganizationTypes) > 0 && !contains(c.OrganizationTypes, req.Subject.OrganizationType) {
+		return false
+	}
+	if len(c.AuthMethods) > 0 && !contains(c.AuthMethods, req.Subject.AuthMethod) {
+		return false
+	}
+	return true
+}
+
+// Evaluate decides a request against policies. A matching deny wins over any
+// allow, and a request no policy allows is denied.
+func Evaluate(policies []Policy, req Request) Decision {
+	var allow *Policy
+	for i := range policies {
+		p := &policies[i]
+		if !p.Matches(req) {
+			continue
+		}
+		if p.Effect == EffectDeny {
+			return Decision{
+				Effect:   EffectDeny,
+				PolicyID: p.ID,
+				Reason:   "denied by policy " + p.ID,
+			}
+		}
+		if allow == nil {
+			allow = p
+		}
+	}
+
+	if allow == nil {
+		return Decision{
+			Effect: EffectDeny,
+			Reason: fmt.Sprintf("no policy allows %s to %s %s", req.Subject.Role, req.Action, req.Resource.Type),
+		}
+	}
+	return Decision{
+		Allowed:  true,
+		Effect:   EffectAllow,
+		PolicyID: allow.ID,
+		Reason:   "allowed by policy " + allow.ID,
+	}
+}
+
+// sameOwner reports whether a resource attribute names the subject's. Empty
+// attributes match nothing.
+func sameOwner(resource, subject string) bool {
+	return resource != "" && resource == subject
+}
+
+// matchAny reports whether value matches one of the patterns
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*", pattern == value:
+			return true
+		case strings.HasSuffix(pattern, ".*") && strings.HasPrefix(value, strings.TrimSuffix(pattern, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/kafka"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// Event is the envelope published on the claims response and prior
+// authorization status topics when a final answer becomes available
+type Event struct {
+	EventID      string          `json:"eventId"`
+	ProviderID   string          `json:"providerId"`
+	ResourceType string          `json:"resourceType"`
+	ResourceID   string          `json:"resourceId"`
+	Resource     json.RawMessage `json:"resource"`
+	Timestamp    time.Time       `json:"timestamp"`
+}
+
+// Ingestor consumes response events from Kafka and enqueues them for polling
+type Ingestor struct {
+	queue    *Queue
+	brokers  []string
+	security kafka.SecurityConfig
+	groupID  string
+	logger   *logrus.Logger
+}
+
+// NewIngestor creates a new ingestor that feeds the given queue
+func NewIngestor(queue *Queue, brokers []string, security kafka.SecurityConfig, groupID string, logger *logrus.Logger) *Ingestor {
+	return &Ingestor{
+		queue:    queue,
+		brokers:  brokers,
+		security: security,
+		groupID:  groupID,
+		logger:   logger,
+	}
+}
+
+// Run consumes the given topics until the context is cancelled
+func (i *Ingestor) Run(ctx context.Context, topics ...string) {
+	for _, topic := range topics {
+		go i.consume(ctx, topic)
+	}
+}
+
+// consume reads a single topic. Offsets are committed only after the message
+// has been stored, so a crash causes redelivery rather than loss; duplicates
+// are absorbed by the event ID constraint.
+func (i *Ingestor) consume(ctx context.Context, topic string) {
+	consumer, err := kafka.NewConsumer(i.brokers, i.security, topic, i.groupID, i.logger)
+	if err != nil {
+		i.logger.WithError(err).Errorf("Failed to create consumer for topic %s", topic)
+		return
+	}
+	defer consumer.Close()
+
+	i.logger.WithField("topic", topic).Info("Starting poll queue ingestion")
+
+	for {
+		msg, err := consumer.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			i.logger.WithError(err).Errorf("Failed to fetch message from topic %s", topic)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		msgCtx, span := tracing.StartConsumerSpan(ctx, msg, i.groupID)
+		err = i.handle(msgCtx, topic, msg.Value)
+		tracing.End(span, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			i.logger.WithError(err).WithFields(logrus.Fields{
+				"topic":     topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+			}).Error("Failed to enqueue poll message")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if err := consumer.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			i.logger.WithError(err).Errorf("Failed to commit offset on topic %s", topic)
+		}
+	}
+}
+
+// handle validates an event and stores it. Malformed events are logged and
+// skipped so that they do not block the partition.
+func (i *Ingestor) handle(ctx context.Context, topic string, value []byte) error {
+	var event Event
+	if err := json.Unmarshal(value, &event); err != nil {
+		i.logger.WithError(err).WithField("topic", topic).Warn("Skipping malformed poll event")
+		return nil
+	}
+
+	if err := validateEvent(event); err != nil {
+		i.logger.WithError(err).WithFields(logrus.Fields{
+			"topic":    topic,
+			"event_id": event.EventID,
+		}).Warn("Skipping invalid poll event")
+		return nil
+	}
+
+	created, err := i.queue.Enqueue(ctx, Message{
+		EventID:     event.EventID,
+		ProviderID:  event.ProviderID,
+		MessageType: event.ResourceType,
+		ResourceID:  event.ResourceID,
+		Payload:     event.Resource,
+	}, topic)
+	if err != nil {
+		return err
+	}
+
+	i.logger.WithFields(logrus.Fields{
+		"topic":        topic,
+		"event_id":     event.EventID,
+		"message_type": event.ResourceType,
+		"duplicate":    !created,
+	}).Debug("Poll message enqueued")
+
+	return nil
+}
+
+func validateEvent(event Event) error {
+	if event.EventID == "" {
+		return errors.New("missing eventId")
+	}
+	if event.ProviderID == "" {
+		return errors.New("missing providerId")
+	}
+	if !IsSupportedType(event.ResourceType) {
+		return fmt.Errorf("unsupported resourceType %q", event.ResourceType)
+	}
+	if len(event.Resource) == 0 {
+		return errors.New("missing resource")
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package poll
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Message types that can be delivered through the poll queue
+const (
+	MessageTypeClaimResponse               = "ClaimResponse"
+	MessageTypeCoverageEligibilityResponse = "CoverageEligibilityResponse"
+	MessageTypeCommunication               = "Communication"
+)
+
+// Message statuses
+const (
+	StatusQueued       = "queued"
+	StatusDelivered    = "delivered"
+	StatusAcknowledged = "acknowledged"
+	StatusExpired      = "expired"
+)
+
+// Message is an outbound message waiting for a provider to poll it
+type Message struct {
+	ID            string          `json:"id"`
+	EventID       string          `json:"event_id"`
+	ProviderID    string          `json:"provider_id"`
+	MessageType   string          `json:"message_type"`
+	ResourceID    string          `json:"resource_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	DeliveryCount int             `json:"delivery_count"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+}
+
+// Options controls leasing and retention of queued messages
+type Options struct {
+	LeaseDuration time.Duration // how long a polled message stays invisible before redelivery
+	MessageTTL    time.Duration // how long an unacknowledged message is kept
+	AckRetention  time.Duration // how long acknowledged/expired messages are kept before purge
+}
+
+// Queue is a per-provider outbound message queue backed by Postgres
+type Queue struct {
+	db      *sql.DB
+	logger  *logrus.Logger
+	options Options
+}
+
+// NewQueue creates a new poll queue
+func NewQueue(db *sql.DB, logger *logrus.Logger, options Options) *Queue {
+	return &Queue{
+		db:      db,
+		logger:  logger,
+		options: options,
+	}
+}
+
+// IsSupportedType reports whether a message type can be queued
+func IsSupportedType(messageType string) bool {
+	switch messageType {
+	case MessageTypeClaimResponse, MessageTypeCoverageEligibilityResponse, MessageTypeCommunication:
+		return true
+	}
+	return false
+}
+
+// Enqueue stores a message for a provider. Messages are deduplicated by event ID,
+// so enqueueing the same event twice is a no-op.
+func (q *Queue) Enqueue(ctx context.Context, msg Message, sourceTopic string) (bool, error) {
+	query := `
+		INSERT INTO poll_messages (
+			event_id, provider_id, message_type, resource_id, payload, source_topic, status, expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (event_id) DO NOTHING
+	`
+
+	result, err := q.db.ExecContext(ctx, query,
+		msg.EventID,
+		msg.ProviderID,
+		msg.MessageType,
+		msg.ResourceID,
+		[]byte(msg.Payload),
+		sourceTopic,
+		StatusQueued,
+		time.Now().Add(q.options.MessageTTL),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// Poll leases up to limit messages for a provider, oldest first. Leased messages
+// are hidden from later polls until they are acknowledged or the lease expires,
+// after which they are redelivered.
+func (q *Queue) Poll(ctx context.Context, providerID string, messageTypes []string, limit int) ([]Message, error) {
+	query := `
+		UPDATE poll_messages SET
+			status = $1,
+			delivery_count = delivery_count + 1,
+			last_delivered_at = NOW(),
+			lease_expires_at = $2
+		WHERE id IN (
+			SELECT id FROM poll_messages
+			WHERE provider_id = $3
+			  AND expires_at > NOW()
+			  AND (status = $4 OR (status = $1 AND lease_expires_at <= NOW()))
+			  AND ($5::text[] IS NULL OR message_type = ANY($5))
+			ORDER BY created_at
+			LIMIT $6
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_id, provider_id, message_type, resource_id, payload,
+		          delivery_count, created_at, expires_at
+	`
+
+	var typeFilter interface{}
+	if len(messageTypes) > 0 {
+		typeFilter = pq.Array(messageTypes)
+	}
+
+	rows, err := q.db.QueryContext(ctx, query,
+		StatusDelivered,
+		time.Now().Add(q.options.LeaseDuration),
+		providerID,
+		StatusQueued,
+		typeFilter,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		var resourceID sql.NullString
+		var payload []byte
+
+		if err := rows.Scan(
+			&msg.ID,
+			&msg.EventID,
+			&msg.ProviderID,
+			&msg.MessageType,
+			&resourceID,
+			&payload,
+			&msg.DeliveryCount,
+			&msg.CreatedAt,
+			&msg.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+
+		msg.ResourceID = resourceID.String
+		msg.Payload = json.RawMessage(payload)
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING does not preserve the ORDER BY of the subquery
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	return messages, nil
+}
+
+// Acknowledge marks delivered messages as processed by the provider. Only
+// messages that belong to the provider and are currently delivered are
+// acknowledged; the number of acknowledged messages is returned.
+func (q *Queue) Acknowledge(ctx context.Context, providerID string, messageIDs []string) (int64, error) {
+	return q.acknowledge(ctx, q.db, providerID, messageIDs)
+}
+
+// AcknowledgeTx acknowledges messages within the caller's transaction
+func (q *Queue) AcknowledgeTx(ctx context.Context, tx *sql.Tx, providerID string, messageIDs []string) (int64, error) {
+	return q.acknowledge(ctx, tx, providerID, messageIDs)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (q *Queue) acknowledge(ctx context.Context, exec execer, providerID string, messageIDs []string) (int64, error) {
+	query := `
+		UPDATE poll_messages SET
+			status = $1,
+			acknowledged_at = NOW(),
+			lease_expires_at = NULL
+		WHERE provider_id = $2
+		  AND id::text = ANY($3)
+		  AND status = $4
+	`
+
+	result, err := exec.ExecContext(ctx, query, StatusAcknowledged, providerID, pq.Array(messageIDs), StatusDelivered)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Pending returns the number of messages still waiting for a provider
+func (q *Queue) Pending(ctx context.Context, providerID string, messageTypes []string) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM poll_messages
+		WHERE provider_id = $1
+		  AND expires_at > NOW()
+		  AND (status = $2 OR (status = $3 AND lease_expires_at <= NOW()))
+		  AND ($4::text[] IS NULL OR message_type = ANY($4))
+	`
+
+	var typeFilter interface{}
+	if len(messageTypes) > 0 {
+		typeFilter = pq.Array(messageTypes)
+	}
+
+	var count int
+	err := q.db.QueryRowContext(ctx, query, providerID, StatusQueued, StatusDelivered, typeFilter).Scan(&count)
+	return count, err
+}
+
+// Sweep expires messages past their TTL and purges acknowledged and expired
+// messages older than the retention period
+func (q *Queue) Sweep(ctx context.Context) (expired, purged int64, err error) {
+	result, err := q.db.ExecContext(ctx, `
+		UPDATE poll_messages SET status = $1, lease_expires_at = NULL
+		WHERE status IN ($2, $3) AND expires_at <= NOW()
+	`, StatusExpired, StatusQueued, StatusDelivered)
+	if err != nil {
+		return 0, 0, err
+	}
+	expired, _ = result.RowsAffected()
+
+	result, err = q.db.ExecContext(ctx, `
+		DELETE FROM poll_messages
+		WHERE status IN ($1, $2) AND updated_at <= $3
+	`, StatusAcknowledged, StatusExpired, time.Now().Add(-q.options.AckRetention))
+	if err != nil {
+		return expired, 0, err
+	}
+	purged, _ = result.RowsAffected()
+
+	return expired, purged, nil
+}
+
+// RunSweeper periodically applies retention until the context is cancelled
+func (q *Queue) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, purged, err := q.Sweep(ctx)
+			if err != nil {
+				q.logger.WithError(err).Error("Failed to sweep poll queue")
+				continue
+			}
+			if expired > 0 || purged > 0 {
+				q.logger.WithFields(logrus.Fields{
+					"expired": expired,
+					"purged":  purged,
+				}).Info("Poll queue sweep completed")
+			}
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// Package procstats reads resource usage of the current process from the
+// operating system. Only Linux is supported; elsewhere every function returns
+// ErrUnsupported.
+package procstats
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without /proc
+var ErrUnsupported = errors.New("process statistics are not supported on this platform")
@@ -0,0 +1,132 @@
+package procstats
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on all mainstream Linux architectures
+const clockTicks = 100
+
+// CPUTime returns the user plus system CPU time consumed by the process
+func CPUTime() (time.Duration, error) {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name may contain spaces, so fields are counted after its
+	// closing parenthesis; utime and stime are fields 14 and 15
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("unexpected /proc/self/stat format")
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("unexpected /proc/self/stat format")
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(utime+stime) * time.Second / clockTicks, nil
+}
+
+// RSS returns the resident set size of the process in bytes
+func RSS() (uint64, error) {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected /proc/self/statm format")
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * uint64(os.Getpagesize()), nil
+}
+
+// MemoryLimit returns the memory available to the process: the cgroup limit
+// when running in a constrained container, otherwise the host's total memory
+func MemoryLimit() (uint64, error) {
+	total, err := memTotal()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",                   // cgroup v2
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes", // cgroup v1
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			// "max" means unlimited
+			continue
+		}
+		if limit > 0 && limit < total {
+			return limit, nil
+		}
+	}
+
+	return total, nil
+}
+
+func memTotal() (uint64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("MemTotal not found in /proc/meminfo")
+}
+
+// DiskUsage returns the used percentage of the filesystem containing path
+func DiskUsage(path string) (float64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+
+	total := fs.Blocks * uint64(fs.Bsize)
+	if total == 0 {
+		return 0, nil
+	}
+	free := fs.Bfree * uint64(fs.Bsize)
+	return float64(total-free) / float64(total) * 100, nil
+}
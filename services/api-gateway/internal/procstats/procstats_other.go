@@ -0,0 +1,25 @@
+//go:build !linux
+
+package procstats
+
+import "time"
+
+// CPUTime returns the user plus system CPU time consumed by the process
+func CPUTime() (time.Duration, error) {
+	return 0, ErrUnsupported
+}
+
+// RSS returns the resident set size of the process in bytes
+func RSS() (uint64, error) {
+	return 0, ErrUnsupported
+}
+
+// MemoryLimit returns the memory available to the process
+func MemoryLimit() (uint64, error) {
+	return 0, ErrUnsupported
+}
+
+// DiskUsage returns the used percentage of the filesystem containing path
+func DiskUsage(path string) (float64, error) {
+	return 0, ErrUnsupported
+}
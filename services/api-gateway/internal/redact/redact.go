@@ -0,0 +1,334 @@
+// Package redact removes protected health information from log entries before
+// they are written, so that logs can be shipped to central logging under PDPL.
+// Fields are redacted by name and free text by pattern; in allowlist mode every
+// field that is not explicitly allowed is redacted.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Modes
+const (
+	// ModeMask replaces values with Mask
+	ModeMask = "mask"
+	// ModeTokenize replaces values with a keyed hash, so that entries about the
+	// same patient can still be correlated without revealing who it is
+	ModeTokenize = "tokenize"
+)
+
+// Mask replaces redacted values in mask mode
+const Mask = "[REDACTED]"
+
+// tokenPrefix marks tokenized values
+const tokenPrefix = "tok_"
+
+// DefaultFields are the field names treated as PHI. Names are compared
+// case-insensitively and ignoring '_', '-' and '.', so national_id matches
+// nationalId and NATIONAL-ID.
+var DefaultFields = []string{
+	"national_id", "iqama", "iqama_number", "id_number", "passport", "passport_number",
+	"member_id", "subscriber_id", "beneficiary_id", "patient_id",
+	"name", "first_name", "middle_name", "last_name", "full_name", "given", "family",
+	"patient_name", "member_name", "name_ar", "name_en",
+	"phone", "phone_number", "mobile", "mobile_number", "telecom", "email",
+	"birth_date", "date_of_birth", "dob",
+	"address", "gender",
+}
+
+// DefaultAllowlist are the fields every service logs as-is in allowlist
+// mode, those of request logs and startup. Services add their own fields
+// with Config.ServiceAllowlist.
+var DefaultAllowlist = []string{
+	"request_id", "method", "path", "status", "latency", "client_ip", "user_agent",
+	"error", "service", "port", "cert_file", "mtls",
+}
+
+// timeFields are the names of fields holding instants, whose Unix times
+// would otherwise look like national IDs. Fields ending in _at or At are
+// time fields too.
+var timeFields = map[string]bool{
+	"time": true, "timestamp": true, "ts": true, "since": true, "until": true,
+	"exp": true, "iat": true, "nbf": true,
+}
+
+// nationalIDPattern matches Saudi national IDs (starting with 1) and Iqama
+// numbers (starting with 2), ten digits of which the last is a Luhn check
+// digit
+var nationalIDPattern = regexp.MustCompile(`\b[12]\d{9}\b`)
+
+// phonePattern matches Saudi mobile numbers, 05xxxxxxxx or +9665xxxxxxxx
+var phonePattern = regexp.MustCompile(`(?:\+966|\b00966|\b0)5\d{8}\b`)
+
+// Config controls what is redacted
+type Config struct {
+	Enabled          bool
+	Mode             string   // mask or tokenize
+	AllowlistMode    bool     // redact every field not in Allowlist
+	Fields           []string // field names redacted in addition to DefaultFields
+	ServiceAllowlist []string // fields of the service allowed in addition to DefaultAllowlist
+	Allowlist        []string // field names allowed in addition to those, from configuration
+	TokenKey         string   // HMAC key for tokenize mode; random per process if empty
+}
+
+// Hook is a logrus hook that redacts entries. It must be added after any hook
+// that adds fields, since hooks run in the order they were added.
+type Hook struct {
+	mode          string
+	allowlistMode bool
+	fields        map[string]bool
+	allowlist     map[string]bool
+	tokenKey      []byte
+}
+
+// NewHook creates a redaction hook
+func NewHook(cfg Config) (*Hook, error) {
+	h := &Hook{
+		mode:          cfg.Mode,
+		allowlistMode: cfg.AllowlistMode,
+		fields:        normalizedSet(DefaultFields, cfg.Fields),
+		allowlist:     normalizedSet(DefaultAllowlist, cfg.ServiceAllowlist, cfg.Allowlist),
+	}
+
+	switch h.mode {
+	case "", ModeMask:
+		h.mode = ModeMask
+	case ModeTokenize:
+		if cfg.TokenKey != "" {
+			h.tokenKey = []byte(cfg.TokenKey)
+		} else {
+			// Tokens then only correlate within the lifetime of this process
+			h.tokenKey = make([]byte, 32)
+			if _, err := rand.Read(h.tokenKey); err != nil {
+				return nil, fmt.Errorf("failed to generate redaction token key: %w", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown redaction mode %q", cfg.Mode)
+	}
+
+	return h, nil
+}
+
+// Install adds a redaction hook to logger when redaction is enabled
+func Install(logger *logrus.Logger, cfg Config) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	hook, err := NewHook(cfg)
+	if err != nil {
+		return err
+	}
+	logger.AddHook(hook)
+	return nil
+}
+
+// Levels implements logrus.Hook
+func (h *Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook. Logrus hands hooks a copy of the entry's
+// fields; values are replaced rather than modified in place, so nested maps
+// owned by the caller are never changed.
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.text(entry.Message)
+
+	for key, value := range entry.Data {
+		normalized := normalize(key)
+		switch {
+		case h.fields[normalized]:
+			entry.Data[key] = h.replace(value)
+		case h.allowlistMode && !h.allowlist[normalized]:
+			entry.Data[key] = h.replace(value)
+		case timeField(key) && unixTime(value):
+			// Kept, as Unix times look like national IDs
+		default:
+			entry.Data[key] = h.value(value)
+		}
+	}
+	return nil
+}
+
+// value redacts PHI nested in a field value
+func (h *Hook) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		float32, float64, time.Time, time.Duration:
+		return v
+	case string:
+		return h.text(v)
+	case error:
+		// Keep the error itself unless its text has to change
+		if text, redacted := v.Error(), h.text(v.Error()); redacted != text {
+			return redacted
+		}
+		return v
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			if h.fields[normalize(key)] {
+				redacted[key] = h.replace(nested)
+			} else {
+				redacted[key] = h.value(nested)
+			}
+		}
+		return redacted
+	case logrus.Fields:
+		return h.value(map[string]interface{}(v))
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, nested := range v {
+			redacted[i] = h.value(nested)
+		}
+		return redacted
+	}
+
+	// Structs, typed maps and slices are redacted through their JSON form,
+	// which is how the formatter would render them anyway
+	if composite(value) {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return h.text(fmt.Sprint(value))
+		}
+		var generic interface{}
+		if err := json.Unmarshal(encoded, &generic); err != nil {
+			return h.text(string(encoded))
+		}
+		return h.value(generic)
+	}
+
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return h.text(stringer.String())
+	}
+	return value
+}
+
+// replace redacts a whole value
+func (h *Hook) replace(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	// Only scalars are tokenized; a token of a whole structure would not
+	// correlate with anything
+	if h.mode != ModeTokenize || composite(value) {
+		return Mask
+	}
+	return h.token(fmt.Sprint(value))
+}
+
+// composite reports whether value is a struct, map, slice or array
+func composite(value interface{}) bool {
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		_, isTime := value.(time.Time)
+		return !isTime
+	}
+	return false
+}
+
+// text redacts national IDs and phone numbers in free text
+func (h *Hook) text(text string) string {
+	var redacted strings.Builder
+	last := 0
+	for _, match := range nationalIDPattern.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		// Decimals such as fractional Unix times are not identifiers
+		if start > 0 && text[start-1] == '.' || end < len(text) && text[end] == '.' && end+1 < len(text) && isDigit(text[end+1]) {
+			continue
+		}
+		if !luhn(text[start:end]) {
+			continue
+		}
+		redacted.WriteString(text[last:start])
+		redacted.WriteString(h.mask(text[start:end]))
+		last = end
+	}
+	redacted.WriteString(text[last:])
+
+	return phonePattern.ReplaceAllStringFunc(redacted.String(), h.mask)
+}
+
+// mask replaces an identifier found in free text
+func (h *Hook) mask(match string) string {
+	if h.mode == ModeTokenize {
+		return h.token(match)
+	}
+	return Mask
+}
+
+// luhn reports whether the last digit of digits is its Luhn check digit, as
+// in national IDs and Iqama numbers
+func luhn(digits string) bool {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return sum%10 == 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// timeField reports whether a field holds an instant
+func timeField(key string) bool {
+	return timeFields[normalize(key)] || strings.HasSuffix(key, "_at") || strings.HasSuffix(key, "At")
+}
+
+// unixTime reports whether a value is a Unix time, in seconds or
+// milliseconds, written as digits
+func unixTime(value interface{}) bool {
+	text, ok := value.(string)
+	if !ok || text == "" {
+		return false
+	}
+	for i := 0; i < len(text); i++ {
+		if !isDigit(text[i]) {
+			return false
+		}
+	}
+	return len(text) == 10 || len(text) == 13
+}
+
+// token returns a stable keyed hash of value
+func (h *Hook) token(value string) string {
+	mac := hmac.New(sha256.New, h.tokenKey)
+	mac.Write([]byte(value))
+	return tokenPrefix + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// normalize lowercases a field name and drops separators
+func normalize(key string) string {
+	return strings.NewReplacer("_", "", "-", "", ".", "").Replace(strings.ToLower(key))
+}
+
+func normalizedSet(lists ...[]string) map[string]bool {
+	set := make(map[string]bool)
+	for _, list := range lists {
+		for _, key := range list {
+			if key = strings.TrimSpace(key); key != "" {
+				set[normalize(key)] = true
+			}
+		}
+	}
+	return set
+}
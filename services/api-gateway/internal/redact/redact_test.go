@@ -0,0 +1,190 @@
+package redact
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func fire(t *testing.T, cfg Config, data logrus.Fields, message string) *logrus.Entry {
+	t.Helper()
+	hook, err := NewHook(cfg)
+	if err != nil {
+		t.Fatalf("NewHook failed: %v", err)
+	}
+	entry := &logrus.Entry{Message: message, Data: data}
+	if err := hook.Fire(entry); err != nil {
+		t.Fatalf("Fire failed: %v", err)
+	}
+	return entry
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"national ID", "member 1000000008 not found", "member [REDACTED] not found"},
+		{"Iqama number", "iqama 2000000006", "iqama [REDACTED]"},
+		{"failed check digit", "member 1000000001 not found", "member 1000000001 not found"},
+		{"Unix time", "token issued at 1718000000", "token issued at 1718000000"},
+		{"fractional Unix time", "ts=1000000008.25 done", "ts=1000000008.25 done"},
+		{"fraction of a Unix time", "took 0.1000000008s", "took 0.1000000008s"},
+		{"sentence end", "member 1000000008.", "member [REDACTED]."},
+		{"longer number", "order 11000000008", "order 11000000008"},
+		{"several", "1000000008,2000000006", "[REDACTED],[REDACTED]"},
+		{"mobile", "call 0551234567 or +966551234567", "call [REDACTED] or [REDACTED]"},
+	}
+	hook, err := NewHook(Config{})
+	if err != nil {
+		t.Fatalf("NewHook failed: %v", err)
+	}
+	for _, tt := range tests {
+		if got := hook.text(tt.text); got != tt.want {
+			t.Errorf("%s: text(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLuhn(t *testing.T) {
+	tests := []struct {
+		digits string
+		want   bool
+	}{
+		{"1000000008", true},
+		{"2000000006", true},
+		{"1234567897", true},
+		{"1000000001", false},
+		{"1234567890", false},
+	}
+	for _, tt := range tests {
+		if got := luhn(tt.digits); got != tt.want {
+			t.Errorf("luhn(%q) = %v, want %v", tt.digits, got, tt.want)
+		}
+	}
+}
+
+func TestTimeFields(t *testing.T) {
+	entry := fire(t, Config{}, logrus.Fields{
+		"expires_at": "1000000008",
+		"createdAt":  "1000000008000",
+		"timestamp":  "1000000008",
+		"iat":        "1000000008",
+		"since":      "patient 1000000008",
+		"note":       "1000000008",
+	}, "")
+
+	want := logrus.Fields{
+		"expires_at": "1000000008",
+		"createdAt":  "1000000008000",
+		"timestamp":  "1000000008",
+		"iat":        "1000000008",
+		// Only Unix times are kept; other text is redacted as usual
+		"since": "patient [REDACTED]",
+		"note":  Mask,
+	}
+	if !reflect.DeepEqual(entry.Data, want) {
+		t.Errorf("Data = %v, want %v", entry.Data, want)
+	}
+}
+
+func TestFields(t *testing.T) {
+	type member struct {
+		NationalID string `json:"national_id"`
+		Plan       string `json:"plan"`
+		Note       string `json:"note"`
+	}
+	entry := fire(t, Config{}, logrus.Fields{
+		"national_id": "1000000008",
+		"Member-Name": "Ali",
+		"status":      200,
+		"details": map[string]interface{}{
+			"patient_id": "P-1",
+			"note":       "member 1000000008",
+			"nested":     map[string]interface{}{"phone": "0551234567"},
+		},
+		"member": member{NationalID: "1000000008", Plan: "gold", Note: "iqama 2000000006"},
+		"error":  errors.New("no member 1000000008"),
+	}, "lookup of 1000000008")
+
+	if entry.Message != "lookup of [REDACTED]" {
+		t.Errorf("Message = %q", entry.Message)
+	}
+	want := logrus.Fields{
+		"national_id": Mask,
+		"Member-Name": Mask,
+		"status":      200,
+		"details": map[string]interface{}{
+			"patient_id": Mask,
+			"note":       "member [REDACTED]",
+			"nested":     map[string]interface{}{"phone": Mask},
+		},
+		"member": map[string]interface{}{"national_id": Mask, "plan": "gold", "note": "iqama [REDACTED]"},
+		"error":  "no member [REDACTED]",
+	}
+	if !reflect.DeepEqual(entry.Data, want) {
+		t.Errorf("Data = %v, want %v", entry.Data, want)
+	}
+}
+
+func TestAllowlistMode(t *testing.T) {
+	entry := fire(t, Config{
+		AllowlistMode:    true,
+		ServiceAllowlist: []string{"job_id"},
+		Allowlist:        []string{"custom"},
+	}, logrus.Fields{
+		"request_id":  "r-1",
+		"job_id":      "j-1",
+		"custom":      "c-1",
+		"topic":       "claims",
+		"national_id": "1000000008",
+		"path":        "/members/1000000008",
+	}, "")
+
+	want := logrus.Fields{
+		"request_id":  "r-1",
+		"job_id":      "j-1",
+		"custom":      "c-1",
+		"topic":       Mask,
+		"national_id": Mask,
+		// Allowed fields are still scanned
+		"path": "/members/[REDACTED]",
+	}
+	if !reflect.DeepEqual(entry.Data, want) {
+		t.Errorf("Data = %v, want %v", entry.Data, want)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	cfg := Config{Mode: ModeTokenize, TokenKey: "key"}
+	first := fire(t, cfg, logrus.Fields{"national_id": "1000000008", "member_id": "1000000008", "other": "2000000006"}, "member 1000000008")
+	second := fire(t, cfg, logrus.Fields{"national_id": "1000000008"}, "")
+
+	token, ok := first.Data["national_id"].(string)
+	if !ok || !strings.HasPrefix(token, tokenPrefix) || strings.Contains(token, "1000000008") {
+		t.Fatalf("national_id = %v, want a token", first.Data["national_id"])
+	}
+	if second.Data["national_id"] != token || first.Data["member_id"] != token {
+		t.Errorf("tokens of the same value differ: %v, %v, %v", token, second.Data["national_id"], first.Data["member_id"])
+	}
+	if first.Message != "member "+token {
+		t.Errorf("Message = %q, want the token of the free-text ID %q", first.Message, token)
+	}
+	if first.Data["other"] == token {
+		t.Errorf("tokens of different values are equal")
+	}
+
+	otherKey := fire(t, Config{Mode: ModeTokenize, TokenKey: "other"}, logrus.Fields{"national_id": "1000000008"}, "")
+	if otherKey.Data["national_id"] == token {
+		t.Errorf("tokens made with different keys are equal")
+	}
+	// Composite values are masked rather than tokenized
+	composite := fire(t, cfg, logrus.Fields{"address": map[string]interface{}{"city": "Riyadh"}}, "")
+	if composite.Data["address"] != Mask {
+		t.Errorf("address = %v, want %q", composite.Data["address"], Mask)
+	}
+}
@@ -0,0 +1,176 @@
+// Package requestid assigns every request a correlation ID and carries it
+// through log lines, calls to other services, Kafka events and error responses,
+// so that a single provider complaint can be followed across the platform.
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Header carries the request ID on HTTP requests and responses
+	Header = "X-Request-ID"
+	// CorrelationHeader is accepted from clients that already use it
+	CorrelationHeader = "X-Correlation-ID"
+	// KafkaHeader carries the request ID on Kafka messages
+	KafkaHeader = "request-id"
+	// Key names the request ID in gin contexts and log fields
+	Key = "request_id"
+
+	maxLength = 128
+)
+
+type contextKey struct{}
+
+// New returns a UUIDv7, which sorts by creation time
+func New() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// NewContext returns ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Get returns the request ID of the current request
+func Get(c *gin.Context) string {
+	return c.GetString(Key)
+}
+
+// Middleware reuses a well-formed request ID sent by the client or a calling
+// service and generates one otherwise. The ID is stored on the gin and request
+// contexts, recorded on the server span and echoed in the response header.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !valid(id) {
+			id = c.GetHeader(CorrelationHeader)
+		}
+		if !valid(id) {
+			id = New()
+		}
+
+		c.Set(Key, id)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Header(Header, id)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("http.request_id", id))
+
+		c.Next()
+	}
+}
+
+// valid accepts IDs of printable ASCII without separators, so that client
+// supplied values cannot forge log lines or headers
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.', ch == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Transport wraps base so that outgoing requests carry the request ID of
+// their context. A nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper{base: base}
+}
+
+type roundTripper struct {
+	base http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := FromContext(req.Context())
+	if id == "" || req.Header.Get(Header) != "" {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, id)
+	return t.base.RoundTrip(req)
+}
+
+// InjectKafka adds the request ID of ctx to the message headers unless the
+// message already carries one
+func InjectKafka(ctx context.Context, msg *kafka.Message) {
+	id := FromContext(ctx)
+	if id == "" || FromKafka(*msg) != "" {
+		return
+	}
+	msg.Headers = append(msg.Headers, kafka.Header{Key: KafkaHeader, Value: []byte(id)})
+}
+
+// FromKafka returns the request ID carried by a Kafka message
+func FromKafka(msg kafka.Message) string {
+	for _, header := range msg.Headers {
+		if header.Key == KafkaHeader {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// InjectMap adds the request ID of ctx to a carrier stored alongside work that
+// is processed later
+func InjectMap(ctx context.Context, carrier map[string]string) {
+	if id := FromContext(ctx); id != "" {
+		carrier[KafkaHeader] = id
+	}
+}
+
+// ExtractMap returns ctx with the request ID stored by InjectMap
+func ExtractMap(ctx context.Context, carrier map[string]string) context.Context {
+	if id := carrier[KafkaHeader]; id != "" {
+		return NewContext(ctx, id)
+	}
+	return ctx
+}
+
+// LogHook adds the request ID to every entry logged with a request context,
+// e.g. logger.WithContext(c.Request.Context())
+type LogHook struct{}
+
+// Levels implements logrus.Hook
+func (LogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (LogHook) Fire(entry *logrus.Entry) error {
+	if id := FromContext(entry.Context); id != "" {
+		entry.Data[Key] = id
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+package subscription
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/pkg/fhir"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers of notification requests
+const (
+	HeaderSignature      = "X-NPHIES-Signature"
+	HeaderNotificationID = "X-NPHIES-Notification-ID"
+)
+
+// leaseMargin is added to the subscription timeout while a notification is
+// being delivered, so that another instance does not pick it up meanwhile
+const leaseMargin = 30
+
+// DispatcherOptions controls how notifications are delivered
+type DispatcherOptions struct {
+	PollInterval          time.Duration // delay between scans when nothing is due
+	BatchSize             int           // notifications delivered concurrently per scan
+	MaxAttempts           int           // attempts before a notification is marked failed
+	MaxBackoff            time.Duration // upper bound for the retry delay
+	Retention             time.Duration // how long finished notifications are kept
+	AllowPrivateEndpoints bool          // allow endpoints inside the platform network
+}
+
+// notification is a leased notification with what is needed to deliver it
+type notification struct {
+	id               string
+	notificationType string
+	eventNumber      sql.NullInt64
+	focus            sql.NullString
+	resource         []byte
+	attempts         int
+	createdAt        time.Time
+	subscriptionID   string
+	topic            string
+	endpoint         string
+	secret           string
+	content          string
+	timeout          int
+	status           string
+	eventsSinceStart int64
+}
+
+// Dispatcher delivers queued notifications to subscriber endpoints and
+// maintains subscriptions: it queues heartbeats, turns off subscriptions past
+// their end and purges finished notifications
+type Dispatcher struct {
+	db        *sql.DB
+	client    *http.Client
+	logger    *logrus.Logger
+	options   DispatcherOptions
+	delivered *prometheus.CounterVec
+}
+
+// NewDispatcher creates a new dispatcher
+func NewDispatcher(db *sql.DB, logger *logrus.Logger, options DispatcherOptions) *Dispatcher {
+	// Endpoints are chosen by subscribers, so connections are made directly
+	// and redirects are not followed
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !options.AllowPrivateEndpoints {
+		dialer.Control = denyPrivateAddresses
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	delivered := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "subscription_notifications_total",
+			Help: "Total number of subscription notification delivery attempts",
+		},
+		[]string{"type", "result"},
+	)
+	prometheus.MustRegister(delivered)
+
+	return &Dispatcher{
+		db:        db,
+		client:    client,
+		logger:    logger,
+		options:   options,
+		delivered: delivered,
+	}
+}
+
+// Run delivers notifications until the context is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.options.PollInterval)
+	defer ticker.Stop()
+
+	maintenanceTicker := time.NewTicker(time.Minute)
+	defer maintenanceTicker.Stop()
+
+	d.logger.Info("Starting subscription dispatcher")
+
+	for {
+		delivered, err := d.DeliverBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logger.WithError(err).Error("Subscription delivery failed")
+		}
+
+		// Keep draining without waiting while notifications are backed up
+		if err == nil && delivered == d.options.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-maintenanceTicker.C:
+			d.maintain(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverBatch leases the next batch of due notifications, delivers them
+// concurrently and returns how many were picked up. Notifications of
+// subscriptions that are off are left undelivered.
+func (d *Dispatcher) DeliverBatch(ctx context.Context) (int, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		UPDATE subscription_notifications n
+		SET attempts = n.attempts + 1,
+		    next_attempt_at = NOW() + make_interval(secs => s.timeout + $2)
+		FROM subscriptions s
+		WHERE s.id = n.subscription_id
+		  AND n.id IN (
+			SELECT due.id FROM subscription_notifications due
+			JOIN subscriptions owner ON owner.id = due.subscription_id
+			WHERE due.status = 'pending'
+			  AND due.next_attempt_at <= NOW()
+			  AND owner.status <> 'off'
+			ORDER BY due.created_at
+			LIMIT $1
+			FOR UPDATE OF due SKIP LOCKED
+		  )
+		RETURNING n.id, n.type, n.event_number, n.focus, n.resource, n.attempts, n.created_at,
+		          s.id, s.topic, s.endpoint, s.secret, s.content, s.timeout, s.status, s.events_since_start
+	`, d.options.BatchSize, leaseMargin)
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []notification
+	for rows.Next() {
+		var n notification
+		if err := rows.Scan(&n.id, &n.notificationType, &n.eventNumber, &n.focus, &n.resource, &n.attempts, &n.createdAt,
+			&n.subscriptionID, &n.topic, &n.endpoint, &n.secret, &n.content, &n.timeout, &n.status, &n.eventsSinceStart); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, n := range batch {
+		wg.Add(1)
+		go func(n notification) {
+			defer wg.Done()
+			d.deliver(ctx, n)
+		}(n)
+	}
+	wg.Wait()
+
+	return len(batch), nil
+}
+
+// deliver posts a notification and records the outcome
+func (d *Dispatcher) deliver(ctx context.Context, n notification) {
+	statusCode, err := d.post(ctx, n)
+	if err != nil {
+		d.delivered.WithLabelValues(n.notificationType, "failure").Inc()
+		if recordErr := d.markRetry(ctx, n, statusCode, err); recordErr != nil && ctx.Err() == nil {
+			d.logger.WithError(recordErr).WithField("notification_id", n.id).Error("Failed to record subscription delivery failure")
+		}
+		return
+	}
+
+	d.delivered.WithLabelValues(n.notificationType, "success").Inc()
+	if err := d.markDelivered(ctx, n, statusCode); err != nil && ctx.Err() == nil {
+		d.logger.WithError(err).WithField("notification_id", n.id).Error("Failed to record subscription delivery")
+	}
+}
+
+// post sends the notification bundle to the endpoint and returns the HTTP
+// status, or 0 if the endpoint was not reached
+func (d *Dispatcher) post(ctx context.Context, n notification) (int, error) {
+	body, err := json.Marshal(notificationBundle(n))
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(n.timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/fhir+json")
+	req.Header.Set(HeaderNotificationID, n.id)
+	req.Header.Set(HeaderSignature, Sign(n.secret, time.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// markDelivered finishes a notification and marks the subscription healthy.
+// A delivered handshake activates a requested subscription.
+func (d *Dispatcher) markDelivered(ctx context.Context, n notification, statusCode int) error {
+	if _, err := d.db.ExecContext(ctx, `
+		UPDATE subscription_notifications
+		SET status = 'delivered', delivered_at = NOW(), last_status_code = $2
+		WHERE id = $1
+	`, n.id, statusCode); err != nil {
+		return err
+	}
+
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = CASE WHEN status = 'off' THEN status ELSE 'active' END,
+		    consecutive_failures = 0,
+		    last_delivered_at = NOW()
+		WHERE id = $1
+	`, n.subscriptionID)
+	return err
+}
+
+// markRetry schedules the next attempt with exponential backoff, or marks the
+// notification failed once it is out of attempts, and puts the subscription
+// in error
+func (d *Dispatcher) markRetry(ctx context.Context, n notification, statusCode int, deliveryErr error) error {
+	var status string
+	if err := d.db.QueryRowContext(ctx, `
+		UPDATE subscription_notifications
+		SET last_error = $2,
+		    last_status_code = NULLIF($3, 0),
+		    status = CASE WHEN attempts >= $4 THEN 'failed' ELSE 'pending' END,
+		    next_attempt_at = NOW() + make_interval(secs => LEAST(POWER(2, attempts), $5))
+		WHERE id = $1
+		RETURNING status
+	`, n.id, deliveryErr.Error(), statusCode, d.options.MaxAttempts, d.options.MaxBackoff.Seconds()).Scan(&status); err != nil {
+		return err
+	}
+
+	logger := d.logger.WithError(deliveryErr).WithFields(logrus.Fields{
+		"subscription_id": n.subscriptionID,
+		"notification_id": n.id,
+		"attempts":        n.attempts,
+		"status_code":     statusCode,
+	})
+	if status == DeliveryFailed {
+		logger.Error("Subscription notification exceeded maximum delivery attempts")
+	} else {
+		logger.Warn("Failed to deliver subscription notification, will retry")
+	}
+
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = CASE WHEN status = 'off' THEN status ELSE 'error' END,
+		    consecutive_failures = consecutive_failures + 1,
+		    last_error = $2,
+		    last_error_at = NOW()
+		WHERE id = $1
+	`, n.subscriptionID, deliveryErr.Error())
+	return err
+}
+
+// maintain queues heartbeats, turns off ended subscriptions and purges
+// finished notifications
+func (d *Dispatcher) maintain(ctx context.Context) {
+	// A heartbeat is due once nothing was sent for a heartbeat period; it is
+	// not queued again while an earlier one is still pending
+	heartbeats, err := d.exec(ctx, `
+		WITH due AS (
+			UPDATE subscriptions s
+			SET last_notified_at = NOW()
+			WHERE s.status IN ('active', 'error')
+			  AND s.heartbeat_period > 0
+			  AND COALESCE(s.last_notified_at, s.created_at) + make_interval(secs => s.heartbeat_period) <= NOW()
+			  AND NOT EXISTS (
+				SELECT 1 FROM subscription_notifications n
+				WHERE n.subscription_id = s.id AND n.type = 'heartbeat' AND n.status = 'pending'
+			  )
+			RETURNING s.id
+		)
+		INSERT INTO subscription_notifications (subscription_id, type)
+		SELECT id, 'heartbeat' FROM due
+	`)
+	if err != nil {
+		d.logger.WithError(err).Error("Failed to queue subscription heartbeats")
+	}
+
+	ended, err := d.exec(ctx, `UPDATE subscriptions SET status = 'off' WHERE end_at <= NOW() AND status <> 'off'`)
+	if err != nil {
+		d.logger.WithError(err).Error("Failed to turn off ended subscriptions")
+	}
+
+	purged, err := d.exec(ctx, `
+		DELETE FROM subscription_notifications
+		WHERE status IN ('delivered', 'failed') AND updated_at < $1
+	`, time.Now().Add(-d.options.Retention))
+	if err != nil {
+		d.logger.WithError(err).Error("Failed to purge subscription notifications")
+	}
+
+	if heartbeats > 0 || ended > 0 || purged > 0 {
+		d.logger.WithFields(logrus.Fields{
+			"heartbeats": heartbeats,
+			"ended":      ended,
+			"purged":     purged,
+		}).Info("Subscription maintenance completed")
+	}
+}
+
+func (d *Dispatcher) exec(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	result, err := d.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// notificationBundle builds the subscription-notification bundle of a
+// notification. The SubscriptionStatus comes first; full-resource
+// subscriptions also receive the focus resource.
+func notificationBundle(n notification) fhir.Bundle {
+	status := fhir.SubscriptionStatus{
+		ResourceType:                 "SubscriptionStatus",
+		Status:                       n.status,
+		Type:                         n.notificationType,
+		EventsSinceSubscriptionStart: strconv.FormatInt(n.eventsSinceStart, 10),
+		Subscription:                 fhir.Reference{Reference: "Subscription/" + n.subscriptionID},
+		Topic:                        n.topic,
+	}
+
+	bundle := fhir.Bundle{
+		ResourceType: "Bundle",
+		ID:           n.id,
+		Type:         "subscription-notification",
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if n.notificationType != TypeEventNotification {
+		bundle.Entry = []fhir.BundleEntry{{FullURL: "urn:uuid:" + n.id, Resource: status}}
+		return bundle
+	}
+
+	event := fhir.SubscriptionStatusNotificationEvent{
+		EventNumber: strconv.FormatInt(n.eventNumber.Int64, 10),
+		Timestamp:   n.createdAt.UTC().Format(time.RFC3339),
+	}
+	if n.content != ContentEmpty && n.focus.Valid {
+		event.Focus = &fhir.Reference{Reference: n.focus.String}
+	}
+	status.NotificationEvent = []fhir.SubscriptionStatusNotificationEvent{event}
+	bundle.Entry = []fhir.BundleEntry{{FullURL: "urn:uuid:" + n.id, Resource: status}}
+
+	if n.content == ContentFullResource && len(n.resource) > 0 {
+		bundle.Entry = append(bundle.Entry, fhir.BundleEntry{Resource: json.RawMessage(n.resource)})
+	}
+	return bundle
+}
+
+// Sign returns the signature header of a notification body: the time it was
+// signed and the HMAC-SHA256 of the time and the body under the
+// subscription's secret, as "t=<unix seconds>,v1=<hex>". Subscribers verify
+// the HMAC and reject stale times to prevent replays.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,132 @@
+package subscription
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/kafka"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/poll"
+	"github.com/Fadil369/NPHIES/services/api-gateway/internal/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+// Matcher consumes response events from Kafka and queues a notification for
+// every subscription they match. It uses its own consumer group, so it sees
+// every event independently of the poll queue.
+type Matcher struct {
+	store    *Store
+	brokers  []string
+	security kafka.SecurityConfig
+	groupID  string
+	logger   *logrus.Logger
+}
+
+// NewMatcher creates a new matcher that queues notifications in the store
+func NewMatcher(store *Store, brokers []string, security kafka.SecurityConfig, groupID string, logger *logrus.Logger) *Matcher {
+	return &Matcher{
+		store:    store,
+		brokers:  brokers,
+		security: security,
+		groupID:  groupID,
+		logger:   logger,
+	}
+}
+
+// Run consumes the given topics until the context is cancelled
+func (m *Matcher) Run(ctx context.Context, topics ...string) {
+	for _, topic := range topics {
+		go m.consume(ctx, topic)
+	}
+}
+
+// consume reads a single topic. Offsets are committed only after the
+// notifications have been stored; redelivered events are absorbed by the
+// per-subscription event ID constraint.
+func (m *Matcher) consume(ctx context.Context, topic string) {
+	consumer, err := kafka.NewConsumer(m.brokers, m.security, topic, m.groupID, m.logger)
+	if err != nil {
+		m.logger.WithError(err).Errorf("Failed to create consumer for topic %s", topic)
+		return
+	}
+	defer consumer.Close()
+
+	m.logger.WithField("topic", topic).Info("Starting subscription matching")
+
+	for {
+		msg, err := consumer.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			m.logger.WithError(err).Errorf("Failed to fetch message from topic %s", topic)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		msgCtx, span := tracing.StartConsumerSpan(ctx, msg, m.groupID)
+		err = m.handle(msgCtx, topic, msg.Value)
+		tracing.End(span, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			m.logger.WithError(err).WithFields(logrus.Fields{
+				"topic":     topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+			}).Error("Failed to match subscription event")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		if err := consumer.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+			m.logger.WithError(err).Errorf("Failed to commit offset on topic %s", topic)
+		}
+	}
+}
+
+// handle queues an event for the subscriptions of its provider whose filters
+// it satisfies. Malformed events are logged and skipped.
+func (m *Matcher) handle(ctx context.Context, topic string, value []byte) error {
+	var event poll.Event
+	if err := json.Unmarshal(value, &event); err != nil {
+		m.logger.WithError(err).WithField("topic", topic).Warn("Skipping malformed subscription event")
+		return nil
+	}
+	if event.EventID == "" || event.ProviderID == "" || event.ResourceType == "" || len(event.Resource) == 0 {
+		m.logger.WithFields(logrus.Fields{
+			"topic":    topic,
+			"event_id": event.EventID,
+		}).Warn("Skipping incomplete subscription event")
+		return nil
+	}
+
+	subscriptions, err := m.store.Matching(ctx, event.ResourceType, event.ProviderID)
+	if err != nil {
+		return err
+	}
+
+	focus := event.ResourceType
+	if event.ResourceID != "" {
+		focus += "/" + event.ResourceID
+	}
+
+	for _, subscription := range subscriptions {
+		if !Matches(event.Resource, subscription.Filters) {
+			continue
+		}
+		created, err := m.store.AddEvent(ctx, subscription.ID, event.EventID, focus, event.Resource)
+		if err != nil {
+			return err
+		}
+		m.logger.WithFields(logrus.Fields{
+			"topic":           topic,
+			"event_id":        event.EventID,
+			"subscription_id": subscription.ID,
+			"duplicate":       !created,
+		}).Debug("Subscription notification queued")
+	}
+
+	return nil
+}
@@ -0,0 +1,416 @@
+// Package subscription implements FHIR R5 topic-based Subscriptions with
+// REST-hook delivery. Providers subscribe to a topic, such as claim responses
+// for their organization; events consumed from Kafka are matched against the
+// subscriptions and queued as notifications, which a dispatcher posts to each
+// subscriber's endpoint as a signed notification bundle, retrying with
+// exponential backoff. Idle subscriptions receive heartbeats.
+package subscription
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Subscription statuses
+const (
+	StatusRequested = "requested" // waiting for the handshake to be delivered
+	StatusActive    = "active"
+	StatusError     = "error" // the last delivery failed; delivery continues
+	StatusOff       = "off"
+)
+
+// Notification types
+const (
+	TypeHandshake         = "handshake"
+	TypeHeartbeat         = "heartbeat"
+	TypeEventNotification = "event-notification"
+)
+
+// Payload content of notifications
+const (
+	ContentEmpty        = "empty"
+	ContentIDOnly       = "id-only"
+	ContentFullResource = "full-resource"
+)
+
+// Notification delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// secretPrefix starts every signing secret
+const secretPrefix = "whsec_"
+
+var (
+	// ErrNotFound is returned for unknown subscriptions and for subscriptions
+	// of another organization
+	ErrNotFound = errors.New("subscription not found")
+)
+
+// Filter narrows a topic to resources whose parameter has the value
+type Filter struct {
+	Parameter string `json:"parameter"`
+	Value     string `json:"value"`
+}
+
+// Subscription is a REST-hook subscription of an organization to a topic
+type Subscription struct {
+	ID                  string
+	OrganizationID      string
+	TenantID            string
+	Topic               string
+	ResourceType        string
+	Filters             []Filter
+	Endpoint            string
+	Secret              string // HMAC key for notification signatures
+	Content             string
+	HeartbeatPeriod     int // seconds; 0 for no heartbeats
+	Timeout             int // seconds per delivery attempt
+	Status              string
+	Reason              string
+	End                 *time.Time
+	EventsSinceStart    int64
+	ConsecutiveFailures int
+	LastError           string
+	LastErrorAt         *time.Time
+	LastDeliveredAt     *time.Time
+	CreatedBy           string
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// NewSubscription describes a subscription to create
+type NewSubscription struct {
+	OrganizationID  string
+	TenantID        string
+	Topic           Topic
+	Filters         []Filter
+	Endpoint        string
+	Content         string
+	HeartbeatPeriod int
+	Timeout         int
+	Reason          string
+	End             *time.Time
+	CreatedBy       string
+}
+
+// DeliveryError is a failed delivery attempt of a notification
+type DeliveryError struct {
+	NotificationID string
+	Type           string
+	EventNumber    int64
+	Attempts       int
+	Status         string // pending while retries remain, failed afterwards
+	StatusCode     int    // HTTP status of the endpoint; 0 if it was not reached
+	Error          string
+	At             time.Time
+}
+
+// Store persists subscriptions and their notifications
+type Store struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+// NewStore creates a new subscription store
+func NewStore(db *sql.DB, logger *logrus.Logger) *Store {
+	return &Store{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create stores a subscription with a new signing secret and queues its
+// handshake. The subscription stays requested until the handshake is delivered.
+func (s *Store) Create(ctx context.Context, newSubscription NewSubscription) (*Subscription, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	filters, err := json.Marshal(newSubscription.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO subscriptions (
+			organization_id, tenant_id, topic, resource_type, filters, endpoint, secret,
+			content, heartbeat_period, timeout, status, reason, end_at, created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id
+	`,
+		newSubscription.OrganizationID,
+		nullString(newSubscription.TenantID),
+		newSubscription.Topic.URL,
+		newSubscription.Topic.ResourceType,
+		filters,
+		newSubscription.Endpoint,
+		secret,
+		newSubscription.Content,
+		newSubscription.HeartbeatPeriod,
+		newSubscription.Timeout,
+		StatusRequested,
+		nullString(newSubscription.Reason),
+		newSubscription.End,
+		nullString(newSubscription.CreatedBy),
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO subscription_notifications (subscription_id, type) VALUES ($1, $2)
+	`, id, TypeHandshake); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id, newSubscription.OrganizationID)
+}
+
+// Get returns a subscription of an organization
+func (s *Store) Get(ctx context.Context, id, organizationID string) (*Subscription, error) {
+	subscription, err := scanSubscription(s.db.QueryRowContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM subscriptions WHERE id::text = $1 AND organization_id = $2`,
+		id, organizationID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return subscription, err
+}
+
+// List returns the subscriptions of an organization, newest first
+func (s *Store) List(ctx context.Context, organizationID string) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions
+		WHERE organization_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1000`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// CountActive returns the number of subscriptions of an organization that are
+// not off
+func (s *Store) CountActive(ctx context.Context, organizationID string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM subscriptions WHERE organization_id = $1 AND status <> $2`,
+		organizationID, StatusOff).Scan(&count)
+	return count, err
+}
+
+// Deactivate turns a subscription off. Notifications still queued for it are
+// not delivered. Deactivating a subscription that is off is a no-op.
+func (s *Store) Deactivate(ctx context.Context, id, organizationID string) (*Subscription, error) {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE subscriptions SET status = $3
+		WHERE id::text = $1 AND organization_id = $2 AND status <> $3
+	`, id, organizationID, StatusOff); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id, organizationID)
+}
+
+// Matching returns the subscriptions that receive events for a resource type
+// addressed to an organization
+func (s *Store) Matching(ctx context.Context, resourceType, organizationID string) ([]Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM subscriptions
+		WHERE resource_type = $1 AND organization_id = $2
+		  AND status IN ($3, $4)
+		  AND (end_at IS NULL OR end_at > NOW())`,
+		resourceType, organizationID, StatusActive, StatusError)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, *subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// AddEvent queues an event notification and numbers it in the subscription's
+// sequence of events. Notifications are deduplicated by event ID, so adding an
+// event twice is a no-op and reports false.
+func (s *Store) AddEvent(ctx context.Context, subscriptionID, eventID, focus string, resource json.RawMessage) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var notificationID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO subscription_notifications (subscription_id, type, event_id, focus, resource)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+		RETURNING id
+	`, subscriptionID, TypeEventNotification, eventID, focus, []byte(resource)).Scan(&notificationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		WITH numbered AS (
+			UPDATE subscriptions
+			SET events_since_start = events_since_start + 1, last_notified_at = NOW()
+			WHERE id = $1
+			RETURNING events_since_start
+		)
+		UPDATE subscription_notifications SET event_number = numbered.events_since_start
+		FROM numbered WHERE subscription_notifications.id = $2
+	`, subscriptionID, notificationID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// DeliveryErrors returns the most recent failed delivery attempts of a
+// subscription, newest first
+func (s *Store) DeliveryErrors(ctx context.Context, subscriptionID string, limit int) ([]DeliveryError, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, type, COALESCE(event_number, 0), attempts, status,
+		       COALESCE(last_status_code, 0), last_error, updated_at
+		FROM subscription_notifications
+		WHERE subscription_id = $1 AND last_error IS NOT NULL
+		ORDER BY updated_at DESC
+		LIMIT $2
+	`, subscriptionID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveryErrors := []DeliveryError{}
+	for rows.Next() {
+		var deliveryError DeliveryError
+		if err := rows.Scan(
+			&deliveryError.NotificationID,
+			&deliveryError.Type,
+			&deliveryError.EventNumber,
+			&deliveryError.Attempts,
+			&deliveryError.Status,
+			&deliveryError.StatusCode,
+			&deliveryError.Error,
+			&deliveryError.At,
+		); err != nil {
+			return nil, err
+		}
+		deliveryErrors = append(deliveryErrors, deliveryError)
+	}
+	return deliveryErrors, rows.Err()
+}
+
+// subscriptionColumns are the columns read by scanSubscription, in order
+const subscriptionColumns = `id, organization_id, tenant_id, topic, resource_type, filters, endpoint, secret,
+	content, heartbeat_period, timeout, status, reason, end_at, events_since_start, consecutive_failures,
+	last_error, last_error_at, last_delivered_at, created_by, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSubscription(row scanner) (*Subscription, error) {
+	var subscription Subscription
+	var tenantID, reason, lastError, createdBy sql.NullString
+	var endAt, lastErrorAt, lastDeliveredAt sql.NullTime
+	var filters []byte
+
+	if err := row.Scan(
+		&subscription.ID,
+		&subscription.OrganizationID,
+		&tenantID,
+		&subscription.Topic,
+		&subscription.ResourceType,
+		&filters,
+		&subscription.Endpoint,
+		&subscription.Secret,
+		&subscription.Content,
+		&subscription.HeartbeatPeriod,
+		&subscription.Timeout,
+		&subscription.Status,
+		&reason,
+		&endAt,
+		&subscription.EventsSinceStart,
+		&subscription.ConsecutiveFailures,
+		&lastError,
+		&lastErrorAt,
+		&lastDeliveredAt,
+		&createdBy,
+		&subscription.CreatedAt,
+		&subscription.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(filters, &subscription.Filters); err != nil {
+		return nil, err
+	}
+	subscription.TenantID = tenantID.String
+	subscription.Reason = reason.String
+	subscription.LastError = lastError.String
+	subscription.CreatedBy = createdBy.String
+	subscription.End = nullTime(endAt)
+	subscription.LastErrorAt = nullTime(lastErrorAt)
+	subscription.LastDeliveredAt = nullTime(lastDeliveredAt)
+	return &subscription, nil
+}
+
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(buf), nil
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
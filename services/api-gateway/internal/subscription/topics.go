@@ -0,0 +1,213 @@
+package subscription
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// Topic is a subscription topic. Events of its resource type that are
+// addressed to the subscriber's organization match it, narrowed by filters on
+// the parameters it allows.
+type Topic struct {
+	URL          string
+	ResourceType string
+	Description  string
+	Filters      []string // allowed filter parameters
+}
+
+// Topics are the subscription topics of the gateway, fed by the claims
+// response and prior authorization status topics in Kafka
+var Topics = []Topic{
+	{
+		URL:          "http://nphies.sa/fhir/SubscriptionTopic/claim-response",
+		ResourceType: "ClaimResponse",
+		Description:  "A claim was adjudicated",
+		Filters:      []string{"status", "outcome", "use", "patient", "request"},
+	},
+	{
+		URL:          "http://nphies.sa/fhir/SubscriptionTopic/prior-auth-response",
+		ResourceType: "CoverageEligibilityResponse",
+		Description:  "A prior authorization or eligibility request was answered",
+		Filters:      []string{"status", "outcome", "patient", "request"},
+	},
+	{
+		URL:          "http://nphies.sa/fhir/SubscriptionTopic/communication",
+		ResourceType: "Communication",
+		Description:  "A payer sent a communication about a claim or authorization",
+		Filters:      []string{"status", "category", "patient", "about"},
+	},
+}
+
+// LookupTopic returns the topic with the given canonical URL
+func LookupTopic(topicURL string) (Topic, bool) {
+	for _, topic := range Topics {
+		if topic.URL == topicURL {
+			return topic, true
+		}
+	}
+	return Topic{}, false
+}
+
+// ValidateFilters checks that every filter uses a parameter of the topic
+func (t Topic) ValidateFilters(filters []Filter) error {
+	for _, filter := range filters {
+		allowed := false
+		for _, parameter := range t.Filters {
+			if filter.Parameter == parameter {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("filter parameter %q is not supported by topic %s; use one of %s",
+				filter.Parameter, t.URL, strings.Join(t.Filters, ", "))
+		}
+		if filter.Value == "" {
+			return fmt.Errorf("filter parameter %q has no value", filter.Parameter)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a resource satisfies every filter. A filter compares
+// a top-level element of the resource: strings by value, references by their
+// reference, and codeable concepts by any of their codes. References also
+// match by ID alone, so patient=123 matches Patient/123.
+func Matches(resource json.RawMessage, filters []Filter) bool {
+	if len(filters) == 0 {
+		return true
+	}
+
+	var elements map[string]json.RawMessage
+	if err := json.Unmarshal(resource, &elements); err != nil {
+		return false
+	}
+	for _, filter := range filters {
+		if !elementMatches(elements[filter.Parameter], filter.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func elementMatches(element json.RawMessage, value string) bool {
+	if len(element) == 0 {
+		return false
+	}
+
+	var text string
+	if json.Unmarshal(element, &text) == nil {
+		return text == value
+	}
+
+	var reference struct {
+		Reference string `json:"reference"`
+	}
+	if json.Unmarshal(element, &reference) == nil && reference.Reference != "" {
+		return reference.Reference == value || strings.HasSuffix(reference.Reference, "/"+value)
+	}
+
+	var concepts []struct {
+		Coding []struct {
+			Code string `json:"code"`
+		} `json:"coding"`
+	}
+	if json.Unmarshal(element, &concepts) == nil {
+		for _, concept := range concepts {
+			for _, coding := range concept.Coding {
+				if coding.Code == value {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ValidateEndpoint checks a REST-hook endpoint. Endpoints must use HTTPS
+// unless allowHTTP is set and may not carry credentials in the URL.
+func ValidateEndpoint(endpoint string, allowHTTP bool) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return errors.New("endpoint must be an absolute URL")
+	}
+	switch parsed.Scheme {
+	case "https":
+	case "http":
+		if !allowHTTP {
+			return errors.New("endpoint must use https")
+		}
+	default:
+		return errors.New("endpoint must use https")
+	}
+	if parsed.User != nil {
+		return errors.New("endpoint must not contain credentials")
+	}
+	return nil
+}
+
+// errPrivateAddress is returned when an endpoint resolves to an address
+// inside the platform network
+var errPrivateAddress = errors.New("endpoint resolves to a private address")
+
+// blockedPrefixes are the address ranges notifications are never sent to:
+// the IANA special-purpose ranges, which include loopback, private, shared
+// (CGNAT, common inside cloud networks), link-local, benchmarking,
+// documentation, multicast and reserved ones, and IPv6 ranges that
+// translate to IPv4 addresses
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("192.88.99.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("100::/64"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("ff00::/8"),
+}
+
+// denyPrivateAddresses is a net.Dialer control function that refuses
+// addresses in blockedPrefixes. It runs after DNS resolution, so a hostname
+// cannot be pointed at internal services.
+func denyPrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return errPrivateAddress
+	}
+	// IPv4-mapped IPv6 addresses are checked as the IPv4 address they reach
+	addr = addr.WithZone("").Unmap()
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return errPrivateAddress
+		}
+	}
+	return nil
+}
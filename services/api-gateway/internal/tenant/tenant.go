@@ -0,0 +1,92 @@
+// Package tenant carries the payer a request is scoped to. The gateway
+// resolves the tenant from the caller's credentials and forwards it to the
+// services, which restrict coverage and member data to that payer.
+package tenant
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Header carries the tenant on HTTP requests to other services
+	Header = "X-Tenant-ID"
+	// Key names the tenant in gin contexts and log fields
+	Key = "tenant_id"
+
+	maxLength = 64
+)
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying the tenant
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant carried by ctx, or an empty string for
+// requests that are not scoped to a tenant
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Get returns the tenant of the current request
+func Get(c *gin.Context) string {
+	return c.GetString(Key)
+}
+
+// Set scopes the current request to a tenant. The tenant is stored on the gin
+// and request contexts and replaces any tenant header sent by the client.
+func Set(c *gin.Context, id string) {
+	c.Set(Key, id)
+	c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+	c.Request.Header.Set(Header, id)
+}
+
+// Valid accepts tenant IDs of letters, digits, '-', '_' and '.', so that they
+// are safe in headers, log lines and cache keys
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Transport wraps base so that outgoing requests carry the tenant of their
+// context. A nil base uses http.DefaultTransport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper{base: base}
+}
+
+type roundTripper struct {
+	base http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := FromContext(req.Context())
+	if id == "" || req.Header.Get(Header) == id {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, id)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,108 @@
+// Package tracing sets up OpenTelemetry distributed tracing and provides the
+// instrumentation shared by the HTTP, database, Redis and Kafka layers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName identifies spans created by this service's own
+// instrumentation
+const InstrumentationName = "github.com/Fadil369/NPHIES/services/api-gateway"
+
+// Exporters
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterNone   = "none"
+)
+
+// Config controls how spans are sampled and exported
+type Config struct {
+	Enabled        bool
+	ServiceName    string
+	ServiceVersion string
+	Environment    string
+	Exporter       string  // otlp, stdout or none
+	Endpoint       string  // OTLP/HTTP collector, e.g. http://otel-collector:4318
+	Insecure       bool    // use plain HTTP for the OTLP endpoint
+	SampleRatio    float64 // fraction of new traces that are sampled
+}
+
+// Init installs the global tracer provider and W3C trace context propagator.
+// The returned function flushes pending spans and must be called on shutdown.
+// With tracing disabled, incoming trace context is still propagated to
+// downstream calls so that traces are not broken by this service.
+func Init(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if !cfg.Enabled || cfg.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(cfg.ServiceVersion),
+		semconv.DeploymentEnvironment(cfg.Environment),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP, "":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			if strings.Contains(cfg.Endpoint, "://") {
+				opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+			} else {
+				opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+			}
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+}
+
+// Tracer returns the tracer used by this service's own instrumentation
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
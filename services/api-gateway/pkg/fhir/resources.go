@@ -1,5 +1,7 @@
 package fhir
 
+import "encoding/json"
+
 // Base FHIR Resource structure
 type Resource struct {
 	ResourceType string `json:"resourceType"`
@@ -37,8 +39,10 @@ type Identifier struct {
 }
 
 type Period struct {
-	Start string `json:"start,omitempty"`
-	End   string `json:"end,omitempty"`
+	Start        string   `json:"start,omitempty"`
+	StartElement *Element `json:"_start,omitempty"`
+	End          string   `json:"end,omitempty"`
+	EndElement   *Element `json:"_end,omitempty"`
 }
 
 type Reference struct {
@@ -90,6 +94,7 @@ type Patient struct {
 	Telecom           []ContactPoint   `json:"telecom,omitempty"`
 	Gender            string           `json:"gender,omitempty"`
 	BirthDate         string           `json:"birthDate,omitempty"`
+	BirthDateElement  *Element         `json:"_birthDate,omitempty"`
 	DeceasedBoolean   *bool            `json:"deceasedBoolean,omitempty"`
 	DeceasedDateTime  string           `json:"deceasedDateTime,omitempty"`
 	Address           []Address        `json:"address,omitempty"`
@@ -377,8 +382,9 @@ type BundleEntry struct {
 }
 
 type BundleEntrySearch struct {
-	Mode  string  `json:"mode,omitempty"`
-	Score float64 `json:"score,omitempty"`
+	Extension []Extension `json:"extension,omitempty"` // e.g. the match-grade of $match results
+	Mode      string      `json:"mode,omitempty"`
+	Score     float64     `json:"score,omitempty"`
 }
 
 type BundleEntryRequest struct {
@@ -406,4 +412,94 @@ type Signature struct {
 	TargetFormat string      `json:"targetFormat,omitempty"`
 	SigFormat    string      `json:"sigFormat,omitempty"`
 	Data         string      `json:"data,omitempty"`
-}
\ No newline at end of file
+}
+
+// FHIR OperationOutcome for errors returned to FHIR clients
+type OperationOutcome struct {
+	ResourceType string                  `json:"resourceType"`
+	ID           string                  `json:"id,omitempty"`
+	Issue        []OperationOutcomeIssue `json:"issue"`
+}
+
+type OperationOutcomeIssue struct {
+	Severity    string           `json:"severity"` // fatal, error, warning, information
+	Code        string           `json:"code"`     // e.g. forbidden, transient, required
+	Details     *CodeableConcept `json:"details,omitempty"`
+	Diagnostics string           `json:"diagnostics,omitempty"`
+	Expression  []string         `json:"expression,omitempty"`
+}
+
+type Extension struct {
+	URL         string `json:"url"`
+	ValueString string `json:"valueString,omitempty"`
+	ValueCode   string `json:"valueCode,omitempty"`
+}
+
+// Element carries the extensions of a primitive value, serialized as the
+// value's name prefixed with an underscore, e.g. _birthDate
+type Element struct {
+	Extension []Extension `json:"extension,omitempty"`
+}
+
+// FHIR Parameters, the input and output of operations
+type Parameters struct {
+	ResourceType string               `json:"resourceType"`
+	ID           string               `json:"id,omitempty"`
+	Parameter    []ParametersParameter `json:"parameter,omitempty"`
+}
+
+type ParametersParameter struct {
+	Name         string          `json:"name"`
+	ValueString  string          `json:"valueString,omitempty"`
+	ValueCode    string          `json:"valueCode,omitempty"`
+	ValueInteger *int            `json:"valueInteger,omitempty"`
+	ValueBoolean *bool           `json:"valueBoolean,omitempty"`
+	Resource     json.RawMessage `json:"resource,omitempty"`
+}
+
+// FHIR R5 topic-based Subscription
+type Subscription struct {
+	ResourceType    string                 `json:"resourceType"`
+	ID              string                 `json:"id,omitempty"`
+	Meta            *Meta                  `json:"meta,omitempty"`
+	Extension       []Extension            `json:"extension,omitempty"`
+	Status          string                 `json:"status,omitempty"` // requested, active, error, off
+	Topic           string                 `json:"topic"`
+	Reason          string                 `json:"reason,omitempty"`
+	ManagingEntity  *Reference             `json:"managingEntity,omitempty"`
+	End             string                 `json:"end,omitempty"`
+	FilterBy        []SubscriptionFilterBy `json:"filterBy,omitempty"`
+	ChannelType     Coding                 `json:"channelType"`
+	Endpoint        string                 `json:"endpoint,omitempty"`
+	HeartbeatPeriod int                    `json:"heartbeatPeriod,omitempty"` // seconds
+	Timeout         int                    `json:"timeout,omitempty"`         // seconds
+	ContentType     string                 `json:"contentType,omitempty"`
+	Content         string                 `json:"content,omitempty"` // empty, id-only, full-resource
+}
+
+type SubscriptionFilterBy struct {
+	ResourceType    string `json:"resourceType,omitempty"`
+	FilterParameter string `json:"filterParameter"`
+	Comparator      string `json:"comparator,omitempty"`
+	Modifier        string `json:"modifier,omitempty"`
+	Value           string `json:"value"`
+}
+
+// FHIR R5 SubscriptionStatus, the first entry of every notification bundle
+type SubscriptionStatus struct {
+	ResourceType                 string                                `json:"resourceType"`
+	ID                           string                                `json:"id,omitempty"`
+	Status                       string                                `json:"status,omitempty"`
+	Type                         string                                `json:"type"` // handshake, heartbeat, event-notification, query-status
+	EventsSinceSubscriptionStart string                                `json:"eventsSinceSubscriptionStart,omitempty"`
+	NotificationEvent            []SubscriptionStatusNotificationEvent `json:"notificationEvent,omitempty"`
+	Subscription                 Reference                             `json:"subscription"`
+	Topic                        string                                `json:"topic,omitempty"`
+	Error                        []CodeableConcept                     `json:"error,omitempty"`
+}
+
+type SubscriptionStatusNotificationEvent struct {
+	EventNumber string     `json:"eventNumber"`
+	Timestamp   string     `json:"timestamp,omitempty"`
+	Focus       *Reference `json:"focus,omitempty"`
+}
@@ -13,12 +13,17 @@ import (
 
 	"github.com/Fadil369/NPHIES/services/automation-service/internal/config"
 	"github.com/Fadil369/NPHIES/services/automation-service/internal/handlers"
+	"github.com/Fadil369/NPHIES/services/automation-service/internal/mtls"
+	"github.com/Fadil369/NPHIES/services/automation-service/internal/redact"
+	"github.com/Fadil369/NPHIES/services/automation-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/automation-service/internal/tracing"
 )
 
 func main() {
 	// Initialize logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(requestid.LogHook{})
 
 	// Load configuration
 	cfg, err := config.Load()
@@ -26,6 +31,33 @@ func main() {
 		logger.WithError(err).Fatal("Failed to load configuration")
 	}
 
+	// Redaction runs last so that it also covers fields added by other hooks
+	if err := redact.Install(logger, redact.Config{
+		Enabled:       cfg.Logging.Redaction.Enabled,
+		Mode:          cfg.Logging.Redaction.Mode,
+		AllowlistMode: cfg.Logging.Redaction.AllowlistMode,
+		Fields:        cfg.Logging.Redaction.Fields,
+		Allowlist:     cfg.Logging.Redaction.Allowlist,
+		TokenKey:      cfg.Logging.Redaction.TokenKey,
+	}); err != nil {
+		logger.WithError(err).Fatal("Failed to configure log redaction")
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Enabled:        cfg.Tracing.Enabled,
+		ServiceName:    "automation-service",
+		ServiceVersion: "1.0.0",
+		Environment:    cfg.Tracing.Environment,
+		Exporter:       cfg.Tracing.Exporter,
+		Endpoint:       cfg.Tracing.Endpoint,
+		Insecure:       cfg.Tracing.Insecure,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize tracing")
+	}
+
 	// Initialize handlers
 	h, err := handlers.NewHandler(logger, cfg)
 	if err != nil {
@@ -41,10 +73,36 @@ func main() {
 		Handler: router,
 	}
 
+	// Serve TLS, requiring client certificates with mTLS. Certificates are
+	// reloaded when their files change.
+	tlsCtx, stopTLS := context.WithCancel(context.Background())
+	defer stopTLS()
+	if tlsCfg := cfg.Server.TLS; tlsCfg.Enabled || tlsCfg.MTLS {
+		if tlsCfg.MTLS && len(tlsCfg.CAFiles) == 0 {
+			logger.Fatal("ENABLE_MTLS requires TLS_CA_CERTS")
+		}
+		serverTLS, err := mtls.NewStore(tlsCfg.CertFile, tlsCfg.KeyFile, tlsCfg.CAFiles, logger)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load TLS certificates")
+		}
+		go serverTLS.Watch(tlsCtx, time.Duration(tlsCfg.ReloadInterval)*time.Second)
+		srv.TLSConfig = serverTLS.ServerConfig(tlsCfg.MTLS)
+	}
+
 	// Start server
 	go func() {
-		logger.WithField("port", cfg.Server.Port).Info("Starting automation service")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			logger.WithFields(logrus.Fields{
+				"port": cfg.Server.Port,
+				"mtls": cfg.Server.TLS.MTLS,
+			}).Info("Starting automation service with TLS")
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			logger.WithField("port", cfg.Server.Port).Info("Starting automation service")
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.WithError(err).Fatal("Failed to start server")
 		}
 	}()
@@ -64,6 +122,10 @@ func main() {
 		logger.WithError(err).Fatal("Server forced to shutdown")
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		logger.WithError(err).Error("Failed to flush traces")
+	}
+
 	logger.Info("Server exited")
 }
 
@@ -73,7 +135,12 @@ func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger)
 	}
 
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	router.Use(tracing.Middleware("automation-service"))
+	router.Use(requestid.Middleware())
+	router.Use(requestLogger(logger), gin.Recovery())
+	if cfg.Server.TLS.MTLS {
+		router.Use(mtls.RequireClientCert())
+	}
 
 	// Health endpoints
 	router.GET("/health", h.Health)
@@ -129,4 +196,26 @@ func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger)
 	}
 
 	return router
-}
\ No newline at end of file
+}
+
+// requestLogger logs each request through logrus, so that request logs are
+// redacted like any other entry. The route template is logged because raw
+// paths carry member identifiers.
+func requestLogger(logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+		logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"method":    c.Request.Method,
+			"path":      path,
+			"status":    c.Writer.Status(),
+			"latency":   time.Since(start),
+			"client_ip": c.ClientIP(),
+		}).Info("HTTP Request")
+	}
+}
@@ -11,6 +11,12 @@ import (
 
 	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/config"
 	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/handlers"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/mtls"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/redact"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 )
@@ -30,6 +36,14 @@ import (
 // @host localhost:8090
 // @BasePath /api/v1
 
+// logAllowlist are the log fields of the service that are not PHI, logged
+// as-is in allowlist mode in addition to those of every service
+var logAllowlist = []string{
+	"topic", "offset", "purged", "event_id", "event_type", "duration", "provider_id", "system", "code",
+	"tenant_id", "requested_tenant_id", "table", "rows", "row_id", "key_id", "attempts", "job_id",
+	"line", "created", "updated", "errors", "indexed",
+}
+
 func main() {
 	// Load configuration
 	cfg, err := config.Load()
@@ -41,6 +55,20 @@ func main() {
 	logger := logrus.New()
 	logger.SetLevel(logrus.DebugLevel)
 	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(requestid.LogHook{})
+
+	// Redaction runs last so that it also covers fields added by other hooks
+	if err := redact.Install(logger, redact.Config{
+		Enabled:          cfg.Redaction.Enabled,
+		Mode:             cfg.Redaction.Mode,
+		AllowlistMode:    cfg.Redaction.AllowlistMode,
+		Fields:           cfg.Redaction.Fields,
+		ServiceAllowlist: logAllowlist,
+		Allowlist:        cfg.Redaction.Allowlist,
+		TokenKey:         cfg.Redaction.TokenKey,
+	}); err != nil {
+		logger.Fatalf("Failed to configure log redaction: %v", err)
+	}
 
 	// Set Gin mode
 	if cfg.Environment == "production" {
@@ -48,6 +76,30 @@ func main() {
 		logger.SetLevel(logrus.InfoLevel)
 	}
 
+	// Initialize tracing before anything that creates spans
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Enabled:        cfg.Monitoring.TracingEnabled,
+		ServiceName:    "eligibility-service",
+		ServiceVersion: "1.0.0",
+		Environment:    cfg.Environment,
+		Exporter:       cfg.Monitoring.TraceExporter,
+		Endpoint:       cfg.Monitoring.OTLPEndpoint,
+		Insecure:       cfg.Monitoring.OTLPInsecure,
+		SampleRatio:    cfg.Monitoring.TraceSampleRatio,
+	})
+	if err != nil {
+		logger.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
+	// Tenant headers are only trusted from callers the service can
+	// authenticate, by the gateway's signature or a client certificate
+	if cfg.Security.ServiceAuthSecret == "" && !cfg.Security.EnableMTLS {
+		if cfg.Environment != "development" {
+			logger.Fatal("SERVICE_AUTH_SECRET or ENABLE_MTLS is required outside development")
+		}
+		logger.Warn("SERVICE_AUTH_SECRET not set and mTLS disabled; tenant headers are trusted from any caller")
+	}
+
 	// Initialize handlers
 	h, err := handlers.NewHandler(cfg, logger)
 	if err != nil {
@@ -55,8 +107,13 @@ func main() {
 	}
 	defer h.Close()
 
+	// Start background workers
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	h.StartWorkers(workerCtx)
+
 	// Setup router
-	router := setupRouter(h, logger)
+	router := setupRouter(cfg, h, logger)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -67,10 +124,31 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	// Serve TLS, requiring client certificates with mTLS. Certificates are
+	// reloaded when their files change.
+	if cfg.Security.TLSEnabled || cfg.Security.EnableMTLS {
+		if cfg.Security.EnableMTLS && len(cfg.Security.TrustedCACerts) == 0 {
+			logger.Fatal("ENABLE_MTLS requires TLS_CA_CERTS")
+		}
+		serverTLS, err := mtls.NewStore(cfg.Security.TLSCertPath, cfg.Security.TLSKeyPath, cfg.Security.TrustedCACerts, logger)
+		if err != nil {
+			logger.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+		go serverTLS.Watch(workerCtx, time.Duration(cfg.Security.ReloadInterval)*time.Second)
+		srv.TLSConfig = serverTLS.ServerConfig(cfg.Security.EnableMTLS)
+	}
+
 	// Start server in a goroutine
 	go func() {
-		logger.Infof("Starting NPHIES Eligibility Service on port %s", cfg.Port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			logger.Infof("Starting NPHIES Eligibility Service on port %s with TLS (mTLS: %t)", cfg.Port, cfg.Security.EnableMTLS)
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			logger.Infof("Starting NPHIES Eligibility Service on port %s", cfg.Port)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -81,6 +159,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down server...")
+	stopWorkers()
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -90,36 +169,60 @@ func main() {
 		logger.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Errorf("Failed to flush traces: %v", err)
+	}
+
 	logger.Info("Server exited")
 }
 
-func setupRouter(h *handlers.Handler, logger *logrus.Logger) *gin.Engine {
+func setupRouter(cfg *config.Config, h *handlers.Handler, logger *logrus.Logger) *gin.Engine {
 	router := gin.New()
 
 	// Middleware
-	router.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		logger.WithFields(logrus.Fields{
-			"method":     param.Method,
-			"path":       param.Path,
-			"status":     param.StatusCode,
-			"latency":    param.Latency,
-			"client_ip":  param.ClientIP,
-			"user_agent": param.Request.UserAgent(),
+	router.Use(tracing.Middleware("eligibility-service"))
+	router.Use(requestid.Middleware())
+	router.Use(h.MetricsMiddleware())
+	router.Use(func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Log the route template; raw paths carry member identifiers
+		path := c.FullPath()
+		if path == "" {
+			path = c.Request.URL.Path
+		}
+		logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+			"method":     c.Request.Method,
+			"path":       path,
+			"status":     c.Writer.Status(),
+			"latency":    time.Since(start),
+			"client_ip":  c.ClientIP(),
+			"user_agent": c.Request.UserAgent(),
 		}).Info("HTTP Request")
-		return ""
-	}))
+	})
 	router.Use(gin.Recovery())
+	if cfg.Security.EnableMTLS {
+		router.Use(mtls.RequireClientCert())
+	}
 
 	// Health checks
 	router.GET("/health", h.HealthCheck)
 	router.GET("/ready", h.ReadinessCheck)
 	router.GET("/metrics", h.MetricsHandler)
 
-	// API routes
+	// API routes. Member and coverage data is scoped to the tenant forwarded
+	// by the gateway, which is only trusted from authenticated callers. With
+	// mTLS every route already requires a client certificate.
 	v1 := router.Group("/api/v1")
+	var serviceAuth []gin.HandlerFunc
+	if cfg.Security.ServiceAuthSecret != "" || cfg.Security.EnableMTLS {
+		serviceAuth = append(serviceAuth, serviceauth.Middleware(cfg.Security.ServiceAuthSecret))
+	}
+	tenantScope := append(append([]gin.HandlerFunc{}, serviceAuth...), tenant.Middleware(cfg.Tenancy.Required))
 	{
 		// Eligibility endpoints
-		eligibility := v1.Group("/eligibility")
+		eligibility := v1.Group("/eligibility", tenantScope...)
 		{
 			eligibility.POST("/check", h.CheckEligibility)
 			eligibility.GET("/member/:id/coverage", h.GetMemberCoverage)
@@ -128,7 +231,7 @@ func setupRouter(h *handlers.Handler, logger *logrus.Logger) *gin.Engine {
 		}
 
 		// Coverage endpoints
-		coverage := v1.Group("/coverage")
+		coverage := v1.Group("/coverage", tenantScope...)
 		{
 			coverage.GET("", h.SearchCoverage)
 			coverage.POST("", h.CreateCoverage)
@@ -137,12 +240,28 @@ func setupRouter(h *handlers.Handler, logger *logrus.Logger) *gin.Engine {
 			coverage.DELETE("/:id", h.DeleteCoverage)
 		}
 
-		// Administrative endpoints
-		admin := v1.Group("/admin")
+		// Bulk export pages for the gateway's $export jobs
+		export := v1.Group("/export", tenantScope...)
+		{
+			export.GET("/members", h.ExportMembers)
+			export.GET("/coverage", h.ExportCoverage)
+		}
+
+		// Bulk NDJSON $import of members and coverage
+		imports := v1.Group("/import", tenantScope...)
+		{
+			imports.POST("", h.StartImport)
+			imports.GET("/:id", h.GetImport)
+			imports.GET("/:id/errors", h.GetImportErrors)
+		}
+
+		// Administrative endpoints, audited as the authenticated caller
+		admin := v1.Group("/admin", serviceAuth...)
 		{
 			admin.GET("/stats", h.GetServiceStats)
 			admin.POST("/cache/clear", h.ClearCache)
 			admin.GET("/cache/stats", h.GetCacheStats)
+			admin.GET("/encryption/status", h.GetEncryptionStatus)
 		}
 	}
 
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -17,10 +18,11 @@ type Config struct {
 	}
 	
 	Redis struct {
-		URL      string
-		Password string
-		DB       int
-		TTL      int // Cache TTL in seconds
+		URL       string
+		Password  string
+		DB        int
+		TTL       int    // Cache TTL in seconds
+		KeyPrefix string // Namespace for all keys of this service
 	}
 	
 	Kafka struct {
@@ -29,8 +31,34 @@ type Config struct {
 	}
 	
 	Monitoring struct {
-		MetricsEnabled bool
-		LogLevel       string
+		MetricsEnabled   bool
+		LogLevel         string
+		TracingEnabled   bool
+		TraceExporter    string  // otlp, stdout or none
+		OTLPEndpoint     string
+		OTLPInsecure     bool
+		TraceSampleRatio float64
+	}
+	
+	Redaction struct {
+		Enabled       bool
+		Mode          string   // mask or tokenize
+		AllowlistMode bool     // redact every log field that is not allowlisted
+		Fields        []string // PHI field names in addition to the defaults
+		Allowlist     []string // field names logged as-is in allowlist mode
+		TokenKey      string   // HMAC key for tokenize mode
+	}
+	
+	Security struct {
+		TLSEnabled     bool     // serve HTTPS; implied by EnableMTLS
+		EnableMTLS     bool     // require verified client certificates
+		TLSCertPath    string
+		TLSKeyPath     string
+		TrustedCACerts []string // CA bundles that issue client certificates
+		ReloadInterval int      // seconds between certificate file checks
+
+		// Secret the gateway signs its requests with
+		ServiceAuthSecret string
 	}
 	
 	Business struct {
@@ -38,11 +66,48 @@ type Config struct {
 		MaxResponseTime  int // Maximum response time in milliseconds
 		EnableRuleEngine bool
 	}
+	
+	Tenancy struct {
+		Required         bool // reject requests without an X-Tenant-ID header
+		RowLevelSecurity bool // also scope write transactions with Postgres row-level security
+	}
+	
+	FieldEncryption struct {
+		Enabled            bool
+		KMS                string   // key management backend: file
+		KeyRingPath        string   // key ring file for the file KMS
+		Fields             []string // member fields encrypted at rest
+		DataKeyTTL         int      // seconds a data key is reused before a new one is wrapped
+		ReencryptInterval  int      // seconds between re-encryption passes
+		ReencryptBatchSize int
+		ReloadInterval     int // seconds between key ring file checks
+	}
+	
+	Import struct {
+		Enabled        bool
+		BatchSize      int   // lines applied per transaction
+		PollIntervalMs int
+		LeaseSeconds   int   // how long a job stays claimed without progress
+		MaxAttempts    int
+		RetentionHours int   // how long finished jobs and their errors are kept
+		MaxBytes       int64 // largest accepted upload
+		MaxLines       int
+		MaxLineBytes   int
+	}
+	
+	Outbox struct {
+		PollIntervalMs    int
+		BatchSize         int
+		MaxAttempts       int
+		MaxBackoffSeconds int
+		RetentionHours    int
+	}
 }
 
 type KafkaTopics struct {
 	EligibilityRequests  string
 	EligibilityResponses string
+	CoverageEvents       string
 	AuditTrail          string
 }
 
@@ -64,24 +129,81 @@ func Load() (*Config, error) {
 	cfg.Redis.Password = getEnv("REDIS_PASSWORD", "")
 	cfg.Redis.DB = getEnvInt("REDIS_DB", 0)
 	cfg.Redis.TTL = getEnvInt("REDIS_TTL", 300) // 5 minutes
+	cfg.Redis.KeyPrefix = getEnv("REDIS_KEY_PREFIX", "eligibility-service")
 
 	// Kafka configuration
 	cfg.Kafka.Brokers = []string{getEnv("KAFKA_BROKERS", "localhost:9092")}
 	cfg.Kafka.Topics = KafkaTopics{
 		EligibilityRequests:  "eligibility.requests.v1",
 		EligibilityResponses: "eligibility.responses.v1",
+		CoverageEvents:       "coverage.events.v1",
 		AuditTrail:           "audit.trail.v1",
 	}
 
 	// Monitoring configuration
 	cfg.Monitoring.MetricsEnabled = getEnvBool("METRICS_ENABLED", true)
 	cfg.Monitoring.LogLevel = getEnv("LOG_LEVEL", "info")
+	cfg.Monitoring.TracingEnabled = getEnvBool("TRACING_ENABLED", true)
+	cfg.Monitoring.TraceExporter = getEnv("OTEL_TRACES_EXPORTER", "otlp")
+	cfg.Monitoring.OTLPEndpoint = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	cfg.Monitoring.OTLPInsecure = getEnvBool("OTEL_EXPORTER_OTLP_INSECURE", true)
+	cfg.Monitoring.TraceSampleRatio = getEnvFloat("OTEL_TRACES_SAMPLER_ARG", 1.0)
+
+	// Log redaction is on outside development; production logs only
+	// allowlisted fields as-is
+	cfg.Redaction.Enabled = getEnvBool("LOG_REDACTION_ENABLED", cfg.Environment != "development")
+	cfg.Redaction.Mode = getEnv("LOG_REDACTION_MODE", "mask")
+	cfg.Redaction.AllowlistMode = getEnvBool("LOG_REDACTION_ALLOWLIST_MODE", cfg.Environment == "production")
+	cfg.Redaction.Fields = getEnvList("LOG_REDACTION_FIELDS", nil)
+	cfg.Redaction.Allowlist = getEnvList("LOG_REDACTION_ALLOWLIST", nil)
+	cfg.Redaction.TokenKey = getEnv("LOG_REDACTION_TOKEN_KEY", "")
+
+	// Security configuration
+	cfg.Security.EnableMTLS = getEnvBool("ENABLE_MTLS", false)
+	cfg.Security.TLSEnabled = getEnvBool("TLS_ENABLED", cfg.Security.EnableMTLS)
+	cfg.Security.TLSCertPath = getEnv("TLS_CERT_PATH", "")
+	cfg.Security.TLSKeyPath = getEnv("TLS_KEY_PATH", "")
+	cfg.Security.TrustedCACerts = getEnvList("TLS_CA_CERTS", nil)
+	cfg.Security.ReloadInterval = getEnvInt("TLS_RELOAD_INTERVAL", 30)
+	cfg.Security.ServiceAuthSecret = getEnv("SERVICE_AUTH_SECRET", "")
 
 	// Business configuration
 	cfg.Business.CacheTTL = getEnvInt("CACHE_TTL", 300)         // 5 minutes
 	cfg.Business.MaxResponseTime = getEnvInt("MAX_RESPONSE_TIME", 900) // 900ms
 	cfg.Business.EnableRuleEngine = getEnvBool("ENABLE_RULE_ENGINE", true)
 
+	// Tenancy configuration
+	cfg.Tenancy.Required = getEnvBool("TENANT_REQUIRED", true)
+	cfg.Tenancy.RowLevelSecurity = getEnvBool("TENANT_RLS_ENABLED", false)
+
+	// Field-level encryption of member PHI is on outside development
+	cfg.FieldEncryption.Enabled = getEnvBool("FIELD_ENCRYPTION_ENABLED", cfg.Environment != "development")
+	cfg.FieldEncryption.KMS = getEnv("FIELD_ENCRYPTION_KMS", "file")
+	cfg.FieldEncryption.KeyRingPath = getEnv("FIELD_ENCRYPTION_KEYRING_PATH", "/etc/nphies/keys/field-keyring.json")
+	cfg.FieldEncryption.Fields = getEnvList("FIELD_ENCRYPTION_FIELDS", []string{"identifier", "name", "contact_info", "address"})
+	cfg.FieldEncryption.DataKeyTTL = getEnvInt("FIELD_ENCRYPTION_DATA_KEY_TTL", 600)
+	cfg.FieldEncryption.ReencryptInterval = getEnvInt("FIELD_ENCRYPTION_REENCRYPT_INTERVAL", 300)
+	cfg.FieldEncryption.ReencryptBatchSize = getEnvInt("FIELD_ENCRYPTION_REENCRYPT_BATCH_SIZE", 100)
+	cfg.FieldEncryption.ReloadInterval = getEnvInt("FIELD_ENCRYPTION_RELOAD_INTERVAL", 30)
+
+	// Bulk NDJSON import of members and coverage
+	cfg.Import.Enabled = getEnvBool("IMPORT_ENABLED", true)
+	cfg.Import.BatchSize = getEnvInt("IMPORT_BATCH_SIZE", 500)
+	cfg.Import.PollIntervalMs = getEnvInt("IMPORT_POLL_INTERVAL_MS", 2000)
+	cfg.Import.LeaseSeconds = getEnvInt("IMPORT_LEASE_SECONDS", 300)
+	cfg.Import.MaxAttempts = getEnvInt("IMPORT_MAX_ATTEMPTS", 5)
+	cfg.Import.RetentionHours = getEnvInt("IMPORT_RETENTION_HOURS", 168)
+	cfg.Import.MaxBytes = int64(getEnvInt("IMPORT_MAX_BYTES", 512<<20))
+	cfg.Import.MaxLines = getEnvInt("IMPORT_MAX_LINES", 1000000)
+	cfg.Import.MaxLineBytes = getEnvInt("IMPORT_MAX_LINE_BYTES", 1<<20)
+
+	// Outbox relay configuration
+	cfg.Outbox.PollIntervalMs = getEnvInt("OUTBOX_POLL_INTERVAL_MS", 500)
+	cfg.Outbox.BatchSize = getEnvInt("OUTBOX_BATCH_SIZE", 100)
+	cfg.Outbox.MaxAttempts = getEnvInt("OUTBOX_MAX_ATTEMPTS", 20)
+	cfg.Outbox.MaxBackoffSeconds = getEnvInt("OUTBOX_MAX_BACKOFF_SECONDS", 300)
+	cfg.Outbox.RetentionHours = getEnvInt("OUTBOX_RETENTION_HOURS", 72)
+
 	return cfg, nil
 }
 
@@ -102,6 +224,21 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvList(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		if len(items) > 0 {
+			return items
+		}
+	}
+	return defaultValue
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
@@ -109,4 +246,13 @@ func getEnvBool(key string, defaultValue bool) bool {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,242 @@
+// Package fieldcrypt encrypts individual PHI columns at rest with envelope
+// encryption. Each value is sealed with AES-256-GCM under a data key, and the
+// data key is wrapped by a key encryption key held in a KMS and stored next
+// to the value. Key encryption keys are versioned: new values use the primary
+// version and a background job re-encrypts values sealed under older ones.
+// Encrypted columns that must stay searchable get a blind index, a keyed
+// hash of the value that supports equality lookups without decryption.
+package fieldcrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// formatVersion prefixes every encrypted value so that the layout can change
+const formatVersion = "v1"
+
+// dataKeySize is the size of AES-256 data keys
+const dataKeySize = 32
+
+// maxUnwrappedKeys bounds the cache of unwrapped data keys
+const maxUnwrappedKeys = 1024
+
+var (
+	// ErrMalformed is returned for values that are not in the encrypted format
+	ErrMalformed = errors.New("malformed encrypted value")
+	// ErrUnknownKey is returned for values sealed under a key the KMS does not hold
+	ErrUnknownKey = errors.New("unknown key encryption key")
+)
+
+// KMS wraps and unwraps data keys with versioned key encryption keys
+type KMS interface {
+	// PrimaryKeyID returns the version that new data keys are wrapped with
+	PrimaryKeyID() string
+	// WrapKey encrypts a data key with the primary key and returns its version
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped with the given version
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Cipher encrypts and decrypts column values. A data key is reused for many
+// values until its TTL passes, so that a remote KMS is not called for every
+// value.
+type Cipher struct {
+	kms        KMS
+	indexKey   []byte
+	dataKeyTTL time.Duration
+
+	mu        sync.Mutex
+	dataKey   *dataKey
+	unwrapped map[string][]byte
+}
+
+type dataKey struct {
+	keyID     string
+	plaintext []byte
+	wrapped   string
+	expiresAt time.Time
+}
+
+// New creates a cipher. indexKey keys the blind index and is not rotated with
+// the key encryption keys, since changing it invalidates every index.
+func New(kms KMS, indexKey []byte, dataKeyTTL time.Duration) (*Cipher, error) {
+	if len(indexKey) < 32 {
+		return nil, errors.New("blind index key must be at least 32 bytes")
+	}
+	return &Cipher{
+		kms:        kms,
+		indexKey:   indexKey,
+		dataKeyTTL: dataKeyTTL,
+		unwrapped:  make(map[string][]byte),
+	}, nil
+}
+
+// PrimaryKeyID returns the key version new values are sealed under
+func (c *Cipher) PrimaryKeyID() string {
+	return c.kms.PrimaryKeyID()
+}
+
+// Encrypt seals plaintext. binding ties the value to where it is stored,
+// e.g. the table, column and row, so that it cannot be copied elsewhere;
+// Decrypt must be given the same binding.
+func (c *Cipher) Encrypt(ctx context.Context, plaintext []byte, binding string) (string, error) {
+	key, err := c.currentDataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(key.plaintext)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(binding))
+
+	return strings.Join([]string{
+		formatVersion,
+		key.keyID,
+		key.wrapped,
+		base64.RawURLEncoding.EncodeToString(sealed),
+	}, "."), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with the same binding
+func (c *Cipher) Decrypt(ctx context.Context, value, binding string) ([]byte, error) {
+	keyID, wrapped, sealed, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := c.unwrap(ctx, keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(binding))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// KeyID returns the key version a value was sealed under
+func KeyID(value string) (string, error) {
+	keyID, _, _, err := parse(value)
+	return keyID, err
+}
+
+// BlindIndex returns the hex HMAC-SHA256 of value for equality lookups. The
+// binding separates the indexes of different columns, so that equal values in
+// two columns do not hash alike. Values are trimmed before hashing.
+func (c *Cipher) BlindIndex(value, binding string) string {
+	mac := hmac.New(sha256.New, c.indexKey)
+	mac.Write([]byte(binding))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strings.TrimSpace(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// currentDataKey returns the cached data key, generating and wrapping a new
+// one when it has expired or the primary key has changed
+func (c *Cipher) currentDataKey(ctx context.Context) (*dataKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.dataKey != nil && now.Before(c.dataKey.expiresAt) && c.dataKey.keyID == c.kms.PrimaryKeyID() {
+		return c.dataKey, nil
+	}
+
+	plaintext := make([]byte, dataKeySize)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := c.kms.WrapKey(ctx, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	if keyID == "" || strings.Contains(keyID, ".") {
+		return nil, fmt.Errorf("invalid key ID %q", keyID)
+	}
+
+	c.dataKey = &dataKey{
+		keyID:     keyID,
+		plaintext: plaintext,
+		wrapped:   base64.RawURLEncoding.EncodeToString(wrapped),
+		expiresAt: now.Add(c.dataKeyTTL),
+	}
+	return c.dataKey, nil
+}
+
+// unwrap returns the plaintext of a wrapped data key, from the cache when
+// the key was seen before
+func (c *Cipher) unwrap(ctx context.Context, keyID, wrapped string) ([]byte, error) {
+	cacheKey := keyID + "." + wrapped
+
+	c.mu.Lock()
+	key, ok := c.unwrapped[cacheKey]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	key, err = c.kms.UnwrapKey(ctx, keyID, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if len(c.unwrapped) >= maxUnwrappedKeys {
+		c.unwrapped = make(map[string][]byte)
+	}
+	c.unwrapped[cacheKey] = key
+	c.mu.Unlock()
+	return key, nil
+}
+
+func parse(value string) (keyID, wrapped string, sealed []byte, err error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 || parts[0] != formatVersion || parts[1] == "" || parts[2] == "" {
+		return "", "", nil, ErrMalformed
+	}
+	sealed, err = base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", "", nil, ErrMalformed
+	}
+	return parts[1], parts[2], sealed, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,191 @@
+package fieldcrypt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FileKeyRing is a KMS backed by a JSON file of base64 AES-256 keys, for
+// development and for deployments that mount the key ring as a secret:
+//
+//	{
+//	  "primary": "2026-10",
+//	  "keys": {"2026-01": "<base64>", "2026-10": "<base64>"},
+//	  "index_key": "<base64>"
+//	}
+//
+// To rotate, add a key, make it primary and keep the old keys until the
+// re-encryption job has moved every value off them. The file is reloaded when
+// it changes.
+type FileKeyRing struct {
+	path   string
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	primary  string
+	keys     map[string][]byte
+	indexKey []byte
+	modTime  time.Time
+}
+
+type keyRingFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// LoadFileKeyRing reads a key ring file
+func LoadFileKeyRing(path string, logger *logrus.Logger) (*FileKeyRing, error) {
+	if path == "" {
+		return nil, errors.New("file key ring requires a path")
+	}
+
+	r := &FileKeyRing{
+		path:   path,
+		logger: logger,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads and validates the file and swaps it in
+func (r *FileKeyRing) load() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	var file keyRingFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse key ring %s: %w", r.path, err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("key %q in %s is not a base64 256-bit key", id, r.path)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[file.Primary]; !ok {
+		return fmt.Errorf("primary key %q is not in %s", file.Primary, r.path)
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(file.IndexKey)
+	if err != nil || len(indexKey) < 32 {
+		return fmt.Errorf("index_key in %s must be at least 256 bits of base64", r.path)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// The index key cannot change under a running cipher
+	if r.indexKey != nil && string(r.indexKey) != string(indexKey) {
+		return errors.New("index_key changed; restart after re-indexing")
+	}
+	r.primary = file.Primary
+	r.keys = keys
+	r.indexKey = indexKey
+	r.modTime = info.ModTime()
+	return nil
+}
+
+// IndexKey returns the blind index key
+func (r *FileKeyRing) IndexKey() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.indexKey
+}
+
+// PrimaryKeyID returns the primary key version
+func (r *FileKeyRing) PrimaryKeyID() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.primary
+}
+
+// WrapKey encrypts a data key with the primary key
+func (r *FileKeyRing) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	r.mu.RLock()
+	keyID, key := r.primary, r.keys[r.primary]
+	r.mu.RUnlock()
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return keyID, aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// UnwrapKey decrypts a data key wrapped with the given key version
+func (r *FileKeyRing) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	r.mu.RLock()
+	key, ok := r.keys[keyID]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// Watch polls the file until the context is cancelled and reloads it when it
+// changes. A failed reload keeps the previous keys.
+func (r *FileKeyRing) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil {
+				// Mid-rotation the file may briefly be missing; try again next tick
+				continue
+			}
+			r.mu.RLock()
+			changed := !info.ModTime().Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := r.load(); err != nil {
+				r.logger.WithError(err).Error("Failed to reload key ring")
+				continue
+			}
+			r.logger.WithField("key_id", r.PrimaryKeyID()).Info("Reloaded key ring")
+		}
+	}
+}
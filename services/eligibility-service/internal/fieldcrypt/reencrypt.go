@@ -0,0 +1,290 @@
+package fieldcrypt
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Column is an encrypted column of a table
+type Column struct {
+	Name      string // plaintext column, cleared once the value is encrypted
+	Encrypted string // column holding the encrypted value
+	Index     string // blind index column; empty if the column is not searchable
+}
+
+// Table describes the encrypted columns of a table
+type Table struct {
+	Name    string
+	ID      string // primary key column; each value is bound to its row
+	KeyID   string // column recording the key version the row is sealed under
+	Columns []Column
+}
+
+// Binding returns the binding of the value of a column in a row
+func (t Table) Binding(column, id string) string {
+	return t.Name + "." + column + "/" + id
+}
+
+// IndexBinding returns the blind index binding of a column
+func (t Table) IndexBinding(column string) string {
+	return t.Name + "." + column
+}
+
+// ReencryptOptions controls the re-encryption job
+type ReencryptOptions struct {
+	Interval  time.Duration // delay between passes over the table
+	BatchSize int           // rows re-encrypted per transaction
+}
+
+// ReencryptStatus is the progress of re-encryption
+type ReencryptStatus struct {
+	PrimaryKeyID string         `json:"primary_key_id"`
+	Rows         map[string]int `json:"rows"`    // rows by key version; "" for rows not yet encrypted
+	Pending      int            `json:"pending"` // rows still to be encrypted under the primary key
+}
+
+// Reencryptor moves rows onto the primary key. It encrypts plaintext columns
+// left by earlier writes or by the initial migration, and re-encrypts values
+// sealed under older key versions after a rotation.
+type Reencryptor struct {
+	db      *sql.DB
+	cipher  *Cipher
+	table   Table
+	logger  *logrus.Logger
+	options ReencryptOptions
+}
+
+// NewReencryptor creates a re-encryption job for a table
+func NewReencryptor(db *sql.DB, cipher *Cipher, table Table, logger *logrus.Logger, options ReencryptOptions) *Reencryptor {
+	return &Reencryptor{
+		db:      db,
+		cipher:  cipher,
+		table:   table,
+		logger:  logger,
+		options: options,
+	}
+}
+
+// Run re-encrypts the table in passes until the context is cancelled
+func (r *Reencryptor) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.options.Interval)
+	defer ticker.Stop()
+
+	r.logger.WithField("table", r.table.Name).Info("Starting field re-encryption")
+
+	for {
+		if reencrypted, err := r.Pass(ctx); err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).WithField("table", r.table.Name).Error("Field re-encryption failed")
+		} else if reencrypted > 0 {
+			r.logger.WithFields(logrus.Fields{
+				"table":  r.table.Name,
+				"rows":   reencrypted,
+				"key_id": r.cipher.PrimaryKeyID(),
+			}).Info("Re-encrypted rows")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pass walks the table once in batches and returns how many rows it moved
+// onto the primary key. Rows that fail are logged and left for the next pass.
+func (r *Reencryptor) Pass(ctx context.Context) (int, error) {
+	total := 0
+	cursor := ""
+	for {
+		reencrypted, last, scanned, err := r.batch(ctx, cursor)
+		total += reencrypted
+		if err != nil || scanned < r.options.BatchSize {
+			return total, err
+		}
+		cursor = last
+	}
+}
+
+// batch re-encrypts the rows after cursor that are not sealed under the
+// primary key or still hold plaintext. Rows locked by another instance are
+// skipped.
+func (r *Reencryptor) batch(ctx context.Context, cursor string) (reencrypted int, last string, scanned int, err error) {
+	t := r.table
+	primary := r.cipher.PrimaryKeyID()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer tx.Rollback()
+
+	id := pq.QuoteIdentifier(t.ID)
+	columns := []string{id + "::text"}
+	for _, column := range t.Columns {
+		columns = append(columns, pq.QuoteIdentifier(column.Name)+"::text", pq.QuoteIdentifier(column.Encrypted))
+	}
+	args := []interface{}{primary, r.options.BatchSize}
+	after := ""
+	if cursor != "" {
+		args = append(args, cursor)
+		after = fmt.Sprintf("AND %s > $3", id)
+	}
+	query := fmt.Sprintf(`
+		SELECT %s FROM %s
+		WHERE (%s IS DISTINCT FROM $1 OR %s) %s
+		ORDER BY %s
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`,
+		strings.Join(columns, ", "), pq.QuoteIdentifier(t.Name),
+		pq.QuoteIdentifier(t.KeyID), r.plaintextCondition(), after,
+		id)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	type row struct {
+		id     string
+		values []sql.NullString // plaintext and encrypted value of each column
+	}
+	var batch []row
+	for rows.Next() {
+		current := row{values: make([]sql.NullString, 2*len(t.Columns))}
+		dest := []interface{}{&current.id}
+		for i := range current.values {
+			dest = append(dest, &current.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, "", 0, err
+		}
+		batch = append(batch, current)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", 0, err
+	}
+
+	for _, current := range batch {
+		if err := r.reencryptRow(ctx, tx, current.id, current.values, primary); err != nil {
+			r.logger.WithError(err).WithFields(logrus.Fields{
+				"table":  t.Name,
+				"row_id": current.id,
+			}).Error("Failed to re-encrypt row")
+			continue
+		}
+		reencrypted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, "", 0, err
+	}
+	if len(batch) > 0 {
+		last = batch[len(batch)-1].id
+	}
+	return reencrypted, last, len(batch), nil
+}
+
+// reencryptRow seals every column of a row under the primary key, in a
+// savepoint so that a failed row does not abort the batch
+func (r *Reencryptor) reencryptRow(ctx context.Context, tx *sql.Tx, id string, values []sql.NullString, primary string) error {
+	t := r.table
+	assignments := []string{pq.QuoteIdentifier(t.KeyID) + " = $1"}
+	args := []interface{}{primary}
+
+	for i, column := range t.Columns {
+		plaintext, encrypted := values[2*i], values[2*i+1]
+		binding := t.Binding(column.Name, id)
+
+		var value []byte
+		switch {
+		case encrypted.Valid:
+			decrypted, err := r.cipher.Decrypt(ctx, encrypted.String, binding)
+			if err != nil {
+				return fmt.Errorf("%s: %w", column.Name, err)
+			}
+			value = decrypted
+		case plaintext.Valid:
+			value = []byte(plaintext.String)
+		default:
+			continue
+		}
+
+		sealed, err := r.cipher.Encrypt(ctx, value, binding)
+		if err != nil {
+			return fmt.Errorf("%s: %w", column.Name, err)
+		}
+		args = append(args, sealed)
+		assignments = append(assignments,
+			fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(column.Encrypted), len(args)),
+			pq.QuoteIdentifier(column.Name)+" = NULL")
+		if column.Index != "" {
+			args = append(args, r.cipher.BlindIndex(string(value), t.IndexBinding(column.Name)))
+			assignments = append(assignments, fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(column.Index), len(args)))
+		}
+	}
+
+	args = append(args, id)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT reencrypt_row"); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s WHERE %s = $%d",
+		pq.QuoteIdentifier(t.Name), strings.Join(assignments, ", "), pq.QuoteIdentifier(t.ID), len(args)), args...)
+	if err != nil {
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT reencrypt_row")
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT reencrypt_row")
+	return err
+}
+
+// Status counts the rows of the table by key version
+func (r *Reencryptor) Status(ctx context.Context) (*ReencryptStatus, error) {
+	t := r.table
+	status := &ReencryptStatus{
+		PrimaryKeyID: r.cipher.PrimaryKeyID(),
+		Rows:         make(map[string]int),
+	}
+
+	rows, err := r.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT COALESCE(%s, ''), COUNT(*), COUNT(*) FILTER (WHERE %s IS DISTINCT FROM $1 OR %s)
+		FROM %s GROUP BY 1`,
+		pq.QuoteIdentifier(t.KeyID), pq.QuoteIdentifier(t.KeyID), r.plaintextCondition(), pq.QuoteIdentifier(t.Name)),
+		status.PrimaryKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var keyID string
+		var count, pending int
+		if err := rows.Scan(&keyID, &count, &pending); err != nil {
+			return nil, err
+		}
+		status.Rows[keyID] = count
+		status.Pending += pending
+	}
+	return status, rows.Err()
+}
+
+// plaintextCondition matches rows with a plaintext value left in an
+// encrypted column
+func (r *Reencryptor) plaintextCondition() string {
+	if len(r.table.Columns) == 0 {
+		return "FALSE"
+	}
+	conditions := make([]string, 0, len(r.table.Columns))
+	for _, column := range r.table.Columns {
+		conditions = append(conditions, pq.QuoteIdentifier(column.Name)+" IS NOT NULL")
+	}
+	return strings.Join(conditions, " OR ")
+}
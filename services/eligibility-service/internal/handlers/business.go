@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
 	"github.com/google/uuid"
 )
 
@@ -16,7 +17,7 @@ func (h *Handler) performEligibilityCheck(ctx context.Context, req models.Eligib
 	h.metrics.DatabaseQueries.WithLabelValues("eligibility_check").Inc()
 
 	// Get member information
-	member, err := h.getMember(req.MemberID)
+	member, err := h.getMember(ctx, req.MemberID)
 	if err != nil {
 		return models.EligibilityResponse{}, fmt.Errorf("failed to get member: %w", err)
 	}
@@ -37,7 +38,7 @@ func (h *Handler) performEligibilityCheck(ctx context.Context, req models.Eligib
 	}
 
 	// Get active coverage for the member
-	coverages, err := h.getMemberCoverageFromDB(req.MemberID, req.ServiceDate)
+	coverages, err := h.getMemberCoverageFromDB(ctx, req.MemberID, req.ServiceDate)
 	if err != nil {
 		return models.EligibilityResponse{}, fmt.Errorf("failed to get coverage: %w", err)
 	}
@@ -118,7 +119,7 @@ func (h *Handler) performCoverageVerification(ctx context.Context, req models.Co
 	verificationID := uuid.New().String()
 
 	// Get coverage information
-	coverages, err := h.getMemberCoverageFromDB(req.MemberID, req.ServiceDate)
+	coverages, err := h.getMemberCoverageFromDB(ctx, req.MemberID, req.ServiceDate)
 	if err != nil {
 		return models.CoverageVerificationResponse{}, err
 	}
@@ -152,36 +153,75 @@ func (h *Handler) performCoverageVerification(ctx context.Context, req models.Co
 	}, nil
 }
 
-// getMember retrieves member information from database
-func (h *Handler) getMember(memberID string) (*models.Member, error) {
-	query := `
-		SELECT id, identifier, name, birth_date, gender, contact_info, address, status, created_at, updated_at
-		FROM members 
-		WHERE identifier = $1 AND status = 'active'
+// getMember retrieves member information from database. Within a tenant only
+// members holding coverage of that payer are found. Members whose national ID
+// is encrypted are found by its blind index.
+func (h *Handler) getMember(ctx context.Context, memberID string) (*models.Member, error) {
+	query := memberSelect + `
+		WHERE (identifier = $1 OR identifier_index = $3) AND status = 'active'
+		  AND ($2::text = '' OR EXISTS (
+		      SELECT 1 FROM coverage
+		      WHERE coverage.member_ref = members.id AND coverage.payer_id = $2))
 	`
 
+	member, err := h.scanMember(ctx, h.db.QueryRowContext(ctx, query, memberID, tenant.FromContext(ctx), h.memberIdentifierIndex(memberID)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// memberSelect selects the member columns read by scanMember
+const memberSelect = `
+		SELECT id, identifier, identifier_encrypted, name, name_encrypted, birth_date, gender,
+		       contact_info, contact_info_encrypted, address, address_encrypted, status, created_at, updated_at
+		FROM members`
+
+// scanMember reads a member row selected by memberSelect and decrypts the
+// fields held encrypted
+func (h *Handler) scanMember(ctx context.Context, row interface{ Scan(...interface{}) error }) (*models.Member, error) {
 	var member models.Member
+	var identifier []byte
 	var nameJSON, contactJSON, addressJSON []byte
+	var identifierEncrypted, nameEncrypted, contactEncrypted, addressEncrypted sql.NullString
 
-	err := h.db.QueryRow(query, memberID).Scan(
+	err := row.Scan(
 		&member.ID,
-		&member.Identifier,
+		&identifier,
+		&identifierEncrypted,
 		&nameJSON,
+		&nameEncrypted,
 		&member.BirthDate,
 		&member.Gender,
 		&contactJSON,
+		&contactEncrypted,
 		&addressJSON,
+		&addressEncrypted,
 		&member.Status,
 		&member.CreatedAt,
 		&member.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+	// Decrypt encrypted fields
+	if identifier, err = h.openMemberField(ctx, "identifier", member.ID, identifier, identifierEncrypted); err != nil {
+		return nil, err
 	}
-	if err != nil {
+	if nameJSON, err = h.openMemberField(ctx, "name", member.ID, nameJSON, nameEncrypted); err != nil {
 		return nil, err
 	}
+	if contactJSON, err = h.openMemberField(ctx, "contact_info", member.ID, contactJSON, contactEncrypted); err != nil {
+		return nil, err
+	}
+	if addressJSON, err = h.openMemberField(ctx, "address", member.ID, addressJSON, addressEncrypted); err != nil {
+		return nil, err
+	}
+	member.Identifier = string(identifier)
 
 	// Parse JSON fields
 	json.Unmarshal(nameJSON, &member.Name)
@@ -191,21 +231,19 @@ func (h *Handler) getMember(memberID string) (*models.Member, error) {
 	return &member, nil
 }
 
-// getMemberCoverageFromDB retrieves coverage information from database
-func (h *Handler) getMemberCoverageFromDB(memberID, serviceDate string) ([]models.Coverage, error) {
-	query := `
-		SELECT id, member_id, payer_id, policy_number, group_number, status, type,
-		       effective_date, expiration_date, benefit_details, cost_sharing,
-		       network, prior_auth_rules, limitations, created_at, updated_at
-		FROM coverage 
-		WHERE member_id = $1 
-		  AND status = 'active'
-		  AND effective_date <= $2
-		  AND (expiration_date IS NULL OR expiration_date >= $2)
-		ORDER BY effective_date DESC
+// getMemberCoverageFromDB retrieves coverage information from database,
+// restricted to the payer of the tenant
+func (h *Handler) getMemberCoverageFromDB(ctx context.Context, memberID, serviceDate string) ([]models.Coverage, error) {
+	query := coverageSelect + `
+		WHERE (m.identifier = $1 OR m.identifier_index = $4)
+		  AND c.status = 'active'
+		  AND c.effective_date <= $2
+		  AND (c.expiration_date IS NULL OR c.expiration_date >= $2)
+		  AND ($3::text = '' OR c.payer_id = $3)
+		ORDER BY c.effective_date DESC
 	`
 
-	rows, err := h.db.Query(query, memberID, serviceDate)
+	rows, err := h.db.QueryContext(ctx, query, memberID, serviceDate, tenant.FromContext(ctx), h.memberIdentifierIndex(memberID))
 	if err != nil {
 		return nil, err
 	}
@@ -214,38 +252,12 @@ func (h *Handler) getMemberCoverageFromDB(memberID, serviceDate string) ([]model
 	var coverages []models.Coverage
 
 	for rows.Next() {
-		var coverage models.Coverage
-		var benefitJSON, costSharingJSON, authRulesJSON, limitationsJSON []byte
-
-		err := rows.Scan(
-			&coverage.ID,
-			&coverage.MemberID,
-			&coverage.PayerID,
-			&coverage.PolicyNumber,
-			&coverage.GroupNumber,
-			&coverage.Status,
-			&coverage.Type,
-			&coverage.EffectiveDate,
-			&coverage.ExpirationDate,
-			&benefitJSON,
-			&costSharingJSON,
-			&coverage.Network,
-			&authRulesJSON,
-			&limitationsJSON,
-			&coverage.CreatedAt,
-			&coverage.UpdatedAt,
-		)
+		coverage, err := h.scanCoverage(ctx, rows)
 		if err != nil {
 			return nil, err
 		}
 
-		// Parse JSON fields
-		json.Unmarshal(benefitJSON, &coverage.BenefitDetails)
-		json.Unmarshal(costSharingJSON, &coverage.CostSharing)
-		json.Unmarshal(authRulesJSON, &coverage.PriorAuthRules)
-		json.Unmarshal(limitationsJSON, &coverage.Limitations)
-
-		coverages = append(coverages, coverage)
+		coverages = append(coverages, *coverage)
 	}
 
 	return coverages, nil
@@ -296,9 +308,9 @@ func (h *Handler) calculateLimitations(coverage models.Coverage, serviceCodes []
 }
 
 // getMemberBenefits retrieves detailed benefit information
-func (h *Handler) getMemberBenefits(memberID, serviceCategory string) ([]models.BenefitInformation, error) {
+func (h *Handler) getMemberBenefits(ctx context.Context, memberID, serviceCategory string) ([]models.BenefitInformation, error) {
 	// Get active coverage
-	coverages, err := h.getMemberCoverageFromDB(memberID, time.Now().Format("2006-01-02"))
+	coverages, err := h.getMemberCoverageFromDB(ctx, memberID, time.Now().Format("2006-01-02"))
 	if err != nil {
 		return nil, err
 	}
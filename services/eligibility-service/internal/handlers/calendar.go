@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/hijri"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Hijri dates in requests and responses. A request writes its dates in the
+// Umm al-Qura calendar with calendar=islamic-umalqura (or calendar=hijri);
+// dual_calendar=true adds the Hijri date of each date to the response.
+
+const (
+	calendarParam     = "calendar"
+	dualCalendarParam = "dual_calendar"
+)
+
+// requestDate returns a date of a request in the Gregorian calendar,
+// converting it from Hijri when the request's calendar says so. Dates that
+// do not exist in their calendar are rejected.
+func requestDate(c *gin.Context, value string) (string, error) {
+	switch calendar := c.Query(calendarParam); {
+	case hijri.IsHijri(calendar):
+		return hijri.ToGregorian(value)
+	case calendar == "" || calendar == "gregorian":
+		if _, err := time.Parse(hijri.Layout, value); err != nil {
+			return "", fmt.Errorf("invalid date %q: expected YYYY-MM-DD", value)
+		}
+		return value, nil
+	default:
+		return "", fmt.Errorf("unsupported calendar %q; use gregorian or %s", calendar, hijri.Calendar)
+	}
+}
+
+// dualCalendar reports whether the response carries Hijri dates as well
+func dualCalendar(c *gin.Context) bool {
+	return c.Query(dualCalendarParam) == "true"
+}
+
+// hijriDate returns the Hijri date of a Gregorian one, or "" for dates that
+// are empty or outside the Umm al-Qura table
+func hijriDate(gregorian string) string {
+	if gregorian == "" {
+		return ""
+	}
+	date, err := hijri.ToHijri(gregorian)
+	if err != nil {
+		return ""
+	}
+	return date
+}
+
+// addEligibilityHijriDates adds the Hijri coverage dates to an eligibility
+// response
+func addEligibilityHijriDates(response *models.EligibilityResponse) {
+	response.EffectiveDateHijri = hijriDate(response.EffectiveDate)
+	response.ExpirationDateHijri = hijriDate(response.ExpirationDate)
+}
+
+// addCoverageHijriDates adds the Hijri dates of each coverage
+func addCoverageHijriDates(coverages []models.Coverage) {
+	for i := range coverages {
+		coverage := &coverages[i]
+		coverage.EffectiveDateHijri = hijriDate(coverage.EffectiveDate.Format(hijri.Layout))
+		if coverage.ExpirationDate != nil {
+			coverage.ExpirationDateHijri = hijriDate(coverage.ExpirationDate.Format(hijri.Layout))
+		}
+	}
+}
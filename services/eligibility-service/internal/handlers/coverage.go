@@ -1,20 +1,26 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/cache"
 	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 // SearchCoverage godoc
 // @Summary Search coverage records
-// @Description Search for coverage records with various filters
+// @Description Search for coverage records with various filters. Callers scoped to a tenant only see coverage of their own payer.
 // @Tags coverage
 // @Accept json
 // @Produce json
@@ -26,6 +32,7 @@ import (
 // @Param _offset query int false "Offset for pagination" default(0)
 // @Success 200 {array} models.Coverage
 // @Failure 400 {object} models.ResponseMessage
+// @Failure 403 {object} models.ResponseMessage
 // @Failure 500 {object} models.ResponseMessage
 // @Router /api/v1/coverage [get]
 func (h *Handler) SearchCoverage(c *gin.Context) {
@@ -35,6 +42,14 @@ func (h *Handler) SearchCoverage(c *gin.Context) {
 	status := c.Query("status")
 	effectiveDate := c.Query("effective_date")
 
+	if tenantID := tenant.Get(c); tenantID != "" {
+		if payerID != "" && payerID != tenantID {
+			h.rejectCrossTenant(c, payerID)
+			return
+		}
+		payerID = tenantID
+	}
+
 	// Parse pagination parameters
 	count := 20
 	if countStr := c.Query("_count"); countStr != "" {
@@ -51,50 +66,47 @@ func (h *Handler) SearchCoverage(c *gin.Context) {
 	}
 
 	// Build query
-	query := `
-		SELECT id, member_id, payer_id, policy_number, group_number, status, type,
-		       effective_date, expiration_date, benefit_details, cost_sharing,
-		       network, prior_auth_rules, limitations, created_at, updated_at
-		FROM coverage 
+	query := coverageSelect + `
 		WHERE 1=1
 	`
 	args := []interface{}{}
 	argIndex := 1
 
 	if memberID != "" {
-		query += " AND member_id = $" + strconv.Itoa(argIndex)
-		args = append(args, memberID)
-		argIndex++
+		query += " AND (m.identifier = $" + strconv.Itoa(argIndex) + " OR m.identifier_index = $" + strconv.Itoa(argIndex+1) + ")"
+		args = append(args, memberID, h.memberIdentifierIndex(memberID))
+		argIndex += 2
 	}
 
 	if payerID != "" {
-		query += " AND payer_id = $" + strconv.Itoa(argIndex)
+		query += " AND c.payer_id = $" + strconv.Itoa(argIndex)
 		args = append(args, payerID)
 		argIndex++
 	}
 
 	if status != "" {
-		query += " AND status = $" + strconv.Itoa(argIndex)
+		query += " AND c.status = $" + strconv.Itoa(argIndex)
 		args = append(args, status)
 		argIndex++
 	}
 
 	if effectiveDate != "" {
-		query += " AND effective_date <= $" + strconv.Itoa(argIndex)
+		query += " AND c.effective_date <= $" + strconv.Itoa(argIndex)
 		args = append(args, effectiveDate)
 		argIndex++
 	}
 
-	query += " ORDER BY created_at DESC LIMIT $" + strconv.Itoa(argIndex) + " OFFSET $" + strconv.Itoa(argIndex+1)
+	query += " ORDER BY c.created_at DESC LIMIT $" + strconv.Itoa(argIndex) + " OFFSET $" + strconv.Itoa(argIndex+1)
 	args = append(args, count, offset)
 
-	rows, err := h.db.Query(query, args...)
+	rows, err := h.db.QueryContext(c.Request.Context(), query, args...)
 	if err != nil {
-		h.logger.Errorf("Failed to search coverage: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to search coverage: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_SEARCH_FAILED",
-			Message: "Failed to search coverage records",
+			Type:      "error",
+			Code:      "COVERAGE_SEARCH_FAILED",
+			Message:   "Failed to search coverage records",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
@@ -103,43 +115,17 @@ func (h *Handler) SearchCoverage(c *gin.Context) {
 	var coverages []models.Coverage
 
 	for rows.Next() {
-		var coverage models.Coverage
-		var benefitJSON, costSharingJSON, authRulesJSON, limitationsJSON []byte
-
-		err := rows.Scan(
-			&coverage.ID,
-			&coverage.MemberID,
-			&coverage.PayerID,
-			&coverage.PolicyNumber,
-			&coverage.GroupNumber,
-			&coverage.Status,
-			&coverage.Type,
-			&coverage.EffectiveDate,
-			&coverage.ExpirationDate,
-			&benefitJSON,
-			&costSharingJSON,
-			&coverage.Network,
-			&authRulesJSON,
-			&limitationsJSON,
-			&coverage.CreatedAt,
-			&coverage.UpdatedAt,
-		)
+		coverage, err := h.scanCoverage(c.Request.Context(), rows)
 		if err != nil {
-			h.logger.Errorf("Failed to scan coverage row: %v", err)
+			h.logger.WithContext(c.Request.Context()).Errorf("Failed to scan coverage row: %v", err)
 			continue
 		}
 
-		// Parse JSON fields
-		json.Unmarshal(benefitJSON, &coverage.BenefitDetails)
-		json.Unmarshal(costSharingJSON, &coverage.CostSharing)
-		json.Unmarshal(authRulesJSON, &coverage.PriorAuthRules)
-		json.Unmarshal(limitationsJSON, &coverage.Limitations)
-
-		coverages = append(coverages, coverage)
+		coverages = append(coverages, *coverage)
 	}
 
 	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "coverage.search", "", c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "coverage.search", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
 		"filters": map[string]string{
 			"member_id":      memberID,
 			"payer_id":       payerID,
@@ -156,37 +142,49 @@ func (h *Handler) SearchCoverage(c *gin.Context) {
 
 // CreateCoverage godoc
 // @Summary Create a new coverage record
-// @Description Create a new coverage record for a member
+// @Description Create a new coverage record for a member. Tenant-scoped callers may only create coverage of their own payer, which is the default payer_id.
 // @Tags coverage
 // @Accept json
 // @Produce json
 // @Param coverage body models.Coverage true "Coverage record"
 // @Success 201 {object} models.Coverage
 // @Failure 400 {object} models.ResponseMessage
+// @Failure 403 {object} models.ResponseMessage
 // @Failure 500 {object} models.ResponseMessage
 // @Router /api/v1/coverage [post]
 func (h *Handler) CreateCoverage(c *gin.Context) {
 	var coverage models.Coverage
 	if err := c.ShouldBindJSON(&coverage); err != nil {
 		c.JSON(http.StatusBadRequest, models.ResponseMessage{
-			Type:    "error",
-			Code:    "INVALID_REQUEST",
-			Message: "Invalid coverage data",
-			Details: err.Error(),
+			Type:      "error",
+			Code:      "INVALID_REQUEST",
+			Message:   "Invalid coverage data",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
+	if !h.scopeCoverage(c, &coverage) {
+		return
+	}
+
 	// Validate required fields
 	if coverage.MemberID == "" || coverage.PayerID == "" {
 		c.JSON(http.StatusBadRequest, models.ResponseMessage{
-			Type:    "error",
-			Code:    "MISSING_REQUIRED_FIELDS",
-			Message: "Member ID and Payer ID are required",
+			Type:      "error",
+			Code:      "MISSING_REQUIRED_FIELDS",
+			Message:   "Member ID and Payer ID are required",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
+	memberRef, ok := h.coverageMemberRef(c, coverage.MemberID)
+	if !ok {
+		return
+	}
+
 	// Generate ID and set timestamps
 	coverage.ID = uuid.New().String()
 	coverage.CreatedAt = time.Now()
@@ -205,52 +203,77 @@ func (h *Handler) CreateCoverage(c *gin.Context) {
 	// Insert into database
 	query := `
 		INSERT INTO coverage (
-			id, member_id, payer_id, policy_number, group_number, status, type,
+			id, member_ref, payer_id, policy_number, group_number, status, type,
 			effective_date, expiration_date, benefit_details, cost_sharing,
 			network, prior_auth_rules, limitations, created_at, updated_at
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 	`
 
-	_, err := h.db.Exec(query,
-		coverage.ID,
-		coverage.MemberID,
-		coverage.PayerID,
-		coverage.PolicyNumber,
-		coverage.GroupNumber,
-		coverage.Status,
-		coverage.Type,
-		coverage.EffectiveDate,
-		coverage.ExpirationDate,
-		benefitJSON,
-		costSharingJSON,
-		coverage.Network,
-		authRulesJSON,
-		limitationsJSON,
-		coverage.CreatedAt,
-		coverage.UpdatedAt,
-	)
+	ctx := c.Request.Context()
+
+	// The coverage row and its events commit together
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to begin coverage transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "COVERAGE_CREATE_FAILED",
+			Message:   "Failed to create coverage record",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	err = h.scopeTx(ctx, tx)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, query,
+			coverage.ID,
+			memberRef,
+			coverage.PayerID,
+			coverage.PolicyNumber,
+			coverage.GroupNumber,
+			coverage.Status,
+			coverage.Type,
+			coverage.EffectiveDate,
+			coverage.ExpirationDate,
+			benefitJSON,
+			costSharingJSON,
+			coverage.Network,
+			authRulesJSON,
+			limitationsJSON,
+			coverage.CreatedAt,
+			coverage.UpdatedAt,
+		)
+	}
+	if err == nil {
+		err = h.publishCoverageEvent(ctx, tx, "coverage.created", coverage.ID, coverage)
+	}
+	if err == nil {
+		err = h.logAuditEventTx(ctx, tx, "coverage.create", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
+			"coverage_id": coverage.ID,
+			"member_id":   coverage.MemberID,
+			"payer_id":    coverage.PayerID,
+			"status":      coverage.Status,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 
 	if err != nil {
-		h.logger.Errorf("Failed to create coverage: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to create coverage: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_CREATE_FAILED",
-			Message: "Failed to create coverage record",
+			Type:      "error",
+			Code:      "COVERAGE_CREATE_FAILED",
+			Message:   "Failed to create coverage record",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	// Clear cache for this member
-	cachePattern := fmt.Sprintf("*:%s:*", coverage.MemberID)
-	_ = h.cache.DeletePattern(c.Request.Context(), cachePattern)
-
-	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "coverage.create", "", c.ClientIP(), map[string]interface{}{
-		"coverage_id": coverage.ID,
-		"member_id":   coverage.MemberID,
-		"payer_id":    coverage.PayerID,
-		"status":      coverage.Status,
-	})
+	_, _ = h.cache.Invalidate(ctx, cache.Selector{MemberID: coverage.MemberID}, false)
 
 	c.Header("Location", "/api/v1/coverage/"+coverage.ID)
 	c.JSON(http.StatusCreated, coverage)
@@ -258,7 +281,7 @@ func (h *Handler) CreateCoverage(c *gin.Context) {
 
 // GetCoverage godoc
 // @Summary Get coverage by ID
-// @Description Retrieve a specific coverage record by ID
+// @Description Retrieve a specific coverage record by ID. Records of other payers are not found for tenant-scoped callers.
 // @Tags coverage
 // @Accept json
 // @Produce json
@@ -270,62 +293,35 @@ func (h *Handler) CreateCoverage(c *gin.Context) {
 func (h *Handler) GetCoverage(c *gin.Context) {
 	coverageID := c.Param("id")
 
-	query := `
-		SELECT id, member_id, payer_id, policy_number, group_number, status, type,
-		       effective_date, expiration_date, benefit_details, cost_sharing,
-		       network, prior_auth_rules, limitations, created_at, updated_at
-		FROM coverage 
-		WHERE id = $1
+	query := coverageSelect + `
+		WHERE c.id = $1 AND ($2::text = '' OR c.payer_id = $2)
 	`
 
-	var coverage models.Coverage
-	var benefitJSON, costSharingJSON, authRulesJSON, limitationsJSON []byte
-
-	err := h.db.QueryRow(query, coverageID).Scan(
-		&coverage.ID,
-		&coverage.MemberID,
-		&coverage.PayerID,
-		&coverage.PolicyNumber,
-		&coverage.GroupNumber,
-		&coverage.Status,
-		&coverage.Type,
-		&coverage.EffectiveDate,
-		&coverage.ExpirationDate,
-		&benefitJSON,
-		&costSharingJSON,
-		&coverage.Network,
-		&authRulesJSON,
-		&limitationsJSON,
-		&coverage.CreatedAt,
-		&coverage.UpdatedAt,
-	)
+	coverage, err := h.scanCoverage(c.Request.Context(), h.db.QueryRowContext(c.Request.Context(), query, coverageID, tenant.Get(c)))
 
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
+			h.auditCoverageOwner(c, coverageID)
 			c.JSON(http.StatusNotFound, models.ResponseMessage{
-				Type:    "error",
-				Code:    "COVERAGE_NOT_FOUND",
-				Message: "Coverage record not found",
+				Type:      "error",
+				Code:      "COVERAGE_NOT_FOUND",
+				Message:   "Coverage record not found",
+				RequestID: requestid.Get(c),
 			})
 		} else {
-			h.logger.Errorf("Failed to get coverage: %v", err)
+			h.logger.WithContext(c.Request.Context()).Errorf("Failed to get coverage: %v", err)
 			c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-				Type:    "error",
-				Code:    "COVERAGE_RETRIEVAL_FAILED",
-				Message: "Failed to retrieve coverage record",
+				Type:      "error",
+				Code:      "COVERAGE_RETRIEVAL_FAILED",
+				Message:   "Failed to retrieve coverage record",
+				RequestID: requestid.Get(c),
 			})
 		}
 		return
 	}
 
-	// Parse JSON fields
-	json.Unmarshal(benefitJSON, &coverage.BenefitDetails)
-	json.Unmarshal(costSharingJSON, &coverage.CostSharing)
-	json.Unmarshal(authRulesJSON, &coverage.PriorAuthRules)
-	json.Unmarshal(limitationsJSON, &coverage.Limitations)
-
 	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "coverage.read", "", c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "coverage.read", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
 		"coverage_id": coverageID,
 		"member_id":   coverage.MemberID,
 	})
@@ -335,7 +331,7 @@ func (h *Handler) GetCoverage(c *gin.Context) {
 
 // UpdateCoverage godoc
 // @Summary Update coverage record
-// @Description Update an existing coverage record
+// @Description Update an existing coverage record. Tenant-scoped callers may only update coverage of their own payer and cannot move it to another.
 // @Tags coverage
 // @Accept json
 // @Produce json
@@ -343,6 +339,7 @@ func (h *Handler) GetCoverage(c *gin.Context) {
 // @Param coverage body models.Coverage true "Updated coverage record"
 // @Success 200 {object} models.Coverage
 // @Failure 400 {object} models.ResponseMessage
+// @Failure 403 {object} models.ResponseMessage
 // @Failure 404 {object} models.ResponseMessage
 // @Failure 500 {object} models.ResponseMessage
 // @Router /api/v1/coverage/{id} [put]
@@ -352,14 +349,24 @@ func (h *Handler) UpdateCoverage(c *gin.Context) {
 	var coverage models.Coverage
 	if err := c.ShouldBindJSON(&coverage); err != nil {
 		c.JSON(http.StatusBadRequest, models.ResponseMessage{
-			Type:    "error",
-			Code:    "INVALID_REQUEST",
-			Message: "Invalid coverage data",
-			Details: err.Error(),
+			Type:      "error",
+			Code:      "INVALID_REQUEST",
+			Message:   "Invalid coverage data",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
+	if !h.scopeCoverage(c, &coverage) {
+		return
+	}
+
+	memberRef, ok := h.coverageMemberRef(c, coverage.MemberID)
+	if !ok {
+		return
+	}
+
 	// Ensure ID matches
 	coverage.ID = coverageID
 	coverage.UpdatedAt = time.Now()
@@ -373,67 +380,105 @@ func (h *Handler) UpdateCoverage(c *gin.Context) {
 	// Update database
 	query := `
 		UPDATE coverage SET
-			member_id = $2, payer_id = $3, policy_number = $4, group_number = $5,
+			member_ref = $2, payer_id = $3, policy_number = $4, group_number = $5,
 			status = $6, type = $7, effective_date = $8, expiration_date = $9,
 			benefit_details = $10, cost_sharing = $11, network = $12,
 			prior_auth_rules = $13, limitations = $14, updated_at = $15
-		WHERE id = $1
+		WHERE id = $1 AND ($16::text = '' OR payer_id = $16)
 	`
 
-	result, err := h.db.Exec(query,
-		coverage.ID,
-		coverage.MemberID,
-		coverage.PayerID,
-		coverage.PolicyNumber,
-		coverage.GroupNumber,
-		coverage.Status,
-		coverage.Type,
-		coverage.EffectiveDate,
-		coverage.ExpirationDate,
-		benefitJSON,
-		costSharingJSON,
-		coverage.Network,
-		authRulesJSON,
-		limitationsJSON,
-		coverage.UpdatedAt,
-	)
+	ctx := c.Request.Context()
+
+	// The coverage row and its events commit together
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to begin coverage transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "COVERAGE_UPDATE_FAILED",
+			Message:   "Failed to update coverage record",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	err = h.scopeTx(ctx, tx)
+	if err == nil {
+		result, err = tx.ExecContext(ctx, query,
+			coverage.ID,
+			memberRef,
+			coverage.PayerID,
+			coverage.PolicyNumber,
+			coverage.GroupNumber,
+			coverage.Status,
+			coverage.Type,
+			coverage.EffectiveDate,
+			coverage.ExpirationDate,
+			benefitJSON,
+			costSharingJSON,
+			coverage.Network,
+			authRulesJSON,
+			limitationsJSON,
+			coverage.UpdatedAt,
+			tenant.Get(c),
+		)
+	}
 
 	if err != nil {
-		h.logger.Errorf("Failed to update coverage: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to update coverage: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_UPDATE_FAILED",
-			Message: "Failed to update coverage record",
+			Type:      "error",
+			Code:      "COVERAGE_UPDATE_FAILED",
+			Message:   "Failed to update coverage record",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
+		h.auditCoverageOwner(c, coverageID)
 		c.JSON(http.StatusNotFound, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_NOT_FOUND",
-			Message: "Coverage record not found",
+			Type:      "error",
+			Code:      "COVERAGE_NOT_FOUND",
+			Message:   "Coverage record not found",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
-	// Clear cache for this member
-	cachePattern := fmt.Sprintf("*:%s:*", coverage.MemberID)
-	_ = h.cache.DeletePattern(c.Request.Context(), cachePattern)
+	err = h.publishCoverageEvent(ctx, tx, "coverage.updated", coverageID, coverage)
+	if err == nil {
+		err = h.logAuditEventTx(ctx, tx, "coverage.update", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
+			"coverage_id": coverageID,
+			"member_id":   coverage.MemberID,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to update coverage: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "COVERAGE_UPDATE_FAILED",
+			Message:   "Failed to update coverage record",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
 
-	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "coverage.update", "", c.ClientIP(), map[string]interface{}{
-		"coverage_id": coverageID,
-		"member_id":   coverage.MemberID,
-	})
+	// Clear cache for this member
+	_, _ = h.cache.Invalidate(ctx, cache.Selector{MemberID: coverage.MemberID}, false)
 
 	c.JSON(http.StatusOK, coverage)
 }
 
 // DeleteCoverage godoc
 // @Summary Delete coverage record
-// @Description Delete a coverage record (soft delete by changing status)
+// @Description Delete a coverage record (soft delete by changing status). Records of other payers are not found for tenant-scoped callers.
 // @Tags coverage
 // @Accept json
 // @Produce json
@@ -450,38 +495,174 @@ func (h *Handler) DeleteCoverage(c *gin.Context) {
 		UPDATE coverage SET 
 			status = 'deleted', 
 			updated_at = $2 
-		WHERE id = $1 AND status != 'deleted'
+		WHERE id = $1 AND status != 'deleted' AND ($3::text = '' OR payer_id = $3)
 	`
 
-	result, err := h.db.Exec(query, coverageID, time.Now())
+	ctx := c.Request.Context()
+
+	// The status change and its events commit together
+	tx, err := h.db.BeginTx(ctx, nil)
 	if err != nil {
-		h.logger.Errorf("Failed to delete coverage: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to begin coverage transaction: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_DELETE_FAILED",
-			Message: "Failed to delete coverage record",
+			Type:      "error",
+			Code:      "COVERAGE_DELETE_FAILED",
+			Message:   "Failed to delete coverage record",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	err = h.scopeTx(ctx, tx)
+	if err == nil {
+		result, err = tx.ExecContext(ctx, query, coverageID, time.Now(), tenant.Get(c))
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to delete coverage: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "COVERAGE_DELETE_FAILED",
+			Message:   "Failed to delete coverage record",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
+		h.auditCoverageOwner(c, coverageID)
 		c.JSON(http.StatusNotFound, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_NOT_FOUND",
-			Message: "Coverage record not found",
+			Type:      "error",
+			Code:      "COVERAGE_NOT_FOUND",
+			Message:   "Coverage record not found",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	err = h.publishCoverageEvent(ctx, tx, "coverage.deleted", coverageID, map[string]interface{}{
+		"status": "deleted",
+	})
+	if err == nil {
+		err = h.logAuditEventTx(ctx, tx, "coverage.delete", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
+			"coverage_id": coverageID,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to delete coverage: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "COVERAGE_DELETE_FAILED",
+			Message:   "Failed to delete coverage record",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	// Clear cache (we don't know the member ID, so clear by coverage ID pattern)
 	cachePattern := fmt.Sprintf("*:%s", coverageID)
-	_ = h.cache.DeletePattern(c.Request.Context(), cachePattern)
-
-	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "coverage.delete", "", c.ClientIP(), map[string]interface{}{
-		"coverage_id": coverageID,
-	})
+	_ = h.cache.DeletePattern(ctx, cachePattern)
 
 	c.Status(http.StatusNoContent)
-}
\ No newline at end of file
+}
+
+// coverageSelect selects the coverage columns read by scanCoverage. Coverage
+// refers to its member by row ID; the national ID is read from the member.
+const coverageSelect = `
+		SELECT c.id, m.id, m.identifier, m.identifier_encrypted, c.payer_id, c.policy_number,
+		       c.group_number, c.status, c.type, c.effective_date, c.expiration_date,
+		       c.benefit_details, c.cost_sharing, c.network, c.prior_auth_rules, c.limitations,
+		       c.created_at, c.updated_at
+		FROM coverage c
+		JOIN members m ON m.id = c.member_ref`
+
+// scanCoverage reads a coverage row selected by coverageSelect, decrypting
+// the member's national ID if it is held encrypted
+func (h *Handler) scanCoverage(ctx context.Context, row interface{ Scan(...interface{}) error }) (*models.Coverage, error) {
+	var coverage models.Coverage
+	var memberRef string
+	var identifier []byte
+	var identifierEncrypted sql.NullString
+	var benefitJSON, costSharingJSON, authRulesJSON, limitationsJSON []byte
+
+	err := row.Scan(
+		&coverage.ID,
+		&memberRef,
+		&identifier,
+		&identifierEncrypted,
+		&coverage.PayerID,
+		&coverage.PolicyNumber,
+		&coverage.GroupNumber,
+		&coverage.Status,
+		&coverage.Type,
+		&coverage.EffectiveDate,
+		&coverage.ExpirationDate,
+		&benefitJSON,
+		&costSharingJSON,
+		&coverage.Network,
+		&authRulesJSON,
+		&limitationsJSON,
+		&coverage.CreatedAt,
+		&coverage.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if identifier, err = h.openMemberField(ctx, "identifier", memberRef, identifier, identifierEncrypted); err != nil {
+		return nil, err
+	}
+	coverage.MemberID = string(identifier)
+
+	// Parse JSON fields
+	json.Unmarshal(benefitJSON, &coverage.BenefitDetails)
+	json.Unmarshal(costSharingJSON, &coverage.CostSharing)
+	json.Unmarshal(authRulesJSON, &coverage.PriorAuthRules)
+	json.Unmarshal(limitationsJSON, &coverage.Limitations)
+
+	return &coverage, nil
+}
+
+// memberRef returns the row ID of the member with a national ID, by which
+// coverage refers to members. It returns sql.ErrNoRows when there is none.
+func (h *Handler) memberRef(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, identifier string) (string, error) {
+	var id string
+	err := q.QueryRowContext(ctx, `
+		SELECT id FROM members WHERE identifier = $1 OR identifier_index = $2
+	`, identifier, h.memberIdentifierIndex(identifier)).Scan(&id)
+	return id, err
+}
+
+// coverageMemberRef returns the row ID of the member a coverage record is
+// written for, answering 400 when there is no such member. It reports
+// whether the request may proceed.
+func (h *Handler) coverageMemberRef(c *gin.Context, memberID string) (string, bool) {
+	id, err := h.memberRef(c.Request.Context(), h.db, memberID)
+	switch {
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusBadRequest, models.ResponseMessage{
+			Type:      "error",
+			Code:      "MEMBER_NOT_FOUND",
+			Message:   "Member not found",
+			RequestID: requestid.Get(c),
+		})
+		return "", false
+	case err != nil:
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to look up coverage member: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "MEMBER_RETRIEVAL_FAILED",
+			Message:   "Failed to retrieve member",
+			RequestID: requestid.Get(c),
+		})
+		return "", false
+	}
+	return id, true
+}
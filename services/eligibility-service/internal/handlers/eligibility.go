@@ -2,11 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/cache"
 	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -18,6 +23,8 @@ import (
 // @Accept json
 // @Produce json
 // @Param request body models.EligibilityRequest true "Eligibility check request"
+// @Param calendar query string false "Calendar of the service date (gregorian or islamic-umalqura)"
+// @Param dual_calendar query bool false "Add Hijri dates to the response"
 // @Success 200 {object} models.EligibilityResponse
 // @Failure 400 {object} models.ResponseMessage
 // @Failure 500 {object} models.ResponseMessage
@@ -29,14 +36,28 @@ func (h *Handler) CheckEligibility(c *gin.Context) {
 	var req models.EligibilityRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ResponseMessage{
-			Type:    "error",
-			Code:    "INVALID_REQUEST",
-			Message: "Invalid request format",
-			Details: err.Error(),
+			Type:      "error",
+			Code:      "INVALID_REQUEST",
+			Message:   "Invalid request format",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
+	serviceDate, err := requestDate(c, req.ServiceDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ResponseMessage{
+			Type:      "error",
+			Code:      "INVALID_REQUEST",
+			Message:   "Invalid service date",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	req.ServiceDate = serviceDate
+
 	// Generate request ID if not provided
 	if req.RequestID == "" {
 		req.RequestID = uuid.New().String()
@@ -46,9 +67,10 @@ func (h *Handler) CheckEligibility(c *gin.Context) {
 	// Create cache key
 	cacheKey := fmt.Sprintf("eligibility:%s:%s:%s", req.MemberID, req.ProviderID, req.ServiceDate)
 
-	// Check cache first
+	// Check cache first; cached responses are kept per tenant
+	tenantCache := h.tenantCache(c.Request.Context())
 	var response models.EligibilityResponse
-	cached, err := h.cache.Get(c.Request.Context(), cacheKey)
+	cached, err := tenantCache.Get(c.Request.Context(), cacheKey)
 	if err == nil && cached != "" {
 		if err := json.Unmarshal([]byte(cached), &response); err == nil {
 			h.metrics.CacheHits.Inc()
@@ -63,6 +85,9 @@ func (h *Handler) CheckEligibility(c *gin.Context) {
 				"duration":   time.Since(start).Milliseconds(),
 			})
 
+			if dualCalendar(c) {
+				addEligibilityHijriDates(&response)
+			}
 			c.JSON(http.StatusOK, response)
 			return
 		}
@@ -73,12 +98,13 @@ func (h *Handler) CheckEligibility(c *gin.Context) {
 	// Perform eligibility check
 	response, err = h.performEligibilityCheck(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Errorf("Failed to perform eligibility check: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to perform eligibility check: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "ELIGIBILITY_CHECK_FAILED",
-			Message: "Failed to check eligibility",
-			Details: err.Error(),
+			Type:      "error",
+			Code:      "ELIGIBILITY_CHECK_FAILED",
+			Message:   "Failed to check eligibility",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
@@ -89,7 +115,7 @@ func (h *Handler) CheckEligibility(c *gin.Context) {
 
 	// Cache the response
 	responseData, _ := json.Marshal(response)
-	_ = h.cache.Set(c.Request.Context(), cacheKey, string(responseData))
+	_ = tenantCache.Set(c.Request.Context(), cacheKey, string(responseData))
 
 	// Log audit event
 	h.logAuditEvent(c.Request.Context(), "eligibility.check", req.RequestedBy, c.ClientIP(), map[string]interface{}{
@@ -103,10 +129,13 @@ func (h *Handler) CheckEligibility(c *gin.Context) {
 	// Check if response time exceeds SLA
 	duration := time.Since(start)
 	if duration.Milliseconds() > int64(h.config.Business.MaxResponseTime) {
-		h.logger.Warnf("Eligibility check exceeded SLA: %dms (max: %dms)", 
+		h.logger.WithContext(c.Request.Context()).Warnf("Eligibility check exceeded SLA: %dms (max: %dms)", 
 			duration.Milliseconds(), h.config.Business.MaxResponseTime)
 	}
 
+	if dualCalendar(c) {
+		addEligibilityHijriDates(&response)
+	}
 	c.JSON(http.StatusOK, response)
 }
 
@@ -118,6 +147,8 @@ func (h *Handler) CheckEligibility(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Member ID"
 // @Param effective_date query string false "Effective date for coverage lookup (YYYY-MM-DD)"
+// @Param calendar query string false "Calendar of the effective date (gregorian or islamic-umalqura)"
+// @Param dual_calendar query bool false "Add Hijri dates to the response"
 // @Success 200 {array} models.Coverage
 // @Failure 404 {object} models.ResponseMessage
 // @Failure 500 {object} models.ResponseMessage
@@ -128,17 +159,34 @@ func (h *Handler) GetMemberCoverage(c *gin.Context) {
 
 	if effectiveDate == "" {
 		effectiveDate = time.Now().Format("2006-01-02")
+	} else {
+		date, err := requestDate(c, effectiveDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ResponseMessage{
+				Type:      "error",
+				Code:      "INVALID_REQUEST",
+				Message:   "Invalid effective date",
+				Details:   err.Error(),
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+		effectiveDate = date
 	}
 
 	// Create cache key
 	cacheKey := fmt.Sprintf("coverage:%s:%s", memberID, effectiveDate)
 
-	// Check cache first
-	cached, err := h.cache.Get(c.Request.Context(), cacheKey)
+	// Check cache first; cached responses are kept per tenant
+	tenantCache := h.tenantCache(c.Request.Context())
+	cached, err := tenantCache.Get(c.Request.Context(), cacheKey)
 	if err == nil && cached != "" {
 		var coverages []models.Coverage
 		if err := json.Unmarshal([]byte(cached), &coverages); err == nil {
 			h.metrics.CacheHits.Inc()
+			if dualCalendar(c) {
+				addCoverageHijriDates(coverages)
+			}
 			c.JSON(http.StatusOK, coverages)
 			return
 		}
@@ -147,37 +195,42 @@ func (h *Handler) GetMemberCoverage(c *gin.Context) {
 	h.metrics.CacheMisses.Inc()
 
 	// Query database for coverage
-	coverages, err := h.getMemberCoverageFromDB(memberID, effectiveDate)
+	coverages, err := h.getMemberCoverageFromDB(c.Request.Context(), memberID, effectiveDate)
 	if err != nil {
-		h.logger.Errorf("Failed to get member coverage: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to get member coverage: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_LOOKUP_FAILED",
-			Message: "Failed to retrieve coverage information",
+			Type:      "error",
+			Code:      "COVERAGE_LOOKUP_FAILED",
+			Message:   "Failed to retrieve coverage information",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	if len(coverages) == 0 {
 		c.JSON(http.StatusNotFound, models.ResponseMessage{
-			Type:    "information",
-			Code:    "NO_COVERAGE_FOUND",
-			Message: "No active coverage found for the specified member and date",
+			Type:      "information",
+			Code:      "NO_COVERAGE_FOUND",
+			Message:   "No active coverage found for the specified member and date",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	// Cache the response
 	responseData, _ := json.Marshal(coverages)
-	_ = h.cache.Set(c.Request.Context(), cacheKey, string(responseData))
+	_ = tenantCache.Set(c.Request.Context(), cacheKey, string(responseData))
 
 	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "coverage.lookup", "", c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "coverage.lookup", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
 		"member_id":      memberID,
 		"effective_date": effectiveDate,
 		"coverage_count": len(coverages),
 	})
 
+	if dualCalendar(c) {
+		addCoverageHijriDates(coverages)
+	}
 	c.JSON(http.StatusOK, coverages)
 }
 
@@ -189,6 +242,8 @@ func (h *Handler) GetMemberCoverage(c *gin.Context) {
 // @Produce json
 // @Param id path string true "Member ID"
 // @Param request body models.CoverageVerificationRequest true "Coverage verification request"
+// @Param calendar query string false "Calendar of the service date (gregorian or islamic-umalqura)"
+// @Param dual_calendar query bool false "Add Hijri dates to the response"
 // @Success 200 {object} models.CoverageVerificationResponse
 // @Failure 400 {object} models.ResponseMessage
 // @Failure 500 {object} models.ResponseMessage
@@ -199,37 +254,54 @@ func (h *Handler) VerifyCoverage(c *gin.Context) {
 	var req models.CoverageVerificationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.ResponseMessage{
-			Type:    "error",
-			Code:    "INVALID_REQUEST",
-			Message: "Invalid request format",
-			Details: err.Error(),
+			Type:      "error",
+			Code:      "INVALID_REQUEST",
+			Message:   "Invalid request format",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
+	serviceDate, err := requestDate(c, req.ServiceDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ResponseMessage{
+			Type:      "error",
+			Code:      "INVALID_REQUEST",
+			Message:   "Invalid service date",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	req.ServiceDate = serviceDate
 	req.MemberID = memberID
 
 	// Perform coverage verification
 	response, err := h.performCoverageVerification(c.Request.Context(), req)
 	if err != nil {
-		h.logger.Errorf("Failed to verify coverage: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to verify coverage: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "COVERAGE_VERIFICATION_FAILED",
-			Message: "Failed to verify coverage",
-			Details: err.Error(),
+			Type:      "error",
+			Code:      "COVERAGE_VERIFICATION_FAILED",
+			Message:   "Failed to verify coverage",
+			Details:   err.Error(),
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "coverage.verify", "", c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "coverage.verify", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
 		"member_id":        memberID,
 		"verification_id":  response.VerificationID,
 		"service_codes":    req.ServiceCodes,
 		"overall_status":   response.OverallStatus,
 	})
 
+	if dualCalendar(c) {
+		response.ServiceDateHijri = hijriDate(response.ServiceDate)
+	}
 	c.JSON(http.StatusOK, response)
 }
 
@@ -252,8 +324,9 @@ func (h *Handler) GetMemberBenefits(c *gin.Context) {
 	// Create cache key
 	cacheKey := fmt.Sprintf("benefits:%s:%s", memberID, serviceCategory)
 
-	// Check cache first
-	cached, err := h.cache.Get(c.Request.Context(), cacheKey)
+	// Check cache first; cached responses are kept per tenant
+	tenantCache := h.tenantCache(c.Request.Context())
+	cached, err := tenantCache.Get(c.Request.Context(), cacheKey)
 	if err == nil && cached != "" {
 		var benefits []models.BenefitInformation
 		if err := json.Unmarshal([]byte(cached), &benefits); err == nil {
@@ -266,32 +339,34 @@ func (h *Handler) GetMemberBenefits(c *gin.Context) {
 	h.metrics.CacheMisses.Inc()
 
 	// Get benefits from database/business logic
-	benefits, err := h.getMemberBenefits(memberID, serviceCategory)
+	benefits, err := h.getMemberBenefits(c.Request.Context(), memberID, serviceCategory)
 	if err != nil {
-		h.logger.Errorf("Failed to get member benefits: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to get member benefits: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "BENEFITS_LOOKUP_FAILED",
-			Message: "Failed to retrieve benefit information",
+			Type:      "error",
+			Code:      "BENEFITS_LOOKUP_FAILED",
+			Message:   "Failed to retrieve benefit information",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	if len(benefits) == 0 {
 		c.JSON(http.StatusNotFound, models.ResponseMessage{
-			Type:    "information",
-			Code:    "NO_BENEFITS_FOUND",
-			Message: "No benefits found for the specified member",
+			Type:      "information",
+			Code:      "NO_BENEFITS_FOUND",
+			Message:   "No benefits found for the specified member",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
 	// Cache the response
 	responseData, _ := json.Marshal(benefits)
-	_ = h.cache.Set(c.Request.Context(), cacheKey, string(responseData))
+	_ = tenantCache.Set(c.Request.Context(), cacheKey, string(responseData))
 
 	// Log audit event
-	h.logAuditEvent(c.Request.Context(), "benefits.lookup", "", c.ClientIP(), map[string]interface{}{
+	h.logAuditEvent(c.Request.Context(), "benefits.lookup", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
 		"member_id":        memberID,
 		"service_category": serviceCategory,
 		"benefit_count":    len(benefits),
@@ -302,42 +377,49 @@ func (h *Handler) GetMemberBenefits(c *gin.Context) {
 
 // GetServiceStats godoc
 // @Summary Get service statistics
-// @Description Retrieve service performance and usage statistics
+// @Description Retrieve service performance and usage statistics since the service started, from its Prometheus collectors
 // @Tags admin
 // @Accept json
 // @Produce json
 // @Success 200 {object} models.ServiceStats
+// @Failure 500 {object} models.ResponseMessage
 // @Router /api/v1/admin/stats [get]
 func (h *Handler) GetServiceStats(c *gin.Context) {
+	requestStats, err := h.requestStatistics()
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to gather request metrics: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "STATS_UNAVAILABLE",
+			Message:   "Unable to gather request metrics",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	cacheStats, err := h.cacheStatistics()
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to gather cache metrics: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "STATS_UNAVAILABLE",
+			Message:   "Unable to gather cache metrics",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	dbStats := h.db.Stats()
 	stats := models.ServiceStats{
-		Service: "eligibility-service",
-		Version: "1.0.0",
-		Uptime:  time.Since(h.startTime).String(),
-		RequestStats: models.RequestStatistics{
-			// These would be calculated from actual metrics in production
-			TotalRequests:       1000,
-			RequestsPerSecond:   10.5,
-			AverageResponseTime: 120.5,
-			P95ResponseTime:     250.0,
-			P99ResponseTime:     400.0,
-			ErrorRate:           0.02,
-			SuccessRate:         0.98,
-		},
-		CacheStats: models.CacheStatistics{
-			HitRate:       0.85,
-			MissRate:      0.15,
-			TotalHits:     850,
-			TotalMisses:   150,
-			CachedEntries: 500,
-			CacheSize:     "10MB",
-			EvictionCount: 25,
-		},
+		Service:      "eligibility-service",
+		Version:      "1.0.0",
+		Uptime:       time.Since(h.startTime).String(),
+		RequestStats: requestStats,
+		CacheStats:   cacheStats,
 		DatabaseStats: models.DatabaseStatistics{
-			ActiveConnections: h.db.Stats().OpenConnections,
-			IdleConnections:   h.db.Stats().Idle,
-			TotalQueries:      1200,
-			SlowQueries:       5,
-			AverageQueryTime:  15.2,
+			ActiveConnections: dbStats.InUse,
+			IdleConnections:   dbStats.Idle,
+			WaitCount:         dbStats.WaitCount,
 		},
 		Dependencies: models.DependencyStatus{
 			Database: h.db.Ping() == nil,
@@ -350,57 +432,109 @@ func (h *Handler) GetServiceStats(c *gin.Context) {
 }
 
 // ClearCache godoc
-// @Summary Clear service cache
-// @Description Clear all cached data
+// @Summary Invalidate service cache
+// @Description Invalidate cached data of this service by namespace, member ID or key pattern. Keys of other services sharing the Redis are never touched. An empty body invalidates every key of the service; with tenant_id only the keys of that tenant are considered, and with dry_run the matching keys are reported but not deleted.
 // @Tags admin
 // @Accept json
 // @Produce json
-// @Success 200 {object} map[string]interface{}
+// @Param request body models.CacheInvalidationRequest false "Keys to invalidate"
+// @Success 200 {object} models.CacheInvalidationResponse
+// @Failure 400 {object} models.ResponseMessage
+// @Failure 500 {object} models.ResponseMessage
 // @Router /api/v1/admin/cache/clear [post]
 func (h *Handler) ClearCache(c *gin.Context) {
-	if err := h.redis.FlushAll(c.Request.Context()).Err(); err != nil {
-		h.logger.Errorf("Failed to clear cache: %v", err)
+	var req models.CacheInvalidationRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ResponseMessage{
+				Type:      "error",
+				Code:      "INVALID_REQUEST",
+				Message:   "Invalid request format",
+				Details:   err.Error(),
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+	}
+
+	if req.TenantID != "" && !tenant.Valid(req.TenantID) {
+		c.JSON(http.StatusBadRequest, models.ResponseMessage{
+			Type:      "error",
+			Code:      "INVALID_TENANT",
+			Message:   "tenant_id may only contain letters, digits, '-', '_' and '.'",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	selector := cache.Selector{
+		Namespace: req.Namespace,
+		MemberID:  req.MemberID,
+		Pattern:   req.Pattern,
+	}
+
+	result, err := h.cache.ForTenant(req.TenantID).Invalidate(c.Request.Context(), selector, req.DryRun)
+	if errors.Is(err, cache.ErrInvalidSelector) {
+		c.JSON(http.StatusBadRequest, models.ResponseMessage{
+			Type:      "error",
+			Code:      "INVALID_CACHE_SELECTOR",
+			Message:   "Namespace must not contain wildcards or ':', and member_id and pattern cannot be combined",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to clear cache: %v", err)
 		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
-			Type:    "error",
-			Code:    "CACHE_CLEAR_FAILED",
-			Message: "Failed to clear cache",
+			Type:      "error",
+			Code:      "CACHE_CLEAR_FAILED",
+			Message:   "Failed to clear cache",
+			RequestID: requestid.Get(c),
 		})
 		return
 	}
 
-	h.logAuditEvent(c.Request.Context(), "cache.clear", "", c.ClientIP(), map[string]interface{}{
-		"action": "clear_all",
+	h.logAuditEvent(c.Request.Context(), "cache.clear", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
+		"namespace":    req.Namespace,
+		"member_id":    req.MemberID,
+		"tenant_id":    req.TenantID,
+		"patterns":     result.Patterns,
+		"dry_run":      result.DryRun,
+		"matched_keys": result.Matched,
+		"deleted_keys": result.Deleted,
 	})
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Cache cleared successfully",
-		"status":  "success",
+	c.JSON(http.StatusOK, models.CacheInvalidationResponse{
+		Patterns:    result.Patterns,
+		DryRun:      result.DryRun,
+		MatchedKeys: result.Matched,
+		DeletedKeys: result.Deleted,
+		Keys:        result.Keys,
+		Truncated:   result.Truncated,
 	})
 }
 
 // GetCacheStats godoc
 // @Summary Get cache statistics
-// @Description Retrieve detailed cache statistics
+// @Description Retrieve cache hits and misses since the service started
 // @Tags admin
 // @Accept json
 // @Produce json
 // @Success 200 {object} models.CacheStatistics
+// @Failure 500 {object} models.ResponseMessage
 // @Router /api/v1/admin/cache/stats [get]
 func (h *Handler) GetCacheStats(c *gin.Context) {
-	info := h.redis.Info(c.Request.Context(), "memory", "stats")
-	
-	// Parse Redis info (simplified)
-	stats := models.CacheStatistics{
-		HitRate:       0.85, // Would be calculated from Redis stats
-		MissRate:      0.15,
-		TotalHits:     850,
-		TotalMisses:   150,
-		CachedEntries: 500,
-		CacheSize:     "10MB",
-		EvictionCount: 25,
+	stats, err := h.cacheStatistics()
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to gather cache metrics: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "STATS_UNAVAILABLE",
+			Message:   "Unable to gather cache metrics",
+			RequestID: requestid.Get(c),
+		})
+		return
 	}
 
-	_ = info // Use Redis info to populate actual stats
-
 	c.JSON(http.StatusOK, stats)
 }
\ No newline at end of file
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/config"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/fieldcrypt"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// memberColumns are the member fields that can be encrypted at rest
+var memberColumns = map[string]fieldcrypt.Column{
+	"identifier":   {Name: "identifier", Encrypted: "identifier_encrypted", Index: "identifier_index"},
+	"name":         {Name: "name", Encrypted: "name_encrypted"},
+	"contact_info": {Name: "contact_info", Encrypted: "contact_info_encrypted"},
+	"address":      {Name: "address", Encrypted: "address_encrypted"},
+}
+
+// memberTable describes the encrypted member columns. Values are bound to the
+// member's row ID.
+func memberTable(fields []string) (fieldcrypt.Table, error) {
+	table := fieldcrypt.Table{
+		Name:  "members",
+		ID:    "id",
+		KeyID: "encryption_key_id",
+	}
+	for _, field := range fields {
+		column, ok := memberColumns[field]
+		if !ok {
+			return table, fmt.Errorf("unknown member field %q", field)
+		}
+		table.Columns = append(table.Columns, column)
+	}
+	return table, nil
+}
+
+// fieldEncryption holds the cipher for member PHI and the job that keeps
+// members on the primary key
+type fieldEncryption struct {
+	cipher      *fieldcrypt.Cipher
+	keyRing     *fieldcrypt.FileKeyRing
+	table       fieldcrypt.Table
+	reencryptor *fieldcrypt.Reencryptor
+}
+
+// newFieldEncryption sets up member field encryption, or returns nil when it
+// is disabled
+func newFieldEncryption(cfg *config.Config, db *sql.DB, logger *logrus.Logger) (*fieldEncryption, error) {
+	if !cfg.FieldEncryption.Enabled {
+		return nil, nil
+	}
+
+	table, err := memberTable(cfg.FieldEncryption.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyRing *fieldcrypt.FileKeyRing
+	switch cfg.FieldEncryption.KMS {
+	case "file":
+		keyRing, err = fieldcrypt.LoadFileKeyRing(cfg.FieldEncryption.KeyRingPath, logger)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported KMS %q", cfg.FieldEncryption.KMS)
+	}
+
+	cipher, err := fieldcrypt.New(keyRing, keyRing.IndexKey(), time.Duration(cfg.FieldEncryption.DataKeyTTL)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fieldEncryption{
+		cipher:  cipher,
+		keyRing: keyRing,
+		table:   table,
+		reencryptor: fieldcrypt.NewReencryptor(db, cipher, table, logger, fieldcrypt.ReencryptOptions{
+			Interval:  time.Duration(cfg.FieldEncryption.ReencryptInterval) * time.Second,
+			BatchSize: cfg.FieldEncryption.ReencryptBatchSize,
+		}),
+	}, nil
+}
+
+// memberIdentifierIndex returns the blind index of a national ID, or "" when
+// field encryption is disabled
+func (h *Handler) memberIdentifierIndex(identifier string) string {
+	if h.encryption == nil {
+		return ""
+	}
+	return h.encryption.cipher.BlindIndex(identifier, h.encryption.table.IndexBinding("identifier"))
+}
+
+// memberNameIndex returns the searchable form of the phonetic keys of a
+// member's name: blind indexes of the keys, or the keys themselves when
+// field encryption is disabled
+func (h *Handler) memberNameIndex(keys []string) []string {
+	index := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if h.encryption != nil {
+			key = h.encryption.cipher.BlindIndex(key, h.encryption.table.IndexBinding("name"))
+		}
+		index = append(index, key)
+	}
+	return index
+}
+
+// openMemberField returns the value of a member field, decrypting it if the
+// row holds it encrypted. Rows not yet reached by the re-encryption job still
+// hold plaintext.
+func (h *Handler) openMemberField(ctx context.Context, field, memberID string, plaintext []byte, encrypted sql.NullString) ([]byte, error) {
+	if !encrypted.Valid {
+		return plaintext, nil
+	}
+	if h.encryption == nil {
+		return nil, fmt.Errorf("member field %s is encrypted but field encryption is disabled", field)
+	}
+	return h.encryption.cipher.Decrypt(ctx, encrypted.String, h.encryption.table.Binding(field, memberID))
+}
+
+// GetEncryptionStatus godoc
+// @Summary Get field encryption status
+// @Description Count members by the key version their PHI is encrypted under
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} fieldcrypt.ReencryptStatus
+// @Failure 404 {object} models.ResponseMessage
+// @Router /api/v1/admin/encryption/status [get]
+func (h *Handler) GetEncryptionStatus(c *gin.Context) {
+	if h.encryption == nil {
+		c.JSON(http.StatusNotFound, models.ResponseMessage{
+			Type:      "information",
+			Code:      "FIELD_ENCRYPTION_DISABLED",
+			Message:   "Field encryption is not enabled",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	status, err := h.encryption.reencryptor.Status(c.Request.Context())
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to read field encryption status")
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "ENCRYPTION_STATUS_FAILED",
+			Message:   "Failed to read field encryption status",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
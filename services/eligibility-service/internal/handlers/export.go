@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/names"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Export pages for the gateway's FHIR $export jobs and its Patient
+// $everything and $match operations
+
+const (
+	defaultExportPageSize = 500
+	maxExportPageSize     = 1000
+)
+
+// exportParams are the filters shared by the export endpoints
+type exportParams struct {
+	since     sql.NullTime // only records updated since
+	group     string       // only records of a coverage group number
+	member    string       // only records of a member, by national ID
+	birthDate sql.NullTime // only members born on a date
+	nameKeys  []string     // only members whose name has all these keys
+	after     string       // cursor from the previous page
+	count     int
+}
+
+// ExportMembers godoc
+// @Summary Export members
+// @Description Page through members for bulk export. Tenant-scoped callers and group exports only see members with coverage of the payer or group.
+// @Tags export
+// @Produce json
+// @Param _since query string false "Only members updated at or after this instant (RFC 3339)"
+// @Param group query string false "Coverage group number"
+// @Param member query string false "Member national ID"
+// @Param birth_date query string false "Member birth date (YYYY-MM-DD)"
+// @Param name query string false "Member name, matched across Arabic and Latin spellings"
+// @Param after query string false "Cursor returned by the previous page"
+// @Param _count query int false "Page size" default(500)
+// @Success 200 {object} models.MemberExportPage
+// @Failure 400 {object} models.ResponseMessage
+// @Failure 500 {object} models.ResponseMessage
+// @Router /api/v1/export/members [get]
+func (h *Handler) ExportMembers(c *gin.Context) {
+	params, ok := h.exportParams(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	var page models.MemberExportPage
+	var err error
+	tenantID := tenant.Get(c)
+	if tenantID != "" || params.group != "" {
+		page, err = h.exportCoveredMembers(ctx, tenantID, params)
+	} else {
+		page, err = h.exportAllMembers(ctx, params)
+	}
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to export members: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "MEMBER_EXPORT_FAILED",
+			Message:   "Failed to export members",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	h.logAuditEvent(ctx, "member.export", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
+		"group":        params.group,
+		"result_count": len(page.Members),
+	})
+
+	c.JSON(http.StatusOK, page)
+}
+
+// exportAllMembers pages through every member in ID order
+func (h *Handler) exportAllMembers(ctx context.Context, params exportParams) (models.MemberExportPage, error) {
+	page := models.MemberExportPage{Members: []models.Member{}}
+
+	query := memberSelect + `
+		WHERE ($1::timestamptz IS NULL OR updated_at >= $1)
+		  AND ($3::text = '' OR identifier = $3 OR identifier_index = $4)
+		  AND ($5::date IS NULL OR birth_date = $5)
+		  AND ($6::text[] IS NULL OR name_keys @> $6)`
+	args := []interface{}{params.since, params.count, params.member, h.memberIdentifierIndex(params.member), params.birthDate, pq.Array(params.nameKeys)}
+	if params.after != "" {
+		query += ` AND id > $7`
+		args = append(args, params.after)
+	}
+	query += ` ORDER BY id LIMIT $2`
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		member, err := h.scanMember(ctx, rows)
+		if err != nil {
+			return page, err
+		}
+		page.Members = append(page.Members, *member)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Members) == params.count {
+		page.Next = page.Members[len(page.Members)-1].ID
+	}
+	return page, nil
+}
+
+// exportCoveredMembers pages through the members with coverage of a payer or
+// group in ID order
+func (h *Handler) exportCoveredMembers(ctx context.Context, tenantID string, params exportParams) (models.MemberExportPage, error) {
+	page := models.MemberExportPage{Members: []models.Member{}}
+
+	query := memberSelect + `
+		WHERE EXISTS (
+		      SELECT 1 FROM coverage
+		      WHERE coverage.member_ref = members.id
+		        AND ($7::text = '' OR coverage.payer_id = $7)
+		        AND ($8::text = '' OR coverage.group_number = $8))
+		  AND ($1::timestamptz IS NULL OR updated_at >= $1)
+		  AND ($3::text = '' OR identifier = $3 OR identifier_index = $4)
+		  AND ($5::date IS NULL OR birth_date = $5)
+		  AND ($6::text[] IS NULL OR name_keys @> $6)`
+	args := []interface{}{params.since, params.count, params.member, h.memberIdentifierIndex(params.member),
+		params.birthDate, pq.Array(params.nameKeys), tenantID, params.group}
+	if params.after != "" {
+		query += ` AND id > $9`
+		args = append(args, params.after)
+	}
+	query += ` ORDER BY id LIMIT $2`
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		member, err := h.scanMember(ctx, rows)
+		if err != nil {
+			return page, err
+		}
+		page.Members = append(page.Members, *member)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if len(page.Members) == params.count {
+		page.Next = page.Members[len(page.Members)-1].ID
+	}
+	return page, nil
+}
+
+// ExportCoverage godoc
+// @Summary Export coverage
+// @Description Page through coverage records for bulk export. Tenant-scoped callers only see coverage of their own payer.
+// @Tags export
+// @Produce json
+// @Param _since query string false "Only coverage updated at or after this instant (RFC 3339)"
+// @Param group query string false "Coverage group number"
+// @Param member query string false "Member national ID"
+// @Param after query string false "Cursor returned by the previous page"
+// @Param _count query int false "Page size" default(500)
+// @Success 200 {object} models.CoverageExportPage
+// @Failure 400 {object} models.ResponseMessage
+// @Failure 500 {object} models.ResponseMessage
+// @Router /api/v1/export/coverage [get]
+func (h *Handler) ExportCoverage(c *gin.Context) {
+	params, ok := h.exportParams(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	page := models.CoverageExportPage{Coverage: []models.Coverage{}}
+
+	query := coverageSelect + `
+		WHERE ($1::text = '' OR c.payer_id = $1)
+		  AND ($2::text = '' OR c.group_number = $2)
+		  AND ($3::timestamptz IS NULL OR c.updated_at >= $3)
+		  AND ($5::text = '' OR m.identifier = $5 OR m.identifier_index = $6)`
+	args := []interface{}{tenant.Get(c), params.group, params.since, params.count, params.member, h.memberIdentifierIndex(params.member)}
+	if params.after != "" {
+		query += ` AND c.id > $7`
+		args = append(args, params.after)
+	}
+	query += ` ORDER BY c.id LIMIT $4`
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to export coverage: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "COVERAGE_EXPORT_FAILED",
+			Message:   "Failed to export coverage records",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		coverage, err := h.scanCoverage(ctx, rows)
+		if err != nil {
+			h.logger.WithContext(ctx).Errorf("Failed to scan coverage row: %v", err)
+			c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+				Type:      "error",
+				Code:      "COVERAGE_EXPORT_FAILED",
+				Message:   "Failed to export coverage records",
+				RequestID: requestid.Get(c),
+			})
+			return
+		}
+
+		page.Coverage = append(page.Coverage, *coverage)
+	}
+
+	if len(page.Coverage) == params.count {
+		page.Next = page.Coverage[len(page.Coverage)-1].ID
+	}
+
+	h.logAuditEvent(ctx, "coverage.export", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
+		"group":        params.group,
+		"result_count": len(page.Coverage),
+	})
+
+	c.JSON(http.StatusOK, page)
+}
+
+// exportParams parses the export filters, answering 400 when they are invalid
+func (h *Handler) exportParams(c *gin.Context) (exportParams, bool) {
+	params := exportParams{
+		group:  c.Query("group"),
+		member: c.Query("member"),
+		after:  c.Query("after"),
+		count:  defaultExportPageSize,
+	}
+
+	if since := c.Query("_since"); since != "" {
+		parsed, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ResponseMessage{
+				Type:      "error",
+				Code:      "INVALID_REQUEST",
+				Message:   "_since must be an RFC 3339 instant",
+				RequestID: requestid.Get(c),
+			})
+			return params, false
+		}
+		params.since = sql.NullTime{Time: parsed, Valid: true}
+	}
+
+	if birthDate := c.Query("birth_date"); birthDate != "" {
+		parsed, err := time.Parse("2006-01-02", birthDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ResponseMessage{
+				Type:      "error",
+				Code:      "INVALID_REQUEST",
+				Message:   "birth_date must be a date (YYYY-MM-DD)",
+				RequestID: requestid.Get(c),
+			})
+			return params, false
+		}
+		params.birthDate = sql.NullTime{Time: parsed, Valid: true}
+	}
+
+	if name := c.Query("name"); name != "" {
+		params.nameKeys = h.memberNameIndex(names.Keys(name))
+		if len(params.nameKeys) == 0 {
+			c.JSON(http.StatusBadRequest, models.ResponseMessage{
+				Type:      "error",
+				Code:      "INVALID_REQUEST",
+				Message:   "name must contain a part of at least two consonants",
+				RequestID: requestid.Get(c),
+			})
+			return params, false
+		}
+	}
+
+	if countStr := c.Query("_count"); countStr != "" {
+		if parsedCount, err := strconv.Atoi(countStr); err == nil && parsedCount > 0 && parsedCount <= maxExportPageSize {
+			params.count = parsedCount
+		}
+	}
+
+	return params, true
+}
@@ -10,6 +10,11 @@ import (
 
 	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/cache"
 	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/config"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/importer"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/outbox"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
@@ -21,14 +26,16 @@ import (
 )
 
 type Handler struct {
-	config    *config.Config
-	logger    *logrus.Logger
-	db        *sql.DB
-	redis     *redis.Client
-	cache     *cache.Manager
-	kafka     *kafka.Writer
-	metrics   *MetricsCollector
-	startTime time.Time
+	config     *config.Config
+	logger     *logrus.Logger
+	db         *sql.DB
+	redis      *redis.Client
+	cache      *cache.Manager
+	kafka      *kafka.Writer
+	encryption *fieldEncryption // nil when field encryption is disabled
+	imports    *importer.Store  // nil when bulk import is disabled
+	metrics    *MetricsCollector
+	startTime  time.Time
 }
 
 type MetricsCollector struct {
@@ -44,7 +51,7 @@ type MetricsCollector struct {
 // NewHandler creates a new handler instance
 func NewHandler(cfg *config.Config, logger *logrus.Logger) (*Handler, error) {
 	// Initialize database connection
-	db, err := sql.Open("postgres", cfg.Database.URL)
+	db, err := tracing.OpenDB(cfg.Database.URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -64,19 +71,33 @@ func NewHandler(cfg *config.Config, logger *logrus.Logger) (*Handler, error) {
 		return nil, fmt.Errorf("failed to parse Redis URL: %w", err)
 	}
 	redisClient := redis.NewClient(redisOpts)
+	tracing.InstrumentRedis(redisClient)
 
 	if err := redisClient.Ping(redisClient.Context()).Err(); err != nil {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
 	// Initialize cache manager
-	cacheManager := cache.NewManager(redisClient, time.Duration(cfg.Business.CacheTTL)*time.Second)
+	cacheManager := cache.NewManager(redisClient, cfg.Redis.KeyPrefix, time.Duration(cfg.Business.CacheTTL)*time.Second)
 
-	// Initialize Kafka writer
+	// Initialize Kafka writer. Messages carry their own topic and are keyed by
+	// aggregate, so the hash balancer keeps each aggregate on one partition.
 	kafkaWriter := &kafka.Writer{
-		Addr:     kafka.TCP(cfg.Kafka.Brokers...),
-		Topic:    cfg.Kafka.Topics.AuditTrail,
-		Balancer: &kafka.LeastBytes{},
+		Addr:         kafka.TCP(cfg.Kafka.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+
+	// Initialize field-level encryption of member PHI
+	encryption, err := newFieldEncryption(cfg, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize field encryption: %w", err)
+	}
+
+	// Initialize bulk import jobs
+	var imports *importer.Store
+	if cfg.Import.Enabled {
+		imports = importer.NewStore(db, logger)
 	}
 
 	// Initialize metrics
@@ -137,17 +158,53 @@ func NewHandler(cfg *config.Config, logger *logrus.Logger) (*Handler, error) {
 	prometheus.MustRegister(metrics.ActiveConnections)
 
 	return &Handler{
-		config:    cfg,
-		logger:    logger,
-		db:        db,
-		redis:     redisClient,
-		cache:     cacheManager,
-		kafka:     kafkaWriter,
-		metrics:   metrics,
-		startTime: time.Now(),
+		config:     cfg,
+		logger:     logger,
+		db:         db,
+		redis:      redisClient,
+		cache:      cacheManager,
+		kafka:      kafkaWriter,
+		encryption: encryption,
+		imports:    imports,
+		metrics:    metrics,
+		startTime:  time.Now(),
 	}, nil
 }
 
+// StartWorkers starts background jobs. They stop when the context is cancelled.
+func (h *Handler) StartWorkers(ctx context.Context) {
+	// Audit and domain events written to the outbox
+	relay := outbox.NewRelay(h.db, h.kafka, h.logger, outbox.RelayOptions{
+		PollInterval: time.Duration(h.config.Outbox.PollIntervalMs) * time.Millisecond,
+		BatchSize:    h.config.Outbox.BatchSize,
+		MaxAttempts:  h.config.Outbox.MaxAttempts,
+		MaxBackoff:   time.Duration(h.config.Outbox.MaxBackoffSeconds) * time.Second,
+		Retention:    time.Duration(h.config.Outbox.RetentionHours) * time.Hour,
+	})
+	go relay.Run(ctx)
+
+	// Encryption of plaintext member PHI and re-encryption after key rotation
+	if h.encryption != nil {
+		go h.encryption.keyRing.Watch(ctx, time.Duration(h.config.FieldEncryption.ReloadInterval)*time.Second)
+		go h.encryption.reencryptor.Run(ctx)
+	}
+
+	// Name index of members written before it existed
+	go h.indexMemberNames(ctx)
+
+	// Bulk imports of members and coverage
+	if h.imports != nil {
+		runner := importer.NewRunner(h.db, h.imports, &importProcessor{h: h}, h.logger, importer.RunnerOptions{
+			PollInterval: time.Duration(h.config.Import.PollIntervalMs) * time.Millisecond,
+			Lease:        time.Duration(h.config.Import.LeaseSeconds) * time.Second,
+			BatchSize:    h.config.Import.BatchSize,
+			MaxAttempts:  h.config.Import.MaxAttempts,
+			Retention:    time.Duration(h.config.Import.RetentionHours) * time.Hour,
+		})
+		go runner.Run(ctx)
+	}
+}
+
 // Close closes all connections
 func (h *Handler) Close() error {
 	if h.db != nil {
@@ -180,7 +237,7 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 		status = "unhealthy"
 		statusCode = http.StatusServiceUnavailable
 		checks["database"] = "unhealthy: " + err.Error()
-		h.logger.Errorf("Database health check failed: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Database health check failed: %v", err)
 	} else {
 		checks["database"] = "healthy"
 	}
@@ -190,7 +247,7 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 		status = "unhealthy"
 		statusCode = http.StatusServiceUnavailable
 		checks["redis"] = "unhealthy: " + err.Error()
-		h.logger.Errorf("Redis health check failed: %v", err)
+		h.logger.WithContext(c.Request.Context()).Errorf("Redis health check failed: %v", err)
 	} else {
 		checks["redis"] = "healthy"
 	}
@@ -260,31 +317,68 @@ func (h *Handler) MetricsHandler(c *gin.Context) {
 	promhttp.Handler().ServeHTTP(c.Writer, c.Request)
 }
 
-// logAuditEvent logs an audit event to Kafka
+// logAuditEvent records an audit event in the outbox for relay to Kafka
 func (h *Handler) logAuditEvent(ctx context.Context, eventType, userID, clientIP string, data map[string]interface{}) {
+	if err := h.logAuditEventTx(ctx, h.db, eventType, userID, clientIP, data); err != nil {
+		h.logger.WithContext(ctx).Errorf("Failed to record audit event: %v", err)
+	}
+}
+
+// logAuditEventTx records an audit event using the given executor, so that it
+// commits or rolls back together with the caller's transaction
+func (h *Handler) logAuditEventTx(ctx context.Context, exec outbox.Execer, eventType, userID, clientIP string, data map[string]interface{}) error {
+	eventID := uuid.New().String()
 	auditEvent := map[string]interface{}{
-		"eventId":     uuid.New().String(),
+		"eventId":     eventID,
 		"eventType":   eventType,
 		"userId":      userID,
 		"clientIP":    clientIP,
 		"timestamp":   time.Now().UTC(),
 		"service":     "eligibility-service",
+		"requestId":   requestid.FromContext(ctx),
+		"tenantId":    tenant.FromContext(ctx),
 		"data":        data,
 	}
 
 	eventData, err := json.Marshal(auditEvent)
 	if err != nil {
-		h.logger.Errorf("Failed to marshal audit event: %v", err)
-		return
+		return fmt.Errorf("failed to marshal audit event: %w", err)
 	}
 
-	message := kafka.Message{
-		Key:   []byte(eventType),
-		Value: eventData,
-		Time:  time.Now(),
+	return outbox.Add(ctx, exec, outbox.Event{
+		ID:            eventID,
+		Topic:         h.config.Kafka.Topics.AuditTrail,
+		AggregateType: "audit",
+		AggregateID:   eventType,
+		EventType:     eventType,
+		Payload:       eventData,
+	})
+}
+
+// publishCoverageEvent records a coverage domain event in the outbox. Events
+// are keyed by coverage ID so consumers see changes to a coverage in order.
+func (h *Handler) publishCoverageEvent(ctx context.Context, exec outbox.Execer, eventType, coverageID string, data interface{}) error {
+	eventID := uuid.New().String()
+	event := map[string]interface{}{
+		"eventId":    eventID,
+		"eventType":  eventType,
+		"coverageId": coverageID,
+		"timestamp":  time.Now().UTC(),
+		"service":    "eligibility-service",
+		"data":       data,
 	}
 
-	if err := h.kafka.WriteMessages(ctx, message); err != nil {
-		h.logger.Errorf("Failed to publish audit event: %v", err)
+	eventData, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal coverage event: %w", err)
 	}
-}
\ No newline at end of file
+
+	return outbox.Add(ctx, exec, outbox.Event{
+		ID:            eventID,
+		Topic:         h.config.Kafka.Topics.CoverageEvents,
+		AggregateType: "coverage",
+		AggregateID:   coverageID,
+		EventType:     eventType,
+		Payload:       eventData,
+	})
+}
@@ -0,0 +1,794 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/cache"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/hijri"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/importer"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Bulk NDJSON import of members and coverage
+
+const (
+	defaultImportErrorPageSize = 100
+	maxImportErrorPageSize     = 1000
+
+	// patientIdentifierSystem identifies national IDs among the identifiers
+	// of imported Patient resources
+	patientIdentifierSystem = "https://nphies.sa/patient-id"
+)
+
+// importMediaTypes are the accepted content types of an upload
+var importMediaTypes = map[string]bool{
+	"application/fhir+ndjson": true,
+	"application/x-ndjson":    true,
+	"application/ndjson":      true,
+}
+
+// coverageStatuses are the valid statuses of a coverage record
+var coverageStatuses = map[string]bool{
+	"active":           true,
+	"cancelled":        true,
+	"draft":            true,
+	"entered-in-error": true,
+}
+
+// StartImport godoc
+// @Summary Import members and coverage
+// @Description Queue an $import of NDJSON with one FHIR Patient or Coverage resource, or one coverage record, per line. Lines are applied in order in the background; lines that fail validation are skipped and reported. Tenant-scoped callers may only import coverage of their own payer.
+// @Tags import
+// @Accept application/fhir+ndjson
+// @Produce json
+// @Success 202 {object} importer.Job
+// @Failure 400 {object} models.ResponseMessage
+// @Failure 404 {object} models.ResponseMessage
+// @Failure 413 {object} models.ResponseMessage
+// @Failure 415 {object} models.ResponseMessage
+// @Failure 500 {object} models.ResponseMessage
+// @Router /api/v1/import [post]
+func (h *Handler) StartImport(c *gin.Context) {
+	if !h.importsEnabled(c) {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if !importMediaTypes[mediaType] {
+		c.JSON(http.StatusUnsupportedMediaType, models.ResponseMessage{
+			Type:      "error",
+			Code:      "UNSUPPORTED_MEDIA_TYPE",
+			Message:   "Imports must be uploaded as application/fhir+ndjson",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := tenant.Get(c)
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, h.config.Import.MaxBytes)
+
+	job, err := h.imports.Create(ctx, tenantID, body, importer.Limits{
+		MaxLines:     h.config.Import.MaxLines,
+		MaxLineBytes: h.config.Import.MaxLineBytes,
+	})
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, importer.ErrEmptyUpload):
+			c.JSON(http.StatusBadRequest, models.ResponseMessage{
+				Type:      "error",
+				Code:      "EMPTY_IMPORT",
+				Message:   "The upload contains no lines",
+				RequestID: requestid.Get(c),
+			})
+		case errors.As(err, &tooLarge), errors.Is(err, importer.ErrTooManyLines), errors.Is(err, importer.ErrLineTooLong):
+			c.JSON(http.StatusRequestEntityTooLarge, models.ResponseMessage{
+				Type:    "error",
+				Code:    "IMPORT_TOO_LARGE",
+				Message: "The upload exceeds the import limits",
+				Details: fmt.Sprintf("at most %d bytes, %d lines and %d bytes per line",
+					h.config.Import.MaxBytes, h.config.Import.MaxLines, h.config.Import.MaxLineBytes),
+				RequestID: requestid.Get(c),
+			})
+		default:
+			h.logger.WithContext(ctx).Errorf("Failed to create import job: %v", err)
+			c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+				Type:      "error",
+				Code:      "IMPORT_CREATE_FAILED",
+				Message:   "Failed to create import job",
+				RequestID: requestid.Get(c),
+			})
+		}
+		return
+	}
+
+	h.logAuditEvent(ctx, "import.create", serviceauth.Principal(c), c.ClientIP(), map[string]interface{}{
+		"job_id":      job.ID,
+		"total_lines": job.TotalLines,
+	})
+
+	c.Header("Location", "/api/v1/import/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetImport godoc
+// @Summary Get import status
+// @Description Get the progress of an import job. Jobs of other payers are not found for tenant-scoped callers.
+// @Tags import
+// @Produce json
+// @Param id path string true "Import job ID"
+// @Success 200 {object} importer.Job
+// @Failure 404 {object} models.ResponseMessage
+// @Failure 500 {object} models.ResponseMessage
+// @Router /api/v1/import/{id} [get]
+func (h *Handler) GetImport(c *gin.Context) {
+	if !h.importsEnabled(c) {
+		return
+	}
+
+	job, ok := h.findImport(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// GetImportErrors godoc
+// @Summary Get import errors
+// @Description Page through the lines an import job could not apply, in line order
+// @Tags import
+// @Produce json
+// @Param id path string true "Import job ID"
+// @Param _count query int false "Number of results to return" default(100)
+// @Param _offset query int false "Offset for pagination" default(0)
+// @Success 200 {array} importer.LineError
+// @Failure 404 {object} models.ResponseMessage
+// @Failure 500 {object} models.ResponseMessage
+// @Router /api/v1/import/{id}/errors [get]
+func (h *Handler) GetImportErrors(c *gin.Context) {
+	if !h.importsEnabled(c) {
+		return
+	}
+
+	job, ok := h.findImport(c)
+	if !ok {
+		return
+	}
+
+	count := defaultImportErrorPageSize
+	if countStr := c.Query("_count"); countStr != "" {
+		if parsedCount, err := strconv.Atoi(countStr); err == nil && parsedCount > 0 && parsedCount <= maxImportErrorPageSize {
+			count = parsedCount
+		}
+	}
+
+	offset := 0
+	if offsetStr := c.Query("_offset"); offsetStr != "" {
+		if parsedOffset, err := strconv.Atoi(offsetStr); err == nil && parsedOffset >= 0 {
+			offset = parsedOffset
+		}
+	}
+
+	lineErrors, err := h.imports.Errors(c.Request.Context(), job.ID, count, offset)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to get import errors: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "IMPORT_RETRIEVAL_FAILED",
+			Message:   "Failed to retrieve import errors",
+			RequestID: requestid.Get(c),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, lineErrors)
+}
+
+// importsEnabled answers requests for imports when they are disabled
+func (h *Handler) importsEnabled(c *gin.Context) bool {
+	if h.imports != nil {
+		return true
+	}
+	c.JSON(http.StatusNotFound, models.ResponseMessage{
+		Type:      "information",
+		Code:      "IMPORT_DISABLED",
+		Message:   "Bulk import is not enabled",
+		RequestID: requestid.Get(c),
+	})
+	return false
+}
+
+// findImport loads the import job of the request within the caller's tenant
+func (h *Handler) findImport(c *gin.Context) (*importer.Job, bool) {
+	job, err := h.imports.Get(c.Request.Context(), c.Param("id"), tenant.Get(c))
+	if err == nil {
+		return job, true
+	}
+
+	if errors.Is(err, importer.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, models.ResponseMessage{
+			Type:      "error",
+			Code:      "IMPORT_NOT_FOUND",
+			Message:   "Import job not found",
+			RequestID: requestid.Get(c),
+		})
+	} else {
+		h.logger.WithContext(c.Request.Context()).Errorf("Failed to get import job: %v", err)
+		c.JSON(http.StatusInternalServerError, models.ResponseMessage{
+			Type:      "error",
+			Code:      "IMPORT_RETRIEVAL_FAILED",
+			Message:   "Failed to retrieve import job",
+			RequestID: requestid.Get(c),
+		})
+	}
+	return nil, false
+}
+
+// invalidLineError is a line that was rejected by validation
+type invalidLineError struct {
+	message string
+}
+
+func (e *invalidLineError) Error() string {
+	return e.message
+}
+
+func invalidLine(format string, args ...interface{}) error {
+	return &invalidLineError{message: fmt.Sprintf(format, args...)}
+}
+
+// importProcessor applies import lines for the import runner
+type importProcessor struct {
+	h *Handler
+}
+
+// Process applies a batch of lines in the batch's transaction. Each line runs
+// in a savepoint, so a rejected line is rolled back on its own; errors that
+// are not about the line fail the batch, which is retried.
+func (p *importProcessor) Process(ctx context.Context, tx *sql.Tx, job *importer.Job, lines []importer.Line) (*importer.Result, error) {
+	h := p.h
+	ctx = tenant.NewContext(ctx, job.TenantID)
+	if err := h.scopeTx(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	result := &importer.Result{}
+	members := make(map[string]bool)
+	for _, line := range lines {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT import_line"); err != nil {
+			return nil, err
+		}
+
+		created, memberID, err := p.applyLine(ctx, tx, job.TenantID, line.Content)
+		if err != nil {
+			if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_line"); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+			message, rejected := lineErrorMessage(err)
+			if !rejected {
+				return nil, err
+			}
+			result.Errors = append(result.Errors, importer.LineError{Line: line.Number, Message: message})
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT import_line"); err != nil {
+			return nil, err
+		}
+
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+		if !members[memberID] {
+			members[memberID] = true
+			result.Members = append(result.Members, memberID)
+		}
+	}
+
+	err := h.logAuditEventTx(ctx, tx, "coverage.import", "", "", map[string]interface{}{
+		"job_id":     job.ID,
+		"first_line": lines[0].Number,
+		"last_line":  lines[len(lines)-1].Number,
+		"created":    result.Created,
+		"updated":    result.Updated,
+		"errors":     len(result.Errors),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Committed clears the cached eligibility of the members a batch changed.
+// The batch is already committed, so failures are only logged; the stale
+// entries expire with their TTL.
+func (p *importProcessor) Committed(ctx context.Context, job *importer.Job, result *importer.Result) {
+	for _, memberID := range result.Members {
+		if _, err := p.h.cache.Invalidate(ctx, cache.Selector{MemberID: memberID}, false); err != nil {
+			p.h.logger.WithContext(ctx).WithError(err).WithFields(logrus.Fields{
+				"job_id":    job.ID,
+				"member_id": memberID,
+			}).Error("Failed to clear cached eligibility of an imported member")
+		}
+	}
+}
+
+// lineErrorMessage returns the message recorded for a line that was
+// rejected, and whether the error rejects the line rather than the batch
+func lineErrorMessage(err error) (string, bool) {
+	var invalid *invalidLineError
+	if errors.As(err, &invalid) {
+		return invalid.message, true
+	}
+
+	// Data and constraint errors are about the line; anything else, such as a
+	// lost connection, is retried with the batch
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "22":
+			return "invalid value: " + pqErr.Message, true
+		case "23":
+			return "conflicts with an existing record", true
+		}
+	}
+	return "", false
+}
+
+// applyLine applies one line. It reports whether a record was created, and
+// the national ID of the member whose data changed.
+func (p *importProcessor) applyLine(ctx context.Context, tx *sql.Tx, tenantID string, content []byte) (bool, string, error) {
+	var probe struct {
+		ResourceType *string `json:"resourceType"`
+	}
+	if err := json.Unmarshal(content, &probe); err != nil {
+		return false, "", invalidLine("invalid JSON: %v", err)
+	}
+
+	switch {
+	case probe.ResourceType == nil:
+		var coverage models.Coverage
+		if err := json.Unmarshal(content, &coverage); err != nil {
+			return false, "", invalidLine("invalid coverage record: %v", err)
+		}
+		created, err := p.upsertCoverage(ctx, tx, tenantID, &coverage)
+		return created, coverage.MemberID, err
+	case *probe.ResourceType == "Patient":
+		member, err := parsePatient(content)
+		if err != nil {
+			return false, "", err
+		}
+		created, err := p.upsertMember(ctx, tx, tenantID, member)
+		return created, member.Identifier, err
+	case *probe.ResourceType == "Coverage":
+		coverage, err := parseCoverageResource(content)
+		if err != nil {
+			return false, "", err
+		}
+		created, err := p.upsertCoverage(ctx, tx, tenantID, coverage)
+		return created, coverage.MemberID, err
+	default:
+		return false, "", invalidLine("unsupported resourceType %q", *probe.ResourceType)
+	}
+}
+
+// upsertMember creates a member or replaces the demographics of the member
+// with the same national ID. Values are written in plaintext as on any other
+// write and the re-encryption job seals them; an update clears the encrypted
+// values it replaces. Payers may not change members covered by another payer.
+func (p *importProcessor) upsertMember(ctx context.Context, tx *sql.Tx, tenantID string, member *models.Member) (bool, error) {
+	h := p.h
+	index := h.memberIdentifierIndex(member.Identifier)
+
+	var id string
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM members
+		WHERE identifier = $1 OR identifier_index = $2
+		LIMIT 1
+		FOR UPDATE
+	`, member.Identifier, index).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	nameJSON, _ := json.Marshal(member.Name)
+	nameKeys := pq.Array(h.memberNameIndex(memberNameKeys(member.Name)))
+	contactJSON, _ := json.Marshal(member.ContactInfo)
+	addressJSON, _ := json.Marshal(member.Address)
+
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO members (
+				identifier, identifier_index, name, name_keys, birth_date, gender,
+				contact_info, address, status
+			) VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9)
+		`, member.Identifier, index, nameJSON, nameKeys, member.BirthDate, member.Gender,
+			contactJSON, addressJSON, member.Status)
+		return true, err
+	}
+
+	if tenantID != "" {
+		// Checked outside the transaction, whose row-level security hides
+		// coverage of other payers
+		var covered bool
+		err := h.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM coverage WHERE member_ref = $1 AND payer_id <> $2)
+		`, id, tenantID).Scan(&covered)
+		if err != nil {
+			return false, err
+		}
+		if covered {
+			return false, invalidLine("member %s is covered by another payer and cannot be updated", member.Identifier)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE members SET
+			identifier = $2, identifier_encrypted = NULL,
+			name = $3, name_encrypted = NULL, name_keys = $9,
+			birth_date = $4, gender = $5,
+			contact_info = $6, contact_info_encrypted = NULL,
+			address = $7, address_encrypted = NULL,
+			status = $8, updated_at = NOW()
+		WHERE id = $1
+	`, id, member.Identifier, nameJSON, member.BirthDate, member.Gender,
+		contactJSON, addressJSON, member.Status, nameKeys)
+	return false, err
+}
+
+// upsertCoverage validates a coverage record and creates or updates it.
+// Records are matched by ID, or else by member, payer and policy number.
+func (p *importProcessor) upsertCoverage(ctx context.Context, tx *sql.Tx, tenantID string, coverage *models.Coverage) (bool, error) {
+	h := p.h
+
+	if tenantID != "" {
+		if coverage.PayerID == "" {
+			coverage.PayerID = tenantID
+		}
+		if coverage.PayerID != tenantID {
+			return false, invalidLine("coverage of payer %s cannot be imported by another payer", coverage.PayerID)
+		}
+	}
+	if coverage.Status == "" {
+		coverage.Status = "active"
+	}
+
+	switch {
+	case coverage.MemberID == "" || coverage.PayerID == "":
+		return false, invalidLine("member ID and payer ID are required")
+	case coverage.Type == "":
+		return false, invalidLine("coverage type is required")
+	case coverage.EffectiveDate.IsZero():
+		return false, invalidLine("effective date is required")
+	case coverage.ExpirationDate != nil && coverage.ExpirationDate.Before(coverage.EffectiveDate):
+		return false, invalidLine("expiration date is before the effective date")
+	case !coverageStatuses[coverage.Status]:
+		return false, invalidLine("invalid coverage status %q", coverage.Status)
+	}
+	if coverage.ID != "" {
+		if _, err := uuid.Parse(coverage.ID); err != nil {
+			return false, invalidLine("invalid coverage ID %q", coverage.ID)
+		}
+	}
+
+	memberRef, err := h.memberRef(ctx, tx, coverage.MemberID)
+	if err == sql.ErrNoRows {
+		return false, invalidLine("member %s not found", coverage.MemberID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Find the record to update
+	var existingID string
+	if coverage.ID != "" {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id FROM coverage WHERE id = $1 AND payer_id = $2
+		`, coverage.ID, coverage.PayerID).Scan(&existingID)
+	} else if coverage.PolicyNumber != "" {
+		err = tx.QueryRowContext(ctx, `
+			SELECT id FROM coverage
+			WHERE member_ref = $1 AND payer_id = $2 AND policy_number = $3
+			ORDER BY created_at
+			LIMIT 1
+		`, memberRef, coverage.PayerID, coverage.PolicyNumber).Scan(&existingID)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	benefitJSON, _ := json.Marshal(coverage.BenefitDetails)
+	costSharingJSON, _ := json.Marshal(coverage.CostSharing)
+	authRulesJSON, _ := json.Marshal(coverage.PriorAuthRules)
+	limitationsJSON, _ := json.Marshal(coverage.Limitations)
+	coverage.UpdatedAt = time.Now()
+
+	if existingID != "" {
+		coverage.ID = existingID
+		_, err = tx.ExecContext(ctx, `
+			UPDATE coverage SET
+				member_ref = $2, payer_id = $3, policy_number = $4, group_number = $5,
+				status = $6, type = $7, effective_date = $8, expiration_date = $9,
+				benefit_details = $10, cost_sharing = $11, network = $12,
+				prior_auth_rules = $13, limitations = $14, updated_at = $15
+			WHERE id = $1
+		`,
+			coverage.ID,
+			memberRef,
+			coverage.PayerID,
+			coverage.PolicyNumber,
+			coverage.GroupNumber,
+			coverage.Status,
+			coverage.Type,
+			coverage.EffectiveDate,
+			coverage.ExpirationDate,
+			benefitJSON,
+			costSharingJSON,
+			coverage.Network,
+			authRulesJSON,
+			limitationsJSON,
+			coverage.UpdatedAt,
+		)
+		if err != nil {
+			return false, err
+		}
+		return false, h.publishCoverageEvent(ctx, tx, "coverage.updated", coverage.ID, coverage)
+	}
+
+	if coverage.ID == "" {
+		coverage.ID = uuid.New().String()
+	}
+	coverage.CreatedAt = coverage.UpdatedAt
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO coverage (
+			id, member_ref, payer_id, policy_number, group_number, status, type,
+			effective_date, expiration_date, benefit_details, cost_sharing,
+			network, prior_auth_rules, limitations, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	`,
+		coverage.ID,
+		memberRef,
+		coverage.PayerID,
+		coverage.PolicyNumber,
+		coverage.GroupNumber,
+		coverage.Status,
+		coverage.Type,
+		coverage.EffectiveDate,
+		coverage.ExpirationDate,
+		benefitJSON,
+		costSharingJSON,
+		coverage.Network,
+		authRulesJSON,
+		limitationsJSON,
+		coverage.CreatedAt,
+		coverage.UpdatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, h.publishCoverageEvent(ctx, tx, "coverage.created", coverage.ID, coverage)
+}
+
+// The subset of FHIR R4 read from imported resources
+
+type fhirIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+type fhirReference struct {
+	Reference  string          `json:"reference"`
+	Identifier *fhirIdentifier `json:"identifier"`
+}
+
+type fhirCodeableConcept struct {
+	Coding []struct {
+		System string `json:"system"`
+		Code   string `json:"code"`
+	} `json:"coding"`
+	Text string `json:"text"`
+}
+
+// fhirElement holds the extensions of a primitive value, e.g. _birthDate
+type fhirElement struct {
+	Extension []struct {
+		URL       string `json:"url"`
+		ValueCode string `json:"valueCode"`
+	} `json:"extension"`
+}
+
+type fhirPatient struct {
+	Identifier       []fhirIdentifier         `json:"identifier"`
+	Active           *bool                    `json:"active"`
+	Name             []map[string]interface{} `json:"name"`
+	Telecom          []fhirIdentifier         `json:"telecom"` // system and value of each contact point
+	Gender           string                   `json:"gender"`
+	BirthDate        string                   `json:"birthDate"`
+	BirthDateElement *fhirElement             `json:"_birthDate"`
+	Address          []map[string]interface{} `json:"address"`
+}
+
+type fhirCoverage struct {
+	ID           string               `json:"id"`
+	Status       string               `json:"status"`
+	Type         *fhirCodeableConcept `json:"type"`
+	SubscriberID string               `json:"subscriberId"`
+	Beneficiary  fhirReference        `json:"beneficiary"`
+	Period       *struct {
+		Start        string       `json:"start"`
+		End          string       `json:"end"`
+		StartElement *fhirElement `json:"_start"`
+		EndElement   *fhirElement `json:"_end"`
+	} `json:"period"`
+	Payor []fhirReference `json:"payor"`
+	Class []struct {
+		Type  fhirCodeableConcept `json:"type"`
+		Value string              `json:"value"`
+	} `json:"class"`
+	Network string `json:"network"`
+}
+
+var memberGenders = map[string]bool{
+	"male":    true,
+	"female":  true,
+	"other":   true,
+	"unknown": true,
+}
+
+// parsePatient maps a FHIR Patient to a member. The national ID is the
+// identifier of the NPHIES patient ID system, or else the first identifier.
+func parsePatient(content []byte) (*models.Member, error) {
+	var patient fhirPatient
+	if err := json.Unmarshal(content, &patient); err != nil {
+		return nil, invalidLine("invalid Patient: %v", err)
+	}
+
+	member := &models.Member{Status: "active"}
+	for _, identifier := range patient.Identifier {
+		if identifier.Value != "" && (member.Identifier == "" || identifier.System == patientIdentifierSystem) {
+			member.Identifier = identifier.Value
+		}
+	}
+	if member.Identifier == "" {
+		return nil, invalidLine("Patient has no identifier")
+	}
+	if len(patient.Name) == 0 {
+		return nil, invalidLine("Patient has no name")
+	}
+	member.Name = patient.Name[0]
+	if !memberGenders[patient.Gender] {
+		return nil, invalidLine("invalid Patient gender %q", patient.Gender)
+	}
+	member.Gender = patient.Gender
+
+	birthDate, err := parseFHIRDate(patient.BirthDate, patient.BirthDateElement)
+	if err != nil {
+		return nil, invalidLine("invalid Patient birthDate %q", patient.BirthDate)
+	}
+	member.BirthDate = birthDate
+
+	contactInfo := map[string]interface{}{}
+	for _, telecom := range patient.Telecom {
+		if (telecom.System == "phone" || telecom.System == "email") && telecom.Value != "" {
+			if _, ok := contactInfo[telecom.System]; !ok {
+				contactInfo[telecom.System] = telecom.Value
+			}
+		}
+	}
+	if len(contactInfo) > 0 {
+		member.ContactInfo = contactInfo
+	}
+	if len(patient.Address) > 0 {
+		member.Address = patient.Address[0]
+	}
+	if patient.Active != nil && !*patient.Active {
+		member.Status = "inactive"
+	}
+	return member, nil
+}
+
+// parseCoverageResource maps a FHIR Coverage to a coverage record. The
+// beneficiary refers to the member by national ID and the payor to the payer
+// by ID, either as a reference or as an identifier.
+func parseCoverageResource(content []byte) (*models.Coverage, error) {
+	var resource fhirCoverage
+	if err := json.Unmarshal(content, &resource); err != nil {
+		return nil, invalidLine("invalid Coverage: %v", err)
+	}
+
+	coverage := &models.Coverage{
+		ID:           resource.ID,
+		Status:       resource.Status,
+		PolicyNumber: resource.SubscriberID,
+		Network:      resource.Network,
+		MemberID:     referenceID(resource.Beneficiary, "Patient"),
+	}
+	if coverage.MemberID == "" {
+		return nil, invalidLine("Coverage has no beneficiary")
+	}
+	if len(resource.Payor) > 0 {
+		coverage.PayerID = referenceID(resource.Payor[0], "Organization")
+	}
+
+	if resource.Type != nil {
+		coverage.Type = resource.Type.Text
+		if len(resource.Type.Coding) > 0 && resource.Type.Coding[0].Code != "" {
+			coverage.Type = resource.Type.Coding[0].Code
+		}
+	}
+	for _, class := range resource.Class {
+		for _, coding := range class.Type.Coding {
+			if coding.Code == "group" {
+				coverage.GroupNumber = class.Value
+			}
+		}
+	}
+
+	if resource.Period == nil || resource.Period.Start == "" {
+		return nil, invalidLine("Coverage has no period start")
+	}
+	start, err := parseFHIRDate(resource.Period.Start, resource.Period.StartElement)
+	if err != nil {
+		return nil, invalidLine("invalid Coverage period start %q", resource.Period.Start)
+	}
+	coverage.EffectiveDate = start
+	if resource.Period.End != "" {
+		end, err := parseFHIRDate(resource.Period.End, resource.Period.EndElement)
+		if err != nil {
+			return nil, invalidLine("invalid Coverage period end %q", resource.Period.End)
+		}
+		coverage.ExpirationDate = &end
+	}
+	return coverage, nil
+}
+
+// referenceID returns the ID of a reference to a resource type, or the value
+// of its logical identifier
+func referenceID(reference fhirReference, resourceType string) string {
+	if id := strings.TrimPrefix(reference.Reference, resourceType+"/"); id != reference.Reference {
+		return id
+	}
+	if reference.Identifier != nil {
+		return reference.Identifier.Value
+	}
+	return ""
+}
+
+// parseFHIRDate parses a FHIR date or dateTime. Dates tagged with the
+// date-calendar extension as Umm al-Qura are Hijri and converted.
+func parseFHIRDate(value string, element *fhirElement) (time.Time, error) {
+	if element != nil {
+		for _, extension := range element.Extension {
+			if extension.URL == hijri.CalendarExtension && hijri.IsHijri(extension.ValueCode) {
+				date, err := hijri.Parse(value)
+				if err != nil {
+					return time.Time{}, err
+				}
+				return date.Gregorian()
+			}
+		}
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/names"
+	"github.com/lib/pq"
+)
+
+// nameIndexBatchSize is the number of members indexed per query when
+// building the name index of existing members
+const nameIndexBatchSize = 500
+
+// memberNameKeys returns the phonetic keys of a member's FHIR HumanName, so
+// that members can be searched by name whatever the spelling or script
+func memberNameKeys(name map[string]interface{}) []string {
+	var parts []string
+	for _, field := range []string{"given", "family", "text"} {
+		switch value := name[field].(type) {
+		case string:
+			parts = append(parts, value)
+		case []interface{}:
+			for _, part := range value {
+				if s, ok := part.(string); ok {
+					parts = append(parts, s)
+				}
+			}
+		}
+	}
+	return names.Keys(strings.Join(parts, " "))
+}
+
+// indexMemberNames fills the name index of members written before it
+// existed. Later writes index the name themselves, so a single pass is made.
+func (h *Handler) indexMemberNames(ctx context.Context) {
+	indexed := 0
+	after := "00000000-0000-0000-0000-000000000000"
+	for ctx.Err() == nil {
+		rows, err := h.db.QueryContext(ctx, memberSelect+`
+			WHERE name_keys IS NULL AND id > $1
+			ORDER BY id
+			LIMIT $2
+		`, after, nameIndexBatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				h.logger.WithError(err).Error("Failed to read members to index")
+			}
+			return
+		}
+
+		keys := make(map[string][]string)
+		var ids []string
+		for rows.Next() {
+			member, err := h.scanMember(ctx, rows)
+			if err != nil {
+				h.logger.WithError(err).Error("Failed to read member name to index")
+				continue
+			}
+			ids = append(ids, member.ID)
+			keys[member.ID] = h.memberNameIndex(memberNameKeys(member.Name))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			h.logger.WithError(err).Error("Failed to read members to index")
+			return
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		for _, id := range ids {
+			// Members written meanwhile were indexed by the write
+			_, err := h.db.ExecContext(ctx, `
+				UPDATE members SET name_keys = $2 WHERE id = $1 AND name_keys IS NULL
+			`, id, pq.Array(keys[id]))
+			if err != nil {
+				if ctx.Err() == nil {
+					h.logger.WithError(err).Error("Failed to index member name")
+				}
+				return
+			}
+			indexed++
+		}
+		after = ids[len(ids)-1]
+	}
+
+	if indexed > 0 {
+		h.logger.WithField("indexed", indexed).Info("Indexed member names")
+	}
+}
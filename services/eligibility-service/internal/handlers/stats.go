@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricsMiddleware records the request count and duration of every request
+// by route template
+func (h *Handler) MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		// Use the route template rather than the raw path, which carries
+		// member identifiers, to bound label cardinality
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+
+		method := c.Request.Method
+		h.metrics.RequestsTotal.WithLabelValues(method, endpoint, strconv.Itoa(c.Writer.Status())).Inc()
+		h.metrics.RequestDuration.WithLabelValues(method, endpoint).Observe(time.Since(start).Seconds())
+	}
+}
+
+// requestStatistics derives request statistics since the service started
+// from the request collectors
+func (h *Handler) requestStatistics() (models.RequestStatistics, error) {
+	var stats models.RequestStatistics
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return stats, err
+	}
+
+	var totalErrors float64
+	var durationCount uint64
+	var durationSum float64
+	buckets := make(map[float64]uint64)
+
+	for _, family := range families {
+		switch family.GetName() {
+		case "eligibility_requests_total":
+			for _, metric := range family.GetMetric() {
+				value := metric.GetCounter().GetValue()
+				stats.TotalRequests += int64(value)
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "status" && strings.HasPrefix(label.GetValue(), "5") {
+						totalErrors += value
+					}
+				}
+			}
+		case "eligibility_request_duration_seconds":
+			// Merge the per-endpoint histograms; they share bucket bounds
+			for _, metric := range family.GetMetric() {
+				histogram := metric.GetHistogram()
+				durationCount += histogram.GetSampleCount()
+				durationSum += histogram.GetSampleSum()
+				for _, bucket := range histogram.GetBucket() {
+					buckets[bucket.GetUpperBound()] += bucket.GetCumulativeCount()
+				}
+			}
+		}
+	}
+
+	if stats.TotalRequests > 0 {
+		stats.ErrorRate = round(totalErrors/float64(stats.TotalRequests), 4)
+		stats.SuccessRate = round(1-stats.ErrorRate, 4)
+		// Averaged over the uptime
+		stats.RequestsPerSecond = round(float64(stats.TotalRequests)/time.Since(h.startTime).Seconds(), 2)
+	}
+	if durationCount > 0 {
+		stats.AverageResponseTime = round(durationSum/float64(durationCount)*1000, 2)
+		stats.P95ResponseTime = round(histogramQuantile(0.95, buckets, durationCount)*1000, 2)
+		stats.P99ResponseTime = round(histogramQuantile(0.99, buckets, durationCount)*1000, 2)
+	}
+
+	return stats, nil
+}
+
+// cacheStatistics reports cache hits and misses since the service started
+func (h *Handler) cacheStatistics() (models.CacheStatistics, error) {
+	var stats models.CacheStatistics
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			switch family.GetName() {
+			case "eligibility_cache_hits_total":
+				stats.TotalHits += int64(metric.GetCounter().GetValue())
+			case "eligibility_cache_misses_total":
+				stats.TotalMisses += int64(metric.GetCounter().GetValue())
+			}
+		}
+	}
+
+	if lookups := stats.TotalHits + stats.TotalMisses; lookups > 0 {
+		stats.HitRate = round(float64(stats.TotalHits)/float64(lookups), 4)
+		stats.MissRate = round(float64(stats.TotalMisses)/float64(lookups), 4)
+	}
+	return stats, nil
+}
+
+// histogramQuantile estimates a quantile by linear interpolation within the
+// bucket that contains it, like PromQL's histogram_quantile
+func histogramQuantile(q float64, buckets map[float64]uint64, total uint64) float64 {
+	bounds := make([]float64, 0, len(buckets))
+	for bound := range buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * float64(total)
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, bound := range bounds {
+		count := float64(buckets[bound])
+		if count >= rank {
+			if math.IsInf(bound, 1) || count == lowerCount {
+				return lowerBound
+			}
+			return lowerBound + (bound-lowerBound)*(rank-lowerCount)/(count-lowerCount)
+		}
+		lowerBound, lowerCount = bound, count
+	}
+
+	// Observations above the highest bucket are reported at that bound
+	return lowerBound
+}
+
+func round(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/cache"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/serviceauth"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// tenantCache returns the cache of the request's tenant, so that cached
+// member and coverage data of one payer is never served to another
+func (h *Handler) tenantCache(ctx context.Context) *cache.Manager {
+	return h.cache.ForTenant(tenant.FromContext(ctx))
+}
+
+// scopeTx applies the request's tenant to a transaction for the Postgres
+// row-level security policies, when enabled. Queries filter by payer_id
+// either way; the policies are a second line of defence for writes.
+func (h *Handler) scopeTx(ctx context.Context, tx *sql.Tx) error {
+	tenantID := tenant.FromContext(ctx)
+	if !h.config.Tenancy.RowLevelSecurity || tenantID == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "SELECT set_config('app.tenant_id', $1, true)", tenantID)
+	return err
+}
+
+// rejectCrossTenant answers a request for data of the requested payer made
+// by a caller scoped to another tenant, and audits the attempt
+func (h *Handler) rejectCrossTenant(c *gin.Context, requested string) {
+	h.auditCrossTenant(c, requested, nil)
+
+	c.JSON(http.StatusForbidden, models.ResponseMessage{
+		Type:      "error",
+		Code:      "CROSS_TENANT_ACCESS",
+		Message:   "Access to data of another payer is not allowed",
+		RequestID: requestid.Get(c),
+	})
+}
+
+// auditCoverageOwner audits a lookup of a coverage record that was not found
+// within the caller's tenant but exists for another payer. The caller still
+// answers 404 so that record IDs of other payers are not confirmed.
+func (h *Handler) auditCoverageOwner(c *gin.Context, coverageID string) {
+	if tenant.Get(c) == "" {
+		return
+	}
+
+	var payerID string
+	err := h.db.QueryRowContext(c.Request.Context(), "SELECT payer_id FROM coverage WHERE id = $1", coverageID).Scan(&payerID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			h.logger.WithContext(c.Request.Context()).Errorf("Failed to check coverage owner: %v", err)
+		}
+		return
+	}
+
+	h.auditCrossTenant(c, payerID, map[string]interface{}{"coverage_id": coverageID})
+}
+
+// auditCrossTenant logs and audits a cross-tenant access attempt
+func (h *Handler) auditCrossTenant(c *gin.Context, requested string, data map[string]interface{}) {
+	tenantID := tenant.Get(c)
+
+	h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
+		"tenant_id":           tenantID,
+		"requested_tenant_id": requested,
+		"client_ip":           c.ClientIP(),
+	}).Warn("Rejected cross-tenant access")
+
+	event := map[string]interface{}{
+		"tenant_id":           tenantID,
+		"requested_tenant_id": requested,
+		"method":              c.Request.Method,
+		"path":                c.FullPath(),
+	}
+	for k, v := range data {
+		event[k] = v
+	}
+	h.logAuditEvent(c.Request.Context(), "tenant.cross_access_denied", serviceauth.Principal(c), c.ClientIP(), event)
+}
+
+// scopeCoverage assigns a coverage record written by a tenant-scoped caller to
+// that tenant, and rejects records of another payer. It reports whether the
+// request may proceed.
+func (h *Handler) scopeCoverage(c *gin.Context, coverage *models.Coverage) bool {
+	tenantID := tenant.Get(c)
+	if tenantID == "" {
+		return true
+	}
+	if coverage.PayerID == "" {
+		coverage.PayerID = tenantID
+	}
+	if coverage.PayerID != tenantID {
+		h.rejectCrossTenant(c, coverage.PayerID)
+		return false
+	}
+	return true
+}
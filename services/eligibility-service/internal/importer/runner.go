@@ -0,0 +1,172 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Result is the outcome of applying a batch of lines
+type Result struct {
+	Created int
+	Updated int
+	Errors  []LineError
+	Members []string // national IDs of the members whose data changed
+}
+
+// Processor applies lines to the service's data. Process runs in the batch's
+// transaction and reports bad lines in the result rather than failing;
+// Committed is called once the batch is committed.
+type Processor interface {
+	Process(ctx context.Context, tx *sql.Tx, job *Job, lines []Line) (*Result, error)
+	Committed(ctx context.Context, job *Job, result *Result)
+}
+
+// RunnerOptions tune the import runner
+type RunnerOptions struct {
+	PollInterval time.Duration
+	Lease        time.Duration // how long a job stays claimed without a committed batch
+	BatchSize    int           // lines applied per transaction
+	MaxAttempts  int
+	Retention    time.Duration // how long finished jobs and their errors are kept
+}
+
+// Runner claims queued imports and applies them. Every replica may run one;
+// jobs are leased so each is applied by one runner at a time.
+type Runner struct {
+	db        *sql.DB
+	store     *Store
+	processor Processor
+	logger    *logrus.Logger
+	options   RunnerOptions
+}
+
+// NewRunner creates a new import runner
+func NewRunner(db *sql.DB, store *Store, processor Processor, logger *logrus.Logger, options RunnerOptions) *Runner {
+	return &Runner{
+		db:        db,
+		store:     store,
+		processor: processor,
+		logger:    logger,
+		options:   options,
+	}
+}
+
+// Run applies queued imports until the context is cancelled
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+
+	purgeTicker := time.NewTicker(time.Hour)
+	defer purgeTicker.Stop()
+
+	r.logger.Info("Starting import runner")
+
+	for {
+		job, err := r.store.Claim(ctx, r.options.Lease)
+		if err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Error("Failed to claim import job")
+		}
+		if job != nil {
+			r.run(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-purgeTicker.C:
+			r.purge(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// run applies the batches of a claimed job from its cursor on
+func (r *Runner) run(ctx context.Context, job *Job) {
+	logger := r.logger.WithFields(logrus.Fields{
+		"job_id":   job.ID,
+		"attempts": job.Attempts,
+	})
+	if job.CursorLine > 0 {
+		logger = logger.WithField("line", job.CursorLine)
+		logger.Info("Resuming import job")
+	} else {
+		logger.Info("Running import job")
+	}
+
+	for {
+		done, err := r.batch(ctx, job)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Shutting down; the job resumes once its lease expires
+				return
+			}
+			failed, failErr := r.store.Fail(ctx, job, err.Error(), r.options.MaxAttempts)
+			switch {
+			case failErr != nil:
+				logger.WithError(failErr).Error("Failed to record import job failure")
+			case failed:
+				logger.WithError(err).Error("Import job failed")
+			default:
+				logger.WithError(err).Warn("Import batch failed; the job will resume")
+			}
+			return
+		}
+		if done {
+			break
+		}
+	}
+
+	if err := r.store.Complete(ctx, job.ID); err != nil {
+		logger.WithError(err).Error("Failed to complete import job")
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"created": job.CreatedCount,
+		"updated": job.UpdatedCount,
+		"errors":  job.ErrorCount,
+	}).Info("Import job completed")
+}
+
+// batch applies the next batch of lines and reports whether none were left
+func (r *Runner) batch(ctx context.Context, job *Job) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	lines, err := r.store.Lines(ctx, tx, job, r.options.BatchSize)
+	if err != nil || len(lines) == 0 {
+		return err == nil, err
+	}
+
+	result, err := r.processor.Process(ctx, tx, job, lines)
+	if err != nil {
+		return false, err
+	}
+	if err := r.store.Advance(ctx, tx, job, lines[len(lines)-1].Number, result, r.options.Lease); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	r.processor.Committed(ctx, job, result)
+	return false, nil
+}
+
+// purge removes finished jobs past their retention
+func (r *Runner) purge(ctx context.Context) {
+	purged, err := r.store.Purge(ctx, r.options.Retention)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to purge import jobs")
+		return
+	}
+	if purged > 0 {
+		r.logger.WithField("purged", purged).Info("Purged finished import jobs")
+	}
+}
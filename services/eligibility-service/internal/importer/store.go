@@ -0,0 +1,385 @@
+// Package importer runs bulk NDJSON imports. An upload is staged line by line
+// in the database; a runner then applies it in batches, committing each batch
+// together with the job's cursor so that an import resumes after the last
+// committed batch when its runner stops.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrJobNotFound is returned for unknown import jobs
+	ErrJobNotFound = errors.New("import job not found")
+	// ErrEmptyUpload is returned for uploads without any lines
+	ErrEmptyUpload = errors.New("upload contains no lines")
+	// ErrTooManyLines is returned for uploads over the line limit
+	ErrTooManyLines = errors.New("upload has too many lines")
+	// ErrLineTooLong is returned for uploads with a line over the size limit
+	ErrLineTooLong = errors.New("upload has a line that is too long")
+)
+
+// Job is an import of an uploaded NDJSON file
+type Job struct {
+	ID           string     `json:"id"`
+	TenantID     string     `json:"tenant_id,omitempty"`
+	Status       string     `json:"status"`
+	TotalLines   int        `json:"total_lines"`
+	CursorLine   int        `json:"processed_lines"` // lines up to here are applied
+	CreatedCount int        `json:"created"`
+	UpdatedCount int        `json:"updated"`
+	ErrorCount   int        `json:"errors"`
+	Attempts     int        `json:"attempts"`
+	LastError    string     `json:"last_error,omitempty"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Line is an uploaded line. Numbers count from 1 and include blank lines, so
+// they match the line numbers of the uploaded file.
+type Line struct {
+	Number  int
+	Content []byte
+}
+
+// LineError is a line that could not be imported
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Limits bound the size of an upload
+type Limits struct {
+	MaxLines     int
+	MaxLineBytes int
+}
+
+// Store persists import jobs and their staged lines
+type Store struct {
+	db     *sql.DB
+	logger *logrus.Logger
+}
+
+// NewStore creates a new import job store
+func NewStore(db *sql.DB, logger *logrus.Logger) *Store {
+	return &Store{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Create stages the non-blank lines of an upload and queues its import
+func (s *Store) Create(ctx context.Context, tenantID string, upload io.Reader, limits Limits) (*Job, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO import_jobs (tenant_id) VALUES ($1) RETURNING id
+	`, sql.NullString{String: tenantID, Valid: tenantID != ""}).Scan(&id); err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("import_lines", "job_id", "line", "content"))
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(upload)
+	scanner.Buffer(make([]byte, 0, 4096), limits.MaxLineBytes)
+	number, staged := 0, 0
+	for scanner.Scan() {
+		number++
+		content := bytes.TrimSpace(scanner.Bytes())
+		if len(content) == 0 {
+			continue
+		}
+		staged++
+		if staged > limits.MaxLines {
+			stmt.Close()
+			return nil, ErrTooManyLines
+		}
+		// Postgres text holds neither invalid UTF-8 nor NUL; such lines then
+		// fail to parse as JSON and are reported like any other bad line
+		text := strings.ReplaceAll(strings.ToValidUTF8(string(content), "\uFFFD"), "\x00", "\uFFFD")
+		if _, err := stmt.ExecContext(ctx, id, number, text); err != nil {
+			stmt.Close()
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		stmt.Close()
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, ErrLineTooLong
+		}
+		return nil, err
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return nil, err
+	}
+	if err := stmt.Close(); err != nil {
+		return nil, err
+	}
+	if staged == 0 {
+		return nil, ErrEmptyUpload
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE import_jobs SET total_lines = $2 WHERE id = $1`, id, number); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, id, tenantID)
+}
+
+// Get returns an import job. Callers scoped to a tenant only find the jobs of
+// that tenant.
+func (s *Store) Get(ctx context.Context, id, tenantID string) (*Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `
+		SELECT `+jobColumns+` FROM import_jobs
+		WHERE id::text = $1 AND ($2 = '' OR tenant_id = $2)
+	`, id, tenantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// Errors returns a page of the line errors of a job, in line order
+func (s *Store) Errors(ctx context.Context, id string, limit, offset int) ([]LineError, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT line, message FROM import_errors
+		WHERE job_id = $1
+		ORDER BY line
+		LIMIT $2 OFFSET $3
+	`, id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lineErrors := []LineError{}
+	for rows.Next() {
+		var lineError LineError
+		if err := rows.Scan(&lineError.Line, &lineError.Message); err != nil {
+			return nil, err
+		}
+		lineErrors = append(lineErrors, lineError)
+	}
+	return lineErrors, rows.Err()
+}
+
+// Claim leases the oldest queued job, or a running job whose lease expired
+// because its runner stopped. It returns nil when no job is due.
+func (s *Store) Claim(ctx context.Context, lease time.Duration) (*Job, error) {
+	job, err := scanJob(s.db.QueryRowContext(ctx, `
+		UPDATE import_jobs
+		SET status = $1,
+		    attempts = attempts + 1,
+		    lease_expires_at = $2,
+		    started_at = COALESCE(started_at, NOW())
+		WHERE id = (
+			SELECT id FROM import_jobs
+			WHERE status = $3 OR (status = $1 AND lease_expires_at < NOW())
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		StatusRunning, time.Now().Add(lease), StatusQueued))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Lines returns the next lines of a job after its cursor
+func (s *Store) Lines(ctx context.Context, tx *sql.Tx, job *Job, limit int) ([]Line, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT line, content FROM import_lines
+		WHERE job_id = $1 AND line > $2
+		ORDER BY line
+		LIMIT $3
+	`, job.ID, job.CursorLine, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []Line
+	for rows.Next() {
+		var line Line
+		if err := rows.Scan(&line.Number, &line.Content); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// Advance records the outcome of a batch in the batch's transaction and moves
+// the job's cursor past it. It fails when the job's lease was lost to another
+// runner, which rolls the batch back.
+func (s *Store) Advance(ctx context.Context, tx *sql.Tx, job *Job, cursor int, result *Result, lease time.Duration) error {
+	for _, lineError := range result.Errors {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO import_errors (job_id, line, message) VALUES ($1, $2, $3)
+			ON CONFLICT (job_id, line) DO UPDATE SET message = EXCLUDED.message
+		`, job.ID, lineError.Line, lineError.Message); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE import_jobs
+		SET cursor_line = $2,
+		    created_count = created_count + $3,
+		    updated_count = updated_count + $4,
+		    error_count = error_count + $5,
+		    lease_expires_at = $6
+		WHERE id = $1 AND status = $7 AND cursor_line = $8 AND attempts = $9
+	`, job.ID, cursor, result.Created, result.Updated, len(result.Errors),
+		time.Now().Add(lease), StatusRunning, job.CursorLine, job.Attempts)
+	if err != nil {
+		return err
+	}
+	if advanced, err := res.RowsAffected(); err != nil {
+		return err
+	} else if advanced == 0 {
+		return errors.New("import job lease lost")
+	}
+
+	job.CursorLine = cursor
+	job.CreatedCount += result.Created
+	job.UpdatedCount += result.Updated
+	job.ErrorCount += len(result.Errors)
+	return nil
+}
+
+// Complete marks a job done and drops its staged lines
+func (s *Store) Complete(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE import_jobs
+		SET status = $2, cursor_line = total_lines, completed_at = NOW(), lease_expires_at = NULL
+		WHERE id = $1
+	`, id, StatusCompleted); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM import_lines WHERE job_id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Fail records why a batch of a job could not be applied. A job that has
+// attempts left is queued again and resumes at its cursor; otherwise it
+// fails and its staged lines are dropped. Nothing changes once another
+// worker has claimed the job.
+func (s *Store) Fail(ctx context.Context, job *Job, message string, maxAttempts int) (bool, error) {
+	var status string
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE import_jobs
+		SET status = CASE WHEN attempts >= $3 THEN $4 ELSE $5 END,
+		    last_error = $2,
+		    lease_expires_at = NULL,
+		    completed_at = CASE WHEN attempts >= $3 THEN NOW() END
+		WHERE id = $1 AND status = $6 AND attempts = $7
+		RETURNING status
+	`, job.ID, message, maxAttempts, StatusFailed, StatusQueued, StatusRunning, job.Attempts).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if status != StatusFailed {
+		return false, nil
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM import_lines WHERE job_id = $1`, job.ID)
+	return true, err
+}
+
+// Purge deletes finished jobs and their errors after the retention period
+func (s *Store) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM import_jobs WHERE completed_at < $1
+	`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// jobColumns are the columns read by scanJob, in order
+const jobColumns = `id, tenant_id, status, total_lines, cursor_line, created_count, updated_count,
+	error_count, attempts, last_error, started_at, completed_at, created_at, updated_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var job Job
+	var tenantID, lastError sql.NullString
+	var startedAt, completedAt sql.NullTime
+
+	if err := row.Scan(
+		&job.ID,
+		&tenantID,
+		&job.Status,
+		&job.TotalLines,
+		&job.CursorLine,
+		&job.CreatedCount,
+		&job.UpdatedCount,
+		&job.ErrorCount,
+		&job.Attempts,
+		&lastError,
+		&startedAt,
+		&completedAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	job.TenantID = tenantID.String
+	job.LastError = lastError.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return &job, nil
+}
@@ -13,6 +13,8 @@ type Coverage struct {
 	Type             string                 `json:"type" db:"type"`     // medical, dental, vision, etc.
 	EffectiveDate    time.Time              `json:"effective_date" db:"effective_date"`
 	ExpirationDate   *time.Time             `json:"expiration_date" db:"expiration_date"`
+	EffectiveDateHijri  string              `json:"effective_date_hijri,omitempty" db:"-"`  // Hijri dates, on dual_calendar responses
+	ExpirationDateHijri string              `json:"expiration_date_hijri,omitempty" db:"-"`
 	BenefitDetails   map[string]interface{} `json:"benefit_details" db:"benefit_details"`
 	CostSharing      map[string]interface{} `json:"cost_sharing" db:"cost_sharing"`
 	Network          string                 `json:"network" db:"network"`
@@ -71,6 +73,8 @@ type EligibilityResponse struct {
 	CoverageStatus string                 `json:"coverage_status"`
 	EffectiveDate  string                 `json:"effective_date"`
 	ExpirationDate string                 `json:"expiration_date,omitempty"`
+	EffectiveDateHijri  string            `json:"effective_date_hijri,omitempty"` // Hijri dates, on dual_calendar responses
+	ExpirationDateHijri string            `json:"expiration_date_hijri,omitempty"`
 	Benefits       []BenefitInformation   `json:"benefits"`
 	Limitations    []CoverageLimitation   `json:"limitations"`
 	Messages       []ResponseMessage      `json:"messages"`
@@ -106,10 +110,11 @@ type CoverageLimitation struct {
 
 // ResponseMessage represents informational messages
 type ResponseMessage struct {
-	Type    string `json:"type"`    // information, warning, error
-	Code    string `json:"code"`    
-	Message string `json:"message"` 
-	Details string `json:"details,omitempty"`
+	Type      string `json:"type"` // information, warning, error
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"` // set on error responses
 }
 
 // CoverageVerificationRequest represents a coverage verification request
@@ -126,6 +131,7 @@ type CoverageVerificationResponse struct {
 	MemberID        string                    `json:"member_id"`
 	VerificationID  string                    `json:"verification_id"`
 	ServiceDate     string                    `json:"service_date"`
+	ServiceDateHijri string                   `json:"service_date_hijri,omitempty"` // on dual_calendar responses
 	Services        []ServiceVerification     `json:"services"`
 	OverallStatus   string                    `json:"overall_status"` // covered, not_covered, partial
 	AuthRequired    bool                      `json:"auth_required"`
@@ -169,22 +175,37 @@ type RequestStatistics struct {
 
 // CacheStatistics represents cache statistics
 type CacheStatistics struct {
-	HitRate        float64 `json:"hit_rate"`
-	MissRate       float64 `json:"miss_rate"`
-	TotalHits      int64   `json:"total_hits"`
-	TotalMisses    int64   `json:"total_misses"`
-	CachedEntries  int64   `json:"cached_entries"`
-	CacheSize      string  `json:"cache_size"`
-	EvictionCount  int64   `json:"eviction_count"`
+	HitRate     float64 `json:"hit_rate"`
+	MissRate    float64 `json:"miss_rate"`
+	TotalHits   int64   `json:"total_hits"`
+	TotalMisses int64   `json:"total_misses"`
 }
 
-// DatabaseStatistics represents database statistics
+// CacheInvalidationRequest selects the cache keys to invalidate. An empty
+// request invalidates every key of the service.
+type CacheInvalidationRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+	MemberID  string `json:"member_id,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	TenantID  string `json:"tenant_id,omitempty"` // restrict to one tenant's keys
+	DryRun    bool   `json:"dry_run"`
+}
+
+// CacheInvalidationResponse reports the keys an invalidation matched
+type CacheInvalidationResponse struct {
+	Patterns    []string `json:"patterns"`
+	DryRun      bool     `json:"dry_run"`
+	MatchedKeys int64    `json:"matched_keys"`
+	DeletedKeys int64    `json:"deleted_keys"`
+	Keys        []string `json:"keys,omitempty"`
+	Truncated   bool     `json:"truncated,omitempty"`
+}
+
+// DatabaseStatistics represents database connection pool statistics
 type DatabaseStatistics struct {
-	ActiveConnections int `json:"active_connections"`
-	IdleConnections   int `json:"idle_connections"`
-	TotalQueries      int64 `json:"total_queries"`
-	SlowQueries       int64 `json:"slow_queries"`
-	AverageQueryTime  float64 `json:"average_query_time_ms"`
+	ActiveConnections int   `json:"active_connections"`
+	IdleConnections   int   `json:"idle_connections"`
+	WaitCount         int64 `json:"wait_count"` // connections waited for since start
 }
 
 // DependencyStatus represents the status of external dependencies
@@ -202,4 +223,15 @@ type CacheEntry struct {
 	ExpiresAt  time.Time              `json:"expires_at"`
 	AccessCount int                   `json:"access_count"`
 	LastAccess time.Time              `json:"last_access"`
-}
\ No newline at end of file
+}
+// MemberExportPage is a page of members for bulk export
+type MemberExportPage struct {
+	Members []Member `json:"members"`
+	Next    string   `json:"next,omitempty"` // cursor of the next page; empty on the last page
+}
+
+// CoverageExportPage is a page of coverage records for bulk export
+type CoverageExportPage struct {
+	Coverage []Coverage `json:"coverage"`
+	Next     string     `json:"next,omitempty"` // cursor of the next page; empty on the last page
+}
@@ -0,0 +1,126 @@
+package names
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripArticle(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"العتيبي", "عتيبي"},
+		{"alotaibi", "otaibi"},
+		{"alqahtani", "qahtani"},
+		{"elshamy", "shamy"},
+		{"arrashid", "rashid"},
+		{"azzahrani", "zahrani"},
+		{"abdulrahman", "abdrahman"},
+		{"عبدالرحمن", "عبدرحمن"},
+		// Too short to carry the article
+		{"ali", "ali"},
+		{"alaa", "alaa"},
+		{"alyaa", "alyaa"},
+		// Not Arabic names
+		{"allen", "allen"},
+		{"alexander", "alexander"},
+		{"alfred", "alfred"},
+		{"alice", "alice"},
+		// Doubled letters that are not sun letters
+		{"abbas", "abbas"},
+		{"ammar", "ammar"},
+	}
+	for _, tt := range tests {
+		if got := stripArticle(tt.token); got != tt.want {
+			t.Errorf("stripArticle(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestKeyMatchesSpellings(t *testing.T) {
+	tests := []struct {
+		name      string
+		spellings []string
+	}{
+		{"muhammad", []string{"محمد", "Mohammed", "Muhammad", "Mohamad"}},
+		{"otaibi", []string{"العتيبي", "Al-Otaibi", "Alotaibi", "Otaibi"}},
+		{"abdulrahman", []string{"عبد الرحمن", "Abdulrahman", "Abd al-Rahman", "Abdelrahman"}},
+		{"uthman", []string{"عثمان", "Othman", "Osman", "Uthman"}},
+		{"dhikra", []string{"ذكرى", "Dhikra", "Zikra"}},
+		{"ramadan", []string{"رمضان", "Ramadan", "Ramadhan"}},
+		{"nadhim", []string{"ناظم", "Nadhim", "Nazim"}},
+		{"haitham", []string{"هيثم", "Haitham", "Haytham"}},
+	}
+	for _, tt := range tests {
+		want := Keys(tt.spellings[0])
+		if len(want) == 0 {
+			t.Errorf("%s: Keys(%q) is empty", tt.name, tt.spellings[0])
+			continue
+		}
+		for _, spelling := range tt.spellings[1:] {
+			if got := Keys(spelling); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: Keys(%q) = %v, want %v", tt.name, spelling, got, want)
+			}
+		}
+	}
+}
+
+func TestKeyDistinguishesNames(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"alexander", "exander"},
+		{"saad", "fahad"},
+		{"khalid", "hamid"},
+	}
+	for _, tt := range tests {
+		if a, b := Key(stripArticle(tt.a)), Key(stripArticle(tt.b)); a == b {
+			t.Errorf("%q and %q share the key %q", tt.a, tt.b, a)
+		}
+	}
+}
+
+func TestKeyTooShort(t *testing.T) {
+	for _, token := range []string{"aisha", "عائشة", "ali", "aly", "alaa", "علاء", "ella", "علي", "a", ""} {
+		if got := Key(Normalize(token)); got != "" {
+			t.Errorf("Key(%q) = %q, want no key", token, got)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"Ali bin Saad Al-Ghamdi", []string{"SD", "GMD"}},
+		{"علي بن سعد الغامدي", []string{"SD", "GMD"}},
+		{"Abu Bakr", []string{"BKR"}},
+		{"Ali", nil},
+		{"", nil},
+		{"---", nil},
+	}
+	for _, tt := range tests {
+		if got := Keys(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Keys(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"  Muḥammad   Al-Otaibi ", "muhammad al otaibi"},
+		{"فَاطِمَة", "فاطمه"},
+		{"أحمد", "احمد"},
+		{"O'Neill", "oneill"},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.name); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/tracing"
+	"github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// relayLockID is the advisory lock key that ensures only one relay instance
+// publishes at a time, which keeps per-aggregate ordering intact when the
+// service is scaled out
+const relayLockID = 7262011
+
+// Publisher writes messages to Kafka. Messages carry their destination topic.
+type Publisher interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// RelayOptions controls how the relay drains the outbox
+type RelayOptions struct {
+	PollInterval time.Duration // delay between scans when the outbox is idle
+	BatchSize    int           // maximum events relayed per scan
+	MaxAttempts  int           // attempts before an event is marked failed
+	MaxBackoff   time.Duration // upper bound for the retry delay
+	Retention    time.Duration // how long published events are kept before purge
+}
+
+// Relay publishes outbox events to Kafka
+type Relay struct {
+	db        *sql.DB
+	publisher Publisher
+	logger    *logrus.Logger
+	options   RelayOptions
+}
+
+// NewRelay creates a new outbox relay
+func NewRelay(db *sql.DB, publisher Publisher, logger *logrus.Logger, options RelayOptions) *Relay {
+	return &Relay{
+		db:        db,
+		publisher: publisher,
+		logger:    logger,
+		options:   options,
+	}
+}
+
+// Run relays events until the context is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+
+	purgeTicker := time.NewTicker(time.Hour)
+	defer purgeTicker.Stop()
+
+	r.logger.Info("Starting outbox relay")
+
+	for {
+		relayed, err := r.RelayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.WithError(err).Error("Outbox relay failed")
+		}
+
+		// Keep draining without waiting while the outbox has a backlog
+		if err == nil && relayed == r.options.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-purgeTicker.C:
+			r.purge(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayBatch publishes the next batch of due events and returns how many were
+// picked up. Events are delivered at least once; consumers deduplicate by
+// event ID.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", relayLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		// Another instance is relaying
+		return 0, nil
+	}
+
+	// An event is only due if no earlier event for the same aggregate is still
+	// waiting for a retry, otherwise it would overtake it
+	query := `
+		SELECT o.id, o.topic, o.aggregate_type, o.aggregate_id, o.event_type, o.payload, o.trace_context, o.created_at
+		FROM outbox_events o
+		WHERE o.status = 'pending'
+		  AND o.next_attempt_at <= NOW()
+		  AND NOT EXISTS (
+			SELECT 1 FROM outbox_events p
+			WHERE p.topic = o.topic
+			  AND p.aggregate_id = o.aggregate_id
+			  AND p.status = 'pending'
+			  AND p.sequence < o.sequence
+			  AND p.next_attempt_at > NOW()
+		  )
+		ORDER BY o.sequence
+		LIMIT $1
+	`
+
+	rows, err := tx.QueryContext(ctx, query, r.options.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var topics []string
+	messagesByTopic := make(map[string][]kafka.Message)
+	idsByTopic := make(map[string][]string)
+	linksByTopic := make(map[string][]trace.Link)
+	count := 0
+
+	for rows.Next() {
+		var event Event
+		var traceContext []byte
+		var createdAt time.Time
+		if err := rows.Scan(&event.ID, &event.Topic, &event.AggregateType, &event.AggregateID, &event.EventType, &event.Payload, &traceContext, &createdAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		if _, seen := messagesByTopic[event.Topic]; !seen {
+			topics = append(topics, event.Topic)
+		}
+		msg := kafka.Message{
+			Topic: event.Topic,
+			Key:   []byte(event.AggregateID),
+			Value: event.Payload,
+			Time:  createdAt,
+			Headers: []kafka.Header{
+				{Key: HeaderEventID, Value: []byte(event.ID)},
+				{Key: HeaderEventType, Value: []byte(event.EventType)},
+				{Key: HeaderAggregateType, Value: []byte(event.AggregateType)},
+			},
+		}
+
+		// Consumers continue the trace and see the request ID of the request
+		// that wrote the event; the relay's publish span links to it
+		var carrier map[string]string
+		if len(traceContext) > 0 && json.Unmarshal(traceContext, &carrier) == nil {
+			origin := tracing.ExtractMap(ctx, carrier)
+			tracing.InjectKafka(origin, &msg)
+			requestid.InjectKafka(requestid.ExtractMap(origin, carrier), &msg)
+			if spanContext := trace.SpanContextFromContext(origin); spanContext.IsValid() {
+				linksByTopic[event.Topic] = append(linksByTopic[event.Topic], trace.Link{SpanContext: spanContext})
+			}
+		}
+
+		messagesByTopic[event.Topic] = append(messagesByTopic[event.Topic], msg)
+		idsByTopic[event.Topic] = append(idsByTopic[event.Topic], event.ID)
+		count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, topic := range topics {
+		ids := idsByTopic[topic]
+
+		// A failed write retries the whole topic batch so that no event is
+		// published ahead of an earlier one for the same aggregate
+		publishCtx, span := tracing.StartProducerSpan(ctx, topic, len(ids), linksByTopic[topic]...)
+		err := r.publisher.WriteMessages(publishCtx, messagesByTopic[topic]...)
+		tracing.End(span, err)
+		if err != nil {
+			r.logger.WithError(err).WithFields(logrus.Fields{
+				"topic":       topic,
+				"event_count": len(ids),
+			}).Warn("Failed to relay outbox events, will retry")
+
+			if err := r.markRetry(ctx, tx, ids, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if err := r.markPublished(ctx, tx, ids); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *Relay) markPublished(ctx context.Context, tx *sql.Tx, ids []string) error {
+	query := `
+		UPDATE outbox_events
+		SET status = 'published', published_at = NOW(), last_error = NULL
+		WHERE id = ANY($1)
+	`
+	_, err := tx.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
+
+func (r *Relay) markRetry(ctx context.Context, tx *sql.Tx, ids []string, publishErr error) error {
+	query := `
+		UPDATE outbox_events
+		SET attempts = attempts + 1,
+		    last_error = $2,
+		    status = CASE WHEN attempts + 1 >= $3 THEN 'failed' ELSE 'pending' END,
+		    next_attempt_at = NOW() + make_interval(secs => LEAST(POWER(2, attempts), $4))
+		WHERE id = ANY($1)
+		RETURNING id, status
+	`
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(ids), publishErr.Error(), r.options.MaxAttempts, r.options.MaxBackoff.Seconds())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return err
+		}
+		if status == StatusFailed {
+			r.logger.WithField("event_id", id).Error("Outbox event exceeded maximum relay attempts")
+		}
+	}
+	return rows.Err()
+}
+
+// purge removes published events older than the retention period
+func (r *Relay) purge(ctx context.Context) {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM outbox_events WHERE status = 'published' AND published_at < $1`,
+		time.Now().Add(-r.options.Retention),
+	)
+	if err != nil {
+		r.logger.WithError(err).Error("Failed to purge outbox events")
+		return
+	}
+
+	if purged, _ := result.RowsAffected(); purged > 0 {
+		r.logger.WithField("purged", purged).Info("Outbox events purged")
+	}
+}
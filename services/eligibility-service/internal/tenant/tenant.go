@@ -0,0 +1,97 @@
+// Package tenant carries the payer a request is scoped to. The gateway
+// resolves the tenant from the caller's credentials and forwards it in the
+// X-Tenant-ID header; coverage and member data are restricted to that payer.
+package tenant
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Fadil369/NPHIES/services/eligibility-service/internal/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Header carries the tenant on HTTP requests from the gateway
+	Header = "X-Tenant-ID"
+	// Key names the tenant in gin contexts and log fields
+	Key = "tenant_id"
+
+	maxLength = 64
+)
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying the tenant
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant carried by ctx, or an empty string for
+// requests that are not scoped to a tenant
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Get returns the tenant of the current request
+func Get(c *gin.Context) string {
+	return c.GetString(Key)
+}
+
+// Valid accepts tenant IDs of letters, digits, '-', '_' and '.', so that they
+// are safe in log lines, SQL settings and cache keys
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware stores the tenant of the X-Tenant-ID header on the gin and
+// request contexts. Requests without the header are not scoped to a tenant
+// and are rejected when required is set. The header is trusted, so routes
+// using the middleware must authenticate the gateway first.
+func Middleware(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if id == "" {
+			if required {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error":      "tenant_required",
+					"message":    "The " + Header + " header is required",
+					"request_id": requestid.Get(c),
+				})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if !Valid(id) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":      "invalid_tenant",
+				"message":    "Tenant IDs may only contain letters, digits, '-', '_' and '.'",
+				"request_id": requestid.Get(c),
+			})
+			return
+		}
+
+		c.Set(Key, id)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+
+		c.Next()
+	}
+}
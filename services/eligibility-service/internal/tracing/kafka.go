@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier adapts Kafka message headers to a propagation.TextMapCarrier
+type headerCarrier struct {
+	msg *kafka.Message
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, header := range c.msg.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, header := range c.msg.Headers {
+		if header.Key == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, header := range c.msg.Headers {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
+
+// InjectKafka writes the trace context of ctx into the message headers unless
+// the message already carries one, e.g. from the request that created an
+// outbox event
+func InjectKafka(ctx context.Context, msg *kafka.Message) {
+	carrier := headerCarrier{msg: msg}
+	if carrier.Get("traceparent") != "" {
+		return
+	}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+}
+
+// ExtractKafka returns ctx with the trace context carried by the message
+func ExtractKafka(ctx context.Context, msg kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{msg: &msg})
+}
+
+// InjectMap writes the trace context of ctx into a string map, for storing it
+// alongside work that is processed later
+func InjectMap(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// ExtractMap returns ctx with the trace context stored by InjectMap
+func ExtractMap(ctx context.Context, carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// StartProducerSpan starts a span for publishing messages to a topic
+func StartProducerSpan(ctx context.Context, topic string, messages int, links ...trace.Link) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingBatchMessageCount(messages),
+		),
+	)
+}
+
+// StartConsumerSpan starts a span for processing a consumed message, as a
+// child of the trace context in its headers
+func StartConsumerSpan(ctx context.Context, msg kafka.Message, groupID string) (context.Context, trace.Span) {
+	ctx = ExtractKafka(ctx, msg)
+	return Tracer().Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationDeliver,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingKafkaConsumerGroup(groupID),
+			semconv.MessagingKafkaDestinationPartition(msg.Partition),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			attribute.Int("messaging.message.body.size", len(msg.Value)),
+		),
+	)
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/XSAM/otelsql"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenDB opens a Postgres connection pool whose queries are traced. Statements
+// are recorded without their arguments. Only queries that are part of a trace
+// get spans, so background polling does not start a new trace every tick.
+func OpenDB(dataSourceName string) (*sql.DB, error) {
+	return otelsql.Open("postgres", dataSourceName,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
+}
+
+// InstrumentRedis adds client spans to commands sent through client as part
+// of a trace. Only command names are recorded, never keys or values.
+func InstrumentRedis(client *redis.Client) {
+	client.AddHook(redisHook{})
+}
+
+type redisHook struct{}
+
+func (redisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	ctx, _ = Tracer().Start(ctx, "redis "+cmd.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperation(cmd.Name()),
+		),
+	)
+	return ctx, nil
+}
+
+func (redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	End(trace.SpanFromContext(ctx), redisError(cmd.Err()))
+	return nil
+}
+
+func (redisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil
+	}
+	ctx, _ = Tracer().Start(ctx, "redis pipeline",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			attribute.Int("db.redis.num_cmd", len(cmds)),
+		),
+	)
+	return ctx, nil
+}
+
+func (redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = redisError(cmd.Err()); err != nil {
+			break
+		}
+	}
+	End(trace.SpanFromContext(ctx), err)
+	return nil
+}
+
+// redisError ignores redis.Nil, which is a cache miss rather than a failure
+func redisError(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
@@ -80,7 +80,7 @@ func (h *Handler) LookupCode(c *gin.Context) {
 	system := c.Param("system")
 	code := c.Param("code")
 
-	h.logger.WithFields(logrus.Fields{
+	h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 		"system": system,
 		"code":   code,
 	}).Info("Looking up code")
@@ -119,7 +119,7 @@ func (h *Handler) ValidateCode(c *gin.Context) {
 		return
 	}
 
-	h.logger.WithFields(logrus.Fields{
+	h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 		"system": system,
 		"request": request,
 	}).Info("Validating code")
@@ -147,7 +147,7 @@ func (h *Handler) SearchCodes(c *gin.Context) {
 	system := c.Param("system")
 	query := c.Query("q")
 
-	h.logger.WithFields(logrus.Fields{
+	h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 		"system": system,
 		"query":  query,
 	}).Info("Searching codes")
@@ -184,7 +184,7 @@ func (h *Handler) MapCodes(c *gin.Context) {
 		return
 	}
 
-	h.logger.WithField("request", request).Info("Mapping codes")
+	h.logger.WithContext(c.Request.Context()).WithField("request", request).Info("Mapping codes")
 
 	// Mock mapping response
 	response := gin.H{
@@ -217,7 +217,7 @@ func (h *Handler) MapCodes(c *gin.Context) {
 func (h *Handler) GetConcept(c *gin.Context) {
 	concept := c.Param("concept")
 
-	h.logger.WithField("concept", concept).Info("Getting concept details")
+	h.logger.WithContext(c.Request.Context()).WithField("concept", concept).Info("Getting concept details")
 
 	// Mock concept response
 	response := gin.H{
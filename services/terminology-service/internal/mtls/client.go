@@ -0,0 +1,42 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/Fadil369/NPHIES/services/terminology-service/internal/requestid"
+	"github.com/gin-gonic/gin"
+)
+
+// exemptPaths are served without a client certificate so that probes and
+// scrapers do not need one
+var exemptPaths = map[string]bool{
+	"/health":  true,
+	"/ready":   true,
+	"/metrics": true,
+}
+
+// PeerCertificate returns the verified client certificate of a request, or
+// nil if the client did not present one
+func PeerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// RequireClientCert rejects requests without a verified client certificate.
+// Health, readiness and metrics endpoints are exempt.
+func RequireClientCert() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if exemptPaths[c.Request.URL.Path] || PeerCertificate(c.Request) != nil {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error":      "client_certificate_required",
+			"message":    "A valid client certificate is required",
+			"request_id": requestid.Get(c),
+		})
+	}
+}
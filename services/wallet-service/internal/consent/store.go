@@ -0,0 +1,89 @@
+// Package consent stores the consents members grant, and their revocations,
+// in PostgreSQL so that every replica decides disclosures from the same
+// records and revocations survive restarts.
+package consent
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Fadil369/NPHIES/services/wallet-service/internal/models"
+)
+
+// statusRevoked is the status of revoked consents
+const statusRevoked = "REVOKED"
+
+// Store reads and writes the consents table
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore creates a consent store
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create records a granted consent
+func (s *Store) Create(ctx context.Context, c models.Consent) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO consents (id, member_id, type, scope, status, granted_at, expires_at,
+		                      purpose, purposes, data_types, recipients, blockchain_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		c.ID, c.MemberID, c.Type, c.Scope, c.Status, c.GrantedAt, c.ExpiresAt,
+		c.Purpose, pq.Array(c.Purposes), pq.Array(c.DataTypes), pq.Array(c.Recipients), c.BlockchainHash)
+	return err
+}
+
+// ListByMember returns every consent of a member, oldest first
+func (s *Store) ListByMember(ctx context.Context, memberID string) ([]models.Consent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, member_id, type, scope, status, granted_at, expires_at, revoked_at,
+		       purpose, purposes, data_types, recipients, blockchain_hash
+		FROM consents
+		WHERE member_id = $1
+		ORDER BY granted_at, id`, memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	consents := []models.Consent{}
+	for rows.Next() {
+		var c models.Consent
+		var expiresAt, revokedAt sql.NullTime
+		var blockchainHash sql.NullString
+		if err := rows.Scan(&c.ID, &c.MemberID, &c.Type, &c.Scope, &c.Status, &c.GrantedAt,
+			&expiresAt, &revokedAt, &c.Purpose, pq.Array(&c.Purposes), pq.Array(&c.DataTypes),
+			pq.Array(&c.Recipients), &blockchainHash); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			c.ExpiresAt = &expiresAt.Time
+		}
+		if revokedAt.Valid {
+			c.RevokedAt = &revokedAt.Time
+		}
+		if blockchainHash.Valid {
+			c.BlockchainHash = &blockchainHash.String
+		}
+		consents = append(consents, c)
+	}
+	return consents, rows.Err()
+}
+
+// Revoke revokes a consent of a member at the given time and returns when it
+// was revoked. Revoking a consent again keeps the first revocation time. It
+// returns sql.ErrNoRows when the member has no such consent.
+func (s *Store) Revoke(ctx context.Context, memberID, consentID string, at time.Time) (time.Time, error) {
+	var revokedAt time.Time
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE consents
+		SET status = $3, revoked_at = COALESCE(revoked_at, $4)
+		WHERE member_id = $1 AND id = $2
+		RETURNING revoked_at`,
+		memberID, consentID, statusRevoked, at).Scan(&revokedAt)
+	return revokedAt, err
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -9,6 +12,7 @@ import (
 
 	"github.com/Fadil369/NPHIES/services/wallet-service/internal/blockchain"
 	"github.com/Fadil369/NPHIES/services/wallet-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/wallet-service/internal/serviceauth"
 )
 
 // CreateConsent creates a new consent record
@@ -19,13 +23,12 @@ func (h *Handler) CreateConsent(c *gin.Context) {
 		return
 	}
 
-	memberID := c.GetHeader("X-Member-ID")
-	if memberID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Member ID required in header"})
+	memberID, ok := consentMember(c)
+	if !ok {
 		return
 	}
 
-	h.logger.WithFields(map[string]interface{}{
+	h.logger.WithContext(c.Request.Context()).WithFields(map[string]interface{}{
 		"member_id": memberID,
 		"type":      request.Type,
 		"scope":     request.Scope,
@@ -41,6 +44,7 @@ func (h *Handler) CreateConsent(c *gin.Context) {
 		GrantedAt: time.Now(),
 		ExpiresAt: request.ExpiresAt,
 		Purpose:   request.Purpose,
+		Purposes:  request.Purposes,
 		DataTypes: request.DataTypes,
 		Recipients: request.Recipients,
 	}
@@ -50,6 +54,12 @@ func (h *Handler) CreateConsent(c *gin.Context) {
 		consent.BlockchainHash = &blockchainHash
 	}
 
+	if err := h.consents.Create(c.Request.Context(), consent); err != nil {
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to store consent")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store consent"})
+		return
+	}
+
 	c.JSON(http.StatusCreated, consent)
 }
 
@@ -57,35 +67,13 @@ func (h *Handler) CreateConsent(c *gin.Context) {
 func (h *Handler) GetConsents(c *gin.Context) {
 	memberID := c.Param("memberId")
 
-	h.logger.WithField("member_id", memberID).Info("Retrieving consent records")
+	h.logger.WithContext(c.Request.Context()).WithField("member_id", memberID).Info("Retrieving consent records")
 
-	// Mock consent data
-	consents := []gin.H{
-		{
-			"id":          "consent-001",
-			"member_id":   memberID,
-			"type":        "DATA_SHARING",
-			"scope":       "CLAIMS_DATA",
-			"status":      "ACTIVE",
-			"granted_at":  time.Now().Add(-30 * 24 * time.Hour),
-			"expires_at":  time.Now().Add(365 * 24 * time.Hour),
-			"purpose":     "Insurance processing and analytics",
-			"data_types":  []string{"CLAIMS", "ELIGIBILITY", "DEMOGRAPHICS"},
-			"recipients":  []string{"PRIMARY_INSURER", "ANALYTICS_PLATFORM"},
-			"blockchain_hash": "0xabcdef1234567890",
-		},
-		{
-			"id":         "consent-002",
-			"member_id":  memberID,
-			"type":       "TELEMEDICINE",
-			"scope":      "CONSULTATION_DATA",
-			"status":     "ACTIVE",
-			"granted_at": time.Now().Add(-7 * 24 * time.Hour),
-			"purpose":    "Remote healthcare consultation",
-			"data_types": []string{"VITAL_SIGNS", "MEDICAL_HISTORY"},
-			"recipients": []string{"TELEMEDICINE_PROVIDER"},
-			"blockchain_hash": "0x1234567890abcdef",
-		},
+	consents, err := h.consents.ListByMember(c.Request.Context(), memberID)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to load consents")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load consents"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -97,6 +85,9 @@ func (h *Handler) GetConsents(c *gin.Context) {
 // UpdateConsent updates an existing consent record
 func (h *Handler) UpdateConsent(c *gin.Context) {
 	consentID := c.Param("consentId")
+	if _, ok := consentMember(c); !ok {
+		return
+	}
 
 	var updates map[string]interface{}
 	if err := c.ShouldBindJSON(&updates); err != nil {
@@ -104,7 +95,7 @@ func (h *Handler) UpdateConsent(c *gin.Context) {
 		return
 	}
 
-	h.logger.WithFields(map[string]interface{}{
+	h.logger.WithContext(c.Request.Context()).WithFields(map[string]interface{}{
 		"consent_id": consentID,
 		"updates":    updates,
 	}).Info("Updating consent record")
@@ -120,16 +111,35 @@ func (h *Handler) UpdateConsent(c *gin.Context) {
 	c.JSON(http.StatusOK, updatedConsent)
 }
 
-// RevokeConsent revokes a consent record
+// RevokeConsent revokes a consent record of the authenticated member
 func (h *Handler) RevokeConsent(c *gin.Context) {
 	consentID := c.Param("consentId")
+	memberID, ok := consentMember(c)
+	if !ok {
+		return
+	}
 
-	h.logger.WithField("consent_id", consentID).Info("Revoking consent record")
+	h.logger.WithContext(c.Request.Context()).WithFields(map[string]interface{}{
+		"member_id":  memberID,
+		"consent_id": consentID,
+	}).Info("Revoking consent record")
+
+	// Revoked consents stop permitting disclosures at once, on every replica
+	revokedAt, err := h.consents.Revoke(c.Request.Context(), memberID, consentID, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Consent not found"})
+		return
+	}
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to revoke consent")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke consent"})
+		return
+	}
 
 	// Create revocation record for blockchain
 	revocation := gin.H{
 		"consent_id": consentID,
-		"revoked_at": time.Now(),
+		"revoked_at": revokedAt,
 		"status":     "REVOKED",
 	}
 
@@ -155,7 +165,7 @@ func (h *Handler) EstimateCost(c *gin.Context) {
 		return
 	}
 
-	h.logger.WithFields(map[string]interface{}{
+	h.logger.WithContext(c.Request.Context()).WithFields(map[string]interface{}{
 		"member_id":     memberID,
 		"provider_id":   request.ProviderID,
 		"service_codes": request.ServiceCodes,
@@ -189,7 +199,7 @@ func (h *Handler) EstimateCost(c *gin.Context) {
 func (h *Handler) GetProviderServices(c *gin.Context) {
 	providerID := c.Param("providerId")
 
-	h.logger.WithField("provider_id", providerID).Info("Retrieving provider services")
+	h.logger.WithContext(c.Request.Context()).WithField("provider_id", providerID).Info("Retrieving provider services")
 
 	// Mock provider services
 	services := []gin.H{
@@ -230,7 +240,7 @@ func (h *Handler) GetProviderServices(c *gin.Context) {
 func (h *Handler) GetRemainingBenefits(c *gin.Context) {
 	memberID := c.Param("memberId")
 
-	h.logger.WithField("member_id", memberID).Info("Retrieving remaining benefits")
+	h.logger.WithContext(c.Request.Context()).WithField("member_id", memberID).Info("Retrieving remaining benefits")
 
 	// Mock benefit utilization data
 	benefits := []models.BenefitUtilization{
@@ -281,7 +291,7 @@ func (h *Handler) DeductBenefits(c *gin.Context) {
 		return
 	}
 
-	h.logger.WithFields(map[string]interface{}{
+	h.logger.WithContext(c.Request.Context()).WithFields(map[string]interface{}{
 		"member_id":        memberID,
 		"benefit_type":     request.BenefitType,
 		"service_category": request.ServiceCategory,
@@ -307,7 +317,7 @@ func (h *Handler) DeductBenefits(c *gin.Context) {
 func (h *Handler) GetBenefitUtilization(c *gin.Context) {
 	memberID := c.Param("memberId")
 
-	h.logger.WithField("member_id", memberID).Info("Retrieving benefit utilization history")
+	h.logger.WithContext(c.Request.Context()).WithField("member_id", memberID).Info("Retrieving benefit utilization history")
 
 	// Mock utilization data
 	utilization := []gin.H{
@@ -351,4 +361,17 @@ func (h *Handler) anchorConsentToBlockchain(consent models.Consent) (string, err
 	}
 
 	return response.Hash, nil
-}
\ No newline at end of file
+}
+
+// consentMember returns the member changing their consents: the user the
+// gateway signed the request for, whose ID is their member ID. API keys and
+// client certificates act for organizations, which cannot grant or revoke a
+// member's consent.
+func consentMember(c *gin.Context) (string, bool) {
+	principal := serviceauth.Principal(c)
+	if principal == "" || strings.HasPrefix(principal, "apikey:") || strings.HasPrefix(principal, "cert:") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the member can change their consents"})
+		return "", false
+	}
+	return principal, true
+}
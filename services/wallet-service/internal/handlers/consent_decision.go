@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Fadil369/NPHIES/services/wallet-service/internal/models"
+)
+
+// DecideConsent reports whether an active, unexpired consent of the member
+// allows the recipient to receive the data type for the purpose
+func (h *Handler) DecideConsent(c *gin.Context) {
+	var request models.ConsentDecisionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	consents, err := h.consents.ListByMember(c.Request.Context(), request.MemberID)
+	if err != nil {
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to load consents")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Consents unavailable"})
+		return
+	}
+	decision := decideConsent(consents, request, time.Now())
+
+	h.logger.WithContext(c.Request.Context()).WithFields(map[string]interface{}{
+		"member_id":  request.MemberID,
+		"recipient":  request.Recipient,
+		"data_type":  request.DataType,
+		"purpose":    request.Purpose,
+		"permit":     decision.Permit,
+		"consent_id": decision.ConsentID,
+	}).Info("Consent decision")
+
+	c.JSON(http.StatusOK, decision)
+}
+
+// decideConsent finds a consent covering the request. A consent covers it
+// when it is active and unexpired at now and lists the recipient and data
+// type; consents without purposes allow every purpose.
+func decideConsent(consents []models.Consent, request models.ConsentDecisionRequest, now time.Time) models.ConsentDecision {
+	for _, consent := range consents {
+		if consent.Status != "ACTIVE" || consent.RevokedAt != nil || (consent.ExpiresAt != nil && !now.Before(*consent.ExpiresAt)) {
+			continue
+		}
+		if !containsFold(consent.Recipients, request.Recipient) || !containsFold(consent.DataTypes, request.DataType) {
+			continue
+		}
+		if len(consent.Purposes) > 0 && !containsFold(consent.Purposes, request.Purpose) {
+			continue
+		}
+		return models.ConsentDecision{
+			Permit:    true,
+			ConsentID: consent.ID,
+			ExpiresAt: consent.ExpiresAt,
+			Reason:    "permitted by consent " + consent.ID,
+		}
+	}
+
+	reason := "no active consent allows " + request.Recipient + " to receive " + request.DataType + " data"
+	if request.Purpose != "" {
+		reason += " for " + request.Purpose
+	}
+	return models.ConsentDecision{
+		Permit: false,
+		Reason: reason,
+	}
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
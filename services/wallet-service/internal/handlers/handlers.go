@@ -3,4 +3,5 @@ package handlers
 // This file aggregates all handler methods for the wallet service
 // Individual handler implementations are in separate files:
 // - wallet.go: Digital wallet and blockchain operations
-// - consent.go: Consent management and cost estimation
\ No newline at end of file
+// - consent.go: Consent management and cost estimation
+// - consent_decision.go: Consent decisions for disclosures by the API gateway
\ No newline at end of file
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -11,16 +13,33 @@ import (
 
 	"github.com/Fadil369/NPHIES/services/wallet-service/internal/blockchain"
 	"github.com/Fadil369/NPHIES/services/wallet-service/internal/config"
+	"github.com/Fadil369/NPHIES/services/wallet-service/internal/consent"
 	"github.com/Fadil369/NPHIES/services/wallet-service/internal/models"
+	"github.com/Fadil369/NPHIES/services/wallet-service/internal/tracing"
 )
 
 type Handler struct {
 	logger          *logrus.Logger
 	config          *config.Config
+	db              *sql.DB
 	blockchainClient blockchain.BlockchainClient
+	consents        *consent.Store
 }
 
 func NewHandler(logger *logrus.Logger, cfg *config.Config) (*Handler, error) {
+	// Initialize database connection; consents are stored in it
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Database.Host, cfg.Database.Port, cfg.Database.User,
+		cfg.Database.Password, cfg.Database.Name, cfg.Database.SSLMode)
+	db, err := tracing.OpenDB(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
 	// Initialize blockchain client
 	blockchainClient := blockchain.NewHyperledgerClient(
 		cfg.Blockchain.NodeURL,
@@ -32,10 +51,17 @@ func NewHandler(logger *logrus.Logger, cfg *config.Config) (*Handler, error) {
 	return &Handler{
 		logger:          logger,
 		config:          cfg,
+		db:              db,
 		blockchainClient: blockchainClient,
+		consents:        consent.NewStore(db),
 	}, nil
 }
 
+// Close releases the database connection
+func (h *Handler) Close() error {
+	return h.db.Close()
+}
+
 // Health check endpoint
 func (h *Handler) Health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -47,6 +73,13 @@ func (h *Handler) Health(c *gin.Context) {
 
 // Readiness check endpoint
 func (h *Handler) Ready(c *gin.Context) {
+	if err := h.db.PingContext(c.Request.Context()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "not ready",
+			"error":  "database unavailable",
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"status": "ready",
 	})
@@ -67,7 +100,7 @@ func (h *Handler) Metrics(c *gin.Context) {
 func (h *Handler) GetWallet(c *gin.Context) {
 	memberID := c.Param("memberId")
 
-	h.logger.WithField("member_id", memberID).Info("Retrieving wallet information")
+	h.logger.WithContext(c.Request.Context()).WithField("member_id", memberID).Info("Retrieving wallet information")
 
 	// Mock wallet data
 	wallet := gin.H{
@@ -122,7 +155,7 @@ func (h *Handler) CreateTransaction(c *gin.Context) {
 		return
 	}
 
-	h.logger.WithFields(logrus.Fields{
+	h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 		"member_id": memberID,
 		"type":      request.Type,
 		"amount":    request.Amount,
@@ -158,7 +191,7 @@ func (h *Handler) GetTransactions(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "0"))
 	size, _ := strconv.Atoi(c.DefaultQuery("size", "20"))
 
-	h.logger.WithFields(logrus.Fields{
+	h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 		"member_id": memberID,
 		"page":      page,
 		"size":      size,
@@ -207,7 +240,7 @@ func (h *Handler) GetTransactions(c *gin.Context) {
 func (h *Handler) GetBalance(c *gin.Context) {
 	memberID := c.Param("memberId")
 
-	h.logger.WithField("member_id", memberID).Info("Retrieving wallet balance")
+	h.logger.WithContext(c.Request.Context()).WithField("member_id", memberID).Info("Retrieving wallet balance")
 
 	balance := models.WalletBalance{
 		MemberID:         memberID,
@@ -229,14 +262,14 @@ func (h *Handler) AnchorToBlockchain(c *gin.Context) {
 		return
 	}
 
-	h.logger.WithFields(logrus.Fields{
+	h.logger.WithContext(c.Request.Context()).WithFields(logrus.Fields{
 		"ref_type": request.RefType,
 		"ref_id":   request.RefID,
 	}).Info("Anchoring data to blockchain")
 
 	response, err := h.blockchainClient.SubmitTransaction(request.RefType, request.RefID, request.Data)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to submit transaction to blockchain")
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to submit transaction to blockchain")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to anchor to blockchain"})
 		return
 	}
@@ -248,11 +281,11 @@ func (h *Handler) AnchorToBlockchain(c *gin.Context) {
 func (h *Handler) VerifyHash(c *gin.Context) {
 	hash := c.Param("hash")
 
-	h.logger.WithField("hash", hash).Info("Verifying hash on blockchain")
+	h.logger.WithContext(c.Request.Context()).WithField("hash", hash).Info("Verifying hash on blockchain")
 
 	result, err := h.blockchainClient.VerifyHash(hash)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to verify hash")
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to verify hash")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify hash"})
 		return
 	}
@@ -264,11 +297,11 @@ func (h *Handler) VerifyHash(c *gin.Context) {
 func (h *Handler) GetBlockchainTransaction(c *gin.Context) {
 	txID := c.Param("txId")
 
-	h.logger.WithField("transaction_id", txID).Info("Retrieving blockchain transaction")
+	h.logger.WithContext(c.Request.Context()).WithField("transaction_id", txID).Info("Retrieving blockchain transaction")
 
 	details, err := h.blockchainClient.GetTransaction(txID)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to retrieve transaction")
+		h.logger.WithContext(c.Request.Context()).WithError(err).Error("Failed to retrieve transaction")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transaction"})
 		return
 	}
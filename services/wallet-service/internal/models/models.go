@@ -56,6 +56,7 @@ type Consent struct {
 	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
 	RevokedAt   *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
 	Purpose     string    `json:"purpose" db:"purpose"`
+	Purposes    []string  `json:"purposes,omitempty"` // purpose of use codes, e.g. TREAT, HPAYMT; empty allows any
 	DataTypes   []string  `json:"data_types"`
 	Recipients  []string  `json:"recipients"`
 	BlockchainHash *string `json:"blockchain_hash,omitempty" db:"blockchain_hash"`
@@ -64,7 +65,7 @@ type Consent struct {
 // BlockchainAnchor represents data anchored to blockchain
 type BlockchainAnchor struct {
 	ID            string    `json:"id" db:"id"`
-	RefType       string    `json:"ref_type" db:"ref_type"` // CLAIM, CONSENT, TRANSACTION
+	RefType       string    `json:"ref_type" db:"ref_type"` // CLAIM, CONSENT, TRANSACTION, AUDIT_CHECKPOINT
 	RefID         string    `json:"ref_id" db:"ref_id"`
 	Hash          string    `json:"hash" db:"hash"`
 	BlockchainTx  string    `json:"blockchain_tx" db:"blockchain_tx"`
@@ -105,11 +106,30 @@ type CreateConsentRequest struct {
 	Type       string    `json:"type" validate:"required,oneof=DATA_SHARING TELEMEDICINE RESEARCH"`
 	Scope      string    `json:"scope" validate:"required"`
 	Purpose    string    `json:"purpose" validate:"required"`
+	Purposes   []string  `json:"purposes,omitempty"`
 	DataTypes  []string  `json:"data_types" validate:"required"`
 	Recipients []string  `json:"recipients" validate:"required"`
 	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
 }
 
+// ConsentDecisionRequest asks whether a member's consents allow a recipient to
+// receive a type of data for a purpose
+type ConsentDecisionRequest struct {
+	MemberID  string `json:"member_id" binding:"required"`
+	Recipient string `json:"recipient" binding:"required"`
+	DataType  string `json:"data_type" binding:"required"`
+	Purpose   string `json:"purpose,omitempty"`
+}
+
+// ConsentDecision is the answer to a ConsentDecisionRequest. ConsentID is the
+// consent that permits the disclosure.
+type ConsentDecision struct {
+	Permit    bool       `json:"permit"`
+	ConsentID string     `json:"consent_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Reason    string     `json:"reason"`
+}
+
 // CostEstimateRequest represents a request for cost estimation
 type CostEstimateRequest struct {
 	ProviderID   string   `json:"provider_id" validate:"required"`
@@ -119,7 +139,7 @@ type CostEstimateRequest struct {
 
 // BlockchainAnchorRequest represents a request to anchor data to blockchain
 type BlockchainAnchorRequest struct {
-	RefType string `json:"ref_type" validate:"required,oneof=CLAIM CONSENT TRANSACTION"`
+	RefType string `json:"ref_type" validate:"required,oneof=CLAIM CONSENT TRANSACTION AUDIT_CHECKPOINT"`
 	RefID   string `json:"ref_id" validate:"required"`
 	Data    string `json:"data" validate:"required"`
 }
\ No newline at end of file
@@ -0,0 +1,27 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenDB opens a Postgres connection pool whose queries are traced. Statements
+// are recorded without their arguments. Only queries that are part of a trace
+// get spans, so background polling does not start a new trace every tick.
+func OpenDB(dataSourceName string) (*sql.DB, error) {
+	return otelsql.Open("postgres", dataSourceName,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsValid()
+			},
+		}),
+	)
+}